@@ -0,0 +1,175 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/bsv-blockchain/go-message-box-server/pkg/config"
+	"github.com/bsv-blockchain/go-message-box-server/pkg/db"
+)
+
+// cliChangedBy is recorded in the fee history for changes made with the CLI.
+const cliChangedBy = "cli"
+
+const feesUsage = `Usage: server fees <command>
+
+Manage server delivery fees. Uses DB_DRIVER and DB_SOURCE.
+
+Commands:
+  list                        List delivery fees and the default fee
+  set <messageBox> <fee>      Set the delivery fee of a box ("*" sets the default fee)
+  remove <messageBox>         Remove the delivery fee of a box
+  history [-limit N] [box]    Show recent fee changes
+`
+
+// runFeesCommand implements the "fees" subcommand and returns the process exit code.
+func runFeesCommand(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, feesUsage)
+		return 2
+	}
+
+	return runDatabaseCommand(feesCommand, feesUsage, args, stdout, stderr)
+}
+
+// runDatabaseCommand opens and migrates the database, runs command and returns the process exit code.
+func runDatabaseCommand(command func(*db.DB, []string, io.Writer) error, usageText string, args []string, stdout, stderr io.Writer) int {
+	cfg := config.LoadDatabase()
+	database, err := db.New(cfg.DBDriver, cfg.DBSource)
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to connect to database: %v\n", err)
+		return 1
+	}
+	defer database.Close()
+
+	if err := database.Migrate(); err != nil {
+		fmt.Fprintf(stderr, "failed to run migrations: %v\n", err)
+		return 1
+	}
+
+	if err := command(database, args, stdout); err != nil {
+		fmt.Fprintln(stderr, err)
+		var usage usageError
+		if errors.As(err, &usage) {
+			fmt.Fprint(stderr, usageText)
+			return 2
+		}
+		return 1
+	}
+	return 0
+}
+
+// usageError is returned for malformed CLI arguments.
+type usageError string
+
+func (e usageError) Error() string { return string(e) }
+
+func feesCommand(database *db.DB, args []string, out io.Writer) error {
+	switch args[0] {
+	case "list":
+		fees, err := database.ListServerFees()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "MESSAGE BOX\tDELIVERY FEE\tUPDATED")
+		for _, f := range fees {
+			box := f.MessageBox
+			if box == db.DefaultServerFeeBox {
+				box = "* (default)"
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%s\n", box, f.DeliveryFee, f.UpdatedAt.Format("2006-01-02T15:04:05.000Z"))
+		}
+		return tw.Flush()
+
+	case "set":
+		if len(args) != 3 {
+			return usageError("set requires <messageBox> <fee>")
+		}
+		fee, err := strconv.Atoi(args[2])
+		if err != nil || fee < 0 {
+			return usageError("fee must be a non-negative number of satoshis")
+		}
+		if err := validateFeeBox(args[1]); err != nil {
+			return err
+		}
+		previous, err := database.SetServerDeliveryFee(args[1], fee, cliChangedBy)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%s: %s -> %d\n", args[1], formatFee(previous), fee)
+		return nil
+
+	case "remove":
+		if len(args) != 2 {
+			return usageError("remove requires <messageBox>")
+		}
+		if err := validateFeeBox(args[1]); err != nil {
+			return err
+		}
+		previous, err := database.DeleteServerDeliveryFee(args[1], cliChangedBy)
+		if err != nil {
+			return err
+		}
+		if previous == nil {
+			return fmt.Errorf("no delivery fee is set for %s", args[1])
+		}
+		fmt.Fprintf(out, "%s: %d -> removed\n", args[1], *previous)
+		return nil
+
+	case "history":
+		fs := flag.NewFlagSet("history", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		limit := fs.Int("limit", 50, "maximum number of changes")
+		if err := fs.Parse(args[1:]); err != nil || *limit < 1 || fs.NArg() > 1 {
+			return usageError("history accepts [-limit N] [messageBox]")
+		}
+		var box *string
+		if fs.NArg() == 1 {
+			b := fs.Arg(0)
+			box = &b
+		}
+		changes, err := database.ListServerFeeChanges(box, *limit)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "WHEN\tMESSAGE BOX\tFROM\tTO\tBY")
+		for _, c := range changes {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+				c.CreatedAt.Format("2006-01-02T15:04:05.000Z"), c.MessageBox,
+				formatNullFee(c.PreviousFee), formatNullFee(c.NewFee), c.ChangedBy)
+		}
+		return tw.Flush()
+
+	default:
+		return usageError(fmt.Sprintf("unknown command %q", args[0]))
+	}
+}
+
+// validateFeeBox rejects glob patterns other than the default fee entry.
+func validateFeeBox(box string) error {
+	if box != db.DefaultServerFeeBox && db.IsBoxPattern(box) {
+		return usageError("messageBox must be a box name or * for the default fee")
+	}
+	return nil
+}
+
+func formatFee(fee *int) string {
+	if fee == nil {
+		return "-"
+	}
+	return strconv.Itoa(*fee)
+}
+
+func formatNullFee(fee sql.NullInt64) string {
+	if !fee.Valid {
+		return "-"
+	}
+	return strconv.FormatInt(fee.Int64, 10)
+}
@@ -10,10 +10,12 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
+	_ "time/tzdata" // quiet hours timezones must resolve on minimal images
 
 	"github.com/bsv-blockchain/go-bsv-middleware/pkg/middleware"
 	_ "github.com/bsv-blockchain/go-message-box-server/docs"
 	"github.com/bsv-blockchain/go-message-box-server/internal/firebase"
+	"github.com/bsv-blockchain/go-message-box-server/internal/jobs"
 	"github.com/bsv-blockchain/go-message-box-server/pkg/config"
 	"github.com/bsv-blockchain/go-message-box-server/pkg/db"
 	"github.com/bsv-blockchain/go-message-box-server/pkg/handlers"
@@ -40,6 +42,14 @@ import (
 // @description BRC-31/BRC-104 mutual authentication. Requires multiple x-bsv-auth-* headers (identity-key, nonce, signature, etc.)
 
 func main() {
+	// Operator subcommands run against the database without starting the server
+	if len(os.Args) > 1 && os.Args[1] == "fees" {
+		os.Exit(runFeesCommand(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "settlements" {
+		os.Exit(runSettlementsCommand(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
 	cfg, err := config.Load()
 	if err != nil {
 		slog.Error("failed to load config", "error", err)
@@ -63,6 +73,15 @@ func main() {
 		os.Exit(1)
 	}
 
+	policies := make([]db.DefaultFeePolicy, 0, len(cfg.RecipientFeeDefaults))
+	for _, p := range cfg.RecipientFeeDefaults {
+		policies = append(policies, db.DefaultFeePolicy{MessageBox: p.MessageBox, Fee: p.Fee})
+	}
+	if err := database.SetDefaultFeePolicies(policies); err != nil {
+		slog.Error("invalid RECIPIENT_FEE_DEFAULTS", "error", err)
+		os.Exit(1)
+	}
+
 	// initalize firebase
 	if err := firebase.Initialize(firebase.Config{
 		ProjectID:          cfg.FirebaseProjectID,
@@ -82,7 +101,38 @@ func main() {
 	}
 	defer walletCleanup()
 
-	srv := handlers.NewServer(database, w)
+	// Background jobs stop when the server shuts down
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+
+	go jobs.RunDevicePruner(jobsCtx, database, cfg.DeviceStaleAfter, cfg.DevicePruneInterval)
+	go jobs.RunRateCounterPruner(jobsCtx, database, time.Hour)
+	go jobs.RunQuotePruner(jobsCtx, database, time.Hour)
+	go jobs.RunDeliveryPruner(jobsCtx, database, cfg.NotificationDeliveryRetention, 24*time.Hour)
+	go jobs.RunCertificatePruner(jobsCtx, database, time.Hour)
+	go jobs.RunProofOfWorkPruner(jobsCtx, database, cfg.ProofOfWorkRetention, 24*time.Hour)
+
+	// Chain lookups for payment replay protection
+	chainServices := services.New(slog.Default(), defs.DefaultServicesConfig(bsvNetwork(cfg)))
+	go jobs.RunSpentOutputPruner(jobsCtx, database, chainServices, cfg.PaymentReplayConfirmations, time.Hour)
+
+	srv := handlers.NewServer(database, w,
+		handlers.WithAdminIdentityKeys(cfg.AdminIdentityKeys),
+		handlers.WithDeviceTransferPolicy(cfg.DeviceTokenTransferPolicy),
+		handlers.WithQuoteTTL(cfg.QuoteTTL),
+		handlers.WithCertificateTTL(cfg.SenderCertificateTTL),
+		handlers.WithPaymentReplayWindow(chainServices, cfg.PaymentReplayConfirmations),
+		handlers.WithSpamPolicy(handlers.SpamPolicy{
+			Window:           cfg.SpamReportWindow,
+			RaiseFeesAt:      cfg.SpamRaiseFeesAt,
+			FeeMultiplier:    cfg.SpamFeeMultiplier,
+			RequirePaymentAt: cfg.SpamRequirePaymentAt,
+			MinRecipientFee:  cfg.SpamMinRecipientFee,
+			BanAt:            cfg.SpamBanAt,
+		}),
+	)
+
+	go jobs.RunCreditSettlement(jobsCtx, srv, int64(cfg.CreditSettlementMinAmount), cfg.CreditSettlementInterval)
 
 	// Build router
 	mux := http.NewServeMux()
@@ -93,20 +143,70 @@ func main() {
 	mux.HandleFunc("POST "+prefix+"/sendMessage", srv.SendMessage)
 	mux.HandleFunc("POST "+prefix+"/listMessages", srv.ListMessages)
 	mux.HandleFunc("POST "+prefix+"/acknowledgeMessage", srv.AcknowledgeMessage)
+	mux.HandleFunc("POST "+prefix+"/report", srv.ReportSpam)
+	mux.HandleFunc("GET "+prefix+"/reputation", srv.GetReputation)
+	mux.HandleFunc("POST "+prefix+"/certificates", srv.PresentCertificates)
+	mux.HandleFunc("GET "+prefix+"/certificates", srv.ListCertificates)
+	mux.HandleFunc("DELETE "+prefix+"/certificates", srv.DeleteCertificates)
 	mux.HandleFunc("POST "+prefix+"/registerDevice", srv.RegisterDevice)
 	mux.HandleFunc("GET "+prefix+"/devices", srv.ListDevices)
+	mux.HandleFunc("PATCH "+prefix+"/devices/{id}", srv.UpdateDevice)
+	mux.HandleFunc("DELETE "+prefix+"/devices/{id}", srv.DeleteDevice)
 	mux.HandleFunc("POST "+prefix+"/permissions/set", srv.SetPermission)
 	mux.HandleFunc("GET "+prefix+"/permissions/get", srv.GetPermission)
 	mux.HandleFunc("GET "+prefix+"/permissions/list", srv.ListPermissions)
 	mux.HandleFunc("GET "+prefix+"/permissions/quote", srv.GetQuote)
+	mux.HandleFunc("DELETE "+prefix+"/permissions", srv.DeletePermission)
+	mux.HandleFunc("POST "+prefix+"/permissions/bulkSet", srv.BulkSetPermissions)
+	mux.HandleFunc("GET "+prefix+"/permissions/export", srv.ExportPermissions)
+	mux.HandleFunc("POST "+prefix+"/permissions/rateLimits/set", srv.SetRateLimit)
+	mux.HandleFunc("GET "+prefix+"/permissions/rateLimits/list", srv.ListRateLimits)
+	mux.HandleFunc("POST "+prefix+"/permissions/subscriptions/set", srv.SetSubscriptionOffer)
+	mux.HandleFunc("GET "+prefix+"/permissions/subscriptions/list", srv.ListSubscriptionOffers)
+	mux.HandleFunc("POST "+prefix+"/permissions/certificates/set", srv.SetCertificateRequirement)
+	mux.HandleFunc("GET "+prefix+"/permissions/certificates/list", srv.ListCertificateRequirements)
+	mux.HandleFunc("POST "+prefix+"/notificationPreferences/set", srv.SetNotificationPreference)
+	mux.HandleFunc("GET "+prefix+"/notificationPreferences/get", srv.GetNotificationPreference)
+	mux.HandleFunc("GET "+prefix+"/notificationPreferences/list", srv.ListNotificationPreferences)
+	mux.HandleFunc("POST "+prefix+"/notificationPayloads/set", srv.SetNotificationPayload)
+	mux.HandleFunc("GET "+prefix+"/notificationPayloads/get", srv.GetNotificationPayload)
+	mux.HandleFunc("GET "+prefix+"/notifications/deliveries", srv.ListNotificationDeliveries)
+	mux.HandleFunc("GET "+prefix+"/payments/earnings", srv.ListEarnings)
+	mux.HandleFunc("POST "+prefix+"/payments/reject", srv.RejectPayment)
+	mux.HandleFunc("GET "+prefix+"/payments/refunds", srv.ListRefunds)
+	mux.HandleFunc("GET "+prefix+"/routePrices", srv.ListRoutePrices)
+	mux.HandleFunc("POST "+prefix+"/credits/deposit", srv.DepositCredit)
+	mux.HandleFunc("GET "+prefix+"/credits/balance", srv.GetCreditBalance)
+	mux.HandleFunc("GET "+prefix+"/credits/ledger", srv.ListCreditLedger)
+	mux.HandleFunc("POST "+prefix+"/credits/withdraw", srv.WithdrawCredit)
+	mux.HandleFunc("GET "+prefix+"/credits/payouts", srv.ListCreditPayouts)
+
+	// Operator routes (restricted to ADMIN_IDENTITY_KEYS)
+	mux.HandleFunc("GET "+prefix+"/admin/notifications/failures", srv.GetNotificationFailures)
+	mux.HandleFunc("GET "+prefix+"/admin/serverFees", srv.ListServerFees)
+	mux.HandleFunc("POST "+prefix+"/admin/serverFees/set", srv.SetServerFee)
+	mux.HandleFunc("DELETE "+prefix+"/admin/serverFees", srv.DeleteServerFee)
+	mux.HandleFunc("GET "+prefix+"/admin/serverFees/history", srv.GetServerFeeHistory)
+	mux.HandleFunc("GET "+prefix+"/admin/payments/revenue", srv.GetDeliveryFeeRevenue)
+	mux.HandleFunc("POST "+prefix+"/admin/routePrices/set", srv.SetRoutePrice)
+	mux.HandleFunc("DELETE "+prefix+"/admin/routePrices", srv.DeleteRoutePrice)
+	mux.HandleFunc("DELETE "+prefix+"/admin/reputation", srv.ClearReputation)
+
+	// Auth middleware keeps the identity certificates clients present during the handshake; requesting them
+	// makes the middleware turn away every client that does not present them
+	authOpts := []func(*middleware.AuthMiddlewareConfig){middleware.WithAuthCertificatesReceivedListener(srv.CertificatesReceived)}
+	if len(cfg.AuthCertificateTypes) > 0 {
+		requested, err := handlers.RequestedCertificates(cfg.AuthCertifiers, cfg.AuthCertificateTypes)
+		if err != nil {
+			slog.Error("invalid AUTH_CERTIFICATE_TYPES or AUTH_CERTIFIERS", "error", err)
+			os.Exit(1)
+		}
+		authOpts = append(authOpts, middleware.WithAuthCertificatesToRequest(requested))
+	}
+	authMiddleware := middleware.NewAuth(w, authOpts...)
 
-	// Auth middleware
-	authMiddleware := middleware.NewAuth(w)
-
-	// Payment middleware (returns 0 for now, matching the original)
-	paymentMiddleware := middleware.NewPayment(w, middleware.WithRequestPriceCalculator(func(r *http.Request) (int, error) {
-		return 0, nil
-	}))
+	// Payment middleware charges the route prices set by operators; unpriced routes are free
+	paymentMiddleware := middleware.NewPayment(w, middleware.WithRequestPriceCalculator(srv.RoutePriceCalculator(prefix)))
 
 	// create root mux for swagger to avoid auth
 	rootMux := http.NewServeMux()
@@ -115,7 +215,7 @@ func main() {
 	))
 
 	rootMux.Handle("/", authMiddleware.HTTPHandler(
-		paymentMiddleware.HTTPHandler(mux),
+		paymentMiddleware.HTTPHandler(srv.RecordRouteCharges(prefix, mux)),
 	))
 
 	// Stack: CORS -> rootMux -> Auth -> Payment -> Routes
@@ -143,6 +243,7 @@ func main() {
 	<-stop
 
 	logger.Log("shutting down...")
+	stopJobs()
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	if err := server.Shutdown(ctx); err != nil {
@@ -150,11 +251,15 @@ func main() {
 	}
 }
 
-func createWallet(cfg *config.Config) (sdk.Interface, func(), error) {
-	network := defs.NetworkMainnet
+func bsvNetwork(cfg *config.Config) defs.BSVNetwork {
 	if cfg.BSVNetwork == "testnet" {
-		network = defs.NetworkTestnet
+		return defs.NetworkTestnet
 	}
+	return defs.NetworkMainnet
+}
+
+func createWallet(cfg *config.Config) (sdk.Interface, func(), error) {
+	network := bsvNetwork(cfg)
 
 	if cfg.WalletStorageURL != "" {
 		return createWalletWithRemoteStorage(cfg, network)
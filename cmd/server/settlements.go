@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bsv-blockchain/go-message-box-server/pkg/db"
+)
+
+const settlementsUsage = `Usage: server settlements <command>
+
+Resolve recipient fee settlements interrupted before their payment was recorded. Uses DB_DRIVER and DB_SOURCE.
+
+Commands:
+  list [-older D]             List settlements unpaid for longer than D (default 1h)
+  cancel <payoutId>           Return the fees of an unpaid settlement to pending, to be paid again
+`
+
+// runSettlementsCommand implements the "settlements" subcommand and returns the process exit code.
+func runSettlementsCommand(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, settlementsUsage)
+		return 2
+	}
+	return runDatabaseCommand(settlementsCommand, settlementsUsage, args, stdout, stderr)
+}
+
+func settlementsCommand(database *db.DB, args []string, out io.Writer) error {
+	switch args[0] {
+	case "list":
+		fs := flag.NewFlagSet("list", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		older := fs.Duration("older", time.Hour, "least time a settlement has been unpaid")
+		if err := fs.Parse(args[1:]); err != nil || *older < 0 || fs.NArg() > 0 {
+			return usageError("list accepts [-older D]")
+		}
+		payouts, err := database.ListUnpaidCreditSettlements(time.Now().Add(-*older))
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "PAYOUT\tRECIPIENT\tAMOUNT\tSTARTED")
+		for _, p := range payouts {
+			fmt.Fprintf(tw, "%d\t%s\t%d\t%s\n", p.ID, p.Payee, p.Amount, p.CreatedAt.Format("2006-01-02T15:04:05.000Z"))
+		}
+		return tw.Flush()
+
+	case "cancel":
+		if len(args) != 2 {
+			return usageError("cancel requires <payoutId>")
+		}
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return usageError("payoutId must be a number")
+		}
+		unpaid, err := database.ListUnpaidCreditSettlements(time.Now())
+		if err != nil {
+			return err
+		}
+		i := slices.IndexFunc(unpaid, func(p db.CreditPayout) bool { return p.ID == id })
+		if i < 0 {
+			return fmt.Errorf("no unpaid settlement %d", id)
+		}
+		if err := database.CancelCreditSettlement(id); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%d: %d satoshis owed to %s returned to pending\n", id, unpaid[i].Amount, unpaid[i].Payee)
+		return nil
+
+	default:
+		return usageError(fmt.Sprintf("unknown command %q", args[0]))
+	}
+}
@@ -72,26 +72,40 @@ const docTemplate = `{
                 }
             }
         },
-        "/devices": {
+        "/admin/notifications/failures": {
             "get": {
                 "security": [
                     {
                         "BSVAuth": []
                     }
                 ],
-                "description": "Returns all devices registered for push notifications for the authenticated identity.",
+                "description": "Returns failed push attempts grouped by provider and error code over a recent window. Restricted to identity keys listed in ADMIN_IDENTITY_KEYS.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Devices"
+                    "Admin"
+                ],
+                "summary": "Aggregate push notification failures (operators only)",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Window size in hours (1-720, default 24)",
+                        "name": "sinceHours",
+                        "in": "query"
+                    }
                 ],
-                "summary": "List registered devices",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/handlers.ListDevicesResponse"
+                            "$ref": "#/definitions/handlers.NotificationFailuresResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
                         }
                     },
                     "401": {
@@ -100,6 +114,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/handlers.ErrorResponse"
                         }
                     },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -109,40 +129,52 @@ const docTemplate = `{
                 }
             }
         },
-        "/listMessages": {
-            "post": {
+        "/admin/payments/revenue": {
+            "get": {
                 "security": [
                     {
                         "BSVAuth": []
                     }
                 ],
-                "description": "Returns all stored messages for the specified messageBox belonging to the authenticated identity. If the box does not exist or has no messages, an empty array is returned.",
-                "consumes": [
-                    "application/json"
-                ],
+                "description": "Sums the delivery fees internalized by the server wallet per message box. With kind=route_fee, sums the route prices charged by the payment middleware instead. Restricted to identity keys listed in ADMIN_IDENTITY_KEYS.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Messages"
+                    "Admin"
                 ],
-                "summary": "Retrieve messages from a message box",
+                "summary": "Delivery fee revenue (operators only)",
                 "parameters": [
                     {
-                        "description": "Message box to list messages from",
-                        "name": "request",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/handlers.ListMessagesRequest"
-                        }
+                        "type": "string",
+                        "description": "delivery_fee (default) or route_fee",
+                        "name": "kind",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only payments for this message box",
+                        "name": "messageBox",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only payments at or after this RFC 3339 time",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only payments before this RFC 3339 time",
+                        "name": "until",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/handlers.ListMessagesResponse"
+                            "$ref": "#/definitions/handlers.DeliveryFeeRevenueResponse"
                         }
                     },
                     "400": {
@@ -157,6 +189,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/handlers.ErrorResponse"
                         }
                     },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -166,41 +204,35 @@ const docTemplate = `{
                 }
             }
         },
-        "/permissions/get": {
-            "get": {
+        "/admin/reputation": {
+            "delete": {
                 "security": [
                     {
                         "BSVAuth": []
                     }
                 ],
-                "description": "Retrieves the permission setting for a specific sender or box-wide default for the authenticated identity.",
+                "description": "Deletes every spam report against a sender, restoring a good reputation, e.g. after reports turned out to be abusive.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Permissions"
+                    "Admin"
                 ],
-                "summary": "Get a message permission",
+                "summary": "Clear a sender's spam reports (operators only)",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Name of the message box",
-                        "name": "messageBox",
+                        "description": "Sender's public key",
+                        "name": "identityKey",
                         "in": "query",
                         "required": true
-                    },
-                    {
-                        "type": "string",
-                        "description": "Sender's public key (omit for box-wide setting)",
-                        "name": "sender",
-                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/handlers.GetPermissionResponse"
+                            "$ref": "#/definitions/handlers.ClearReputationResponse"
                         }
                     },
                     "400": {
@@ -215,6 +247,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/handlers.ErrorResponse"
                         }
                     },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -224,52 +262,42 @@ const docTemplate = `{
                 }
             }
         },
-        "/permissions/list": {
-            "get": {
+        "/admin/routePrices": {
+            "delete": {
                 "security": [
                     {
                         "BSVAuth": []
                     }
                 ],
-                "description": "Returns all permission settings for the authenticated identity, optionally filtered by message box.",
+                "description": "Removes the price of a route for a message box, which then falls back to the route's \"*\" price. Removing \"*\" makes boxes without their own price free.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Permissions"
+                    "Admin"
                 ],
-                "summary": "List message permissions",
+                "summary": "Remove a route price (operators only)",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Filter by message box name",
-                        "name": "messageBox",
-                        "in": "query"
-                    },
-                    {
-                        "type": "integer",
-                        "description": "Maximum number of results (1-1000, default 100)",
-                        "name": "limit",
-                        "in": "query"
-                    },
-                    {
-                        "type": "integer",
-                        "description": "Number of results to skip (default 0)",
-                        "name": "offset",
-                        "in": "query"
+                        "description": "Route, e.g. /listMessages",
+                        "name": "route",
+                        "in": "query",
+                        "required": true
                     },
                     {
                         "type": "string",
-                        "description": "Sort order: 'asc' or 'desc' (default 'desc')",
-                        "name": "createdAtOrder",
-                        "in": "query"
+                        "description": "Name of the message box, or * for the route's default price",
+                        "name": "messageBox",
+                        "in": "query",
+                        "required": true
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/handlers.ListPermissionsResponse"
+                            "$ref": "#/definitions/handlers.SetRoutePriceResponse"
                         }
                     },
                     "400": {
@@ -284,6 +312,18 @@ const docTemplate = `{
                             "$ref": "#/definitions/handlers.ErrorResponse"
                         }
                     },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -293,42 +333,40 @@ const docTemplate = `{
                 }
             }
         },
-        "/permissions/quote": {
-            "get": {
+        "/admin/routePrices/set": {
+            "post": {
                 "security": [
                     {
                         "BSVAuth": []
                     }
                 ],
-                "description": "Returns fee information for sending a message to one or more recipients. Single recipient returns QuoteSingleResponse, multiple recipients returns QuoteMultiResponse.",
+                "description": "Sets the satoshis charged per request to a route through the payment middleware, for a message box or \"*\" for boxes without their own price. Each caller gets freeRequests free requests per windowSeconds (default one day) before paying. A price of 0 makes the box free on that route.",
+                "consumes": [
+                    "application/json"
+                ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Permissions"
+                    "Admin"
                 ],
-                "summary": "Get a delivery quote",
+                "summary": "Price a route (operators only)",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Recipient public key (can be repeated for multiple recipients)",
-                        "name": "recipient",
-                        "in": "query",
-                        "required": true
-                    },
-                    {
-                        "type": "string",
-                        "description": "Name of the message box",
-                        "name": "messageBox",
-                        "in": "query",
-                        "required": true
+                        "description": "Price to set",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.SetRoutePriceRequest"
+                        }
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Multiple recipient quote",
+                        "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/handlers.QuoteMultiResponse"
+                            "$ref": "#/definitions/handlers.SetRoutePriceResponse"
                         }
                     },
                     "400": {
@@ -343,6 +381,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/handlers.ErrorResponse"
                         }
                     },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -352,40 +396,76 @@ const docTemplate = `{
                 }
             }
         },
-        "/permissions/set": {
-            "post": {
+        "/admin/serverFees": {
+            "get": {
                 "security": [
                     {
                         "BSVAuth": []
                     }
                 ],
-                "description": "Sets fee requirements for receiving messages. Use recipientFee=0 for free, recipientFee=-1 to block, or a positive value for required payment in satoshis. Omit sender for box-wide defaults.",
-                "consumes": [
+                "description": "Returns the delivery fee of every configured message box and the default fee charged for other boxes. Restricted to identity keys listed in ADMIN_IDENTITY_KEYS.",
+                "produces": [
                     "application/json"
                 ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "List server delivery fees (operators only)",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ListServerFeesResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Removes the delivery fee of a message box, which then falls back to the default fee. Removing \"*\" makes boxes without their own fee free.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Permissions"
+                    "Admin"
                 ],
-                "summary": "Set a message permission",
+                "summary": "Remove a server delivery fee (operators only)",
                 "parameters": [
                     {
-                        "description": "Permission settings",
-                        "name": "request",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/handlers.SetPermissionRequest"
-                        }
+                        "type": "string",
+                        "description": "Name of the message box, or * for the default fee",
+                        "name": "messageBox",
+                        "in": "query",
+                        "required": true
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/handlers.SetPermissionResponse"
+                            "$ref": "#/definitions/handlers.SetServerFeeResponse"
                         }
                     },
                     "400": {
@@ -400,6 +480,18 @@ const docTemplate = `{
                             "$ref": "#/definitions/handlers.ErrorResponse"
                         }
                     },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -409,40 +501,40 @@ const docTemplate = `{
                 }
             }
         },
-        "/registerDevice": {
-            "post": {
+        "/admin/serverFees/history": {
+            "get": {
                 "security": [
                     {
                         "BSVAuth": []
                     }
                 ],
-                "description": "Registers a device with an FCM token for receiving push notifications. Supports iOS, Android, and web platforms.",
-                "consumes": [
-                    "application/json"
-                ],
+                "description": "Returns the most recent server delivery fee changes, newest first, with who made them.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Devices"
+                    "Admin"
                 ],
-                "summary": "Register a device for push notifications",
+                "summary": "Server delivery fee history (operators only)",
                 "parameters": [
                     {
-                        "description": "Device registration details",
-                        "name": "request",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/handlers.RegisterDeviceRequest"
-                        }
+                        "type": "string",
+                        "description": "Only changes to this message box",
+                        "name": "messageBox",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum number of results (1-500, default 50)",
+                        "name": "limit",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/handlers.RegisterDeviceResponse"
+                            "$ref": "#/definitions/handlers.ServerFeeHistoryResponse"
                         }
                     },
                     "400": {
@@ -457,6 +549,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/handlers.ErrorResponse"
                         }
                     },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -466,14 +564,14 @@ const docTemplate = `{
                 }
             }
         },
-        "/sendMessage": {
+        "/admin/serverFees/set": {
             "post": {
                 "security": [
                     {
                         "BSVAuth": []
                     }
                 ],
-                "description": "Inserts a message into the target recipient's message box. Supports single or multiple recipients. Payment may be required depending on recipient's fee settings.",
+                "description": "Sets the delivery fee in satoshis charged by the server for a message box. Use messageBox \"*\" to set the default fee for boxes without their own fee. Every change is recorded in the fee history.",
                 "consumes": [
                     "application/json"
                 ],
@@ -481,17 +579,17 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "Messages"
+                    "Admin"
                 ],
-                "summary": "Send a message to recipient(s)",
+                "summary": "Set a server delivery fee (operators only)",
                 "parameters": [
                     {
-                        "description": "Message to send",
+                        "description": "Fee to set",
                         "name": "request",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/handlers.SendMessageRequest"
+                            "$ref": "#/definitions/handlers.SetServerFeeRequest"
                         }
                     }
                 ],
@@ -499,7 +597,7 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/handlers.SendMessageResponse"
+                            "$ref": "#/definitions/handlers.SetServerFeeResponse"
                         }
                     },
                     "400": {
@@ -517,7 +615,7 @@ const docTemplate = `{
                     "403": {
                         "description": "Forbidden",
                         "schema": {
-                            "$ref": "#/definitions/handlers.DeliveryBlockedError"
+                            "$ref": "#/definitions/handlers.ErrorResponse"
                         }
                     },
                     "500": {
@@ -528,23 +626,3443 @@ const docTemplate = `{
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "handlers.AcknowledgeMessageRequest": {
+        },
+        "/certificates": {
+            "get": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Returns the identity certificates the caller presented that have not expired, with the names of the fields they reveal to the server.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Messages"
+                ],
+                "summary": "List your identity certificates",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ListCertificatesResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Verifies BRC-52 identity certificates of the caller and keeps them, so recipients who require a certificate on a box (see /permissions/certificates/set) accept the caller's messages.\nEach certificate must be about the caller, signed by its certifier, and carry a keyring revealing at least one field to the server. A newer certificate of the same type from the same certifier replaces the earlier one.\nOnly the names of the revealed fields are kept, not their values. Certificates are kept until expiresAt; presenting a certificate again renews it. Certificates requested by the server during the auth handshake are kept the same way.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Messages"
+                ],
+                "summary": "Present identity certificates",
+                "parameters": [
+                    {
+                        "description": "Certificates to present",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.PresentCertificatesRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "All certificates of the caller",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ListCertificatesResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Deletes the caller's certificate with serialNumber, or all of the caller's certificates without it. Recipients who require a certificate then turn the caller away until they present one again.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Messages"
+                ],
+                "summary": "Delete your identity certificates",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Serial number of the certificate to delete",
+                        "name": "serialNumber",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.DeleteCertificatesResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/credits/balance": {
+            "get": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Returns the caller's credit balance and the recipient fees paid to the caller from other senders' credit that are waiting for the next settlement.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Credits"
+                ],
+                "summary": "Get credit balance",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.CreditBalanceResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/credits/deposit": {
+            "post": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Adds a payment to the caller's credit balance. Every output must be a wallet payment to the server whose remittance names the caller; the server wallet internalizes them all and credits their total.\nSends with useCredit then debit delivery and recipient fees from the balance instead of carrying a payment. Outputs can only be deposited once. If the depth of the payment transaction cannot be checked, the deposit fails with 503 ERR_PAYMENT_STATUS_UNAVAILABLE.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Credits"
+                ],
+                "summary": "Deposit credit",
+                "parameters": [
+                    {
+                        "description": "Payment to deposit",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.DepositCreditRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.DepositCreditResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/credits/ledger": {
+            "get": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Returns the deposits, fees, withdrawals and reversals of the caller's credit balance, newest first.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Credits"
+                ],
+                "summary": "List credit ledger",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Maximum number of results (1-500, default 50)",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.CreditLedgerResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/credits/payouts": {
+            "get": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Returns payments made to the caller from credit, newest first: settlements of recipient fees that senders paid from their credit, and the caller's withdrawals.\nEach payout includes the payment to pass to the wallet's internalizeAction.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Credits"
+                ],
+                "summary": "List credit payouts",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Maximum number of results (1-500, default 50)",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ListCreditPayoutsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/credits/withdraw": {
+            "post": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Pays unused credit back to the caller from the server wallet. The payout includes the payment to pass to the wallet's internalizeAction; it is also listed by /credits/payouts.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Credits"
+                ],
+                "summary": "Withdraw credit",
+                "parameters": [
+                    {
+                        "description": "Amount to withdraw, the whole balance by default",
+                        "name": "request",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.WithdrawCreditRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.WithdrawCreditResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "402": {
+                        "description": "Payment Required",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/devices": {
+            "get": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Returns all devices registered for push notifications for the authenticated identity.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Devices"
+                ],
+                "summary": "List registered devices",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ListDevicesResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/devices/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Removes a device registered by the authenticated identity so it no longer receives push notifications (e.g. on logout).",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Devices"
+                ],
+                "summary": "Unregister a device",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Device registration id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.SuccessResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Updates the deviceId and/or platform labels of a device registered by the authenticated identity. Omitted fields are left unchanged.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Devices"
+                ],
+                "summary": "Update device labels",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Device registration id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Labels to update",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.UpdateDeviceRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.UpdateDeviceResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/listMessages": {
+            "post": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Returns all stored messages for the specified messageBox belonging to the authenticated identity. If the box does not exist or has no messages, an empty array is returned.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Messages"
+                ],
+                "summary": "Retrieve messages from a message box",
+                "parameters": [
+                    {
+                        "description": "Message box to list messages from",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ListMessagesRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ListMessagesResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/notificationPayloads/get": {
+            "get": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Returns the payload settings used for pushes triggered by a message box. If nothing was stored the server defaults are returned with isDefault=true.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Notifications"
+                ],
+                "summary": "Get push notification payload settings for a message box",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Name of the message box",
+                        "name": "messageBox",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.GetNotificationPayloadResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/notificationPayloads/set": {
+            "post": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Sets title/body templates, unread badge, silent (data-only) mode, collapse key and TTL for pushes triggered by a message box. Templates use Go text/template syntax with fields .Sender, .SenderName, .MessageBox, .MessageID and .UnreadCount.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Notifications"
+                ],
+                "summary": "Configure push notification payloads for a message box",
+                "parameters": [
+                    {
+                        "description": "Payload settings",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.SetNotificationPayloadRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.SuccessResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/notificationPreferences/get": {
+            "get": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Returns the effective notification preference for a message box. If nothing was stored the server default is returned with isDefault=true.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Notifications"
+                ],
+                "summary": "Get push notification preferences for a message box",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Name of the message box",
+                        "name": "messageBox",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.GetNotificationPreferenceResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/notificationPreferences/list": {
+            "get": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Returns all notification preferences stored for the authenticated identity. Boxes without a stored preference use the server default.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Notifications"
+                ],
+                "summary": "List push notification preferences",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ListNotificationPreferencesResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/notificationPreferences/set": {
+            "post": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Chooses whether messages arriving in a message box trigger push notifications: \"none\", \"always\", or \"allowlist\" (only from allowedSenders). Optional quiet hours suppress pushes during a daily window in the given timezone.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Notifications"
+                ],
+                "summary": "Set push notification preferences for a message box",
+                "parameters": [
+                    {
+                        "description": "Notification preference",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.SetNotificationPreferenceRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.SuccessResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/notifications/deliveries": {
+            "get": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Returns the most recent push attempts to the authenticated identity's devices, including provider, status, error code and latency. Useful to debug missing notifications.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Notifications"
+                ],
+                "summary": "List recent push notification attempts",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Maximum number of results (1-500, default 50)",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ListNotificationDeliveriesResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/payments/earnings": {
+            "get": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Returns the recipient fees senders paid to the authenticated identity, newest first, with the total over the whole period (not only the returned page).\nThese payments are relayed inside the stored messages; amount is what the outputs paid, which may exceed the fee.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Payments"
+                ],
+                "summary": "List recipient fees earned",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Only payments for this message box",
+                        "name": "messageBox",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only payments at or after this RFC 3339 time",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only payments before this RFC 3339 time",
+                        "name": "until",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum number of results (1-500, default 50)",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ListEarningsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/payments/refunds": {
+            "get": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Returns refunds of fees the caller paid as a sender, newest first: delivery fees the server returned because a message could not be stored, and recipient fees returned by recipients who rejected a message.\nEach sent refund includes the payment to pass to the wallet's internalizeAction.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Payments"
+                ],
+                "summary": "List refunds paid to the caller",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Maximum number of results (1-500, default 50)",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ListRefundsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/payments/reject": {
+            "post": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Returns the recipient fee a sender paid with a message. The server never holds recipient fees, so the caller provides the refund: a payment from their wallet whose \"wallet payment\" outputs pay the original sender at least the amount received and name the caller as senderIdentityKey.\nEach refund output refunds one payment only; outputs already used fail with 409 ERR_PAYMENT_REPLAYED. The refund is recorded, listed to the sender by /payments/refunds, and the message is deleted if it was not acknowledged yet. The server delivery fee is not refunded, since the message was delivered. A subscription bought with the message is revoked.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Payments"
+                ],
+                "summary": "Reject a paid message and refund its sender",
+                "parameters": [
+                    {
+                        "description": "Message to reject and the refund",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.RejectPaymentRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.RejectPaymentResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/permissions": {
+            "delete": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Deletes the permission for a specific sender, or the box-wide setting when sender is omitted. The next message falls back to the remaining rules.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Permissions"
+                ],
+                "summary": "Delete a message permission",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Name of the message box (or pattern)",
+                        "name": "messageBox",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Sender's public key (omit for box-wide setting)",
+                        "name": "sender",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.DeletePermissionResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/permissions/bulkSet": {
+            "post": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Upserts up to 1000 permissions in one transaction. Every entry is validated first; if any entry is invalid, nothing is applied and the errors are returned per entry index.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Permissions"
+                ],
+                "summary": "Set many message permissions",
+                "parameters": [
+                    {
+                        "description": "Permissions to set",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.BulkSetPermissionsRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.BulkSetPermissionsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.BulkSetPermissionsError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/permissions/certificates/list": {
+            "get": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Returns the certificate requirements of the authenticated identity.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Permissions"
+                ],
+                "summary": "List certificate requirements",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ListCertificateRequirementsResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/permissions/certificates/set": {
+            "post": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Asks senders into a box for a BRC-52 identity certificate (see /certificates) from one of certifiers, optionally of certificateType and revealing fields to the server.\nWithout verifiedFee, senders without such a certificate cannot deliver (ERR_CERTIFICATE_REQUIRED). With verifiedFee, they pay the box's usual fee and verified senders pay verifiedFee when it is lower.\nSender-specific permissions are the recipient's own choice and bypass the requirement. Use an empty certifiers list to remove it.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Permissions"
+                ],
+                "summary": "Require an identity certificate on a message box",
+                "parameters": [
+                    {
+                        "description": "Certificate requirement",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.SetCertificateRequirementRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.SetCertificateRequirementResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/permissions/export": {
+            "get": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Returns all permissions of the authenticated identity as JSON (default) or CSV, in the /permissions/set format so they can be re-applied with /permissions/bulkSet.\nExpired or used-up rules are left out; capped rules are exported with their remaining message count.",
+                "produces": [
+                    "application/json",
+                    "text/csv"
+                ],
+                "tags": [
+                    "Permissions"
+                ],
+                "summary": "Export message permissions",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Export format: 'json' or 'csv' (default 'json')",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ExportPermissionsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/permissions/get": {
+            "get": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Retrieves the permission setting for a specific sender or box-wide default for the authenticated identity.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Permissions"
+                ],
+                "summary": "Get a message permission",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Name of the message box",
+                        "name": "messageBox",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Sender's public key (omit for box-wide setting)",
+                        "name": "sender",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.GetPermissionResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/permissions/list": {
+            "get": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Returns all permission settings for the authenticated identity, optionally filtered by message box.\nWhen filtered by message box, pattern rules matching the box are included in precedence order and the rule that applies for each sender is marked effective.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Permissions"
+                ],
+                "summary": "List message permissions",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Filter by message box name",
+                        "name": "messageBox",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum number of results (1-1000, default 100)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Number of results to skip (default 0)",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Sort order: 'asc' or 'desc' (default 'desc')",
+                        "name": "createdAtOrder",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ListPermissionsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/permissions/quote": {
+            "get": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Returns fee information for sending a message to one or more recipients. Single recipient returns QuoteSingleResponse, multiple recipients returns QuoteMultiResponse.\nWhen a time-bounded or usage-capped sender rule applies, permissionExpiresAt and remainingMessages are included.\nWhen a rate limit applies, rateLimit reports the remaining messages in the current window.\nWhen the sender's reputation raised a recipient fee, reputation names the level (see /reputation). Banned senders fail with 403 ERR_SENDER_BANNED.\nWhen the recipient offers a subscription to the box, subscription reports its price and duration; sendMessage with subscribe buys it instead of paying recipientFee.\nWhen the recipient's rule requires a proof of work, powDifficulty reports its leading zero bits (see sendMessage's proofOfWork).\nWhen the recipient requires an identity certificate on the box, certificate reports the accepted certifiers, type and fields, the fee for verified senders, and whether the sender is verified; senders who cannot deliver without one have status certificate_required.\nRecipient fees are computed for bodySize; when a recipient uses size-based pricing, baseRecipientFee and pricing are included.\nThe response carries a quoteId signed by the server that sendMessage accepts until quoteExpiresAt to pay exactly the quoted fees. It covers bodies up to bodySize bytes.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Permissions"
+                ],
+                "summary": "Get a delivery quote",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Recipient public key (can be repeated for multiple recipients)",
+                        "name": "recipient",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Name of the message box",
+                        "name": "messageBox",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Size in bytes of the message body to be sent, used for size-based pricing (default 0)",
+                        "name": "bodySize",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Multiple recipient quote",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.QuoteMultiResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/permissions/rateLimits/list": {
+            "get": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Returns all rate limits of the authenticated identity, optionally filtered by message box.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Permissions"
+                ],
+                "summary": "List message rate limits",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Filter by message box name",
+                        "name": "messageBox",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ListRateLimitsResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/permissions/rateLimits/set": {
+            "post": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Limits how many messages a sender may deliver into a message box per time window. Omit sender to limit all senders together. Use maxMessages=0 to remove the limit.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Permissions"
+                ],
+                "summary": "Set a message rate limit",
+                "parameters": [
+                    {
+                        "description": "Rate limit settings",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.SetRateLimitRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.SetRateLimitResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/permissions/set": {
+            "post": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Sets fee requirements for receiving messages. Use recipientFee=0 for free, recipientFee=-1 to block, or a positive value for required payment in satoshis. Omit sender for box-wide defaults.\nSender-specific rules may set expiresAt and/or maxMessages; once either is reached the box-wide default applies again.\nfeePerKb, largePayloadThreshold and largePayloadFee add size-based charges on top of recipientFee.\npowDifficulty makes every message also carry a proof of work with that many leading zero bits (see sendMessage's proofOfWork), a cost for senders into free boxes that needs no payment.\nmessageBox may be a glob pattern (e.g. \"app.*\") matching many boxes. Rules are resolved from most to least specific:\nsender + exact box, sender + pattern, box-wide exact, box-wide pattern; among patterns the longer literal prefix wins.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Permissions"
+                ],
+                "summary": "Set a message permission",
+                "parameters": [
+                    {
+                        "description": "Permission settings",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.SetPermissionRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.SetPermissionResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/permissions/subscriptions/list": {
+            "get": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Returns the subscription offers of the authenticated identity.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Permissions"
+                ],
+                "summary": "List subscription offers",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ListSubscriptionOffersResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/permissions/subscriptions/set": {
+            "post": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Sells senders free access to a message box: a sender who sends with subscribe pays price once instead of the per-message fee, and may then message the box for free for durationDays. Buying again while a subscription runs extends it. Use price=0 to remove the offer; subscriptions already bought keep running.\nA subscription is kept apart from permissions, so the recipient's own rules for the sender still apply once it ends, and a rule blocking the sender also wins while it runs. Rejecting the paying message with /payments/reject revokes the subscription. The server delivery fee still applies to every message.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Permissions"
+                ],
+                "summary": "Set a subscription offer",
+                "parameters": [
+                    {
+                        "description": "Subscription offer",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.SetSubscriptionOfferRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.SetSubscriptionOfferResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/registerDevice": {
+            "post": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Registers a device with an FCM token for receiving push notifications. Supports iOS, Android, and web platforms.\nA token already registered to another identity is rejected (409), or, under the challenge policy, a nonce is pushed to the device (202)\nand the registration must be resubmitted with challengeNonce to transfer ownership. Both identities are notified of a transfer.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Devices"
+                ],
+                "summary": "Register a device for push notifications",
+                "parameters": [
+                    {
+                        "description": "Device registration details",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.RegisterDeviceRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.RegisterDeviceResponse"
+                        }
+                    },
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.DeviceChallengeResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "502": {
+                        "description": "Bad Gateway",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/report": {
+            "post": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Reports a message in one of the caller's boxes as spam and deletes it. Reports against a sender from different recipients count toward the sender's reputation (see /reputation):\ndepending on the server's thresholds, their box-wide and default recipient fees are raised, payment is required even into free boxes, or they are banned from sending. Each message can be reported once; reporting it again returns 409 even though the message is gone.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Messages"
+                ],
+                "summary": "Report a message as spam",
+                "parameters": [
+                    {
+                        "description": "Message to report",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ReportSpamRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ReportSpamResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/reputation": {
+            "get": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Returns the caller's reputation as a sender: how many different recipients reported their messages as spam within the server's window, and what that costs them.\nraised_fees multiplies box-wide and default recipient fees by feeMultiplier, payment_required also charges at least minRecipientFee per recipient, and banned rejects sends and quotes with ERR_SENDER_BANNED. Thresholds are omitted when disabled.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Messages"
+                ],
+                "summary": "Get your sender reputation",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ReputationResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/routePrices": {
+            "get": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Returns the satoshis the payment middleware charges per request to each priced route and message box, and each route's free tier. Prices for \"*\" apply to boxes without their own price. Unpriced routes are free.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Payments"
+                ],
+                "summary": "List route prices",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ListRoutePricesResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/sendMessage": {
+            "post": {
+                "security": [
+                    {
+                        "BSVAuth": []
+                    }
+                ],
+                "description": "Inserts a message into the target recipient's message box. Supports single or multiple recipients. Payment may be required depending on recipient's fee settings.\nA quoteId from /permissions/quote locks the quoted fees for the same sender, box and recipients and a body no larger than the quoted bodySize. Each quote can be used once; invalid, expired, mismatched or reused quotes fail with ERR_INVALID_QUOTE, ERR_QUOTE_EXPIRED, ERR_QUOTE_MISMATCH or 409 ERR_QUOTE_USED. Blocks and rate limits still apply.\nA quoted fee holds only while the sender permission or subscription that priced it still applies; once it expired or its message cap was used up, the send fails with 409 ERR_QUOTE_STALE.\nA messageId repeated in the send or already stored fails with ERR_DUPLICATE_MESSAGE before any fee is taken.\nMessages to all recipients are stored together or not at all. A capped sender permission or rate limit used up by a concurrent send fails the send with 409 ERR_PERMISSION_USED_UP or 429 ERR_RATE_LIMITED. If storing fails after the server took its delivery fee, the fee is refunded to the sender and the error (DeliveryFailedError) carries the refund.\nWith useCredit the delivery and recipient fees are debited from the sender's credit balance (see /credits/deposit) instead of paid by a transaction; a balance that does not cover them fails with 402 ERR_INSUFFICIENT_CREDIT. Debits of a send that is not stored are returned to the balance.\nWith subscribe the sender buys each recipient's subscription offer (see /permissions/subscriptions/set): the offer price replaces the per-message recipient fee, and the sender may then message the box for free until subscribedUntil. Recipients without an offer fail with ERR_NO_SUBSCRIPTION_OFFER; subscribe cannot be combined with quoteId.\nRecipients whose permission sets powDifficulty (see /permissions/quote) require proofOfWork[recipient] to be a nonce of at most 64 characters such that\nsha256(sender + \":\" + recipient + \":\" + messageBox + \":\" + messageId + \":\" + nonce) starts with powDifficulty zero bits; missing or insufficient proofs fail with ERR_PROOF_OF_WORK_REQUIRED. Each proof is accepted once: resending an acknowledged messageId with the same nonce fails with 409 ERR_PROOF_OF_WORK_USED. Subscribing replaces the proof.\nRecipients who require an identity certificate on the box (see /permissions/certificates/set) turn away senders without one with 403 ERR_CERTIFICATE_REQUIRED, or charge verified senders a lower fee.\nRecipient fees of senders reported as spam are raised according to their reputation (see /reputation); banned senders fail with 403 ERR_SENDER_BANNED.\nPayment outputs are checked against the transaction before anything is stored; recipients whose outputs pay less than their fee are listed in a 400 ERR_INSUFFICIENT_PAYMENT error (InsufficientPaymentError).\nEach payment output pays for one send only. Outputs already used by another message, or a payment transaction already PAYMENT_REPLAY_CONFIRMATIONS blocks deep, fail with 409 ERR_PAYMENT_REPLAYED. If the depth cannot be checked, the send fails with 503 ERR_PAYMENT_STATUS_UNAVAILABLE.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Messages"
+                ],
+                "summary": "Send a message to recipient(s)",
+                "parameters": [
+                    {
+                        "description": "Message to send",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.SendMessageRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.SendMessageResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "402": {
+                        "description": "Payment Required",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.DeliveryBlockedError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.RateLimitedError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "handlers.AcknowledgeMessageRequest": {
             "description": "Request to acknowledge (delete) messages",
             "type": "object",
             "properties": {
-                "messageIds": {
+                "messageIds": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "handlers.BulkPermissionEntryError": {
+            "description": "Validation error for one bulk permission entry",
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string",
+                    "example": "ERR_INVALID_PUBLIC_KEY"
+                },
+                "description": {
+                    "type": "string",
+                    "example": "Invalid sender public key format."
+                },
+                "index": {
+                    "type": "integer",
+                    "example": 3
+                }
+            }
+        },
+        "handlers.BulkSetPermissionsError": {
+            "description": "Error response listing invalid bulk permission entries",
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string",
+                    "example": "ERR_INVALID_ENTRIES"
+                },
+                "description": {
+                    "type": "string",
+                    "example": "2 of 120 entries are invalid; no permissions were changed."
+                },
+                "errors": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/handlers.BulkPermissionEntryError"
+                    }
+                },
+                "status": {
+                    "type": "string",
+                    "example": "error"
+                }
+            }
+        },
+        "handlers.BulkSetPermissionsRequest": {
+            "description": "Request to upsert many permissions at once",
+            "type": "object",
+            "properties": {
+                "permissions": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/handlers.SetPermissionRequest"
+                    }
+                }
+            }
+        },
+        "handlers.BulkSetPermissionsResponse": {
+            "description": "Response after upserting many permissions",
+            "type": "object",
+            "properties": {
+                "applied": {
+                    "type": "integer",
+                    "example": 120
+                },
+                "status": {
+                    "type": "string",
+                    "example": "success"
+                }
+            }
+        },
+        "handlers.CertificateDetail": {
+            "description": "Identity certificate details; field values are not returned",
+            "type": "object",
+            "properties": {
+                "certificateType": {
+                    "type": "string",
+                    "example": "z40BOInXkI8m7f/wBrv4MJ09bZfzZbTj2fJqCtONqCY="
+                },
+                "certifier": {
+                    "type": "string",
+                    "example": "02abc..."
+                },
+                "expiresAt": {
+                    "type": "string",
+                    "example": "2024-01-31T12:00:00.000Z"
+                },
+                "fields": {
+                    "description": "fields revealed to the server",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "serialNumber": {
+                    "type": "string",
+                    "example": "KyZP1tZn1kTAQkWvuuZMF2B9HnCPwNjaDBczYCpEbdU="
+                },
+                "updatedAt": {
+                    "type": "string",
+                    "example": "2024-01-01T12:00:00.000Z"
+                }
+            }
+        },
+        "handlers.CertificateQuote": {
+            "description": "Certificate the recipient asks of senders and whether the sender has presented one",
+            "type": "object",
+            "properties": {
+                "certificateType": {
+                    "type": "string",
+                    "example": "z40BOInXkI8m7f/wBrv4MJ09bZfzZbTj2fJqCtONqCY="
+                },
+                "certifiers": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "fields": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "verified": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "verifiedFee": {
+                    "description": "omitted when unverified senders cannot deliver",
+                    "type": "integer",
+                    "example": 0
+                }
+            }
+        },
+        "handlers.CertificateRequirementDetail": {
+            "description": "Certificate requirement details",
+            "type": "object",
+            "properties": {
+                "certificateType": {
+                    "type": "string",
+                    "example": "z40BOInXkI8m7f/wBrv4MJ09bZfzZbTj2fJqCtONqCY="
+                },
+                "certifiers": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "fields": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "messageBox": {
+                    "type": "string",
+                    "example": "business"
+                },
+                "updatedAt": {
+                    "type": "string",
+                    "example": "2024-01-01T12:00:00.000Z"
+                },
+                "verifiedFee": {
+                    "type": "integer",
+                    "example": 0
+                }
+            }
+        },
+        "handlers.ClearReputationResponse": {
+            "description": "Number of spam reports deleted",
+            "type": "object",
+            "properties": {
+                "clearedReports": {
+                    "type": "integer",
+                    "example": 4
+                },
+                "status": {
+                    "type": "string",
+                    "example": "success"
+                }
+            }
+        },
+        "handlers.CreditBalanceResponse": {
+            "description": "Credit balance of the caller and recipient fees owed to it",
+            "type": "object",
+            "properties": {
+                "balance": {
+                    "type": "integer",
+                    "example": 1000
+                },
+                "pendingEarnings": {
+                    "description": "recipient fees paid from credit, settled in the next batch",
+                    "type": "integer",
+                    "example": 30
+                },
+                "status": {
+                    "type": "string",
+                    "example": "success"
+                }
+            }
+        },
+        "handlers.CreditEntryOut": {
+            "description": "A credit or debit of a credit balance",
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "description": "negative for debits",
+                    "type": "integer",
+                    "example": -10
+                },
+                "balanceAfter": {
+                    "type": "integer",
+                    "example": 990
+                },
+                "createdAt": {
+                    "type": "string",
+                    "example": "2024-01-01T12:00:00.000Z"
+                },
+                "id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "kind": {
+                    "description": "deposit, delivery_fee, recipient_fee, withdrawal or reversal",
+                    "type": "string",
+                    "example": "recipient_fee"
+                },
+                "messageBox": {
+                    "type": "string",
+                    "example": "inbox"
+                },
+                "messageId": {
+                    "type": "string",
+                    "example": "msg-123"
+                },
+                "recipient": {
+                    "type": "string",
+                    "example": "02a1b2..."
+                },
+                "settlementStatus": {
+                    "description": "held, pending, settled or reversed",
+                    "type": "string",
+                    "example": "pending"
+                },
+                "txid": {
+                    "type": "string",
+                    "example": "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"
+                }
+            }
+        },
+        "handlers.CreditLedgerResponse": {
+            "description": "Credit ledger of the caller, newest first",
+            "type": "object",
+            "properties": {
+                "entries": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/handlers.CreditEntryOut"
+                    }
+                },
+                "status": {
+                    "type": "string",
+                    "example": "success"
+                }
+            }
+        },
+        "handlers.CreditPayoutOut": {
+            "description": "A settlement of recipient fees or a withdrawal; payment is passed to internalizeAction by the payee",
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "type": "integer",
+                    "example": 120
+                },
+                "createdAt": {
+                    "type": "string",
+                    "example": "2024-01-01T12:00:00.000Z"
+                },
+                "id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "kind": {
+                    "description": "\"settlement\" or \"withdrawal\"",
+                    "type": "string",
+                    "example": "settlement"
+                },
+                "payment": {
+                    "$ref": "#/definitions/handlers.Payment"
+                },
+                "txid": {
+                    "type": "string",
+                    "example": "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"
+                }
+            }
+        },
+        "handlers.DeleteCertificatesResponse": {
+            "description": "Number of identity certificates deleted",
+            "type": "object",
+            "properties": {
+                "deletedCertificates": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "status": {
+                    "type": "string",
+                    "example": "success"
+                }
+            }
+        },
+        "handlers.DeletePermissionResponse": {
+            "description": "Response after deleting a permission",
+            "type": "object",
+            "properties": {
+                "description": {
+                    "type": "string",
+                    "example": "Permission for sender 03abc... to inbox deleted."
+                },
+                "status": {
+                    "type": "string",
+                    "example": "success"
+                }
+            }
+        },
+        "handlers.DeliveryBlockedError": {
+            "description": "Error response when delivery is blocked for some recipients",
+            "type": "object",
+            "properties": {
+                "blockedRecipients": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "code": {
+                    "type": "string",
+                    "example": "ERR_DELIVERY_BLOCKED"
+                },
+                "description": {
+                    "type": "string",
+                    "example": "Blocked recipients: 03abc..."
+                },
+                "status": {
+                    "type": "string",
+                    "example": "error"
+                }
+            }
+        },
+        "handlers.DeliveryFailureOut": {
+            "description": "Aggregated push failures for one provider and error code",
+            "type": "object",
+            "properties": {
+                "count": {
+                    "type": "integer",
+                    "example": 12
+                },
+                "errorCode": {
+                    "type": "string",
+                    "example": "UNREGISTERED"
+                },
+                "provider": {
+                    "type": "string",
+                    "example": "fcm"
+                }
+            }
+        },
+        "handlers.DeliveryFeeRevenueResponse": {
+            "description": "Delivery fees internalized by the server wallet",
+            "type": "object",
+            "properties": {
+                "byMessageBox": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/handlers.RevenueByBoxOut"
+                    }
+                },
+                "status": {
+                    "type": "string",
+                    "example": "success"
+                },
+                "totalAmount": {
+                    "type": "integer",
+                    "example": 400
+                },
+                "totalCount": {
+                    "type": "integer",
+                    "example": 40
+                }
+            }
+        },
+        "handlers.DepositCreditRequest": {
+            "type": "object"
+        },
+        "handlers.DepositCreditResponse": {
+            "description": "Result of a credit deposit",
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "type": "integer",
+                    "example": 1000
+                },
+                "balance": {
+                    "type": "integer",
+                    "example": 1000
+                },
+                "status": {
+                    "type": "string",
+                    "example": "success"
+                },
+                "txid": {
+                    "type": "string",
+                    "example": "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"
+                }
+            }
+        },
+        "handlers.DeviceChallengeResponse": {
+            "description": "Response when a device ownership challenge has been pushed to the device",
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string",
+                    "example": "ERR_DEVICE_CHALLENGE_REQUIRED"
+                },
+                "description": {
+                    "type": "string",
+                    "example": "This token is registered to another identity. A challenge was pushed to the device; resubmit with challengeNonce."
+                },
+                "expiresAt": {
+                    "type": "string",
+                    "example": "2024-01-01T00:10:00.000Z"
+                },
+                "status": {
+                    "type": "string",
+                    "example": "error"
+                }
+            }
+        },
+        "handlers.DeviceOut": {
+            "description": "Device registration object",
+            "type": "object",
+            "properties": {
+                "active": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "createdAt": {
+                    "type": "string",
+                    "example": "2024-01-01T12:00:00.000Z"
+                },
+                "deviceId": {
+                    "type": "string",
+                    "example": "device-abc"
+                },
+                "fcmToken": {
+                    "type": "string",
+                    "example": "...abc123"
+                },
+                "id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "lastUsed": {
+                    "type": "string",
+                    "example": "2024-01-01T12:00:00.000Z"
+                },
+                "platform": {
+                    "type": "string",
+                    "example": "ios"
+                },
+                "updatedAt": {
+                    "type": "string",
+                    "example": "2024-01-01T12:00:00.000Z"
+                }
+            }
+        },
+        "handlers.ErrorResponse": {
+            "description": "Error response with status, code, and description",
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string",
+                    "example": "ERR_INVALID_REQUEST"
+                },
+                "description": {
+                    "type": "string",
+                    "example": "Invalid request parameters"
+                },
+                "status": {
+                    "type": "string",
+                    "example": "error"
+                }
+            }
+        },
+        "handlers.ExportPermissionsResponse": {
+            "description": "Exported permissions, re-applicable with bulkSet",
+            "type": "object",
+            "properties": {
+                "exportedAt": {
+                    "type": "string",
+                    "example": "2024-01-01T12:00:00.000Z"
+                },
+                "permissions": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/handlers.SetPermissionRequest"
+                    }
+                },
+                "status": {
+                    "type": "string",
+                    "example": "success"
+                }
+            }
+        },
+        "handlers.GetNotificationPayloadResponse": {
+            "description": "Response containing push payload settings for a message box",
+            "type": "object",
+            "properties": {
+                "payload": {
+                    "$ref": "#/definitions/handlers.NotificationPayloadDetail"
+                },
+                "status": {
+                    "type": "string",
+                    "example": "success"
+                }
+            }
+        },
+        "handlers.GetNotificationPreferenceResponse": {
+            "description": "Response containing the effective notification preference for a message box",
+            "type": "object",
+            "properties": {
+                "preference": {
+                    "$ref": "#/definitions/handlers.NotificationPreferenceDetail"
+                },
+                "status": {
+                    "type": "string",
+                    "example": "success"
+                }
+            }
+        },
+        "handlers.GetPermissionResponse": {
+            "description": "Response containing permission details",
+            "type": "object",
+            "properties": {
+                "description": {
+                    "type": "string",
+                    "example": "Permission setting found."
+                },
+                "permission": {
+                    "$ref": "#/definitions/handlers.PermissionDetail"
+                },
+                "status": {
+                    "type": "string",
+                    "example": "success"
+                }
+            }
+        },
+        "handlers.ListCertificateRequirementsResponse": {
+            "description": "List of the caller's certificate requirements",
+            "type": "object",
+            "properties": {
+                "requirements": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/handlers.CertificateRequirementDetail"
+                    }
+                },
+                "status": {
+                    "type": "string",
+                    "example": "success"
+                }
+            }
+        },
+        "handlers.ListCertificatesResponse": {
+            "description": "Identity certificates the caller presented",
+            "type": "object",
+            "properties": {
+                "certificates": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/handlers.CertificateDetail"
+                    }
+                },
+                "description": {
+                    "type": "string",
+                    "example": "1 certificate(s) verified."
+                },
+                "status": {
+                    "type": "string",
+                    "example": "success"
+                }
+            }
+        },
+        "handlers.ListCreditPayoutsResponse": {
+            "description": "Payouts made to the caller, newest first",
+            "type": "object",
+            "properties": {
+                "payouts": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/handlers.CreditPayoutOut"
+                    }
+                },
+                "status": {
+                    "type": "string",
+                    "example": "success"
+                }
+            }
+        },
+        "handlers.ListDevicesResponse": {
+            "description": "Response containing list of devices",
+            "type": "object",
+            "properties": {
+                "devices": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/handlers.DeviceOut"
+                    }
+                },
+                "status": {
+                    "type": "string",
+                    "example": "success"
+                }
+            }
+        },
+        "handlers.ListEarningsResponse": {
+            "description": "Recipient fees earned by the caller",
+            "type": "object",
+            "properties": {
+                "payments": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/handlers.PaymentOut"
+                    }
+                },
+                "status": {
+                    "type": "string",
+                    "example": "success"
+                },
+                "totalAmount": {
+                    "type": "integer",
+                    "example": 1200
+                },
+                "totalCount": {
+                    "type": "integer",
+                    "example": 12
+                }
+            }
+        },
+        "handlers.ListMessagesRequest": {
+            "description": "Request to list messages from a message box",
+            "type": "object",
+            "properties": {
+                "messageBox": {
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.ListMessagesResponse": {
+            "description": "Response containing list of messages",
+            "type": "object",
+            "properties": {
+                "messages": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/handlers.MessageOut"
+                    }
+                },
+                "status": {
+                    "type": "string",
+                    "example": "success"
+                }
+            }
+        },
+        "handlers.ListNotificationDeliveriesResponse": {
+            "description": "Response containing recent push attempts for the caller's devices",
+            "type": "object",
+            "properties": {
+                "deliveries": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/handlers.NotificationDeliveryOut"
+                    }
+                },
+                "status": {
+                    "type": "string",
+                    "example": "success"
+                }
+            }
+        },
+        "handlers.ListNotificationPreferencesResponse": {
+            "description": "Response containing all stored notification preferences",
+            "type": "object",
+            "properties": {
+                "preferences": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/handlers.NotificationPreferenceDetail"
+                    }
+                },
+                "status": {
+                    "type": "string",
+                    "example": "success"
+                }
+            }
+        },
+        "handlers.ListPermissionsResponse": {
+            "description": "Response containing list of permissions",
+            "type": "object",
+            "properties": {
+                "permissions": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/handlers.PermissionDetailList"
+                    }
+                },
+                "status": {
+                    "type": "string",
+                    "example": "success"
+                },
+                "totalCount": {
+                    "type": "integer",
+                    "example": 10
+                }
+            }
+        },
+        "handlers.ListRateLimitsResponse": {
+            "description": "Response containing rate limits",
+            "type": "object",
+            "properties": {
+                "rateLimits": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/handlers.RateLimitDetail"
+                    }
+                },
+                "status": {
+                    "type": "string",
+                    "example": "success"
+                }
+            }
+        },
+        "handlers.ListRefundsResponse": {
+            "description": "Refunds paid to the caller",
+            "type": "object",
+            "properties": {
+                "refunds": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/handlers.RefundOut"
+                    }
+                },
+                "status": {
+                    "type": "string",
+                    "example": "success"
+                }
+            }
+        },
+        "handlers.ListRoutePricesResponse": {
+            "description": "Prices charged by the payment middleware per route and box",
+            "type": "object",
+            "properties": {
+                "prices": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/handlers.RoutePriceOut"
+                    }
+                },
+                "routes": {
+                    "description": "routes that can be priced",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "status": {
+                    "type": "string",
+                    "example": "success"
+                }
+            }
+        },
+        "handlers.ListServerFeesResponse": {
+            "description": "Server delivery fees per box and the default for other boxes",
+            "type": "object",
+            "properties": {
+                "defaultFee": {
+                    "description": "null when boxes without a fee are free",
+                    "type": "integer",
+                    "example": 0
+                },
+                "fees": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/handlers.ServerFeeOut"
+                    }
+                },
+                "status": {
+                    "type": "string",
+                    "example": "success"
+                }
+            }
+        },
+        "handlers.ListSubscriptionOffersResponse": {
+            "description": "List of the caller's subscription offers",
+            "type": "object",
+            "properties": {
+                "offers": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/handlers.SubscriptionOfferDetail"
+                    }
+                },
+                "status": {
+                    "type": "string",
+                    "example": "success"
+                }
+            }
+        },
+        "handlers.MessageOut": {
+            "description": "Message object returned by listMessages",
+            "type": "object",
+            "properties": {
+                "body": {
+                    "type": "string",
+                    "example": "{\"text\":\"Hello\"}"
+                },
+                "createdAt": {
+                    "type": "string",
+                    "example": "2024-01-01T12:00:00.000Z"
+                },
+                "messageId": {
+                    "type": "string",
+                    "example": "abc123"
+                },
+                "sender": {
+                    "type": "string",
+                    "example": "03abc..."
+                },
+                "updatedAt": {
+                    "type": "string",
+                    "example": "2024-01-01T12:00:00.000Z"
+                }
+            }
+        },
+        "handlers.NotificationDeliveryOut": {
+            "description": "Push notification delivery attempt for one device",
+            "type": "object",
+            "properties": {
+                "createdAt": {
+                    "type": "string",
+                    "example": "2024-01-01T12:00:00.000Z"
+                },
+                "deviceRegistrationId": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "errorCode": {
+                    "type": "string",
+                    "example": "UNREGISTERED"
+                },
+                "errorMessage": {
+                    "type": "string",
+                    "example": "Requested entity was not found."
+                },
+                "id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "latencyMs": {
+                    "type": "integer",
+                    "example": 120
+                },
+                "messageBox": {
+                    "type": "string",
+                    "example": "notifications"
+                },
+                "messageId": {
+                    "type": "string",
+                    "example": "msg-123"
+                },
+                "provider": {
+                    "type": "string",
+                    "example": "fcm"
+                },
+                "status": {
+                    "type": "string",
+                    "example": "failed"
+                }
+            }
+        },
+        "handlers.NotificationFailuresResponse": {
+            "description": "Aggregated push failures by error type",
+            "type": "object",
+            "properties": {
+                "failures": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/handlers.DeliveryFailureOut"
+                    }
+                },
+                "since": {
+                    "type": "string",
+                    "example": "2024-01-01T12:00:00.000Z"
+                },
+                "status": {
+                    "type": "string",
+                    "example": "success"
+                },
+                "total": {
+                    "type": "integer",
+                    "example": 12
+                }
+            }
+        },
+        "handlers.NotificationPayloadDetail": {
+            "description": "Push notification payload settings for a message box",
+            "type": "object",
+            "properties": {
+                "badge": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "bodyTemplate": {
+                    "type": "string",
+                    "example": "New message in {{.MessageBox}}"
+                },
+                "collapseKey": {
+                    "type": "string",
+                    "example": "inbox"
+                },
+                "isDefault": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "messageBox": {
+                    "type": "string",
+                    "example": "inbox"
+                },
+                "silent": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "titleTemplate": {
+                    "type": "string",
+                    "example": "{{.SenderName}}"
+                },
+                "ttlSeconds": {
+                    "type": "integer",
+                    "example": 86400
+                }
+            }
+        },
+        "handlers.NotificationPreferenceDetail": {
+            "description": "Push notification preference for a message box",
+            "type": "object",
+            "properties": {
+                "allowedSenders": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "createdAt": {
+                    "type": "string",
+                    "example": "2024-01-01T12:00:00.000Z"
+                },
+                "isDefault": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "messageBox": {
+                    "type": "string",
+                    "example": "inbox"
+                },
+                "mode": {
+                    "type": "string",
+                    "example": "allowlist"
+                },
+                "quietHours": {
+                    "$ref": "#/definitions/handlers.QuietHours"
+                },
+                "updatedAt": {
+                    "type": "string",
+                    "example": "2024-01-01T12:00:00.000Z"
+                }
+            }
+        },
+        "handlers.Payment": {
+            "type": "object"
+        },
+        "handlers.PaymentOut": {
+            "description": "A fee paid by a sender for one message",
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "description": "satoshis paid",
+                    "type": "integer",
+                    "example": 100
+                },
+                "createdAt": {
+                    "type": "string",
+                    "example": "2024-01-01T12:00:00.000Z"
+                },
+                "fee": {
+                    "description": "satoshis required",
+                    "type": "integer",
+                    "example": 100
+                },
+                "id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "messageBox": {
+                    "type": "string",
+                    "example": "inbox"
+                },
+                "messageId": {
+                    "type": "string",
+                    "example": "msg-123"
+                },
+                "sender": {
+                    "type": "string",
+                    "example": "028d37b941208cd6b8a4c28288eda5f2f16c2b3ab0fcb6d13c18b47fe37b971fc1"
+                },
+                "status": {
+                    "type": "string",
+                    "example": "relayed"
+                },
+                "txid": {
+                    "type": "string",
+                    "example": "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"
+                }
+            }
+        },
+        "handlers.PermissionDetail": {
+            "description": "Permission details (camelCase for getPermission endpoint)",
+            "type": "object",
+            "properties": {
+                "createdAt": {
+                    "type": "string",
+                    "example": "2024-01-01T12:00:00.000Z"
+                },
+                "expiresAt": {
+                    "type": "string",
+                    "example": "2024-01-08T00:00:00.000Z"
+                },
+                "feePerKb": {
+                    "type": "integer",
+                    "example": 2
+                },
+                "isPattern": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "largePayloadFee": {
+                    "type": "integer",
+                    "example": 500
+                },
+                "largePayloadThreshold": {
+                    "type": "integer",
+                    "example": 65536
+                },
+                "maxMessages": {
+                    "type": "integer",
+                    "example": 5
+                },
+                "messageBox": {
+                    "type": "string",
+                    "example": "inbox"
+                },
+                "messagesUsed": {
+                    "type": "integer",
+                    "example": 2
+                },
+                "powDifficulty": {
+                    "type": "integer",
+                    "example": 20
+                },
+                "recipientFee": {
+                    "type": "integer",
+                    "example": 100
+                },
+                "sender": {
+                    "type": "string",
+                    "example": "03abc..."
+                },
+                "status": {
+                    "type": "string",
+                    "example": "payment_required"
+                },
+                "updatedAt": {
+                    "type": "string",
+                    "example": "2024-01-01T12:00:00.000Z"
+                }
+            }
+        },
+        "handlers.PermissionDetailList": {
+            "description": "Permission details (snake_case for listPermissions endpoint)",
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string",
+                    "example": "2024-01-01T12:00:00.000Z"
+                },
+                "effective": {
+                    "description": "only set when filtering by messageBox",
+                    "type": "boolean",
+                    "example": true
+                },
+                "expires_at": {
+                    "type": "string",
+                    "example": "2024-01-08T00:00:00.000Z"
+                },
+                "fee_per_kb": {
+                    "description": "size-based pricing, omitted for flat fees",
+                    "type": "integer",
+                    "example": 2
+                },
+                "is_pattern": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "large_payload_fee": {
+                    "type": "integer",
+                    "example": 500
+                },
+                "large_payload_threshold": {
+                    "type": "integer",
+                    "example": 65536
+                },
+                "max_messages": {
+                    "type": "integer",
+                    "example": 5
+                },
+                "message_box": {
+                    "type": "string",
+                    "example": "inbox"
+                },
+                "messages_used": {
+                    "type": "integer",
+                    "example": 2
+                },
+                "pow_difficulty": {
+                    "type": "integer",
+                    "example": 20
+                },
+                "recipient_fee": {
+                    "type": "integer",
+                    "example": 100
+                },
+                "sender": {
+                    "type": "string",
+                    "example": "03abc..."
+                },
+                "updated_at": {
+                    "type": "string",
+                    "example": "2024-01-01T12:00:00.000Z"
+                }
+            }
+        },
+        "handlers.PresentCertificatesRequest": {
+            "description": "BRC-52 identity certificates of the caller, each with a keyring revealing fields to the server",
+            "type": "object",
+            "properties": {
+                "certificates": {
                     "type": "array",
                     "items": {
-                        "type": "string"
+                        "type": "object"
                     }
                 }
             }
         },
-        "handlers.DeliveryBlockedError": {
-            "description": "Error response when delivery is blocked for some recipients",
+        "handlers.QuietHours": {
+            "description": "Daily quiet hours window in the identity's timezone",
+            "type": "object",
+            "properties": {
+                "end": {
+                    "description": "HH:MM, may be earlier than start to wrap midnight",
+                    "type": "string",
+                    "example": "07:00"
+                },
+                "start": {
+                    "description": "HH:MM",
+                    "type": "string",
+                    "example": "22:00"
+                },
+                "timezone": {
+                    "description": "IANA timezone name",
+                    "type": "string",
+                    "example": "Europe/London"
+                }
+            }
+        },
+        "handlers.QuoteEntry": {
+            "description": "Quote for one recipient in batch",
+            "type": "object",
+            "properties": {
+                "baseRecipientFee": {
+                    "description": "Base fee and size-based pricing behind recipientFee, set when the price depends on the body size",
+                    "type": "integer",
+                    "example": 10
+                },
+                "certificate": {
+                    "description": "Identity certificate the recipient asks of senders, see /certificates",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/handlers.CertificateQuote"
+                        }
+                    ]
+                },
+                "defaultPolicy": {
+                    "description": "operator default that set the fee when no rule matched",
+                    "type": "string",
+                    "example": "notifications"
+                },
+                "deliveryFee": {
+                    "type": "integer",
+                    "example": 10
+                },
+                "matchedRule": {
+                    "description": "pattern of the rule that set the fee",
+                    "type": "string",
+                    "example": "app.*"
+                },
+                "messageBox": {
+                    "type": "string",
+                    "example": "inbox"
+                },
+                "permissionExpiresAt": {
+                    "type": "string",
+                    "example": "2024-01-08T00:00:00.000Z"
+                },
+                "powDifficulty": {
+                    "description": "Leading zero bits the proof of work for this recipient needs, see sendMessage's proofOfWork",
+                    "type": "integer",
+                    "example": 20
+                },
+                "pricing": {
+                    "$ref": "#/definitions/handlers.SizePricingDetail"
+                },
+                "rateLimit": {
+                    "$ref": "#/definitions/handlers.RateLimitQuote"
+                },
+                "recipient": {
+                    "type": "string",
+                    "example": "03abc..."
+                },
+                "recipientFee": {
+                    "type": "integer",
+                    "example": 100
+                },
+                "remainingMessages": {
+                    "type": "integer",
+                    "example": 3
+                },
+                "reputation": {
+                    "description": "Sender reputation that raised the fee, omitted when good (see /reputation)",
+                    "type": "string",
+                    "example": "raised_fees"
+                },
+                "status": {
+                    "type": "string",
+                    "example": "payment_required"
+                },
+                "subscription": {
+                    "description": "Free access the sender can buy instead of paying per message, see sendMessage's subscribe",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/handlers.SubscriptionQuote"
+                        }
+                    ]
+                }
+            }
+        },
+        "handlers.QuoteMultiResponse": {
+            "description": "Response containing quotes for multiple recipients",
             "type": "object",
             "properties": {
                 "blockedRecipients": {
@@ -553,13 +4071,224 @@ const docTemplate = `{
                         "type": "string"
                     }
                 },
+                "description": {
+                    "type": "string",
+                    "example": "Message delivery quotes generated for 2 recipients."
+                },
+                "quoteExpiresAt": {
+                    "type": "string",
+                    "example": "2024-01-01T12:05:00.000Z"
+                },
+                "quoteId": {
+                    "type": "string",
+                    "example": "eyJpZCI6Ij...MEQCIF..."
+                },
+                "quotesByRecipient": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/handlers.QuoteEntry"
+                    }
+                },
+                "status": {
+                    "type": "string",
+                    "example": "success"
+                },
+                "totals": {
+                    "$ref": "#/definitions/handlers.QuoteTotals"
+                }
+            }
+        },
+        "handlers.QuoteSingle": {
+            "description": "Quote for single recipient",
+            "type": "object",
+            "properties": {
+                "baseRecipientFee": {
+                    "description": "Base fee and size-based pricing behind recipientFee, set when the price depends on the body size",
+                    "type": "integer",
+                    "example": 10
+                },
+                "certificate": {
+                    "description": "Identity certificate the recipient asks of senders, see /certificates",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/handlers.CertificateQuote"
+                        }
+                    ]
+                },
+                "defaultPolicy": {
+                    "description": "operator default that set the fee when no rule matched",
+                    "type": "string",
+                    "example": "notifications"
+                },
+                "deliveryFee": {
+                    "type": "integer",
+                    "example": 10
+                },
+                "matchedRule": {
+                    "description": "pattern of the rule that set the fee",
+                    "type": "string",
+                    "example": "app.*"
+                },
+                "permissionExpiresAt": {
+                    "type": "string",
+                    "example": "2024-01-08T00:00:00.000Z"
+                },
+                "powDifficulty": {
+                    "description": "Leading zero bits the proof of work for this recipient needs, see sendMessage's proofOfWork",
+                    "type": "integer",
+                    "example": 20
+                },
+                "pricing": {
+                    "$ref": "#/definitions/handlers.SizePricingDetail"
+                },
+                "rateLimit": {
+                    "$ref": "#/definitions/handlers.RateLimitQuote"
+                },
+                "recipientFee": {
+                    "description": "for the quoted bodySize",
+                    "type": "integer",
+                    "example": 100
+                },
+                "remainingMessages": {
+                    "type": "integer",
+                    "example": 3
+                },
+                "reputation": {
+                    "description": "Sender reputation that raised the fee, omitted when good (see /reputation)",
+                    "type": "string",
+                    "example": "raised_fees"
+                },
+                "subscription": {
+                    "description": "Free access the sender can buy instead of paying per message, see sendMessage's subscribe",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/handlers.SubscriptionQuote"
+                        }
+                    ]
+                }
+            }
+        },
+        "handlers.QuoteSingleResponse": {
+            "description": "Response containing quote for single recipient",
+            "type": "object",
+            "properties": {
+                "description": {
+                    "type": "string",
+                    "example": "Message delivery quote generated."
+                },
+                "quote": {
+                    "$ref": "#/definitions/handlers.QuoteSingle"
+                },
+                "quoteExpiresAt": {
+                    "type": "string",
+                    "example": "2024-01-01T12:05:00.000Z"
+                },
+                "quoteId": {
+                    "type": "string",
+                    "example": "eyJpZCI6Ij...MEQCIF..."
+                },
+                "status": {
+                    "type": "string",
+                    "example": "success"
+                }
+            }
+        },
+        "handlers.QuoteTotals": {
+            "description": "Totals for batch quote",
+            "type": "object",
+            "properties": {
+                "deliveryFees": {
+                    "type": "integer",
+                    "example": 20
+                },
+                "recipientFees": {
+                    "type": "integer",
+                    "example": 200
+                },
+                "totalForPayableRecipients": {
+                    "type": "integer",
+                    "example": 220
+                }
+            }
+        },
+        "handlers.RateLimitDetail": {
+            "description": "Rate limit details",
+            "type": "object",
+            "properties": {
+                "createdAt": {
+                    "type": "string",
+                    "example": "2024-01-01T12:00:00.000Z"
+                },
+                "maxMessages": {
+                    "type": "integer",
+                    "example": 10
+                },
+                "messageBox": {
+                    "type": "string",
+                    "example": "inbox"
+                },
+                "sender": {
+                    "type": "string",
+                    "example": "03abc..."
+                },
+                "updatedAt": {
+                    "type": "string",
+                    "example": "2024-01-01T12:00:00.000Z"
+                },
+                "windowSeconds": {
+                    "type": "integer",
+                    "example": 3600
+                }
+            }
+        },
+        "handlers.RateLimitQuote": {
+            "description": "Rate limit state for the sender in the current window",
+            "type": "object",
+            "properties": {
+                "limit": {
+                    "type": "integer",
+                    "example": 10
+                },
+                "remaining": {
+                    "type": "integer",
+                    "example": 4
+                },
+                "resetAt": {
+                    "type": "string",
+                    "example": "2024-01-01T13:00:00.000Z"
+                },
+                "retryAfterSeconds": {
+                    "description": "set when remaining is 0",
+                    "type": "integer",
+                    "example": 120
+                },
+                "windowSeconds": {
+                    "type": "integer",
+                    "example": 3600
+                }
+            }
+        },
+        "handlers.RateLimitedError": {
+            "description": "Error response when delivery is rate limited for some recipients",
+            "type": "object",
+            "properties": {
                 "code": {
                     "type": "string",
-                    "example": "ERR_DELIVERY_BLOCKED"
+                    "example": "ERR_RATE_LIMITED"
                 },
                 "description": {
                     "type": "string",
-                    "example": "Blocked recipients: 03abc..."
+                    "example": "Rate limit exceeded for recipients: 03abc..."
+                },
+                "rateLimitedRecipients": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "retryAfterSeconds": {
+                    "type": "integer",
+                    "example": 120
                 },
                 "status": {
                     "type": "string",
@@ -567,87 +4296,269 @@ const docTemplate = `{
                 }
             }
         },
-        "handlers.DeviceOut": {
-            "description": "Device registration object",
+        "handlers.RefundOut": {
+            "description": "A refund of a delivery or recipient fee; payment is passed to internalizeAction by the sender",
             "type": "object",
             "properties": {
-                "active": {
-                    "type": "boolean",
-                    "example": true
+                "amount": {
+                    "type": "integer",
+                    "example": 10
                 },
                 "createdAt": {
                     "type": "string",
                     "example": "2024-01-01T12:00:00.000Z"
                 },
-                "deviceId": {
+                "id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "messageBox": {
                     "type": "string",
-                    "example": "device-abc"
+                    "example": "inbox"
                 },
-                "fcmToken": {
+                "messageId": {
                     "type": "string",
-                    "example": "...abc123"
+                    "example": "msg-123"
                 },
-                "id": {
-                    "type": "integer",
-                    "example": 1
+                "payer": {
+                    "description": "server or recipient identity key",
+                    "type": "string",
+                    "example": "02a1b2..."
                 },
-                "lastUsed": {
+                "payment": {
+                    "$ref": "#/definitions/handlers.Payment"
+                },
+                "reason": {
+                    "description": "\"delivery_failed\" or \"rejected_by_recipient\"",
                     "type": "string",
-                    "example": "2024-01-01T12:00:00.000Z"
+                    "example": "delivery_failed"
+                },
+                "status": {
+                    "description": "\"sent\" or \"failed\"",
+                    "type": "string",
+                    "example": "sent"
+                },
+                "txid": {
+                    "type": "string",
+                    "example": "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"
+                }
+            }
+        },
+        "handlers.RegisterDeviceRequest": {
+            "description": "Request to register a device for push notifications",
+            "type": "object",
+            "properties": {
+                "challengeNonce": {
+                    "description": "Nonce pushed to the device when the token is owned by another identity",
+                    "type": "string"
+                },
+                "deviceId": {
+                    "type": "string"
+                },
+                "fcmToken": {
+                    "type": "string"
                 },
                 "platform": {
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.RegisterDeviceResponse": {
+            "description": "Response after registering a device",
+            "type": "object",
+            "properties": {
+                "deviceId": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "message": {
                     "type": "string",
-                    "example": "ios"
+                    "example": "Device registered successfully for push notifications"
                 },
-                "updatedAt": {
+                "status": {
                     "type": "string",
-                    "example": "2024-01-01T12:00:00.000Z"
+                    "example": "success"
                 }
             }
         },
-        "handlers.ErrorResponse": {
-            "description": "Error response with status, code, and description",
+        "handlers.RejectPaymentRequest": {
+            "description": "Request to reject a paid message and return its fee to the sender",
             "type": "object",
             "properties": {
-                "code": {
+                "messageId": {
                     "type": "string",
-                    "example": "ERR_INVALID_REQUEST"
+                    "example": "msg-123"
+                },
+                "refund": {
+                    "description": "payment from the caller's wallet to the original sender",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/handlers.Payment"
+                        }
+                    ]
+                }
+            }
+        },
+        "handlers.RejectPaymentResponse": {
+            "description": "The recorded refund of a rejected message",
+            "type": "object",
+            "properties": {
+                "refund": {
+                    "$ref": "#/definitions/handlers.RefundOut"
                 },
+                "status": {
+                    "type": "string",
+                    "example": "success"
+                }
+            }
+        },
+        "handlers.ReportSpamRequest": {
+            "description": "Request to report a received message as spam",
+            "type": "object",
+            "properties": {
+                "messageId": {
+                    "type": "string",
+                    "example": "msg-123"
+                }
+            }
+        },
+        "handlers.ReportSpamResponse": {
+            "description": "Result of a spam report",
+            "type": "object",
+            "properties": {
                 "description": {
                     "type": "string",
-                    "example": "Invalid request parameters"
+                    "example": "Message msg-123 from 03abc... was reported as spam and deleted."
+                },
+                "sender": {
+                    "type": "string",
+                    "example": "03abc..."
                 },
                 "status": {
                     "type": "string",
-                    "example": "error"
+                    "example": "success"
                 }
             }
         },
-        "handlers.GetPermissionResponse": {
-            "description": "Response containing permission details",
+        "handlers.ReputationResponse": {
+            "description": "Sender reputation of the caller and the thresholds that change it",
             "type": "object",
             "properties": {
-                "description": {
+                "banAt": {
+                    "type": "integer",
+                    "example": 10
+                },
+                "feeMultiplier": {
+                    "type": "integer",
+                    "example": 10
+                },
+                "minRecipientFee": {
+                    "type": "integer",
+                    "example": 100
+                },
+                "raiseFeesAt": {
+                    "description": "Reporters at which each level starts, omitted when disabled",
+                    "type": "integer",
+                    "example": 3
+                },
+                "reporters": {
+                    "description": "different recipients who reported the caller within the window",
+                    "type": "integer",
+                    "example": 1
+                },
+                "reputation": {
                     "type": "string",
-                    "example": "Permission setting found."
+                    "enum": [
+                        "good",
+                        "raised_fees",
+                        "payment_required",
+                        "banned"
+                    ],
+                    "example": "good"
                 },
-                "permission": {
-                    "$ref": "#/definitions/handlers.PermissionDetail"
+                "requirePaymentAt": {
+                    "type": "integer",
+                    "example": 5
                 },
                 "status": {
                     "type": "string",
                     "example": "success"
+                },
+                "windowSeconds": {
+                    "type": "integer",
+                    "example": 2592000
                 }
             }
         },
-        "handlers.ListDevicesResponse": {
-            "description": "Response containing list of devices",
+        "handlers.RevenueByBoxOut": {
+            "description": "Delivery fee revenue of one message box",
             "type": "object",
             "properties": {
-                "devices": {
+                "amount": {
+                    "type": "integer",
+                    "example": 400
+                },
+                "count": {
+                    "type": "integer",
+                    "example": 40
+                },
+                "messageBox": {
+                    "type": "string",
+                    "example": "notifications"
+                }
+            }
+        },
+        "handlers.RoutePriceOut": {
+            "description": "Satoshis charged per request to a route, after the free tier",
+            "type": "object",
+            "properties": {
+                "freeRequests": {
+                    "type": "integer",
+                    "example": 100
+                },
+                "messageBox": {
+                    "description": "\"*\" for boxes without their own price",
+                    "type": "string",
+                    "example": "inbox"
+                },
+                "price": {
+                    "type": "integer",
+                    "example": 5
+                },
+                "route": {
+                    "type": "string",
+                    "example": "/listMessages"
+                },
+                "updatedAt": {
+                    "type": "string",
+                    "example": "2024-01-01T12:00:00.000Z"
+                },
+                "windowSeconds": {
+                    "type": "integer",
+                    "example": 86400
+                }
+            }
+        },
+        "handlers.SendMessageRequest": {
+            "type": "object"
+        },
+        "handlers.SendMessageResponse": {
+            "description": "Response after sending message(s)",
+            "type": "object",
+            "properties": {
+                "creditBalance": {
+                    "description": "Credit balance left after the fees were debited; only set with useCredit",
+                    "type": "integer",
+                    "example": 990
+                },
+                "message": {
+                    "type": "string",
+                    "example": "Your message has been sent to 1 recipient(s)."
+                },
+                "results": {
                     "type": "array",
                     "items": {
-                        "$ref": "#/definitions/handlers.DeviceOut"
+                        "$ref": "#/definitions/handlers.SendMessageResult"
                     }
                 },
                 "status": {
@@ -656,89 +4567,235 @@ const docTemplate = `{
                 }
             }
         },
-        "handlers.ListMessagesRequest": {
-            "description": "Request to list messages from a message box",
+        "handlers.SendMessageResult": {
+            "description": "Result for a single recipient",
+            "type": "object",
+            "properties": {
+                "messageId": {
+                    "type": "string",
+                    "example": "msg-123"
+                },
+                "recipient": {
+                    "type": "string",
+                    "example": "03abc..."
+                },
+                "subscribedUntil": {
+                    "description": "End of the sender's free access to the box; only set with subscribe",
+                    "type": "string",
+                    "example": "2024-01-31T12:00:00.000Z"
+                }
+            }
+        },
+        "handlers.ServerFeeChangeOut": {
+            "description": "Audit entry for a server delivery fee change",
             "type": "object",
             "properties": {
+                "changedBy": {
+                    "type": "string",
+                    "example": "03abc..."
+                },
+                "createdAt": {
+                    "type": "string",
+                    "example": "2024-01-01T12:00:00.000Z"
+                },
+                "id": {
+                    "type": "integer",
+                    "example": 1
+                },
                 "messageBox": {
-                    "type": "string"
+                    "type": "string",
+                    "example": "notifications"
+                },
+                "newFee": {
+                    "description": "null when the fee was removed",
+                    "type": "integer",
+                    "example": 10
+                },
+                "previousFee": {
+                    "description": "null when the fee was created",
+                    "type": "integer",
+                    "example": 5
                 }
             }
         },
-        "handlers.ListMessagesResponse": {
-            "description": "Response containing list of messages",
+        "handlers.ServerFeeHistoryResponse": {
+            "description": "Recent server delivery fee changes",
             "type": "object",
             "properties": {
-                "messages": {
+                "changes": {
                     "type": "array",
                     "items": {
-                        "$ref": "#/definitions/handlers.MessageOut"
+                        "$ref": "#/definitions/handlers.ServerFeeChangeOut"
                     }
                 },
                 "status": {
                     "type": "string",
-                    "example": "success"
+                    "example": "success"
+                }
+            }
+        },
+        "handlers.ServerFeeOut": {
+            "description": "Server delivery fee for a message box",
+            "type": "object",
+            "properties": {
+                "deliveryFee": {
+                    "type": "integer",
+                    "example": 10
+                },
+                "messageBox": {
+                    "type": "string",
+                    "example": "notifications"
+                },
+                "updatedAt": {
+                    "type": "string",
+                    "example": "2024-01-01T12:00:00.000Z"
+                }
+            }
+        },
+        "handlers.SetCertificateRequirementRequest": {
+            "description": "Request to require an identity certificate of senders into a message box",
+            "type": "object",
+            "properties": {
+                "certificateType": {
+                    "description": "base64 type ID, omit for any type",
+                    "type": "string",
+                    "example": "z40BOInXkI8m7f/wBrv4MJ09bZfzZbTj2fJqCtONqCY="
+                },
+                "certifiers": {
+                    "description": "accepted certifier keys, empty removes the requirement",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "fields": {
+                    "description": "fields the certificate must reveal to the server",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "messageBox": {
+                    "type": "string",
+                    "example": "business"
+                },
+                "verifiedFee": {
+                    "description": "fee for verified senders, omit to turn unverified senders away",
+                    "type": "integer",
+                    "example": 0
                 }
             }
         },
-        "handlers.ListPermissionsResponse": {
-            "description": "Response containing list of permissions",
+        "handlers.SetCertificateRequirementResponse": {
+            "description": "Result of setting a certificate requirement",
             "type": "object",
             "properties": {
-                "permissions": {
-                    "type": "array",
-                    "items": {
-                        "$ref": "#/definitions/handlers.PermissionDetail"
-                    }
+                "description": {
+                    "type": "string",
+                    "example": "Only senders with a certificate from one of 1 certifier(s) can now deliver to business."
                 },
                 "status": {
                     "type": "string",
                     "example": "success"
-                },
-                "totalCount": {
-                    "type": "integer",
-                    "example": 10
                 }
             }
         },
-        "handlers.MessageOut": {
-            "description": "Message object returned by listMessages",
+        "handlers.SetNotificationPayloadRequest": {
+            "description": "Request to configure push notification payloads for a message box",
             "type": "object",
             "properties": {
-                "body": {
+                "badge": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "bodyTemplate": {
                     "type": "string",
-                    "example": "{\"text\":\"Hello\"}"
+                    "example": "New message in {{.MessageBox}}"
                 },
-                "createdAt": {
+                "collapseKey": {
                     "type": "string",
-                    "example": "2024-01-01T12:00:00.000Z"
+                    "example": "inbox"
                 },
-                "messageId": {
+                "messageBox": {
                     "type": "string",
-                    "example": "abc123"
+                    "example": "inbox"
                 },
-                "sender": {
+                "silent": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "titleTemplate": {
                     "type": "string",
-                    "example": "03abc..."
+                    "example": "{{.SenderName}}"
                 },
-                "updatedAt": {
+                "ttlSeconds": {
+                    "type": "integer",
+                    "example": 86400
+                }
+            }
+        },
+        "handlers.SetNotificationPreferenceRequest": {
+            "description": "Request to set push notification preferences for a message box",
+            "type": "object",
+            "properties": {
+                "allowedSenders": {
+                    "description": "Required for \"allowlist\" mode",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "messageBox": {
                     "type": "string",
-                    "example": "2024-01-01T12:00:00.000Z"
+                    "example": "inbox"
+                },
+                "mode": {
+                    "description": "\"none\", \"always\" or \"allowlist\"",
+                    "type": "string",
+                    "example": "allowlist"
+                },
+                "quietHours": {
+                    "$ref": "#/definitions/handlers.QuietHours"
                 }
             }
         },
-        "handlers.PermissionDetail": {
-            "description": "Permission details",
+        "handlers.SetPermissionRequest": {
+            "description": "Request to set a permission",
             "type": "object",
             "properties": {
-                "createdAt": {
+                "expiresAt": {
+                    "description": "Optional limits for sender-specific rules; once either is reached the box-wide default applies",
                     "type": "string",
-                    "example": "2024-01-01T12:00:00.000Z"
+                    "example": "2024-01-08T00:00:00.000Z"
+                },
+                "feePerKb": {
+                    "description": "Optional size-based pricing added on top of recipientFee",
+                    "type": "integer",
+                    "example": 2
+                },
+                "largePayloadFee": {
+                    "description": "satoshis for bodies above the threshold",
+                    "type": "integer",
+                    "example": 500
+                },
+                "largePayloadThreshold": {
+                    "description": "bytes",
+                    "type": "integer",
+                    "example": 65536
+                },
+                "maxMessages": {
+                    "type": "integer",
+                    "example": 5
                 },
                 "messageBox": {
                     "type": "string",
                     "example": "inbox"
                 },
+                "powDifficulty": {
+                    "description": "Optional leading zero bits every message must prove work for, see sendMessage's proofOfWork",
+                    "type": "integer",
+                    "example": 20
+                },
                 "recipientFee": {
                     "type": "integer",
                     "example": 100
@@ -746,22 +4803,29 @@ const docTemplate = `{
                 "sender": {
                     "type": "string",
                     "example": "03abc..."
-                },
-                "status": {
+                }
+            }
+        },
+        "handlers.SetPermissionResponse": {
+            "description": "Response after setting a permission",
+            "type": "object",
+            "properties": {
+                "description": {
                     "type": "string",
-                    "example": "payment_required"
+                    "example": "Messages from sender to inbox now require 100 satoshis."
                 },
-                "updatedAt": {
+                "status": {
                     "type": "string",
-                    "example": "2024-01-01T12:00:00.000Z"
+                    "example": "success"
                 }
             }
         },
-        "handlers.QuoteEntry": {
-            "description": "Quote for one recipient in batch",
+        "handlers.SetRateLimitRequest": {
+            "description": "Request to limit how many messages a sender (or all senders) may deliver per time window",
             "type": "object",
             "properties": {
-                "deliveryFee": {
+                "maxMessages": {
+                    "description": "0 removes the limit",
                     "type": "integer",
                     "example": 10
                 },
@@ -769,73 +4833,108 @@ const docTemplate = `{
                     "type": "string",
                     "example": "inbox"
                 },
-                "recipient": {
+                "sender": {
+                    "description": "omit to limit all senders together",
                     "type": "string",
                     "example": "03abc..."
                 },
-                "recipientFee": {
+                "windowSeconds": {
+                    "description": "default 3600",
                     "type": "integer",
-                    "example": 100
+                    "example": 3600
+                }
+            }
+        },
+        "handlers.SetRateLimitResponse": {
+            "description": "Response after setting or removing a rate limit",
+            "type": "object",
+            "properties": {
+                "description": {
+                    "type": "string",
+                    "example": "Messages from sender to inbox are now limited to 10 per 3600 seconds."
                 },
                 "status": {
                     "type": "string",
-                    "example": "payment_required"
+                    "example": "success"
                 }
             }
         },
-        "handlers.QuoteMultiResponse": {
-            "description": "Response containing quotes for multiple recipients",
+        "handlers.SetRoutePriceRequest": {
+            "description": "Request to price a route for a message box (\"*\" sets the route's default price)",
             "type": "object",
             "properties": {
-                "blockedRecipients": {
-                    "type": "array",
-                    "items": {
-                        "type": "string"
-                    }
+                "freeRequests": {
+                    "description": "free requests per caller in each window",
+                    "type": "integer",
+                    "example": 100
                 },
-                "description": {
+                "messageBox": {
                     "type": "string",
-                    "example": "Message delivery quotes generated for 2 recipients."
+                    "example": "inbox"
                 },
-                "quotesByRecipient": {
-                    "type": "array",
-                    "items": {
-                        "$ref": "#/definitions/handlers.QuoteEntry"
-                    }
+                "price": {
+                    "type": "integer",
+                    "example": 5
+                },
+                "route": {
+                    "type": "string",
+                    "example": "/listMessages"
+                },
+                "windowSeconds": {
+                    "description": "free tier window, default one day",
+                    "type": "integer",
+                    "example": 86400
+                }
+            }
+        },
+        "handlers.SetRoutePriceResponse": {
+            "description": "Result of a route price change",
+            "type": "object",
+            "properties": {
+                "routePrice": {
+                    "description": "omitted after removal",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/handlers.RoutePriceOut"
+                        }
+                    ]
                 },
                 "status": {
                     "type": "string",
                     "example": "success"
-                },
-                "totals": {
-                    "$ref": "#/definitions/handlers.QuoteTotals"
                 }
             }
         },
-        "handlers.QuoteSingle": {
-            "description": "Quote for single recipient",
+        "handlers.SetServerFeeRequest": {
+            "description": "Request to set the server delivery fee of a message box (\"*\" sets the default fee)",
             "type": "object",
             "properties": {
                 "deliveryFee": {
                     "type": "integer",
                     "example": 10
                 },
-                "recipientFee": {
-                    "type": "integer",
-                    "example": 100
+                "messageBox": {
+                    "type": "string",
+                    "example": "notifications"
                 }
             }
         },
-        "handlers.QuoteSingleResponse": {
-            "description": "Response containing quote for single recipient",
+        "handlers.SetServerFeeResponse": {
+            "description": "Result of a server delivery fee change",
             "type": "object",
             "properties": {
-                "description": {
+                "deliveryFee": {
+                    "description": "null after removal",
+                    "type": "integer",
+                    "example": 10
+                },
+                "messageBox": {
                     "type": "string",
-                    "example": "Message delivery quote generated."
+                    "example": "notifications"
                 },
-                "quote": {
-                    "$ref": "#/definitions/handlers.QuoteSingle"
+                "previousFee": {
+                    "type": "integer",
+                    "example": 5
                 },
                 "status": {
                     "type": "string",
@@ -843,119 +4942,124 @@ const docTemplate = `{
                 }
             }
         },
-        "handlers.QuoteTotals": {
-            "description": "Totals for batch quote",
+        "handlers.SetSubscriptionOfferRequest": {
+            "description": "Request to sell senders free access to a message box for a number of days",
             "type": "object",
             "properties": {
-                "deliveryFees": {
+                "durationDays": {
+                    "description": "free access per purchase, default 30 days",
                     "type": "integer",
-                    "example": 20
+                    "example": 30
                 },
-                "recipientFees": {
-                    "type": "integer",
-                    "example": 200
+                "messageBox": {
+                    "type": "string",
+                    "example": "inbox"
                 },
-                "totalForPayableRecipients": {
+                "price": {
+                    "description": "satoshis per purchase, 0 removes the offer",
                     "type": "integer",
-                    "example": 220
+                    "example": 5000
                 }
             }
         },
-        "handlers.RegisterDeviceRequest": {
-            "description": "Request to register a device for push notifications",
+        "handlers.SetSubscriptionOfferResponse": {
+            "description": "Response after setting or removing a subscription offer",
             "type": "object",
             "properties": {
-                "deviceId": {
-                    "type": "string"
-                },
-                "fcmToken": {
-                    "type": "string"
+                "description": {
+                    "type": "string",
+                    "example": "Senders can now buy 30 days of free messages to inbox for 5000 satoshis."
                 },
-                "platform": {
-                    "type": "string"
+                "status": {
+                    "type": "string",
+                    "example": "success"
                 }
             }
         },
-        "handlers.RegisterDeviceResponse": {
-            "description": "Response after registering a device",
+        "handlers.SizePricingDetail": {
+            "description": "Size-based pricing, omitted for flat fees",
             "type": "object",
             "properties": {
-                "deviceId": {
+                "feePerKb": {
                     "type": "integer",
-                    "example": 1
+                    "example": 2
                 },
-                "message": {
-                    "type": "string",
-                    "example": "Device registered successfully for push notifications"
+                "largePayloadFee": {
+                    "type": "integer",
+                    "example": 500
                 },
-                "status": {
-                    "type": "string",
-                    "example": "success"
+                "largePayloadThreshold": {
+                    "type": "integer",
+                    "example": 65536
                 }
             }
         },
-        "handlers.SendMessageRequest": {
-            "type": "object"
-        },
-        "handlers.SendMessageResponse": {
-            "description": "Response after sending message(s)",
+        "handlers.SubscriptionOfferDetail": {
+            "description": "Subscription offer details",
             "type": "object",
             "properties": {
-                "message": {
+                "durationDays": {
+                    "type": "integer",
+                    "example": 30
+                },
+                "messageBox": {
                     "type": "string",
-                    "example": "Your message has been sent to 1 recipient(s)."
+                    "example": "inbox"
                 },
-                "results": {
-                    "type": "array",
-                    "items": {
-                        "$ref": "#/definitions/handlers.SendMessageResult"
-                    }
+                "price": {
+                    "type": "integer",
+                    "example": 5000
                 },
-                "status": {
+                "updatedAt": {
                     "type": "string",
-                    "example": "success"
+                    "example": "2024-01-01T12:00:00.000Z"
                 }
             }
         },
-        "handlers.SendMessageResult": {
-            "description": "Result for a single recipient",
+        "handlers.SubscriptionQuote": {
+            "description": "Price of free access to the box for a number of days",
             "type": "object",
             "properties": {
-                "messageId": {
-                    "type": "string",
-                    "example": "msg-123"
+                "durationDays": {
+                    "type": "integer",
+                    "example": 30
                 },
-                "recipient": {
+                "price": {
+                    "type": "integer",
+                    "example": 5000
+                }
+            }
+        },
+        "handlers.SuccessResponse": {
+            "description": "Simple success response",
+            "type": "object",
+            "properties": {
+                "status": {
                     "type": "string",
-                    "example": "03abc..."
+                    "example": "success"
                 }
             }
         },
-        "handlers.SetPermissionRequest": {
-            "description": "Request to set a permission",
+        "handlers.UpdateDeviceRequest": {
+            "description": "Request to update device labels",
             "type": "object",
             "properties": {
-                "messageBox": {
+                "deviceId": {
                     "type": "string",
-                    "example": "inbox"
+                    "example": "work-phone"
                 },
-                "recipientFee": {
-                    "type": "integer",
-                    "example": 100
-                },
-                "sender": {
+                "platform": {
                     "type": "string",
-                    "example": "03abc..."
+                    "example": "ios"
                 }
             }
         },
-        "handlers.SetPermissionResponse": {
-            "description": "Response after setting a permission",
+        "handlers.UpdateDeviceResponse": {
+            "description": "Response after updating device labels",
             "type": "object",
             "properties": {
-                "description": {
-                    "type": "string",
-                    "example": "Messages from sender to inbox now require 100 satoshis."
+                "device": {
+                    "$ref": "#/definitions/handlers.DeviceOut"
                 },
                 "status": {
                     "type": "string",
@@ -963,10 +5067,28 @@ const docTemplate = `{
                 }
             }
         },
-        "handlers.SuccessResponse": {
-            "description": "Simple success response",
+        "handlers.WithdrawCreditRequest": {
+            "description": "Amount of unused credit to pay back",
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "description": "defaults to the whole balance",
+                    "type": "integer",
+                    "example": 500
+                }
+            }
+        },
+        "handlers.WithdrawCreditResponse": {
+            "description": "Unused credit paid back to the caller",
             "type": "object",
             "properties": {
+                "balance": {
+                    "type": "integer",
+                    "example": 0
+                },
+                "payout": {
+                    "$ref": "#/definitions/handlers.CreditPayoutOut"
+                },
                 "status": {
                     "type": "string",
                     "example": "success"
@@ -0,0 +1,67 @@
+package firebase
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"firebase.google.com/go/v4/messaging"
+)
+
+// DEVICE_CHALLENGE_TYPE marks the data-only push that carries a device ownership challenge.
+const DEVICE_CHALLENGE_TYPE = "device_ownership_challenge"
+
+// SendDeviceChallenge pushes an ownership challenge nonce to a single FCM token.
+// Only the device holding the token receives it, so echoing the nonce back proves control of the device.
+func SendDeviceChallenge(token, nonce string, expiresAt time.Time) error {
+	if !IsEnabled() {
+		return fmt.Errorf("FCM not configured")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), MULTICAST_SEND_TIMEOUT)
+	defer cancel()
+
+	if _, err := Client().Send(ctx, buildChallengeMessage(token, nonce, expiresAt)); err != nil {
+		return fmt.Errorf("failed to send device challenge: %w", err)
+	}
+	return nil
+}
+
+func buildChallengeMessage(token, nonce string, expiresAt time.Time) *messaging.Message {
+	data := map[string]string{
+		"type":      DEVICE_CHALLENGE_TYPE,
+		"nonce":     nonce,
+		"expiresAt": expiresAt.UTC().Format("2006-01-02T15:04:05.000Z"),
+	}
+	customData := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		customData[k] = v
+	}
+
+	ttl := time.Until(expiresAt)
+	if ttl < 0 {
+		ttl = 0
+	}
+
+	return &messaging.Message{
+		Token: token,
+		Data:  data,
+		// data-only so the app can answer the challenge without showing an alert
+		Android: &messaging.AndroidConfig{
+			Priority: "high",
+			TTL:      &ttl,
+			Data:     data,
+		},
+		APNS: &messaging.APNSConfig{
+			Headers: map[string]string{
+				"apns-push-type":  "background",
+				"apns-priority":   "5",
+				"apns-expiration": fmt.Sprintf("%d", expiresAt.Unix()),
+			},
+			Payload: &messaging.APNSPayload{
+				Aps:        &messaging.Aps{ContentAvailable: true},
+				CustomData: customData,
+			},
+		},
+	}
+}
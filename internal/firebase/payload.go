@@ -0,0 +1,72 @@
+package firebase
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// Default templates used when a recipient has not configured their own for a message box.
+const (
+	DefaultTitleTemplate = "New Message"
+	DefaultBodyTemplate  = "New message in {{.MessageBox}}"
+)
+
+// TemplateData holds the values available to notification title and body templates.
+type TemplateData struct {
+	Sender      string // sender identity key
+	SenderName  string // display name supplied by the sender, or a shortened identity key
+	MessageBox  string
+	MessageID   string
+	UnreadCount int
+}
+
+// ValidateTemplate checks that a notification template parses and renders against TemplateData.
+func ValidateTemplate(text string) error {
+	tmpl, err := template.New("notification").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return err
+	}
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, TemplateData{}); err != nil {
+		return fmt.Errorf("template references unknown field: %w", err)
+	}
+	return nil
+}
+
+// RenderTemplate renders a notification template, falling back to fallback when text is
+// empty or fails to render.
+func RenderTemplate(text, fallback string, data TemplateData) string {
+	if text == "" {
+		text = fallback
+	}
+
+	rendered, err := render(text, data)
+	if err != nil && text != fallback {
+		rendered, err = render(fallback, data)
+	}
+	if err != nil {
+		return ""
+	}
+	return rendered
+}
+
+// ShortKey abbreviates an identity key for display (first 8 characters).
+func ShortKey(key string) string {
+	if len(key) <= 8 {
+		return key
+	}
+	return key[:8] + "…"
+}
+
+func render(text string, data TemplateData) (string, error) {
+	tmpl, err := template.New("notification").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -2,7 +2,10 @@ package firebase
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
 	"firebase.google.com/go/v4/messaging"
@@ -10,13 +13,26 @@ import (
 	"github.com/bsv-blockchain/go-message-box-server/internal/logger"
 )
 
-var DEVICE_SEND_MESSAGE_TIMEOUT = 5 * time.Second
+// PROVIDER_FCM identifies Firebase Cloud Messaging in the delivery log.
+const PROVIDER_FCM = "fcm"
+
+// MULTICAST_BATCH_SIZE is the maximum number of tokens FCM accepts in a single multicast send.
+var MULTICAST_BATCH_SIZE = 500
+
+// MULTICAST_SEND_TIMEOUT bounds a single multicast send (one batch of up to MULTICAST_BATCH_SIZE tokens).
+var MULTICAST_SEND_TIMEOUT = 10 * time.Second
 
 // FCMPayload contains the notification data to send.
 type FCMPayload struct {
-	Title      string
-	MessageID  string
-	Originator string
+	Title       string
+	Body        string
+	MessageID   string
+	Originator  string // sender identity key, needed by the iOS NSE to decrypt
+	MessageBox  string
+	Badge       *int          // unread count shown on the app icon, nil leaves it untouched
+	Silent      bool          // data-only push for background sync, no alert is shown
+	CollapseKey string        // newer pushes with the same key replace undelivered older ones
+	TTL         time.Duration // how long FCM keeps the push for offline devices, 0 uses the FCM default
 }
 
 // SendFCMNotificationResult contains the result of a send operation.
@@ -26,7 +42,8 @@ type SendFCMNotificationResult struct {
 }
 
 // SendFCMNotification pushes notification to all registered devices for a recipient.
-// Looks up FCM tokens from device_registrations table and sends to all active devices.
+// Looks up FCM tokens from device_registrations table and sends them in multicast batches
+// of up to MULTICAST_BATCH_SIZE tokens, updating device state in bulk per batch.
 func SendFCMNotification(database *db.DB, recipient string, payload FCMPayload) *SendFCMNotificationResult {
 	if !IsEnabled() {
 		return &SendFCMNotificationResult{Success: false, Error: "FCM not configured"}
@@ -48,34 +65,49 @@ func SendFCMNotification(database *db.DB, recipient string, payload FCMPayload)
 
 	logger.Log("[FCM] Sending notifications", "recipient", recipient, "deviceCount", len(devices))
 
-	var successCount, failureCount int
-
+	tokens := make([]string, 0, len(devices))
+	deviceIDs := make(map[string]int, len(devices))
 	for _, device := range devices {
-		msg := buildMessage(device.FCMToken, payload)
+		tokens = append(tokens, device.FCMToken)
+		deviceIDs[device.FCMToken] = device.ID
+	}
+
+	var successCount, failureCount int
 
-		ctx, cancel := context.WithTimeout(context.Background(), DEVICE_SEND_MESSAGE_TIMEOUT)
-		_, err := Client().Send(ctx, msg)
+	for _, batch := range chunkTokens(tokens, MULTICAST_BATCH_SIZE) {
+		ctx, cancel := context.WithTimeout(context.Background(), MULTICAST_SEND_TIMEOUT)
+		started := time.Now()
+		resp, err := Client().SendEachForMulticast(ctx, buildMulticastMessage(batch, payload))
+		latency := time.Since(started)
 		cancel()
 
+		if err := database.InsertNotificationDeliveries(deliveryRecords(recipient, payload, batch, deviceIDs, resp, err, latency)); err != nil {
+			logger.Error("[FCM] Failed to record delivery attempts", "error", err)
+		}
+
 		if err != nil {
-			logger.Error("[FCM] Failed to send", "error", err, "tokenSuffix", lastN(device.FCMToken, 10))
-			failureCount++
-
-			// we only mark devices as disabled when token is invalid
-			if isInvalidTokenError(err) {
-				logger.Log("[FCM] Deactivating invalid token", "tokenSuffix", lastN(device.FCMToken, 10))
-				if err := database.DeactivateDevice(device.FCMToken); err != nil {
-					logger.Error("[FCM] Failed to deactivate device", "error", err)
-				}
-			}
+			// the whole batch failed, nothing was delivered and no token can be blamed
+			logger.Error("[FCM] Failed to send batch", "error", err, "batchSize", len(batch))
+			failureCount += len(batch)
 			continue
 		}
 
-		successCount++
-		logger.Log("[FCM] Notification sent", "tokenSuffix", lastN(device.FCMToken, 10))
+		sent, invalid := partitionBatchResponse(batch, resp)
+		successCount += len(sent)
+		failureCount += len(batch) - len(sent)
+
+		// we only mark devices as disabled when token is invalid
+		if len(invalid) > 0 {
+			logger.Log("[FCM] Deactivating invalid tokens", "count", len(invalid))
+			if err := database.DeactivateDevices(invalid); err != nil {
+				logger.Error("[FCM] Failed to deactivate devices", "error", err)
+			}
+		}
 
-		if err := database.UpdateDeviceLastUsed(device.FCMToken); err != nil {
-			logger.Error("[FCM] Failed to update last_used", "error", err)
+		if len(sent) > 0 {
+			if err := database.UpdateDevicesLastUsed(sent); err != nil {
+				logger.Error("[FCM] Failed to update last_used", "error", err)
+			}
 		}
 	}
 
@@ -90,20 +122,128 @@ func SendFCMNotification(database *db.DB, recipient string, payload FCMPayload)
 	return &SendFCMNotificationResult{Success: true}
 }
 
-func buildMessage(token string, payload FCMPayload) *messaging.Message {
-	return &messaging.Message{
-		Token: token,
-		Notification: &messaging.Notification{
-			Title: payload.Title,
-			Body:  payload.MessageID,
-		},
+// partitionBatchResponse maps the per-token responses of a multicast send back to the tokens.
+// Returns the tokens that were delivered and the tokens that FCM reported as invalid.
+// Responses are in the same order as the tokens in the request.
+func partitionBatchResponse(tokens []string, resp *messaging.BatchResponse) (sent, invalid []string) {
+	for i, r := range resp.Responses {
+		if i >= len(tokens) || r == nil {
+			break
+		}
+
+		if r.Success {
+			sent = append(sent, tokens[i])
+			continue
+		}
+
+		logger.Error("[FCM] Failed to send", "error", r.Error, "tokenSuffix", lastN(tokens[i], 10))
+		if isInvalidTokenError(r.Error) {
+			invalid = append(invalid, tokens[i])
+		}
+	}
+
+	return sent, invalid
+}
+
+// deliveryRecords builds one delivery log entry per token of a multicast batch.
+// FCM reports no per-token timing, so every entry carries the latency of the whole batch.
+func deliveryRecords(recipient string, payload FCMPayload, batch []string, deviceIDs map[string]int, resp *messaging.BatchResponse, batchErr error, latency time.Duration) []db.NotificationDeliveryRecord {
+	records := make([]db.NotificationDeliveryRecord, 0, len(batch))
+	for i, token := range batch {
+		rec := db.NotificationDeliveryRecord{
+			Recipient:  recipient,
+			MessageID:  sql.NullString{String: payload.MessageID, Valid: payload.MessageID != ""},
+			MessageBox: sql.NullString{String: payload.MessageBox, Valid: payload.MessageBox != ""},
+			Provider:   PROVIDER_FCM,
+			Status:     db.DeliveryStatusSent,
+			LatencyMs:  latency.Milliseconds(),
+		}
+		if id, ok := deviceIDs[token]; ok {
+			rec.DeviceRegistrationID = sql.NullInt64{Int64: int64(id), Valid: true}
+		}
+
+		err := batchErr
+		if err == nil {
+			if resp == nil || i >= len(resp.Responses) || resp.Responses[i] == nil {
+				err = errors.New("missing response for token")
+			} else if !resp.Responses[i].Success {
+				err = resp.Responses[i].Error
+			}
+		}
+
+		if err != nil {
+			rec.Status = db.DeliveryStatusFailed
+			rec.ErrorCode = sql.NullString{String: errorCode(err), Valid: true}
+			rec.ErrorMessage = sql.NullString{String: err.Error(), Valid: true}
+		}
+
+		records = append(records, rec)
+	}
+
+	return records
+}
+
+// errorCode classifies a send error into a stable code for the delivery log.
+func errorCode(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, context.DeadlineExceeded):
+		return "TIMEOUT"
+	case messaging.IsUnregistered(err):
+		return "UNREGISTERED"
+	case messaging.IsInvalidArgument(err):
+		return "INVALID_ARGUMENT"
+	case messaging.IsQuotaExceeded(err):
+		return "QUOTA_EXCEEDED"
+	case messaging.IsSenderIDMismatch(err):
+		return "SENDER_ID_MISMATCH"
+	case messaging.IsThirdPartyAuthError(err):
+		return "THIRD_PARTY_AUTH_ERROR"
+	case messaging.IsUnavailable(err):
+		return "UNAVAILABLE"
+	case messaging.IsInternal(err):
+		return "INTERNAL"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// chunkTokens splits tokens into consecutive batches of at most size elements.
+func chunkTokens(tokens []string, size int) [][]string {
+	if size <= 0 {
+		size = len(tokens)
+	}
+
+	var batches [][]string
+	for start := 0; start < len(tokens); start += size {
+		end := min(start+size, len(tokens))
+		batches = append(batches, tokens[start:end])
+	}
+
+	return batches
+}
+
+func buildMulticastMessage(tokens []string, payload FCMPayload) *messaging.MulticastMessage {
+	data := map[string]string{
+		"messageId":  payload.MessageID,
+		"originator": payload.Originator,
+		"messageBox": payload.MessageBox,
+	}
+	customData := map[string]interface{}{
+		"messageId":  payload.MessageID,
+		"originator": payload.Originator,
+		"messageBox": payload.MessageBox,
+	}
+
+	msg := &messaging.MulticastMessage{
+		Tokens: tokens,
+		Data:   data,
 		// Android configuration for headless service
 		Android: &messaging.AndroidConfig{
-			Priority: "high",
-			Data: map[string]string{
-				"messageId":  payload.MessageID,
-				"originator": payload.Originator,
-			},
+			Priority:    "high",
+			CollapseKey: payload.CollapseKey,
+			Data:        data,
 		},
 		// iOs configuration for mutable content and Notification Service Extension
 		APNS: &messaging.APNSConfig{
@@ -116,16 +256,43 @@ func buildMessage(token string, payload FCMPayload) *messaging.Message {
 					MutableContent: true,
 					Alert: &messaging.ApsAlert{ // include an alert so NSE can modify it
 						Title: payload.Title,
-						Body:  payload.MessageID,
+						Body:  payload.Body,
 					},
+					Badge:    payload.Badge,
+					ThreadID: payload.MessageBox,
 				},
-				CustomData: map[string]interface{}{
-					"messageId":  payload.MessageID,
-					"originator": payload.Originator,
-				},
+				CustomData: customData,
 			},
 		},
 	}
+
+	if payload.Silent {
+		// data-only: no visible notification, wake the app for background sync
+		msg.Android.Priority = "normal"
+		msg.APNS.Headers["apns-push-type"] = "background"
+		msg.APNS.Headers["apns-priority"] = "5" // required by APNs for background pushes
+		msg.APNS.Payload.Aps = &messaging.Aps{
+			ContentAvailable: true,
+			Badge:            payload.Badge,
+		}
+	} else {
+		msg.Notification = &messaging.Notification{
+			Title: payload.Title,
+			Body:  payload.Body,
+		}
+	}
+
+	if payload.CollapseKey != "" {
+		msg.APNS.Headers["apns-collapse-id"] = payload.CollapseKey
+	}
+
+	if payload.TTL > 0 {
+		ttl := payload.TTL
+		msg.Android.TTL = &ttl
+		msg.APNS.Headers["apns-expiration"] = strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	}
+
+	return msg
 }
 
 func isInvalidTokenError(err error) bool {
@@ -1,7 +1,10 @@
 package firebase
 
 import (
+	"context"
+	"fmt"
 	"testing"
+	"time"
 
 	"firebase.google.com/go/v4/messaging"
 )
@@ -63,19 +66,26 @@ func (e *testError) Error() string {
 	return e.msg
 }
 
-func TestBuildMessage(t *testing.T) {
-	token := "test-token-abc123"
+func TestBuildMulticastMessage(t *testing.T) {
+	tokens := []string{"test-token-abc123", "test-token-def456"}
 	payload := FCMPayload{
 		Title:      "Test Title",
+		Body:       "Test Body",
 		MessageID:  "msg-456",
 		Originator: "sender-key-789",
+		MessageBox: "inbox",
 	}
 
-	msg := buildMessage(token, payload)
+	msg := buildMulticastMessage(tokens, payload)
 
-	t.Run("token is set", func(t *testing.T) {
-		if msg.Token != token {
-			t.Errorf("Token = %q, expected %q", msg.Token, token)
+	t.Run("tokens are set", func(t *testing.T) {
+		if len(msg.Tokens) != len(tokens) {
+			t.Fatalf("len(Tokens) = %d, expected %d", len(msg.Tokens), len(tokens))
+		}
+		for i := range tokens {
+			if msg.Tokens[i] != tokens[i] {
+				t.Errorf("Tokens[%d] = %q, expected %q", i, msg.Tokens[i], tokens[i])
+			}
 		}
 	})
 
@@ -86,8 +96,8 @@ func TestBuildMessage(t *testing.T) {
 		if msg.Notification.Title != payload.Title {
 			t.Errorf("Notification.Title = %q, expected %q", msg.Notification.Title, payload.Title)
 		}
-		if msg.Notification.Body != payload.MessageID {
-			t.Errorf("Notification.Body = %q, expected %q", msg.Notification.Body, payload.MessageID)
+		if msg.Notification.Body != payload.Body {
+			t.Errorf("Notification.Body = %q, expected %q", msg.Notification.Body, payload.Body)
 		}
 	})
 
@@ -107,6 +117,9 @@ func TestBuildMessage(t *testing.T) {
 		if msg.Android.Data["originator"] != payload.Originator {
 			t.Errorf("Android.Data[originator] = %q, expected %q", msg.Android.Data["originator"], payload.Originator)
 		}
+		if msg.Android.Data["messageBox"] != payload.MessageBox {
+			t.Errorf("Android.Data[messageBox] = %q, expected %q", msg.Android.Data["messageBox"], payload.MessageBox)
+		}
 	})
 
 	t.Run("apns config is set", func(t *testing.T) {
@@ -152,14 +165,17 @@ func TestBuildMessage(t *testing.T) {
 		if msg.APNS.Payload.CustomData["originator"] != payload.Originator {
 			t.Errorf("CustomData[originator] = %v, expected %q", msg.APNS.Payload.CustomData["originator"], payload.Originator)
 		}
+		if msg.APNS.Payload.CustomData["messageBox"] != payload.MessageBox {
+			t.Errorf("CustomData[messageBox] = %v, expected %q", msg.APNS.Payload.CustomData["messageBox"], payload.MessageBox)
+		}
 	})
 }
 
-func TestBuildMessage_EmptyPayload(t *testing.T) {
-	msg := buildMessage("token", FCMPayload{})
+func TestBuildMulticastMessage_EmptyPayload(t *testing.T) {
+	msg := buildMulticastMessage([]string{"token"}, FCMPayload{})
 
-	if msg.Token != "token" {
-		t.Errorf("Token should be set even with empty payload")
+	if len(msg.Tokens) != 1 || msg.Tokens[0] != "token" {
+		t.Errorf("Tokens should be set even with empty payload")
 	}
 	if msg.Notification == nil {
 		t.Error("Notification should not be nil even with empty payload")
@@ -172,6 +188,181 @@ func TestBuildMessage_EmptyPayload(t *testing.T) {
 	}
 }
 
+func TestBuildMulticastMessage_Silent(t *testing.T) {
+	badge := 3
+	msg := buildMulticastMessage([]string{"token"}, FCMPayload{
+		Title:       "Hidden",
+		MessageID:   "msg-1",
+		Silent:      true,
+		Badge:       &badge,
+		CollapseKey: "inbox",
+		TTL:         time.Hour,
+	})
+
+	if msg.Notification != nil {
+		t.Error("Notification should be nil for silent pushes")
+	}
+	if msg.APNS.Headers["apns-push-type"] != "background" {
+		t.Errorf("apns-push-type = %q, expected %q", msg.APNS.Headers["apns-push-type"], "background")
+	}
+	if msg.APNS.Headers["apns-priority"] != "5" {
+		t.Errorf("apns-priority = %q, expected %q", msg.APNS.Headers["apns-priority"], "5")
+	}
+	if msg.APNS.Payload.Aps.Alert != nil || !msg.APNS.Payload.Aps.ContentAvailable {
+		t.Error("silent APNS payload should be content-available without an alert")
+	}
+	if msg.APNS.Payload.Aps.Badge == nil || *msg.APNS.Payload.Aps.Badge != badge {
+		t.Errorf("badge should be %d", badge)
+	}
+	if msg.Android.CollapseKey != "inbox" || msg.APNS.Headers["apns-collapse-id"] != "inbox" {
+		t.Error("collapse key should be set for android and apns")
+	}
+	if msg.Android.TTL == nil || *msg.Android.TTL != time.Hour {
+		t.Error("android TTL should be one hour")
+	}
+	if msg.APNS.Headers["apns-expiration"] == "" {
+		t.Error("apns-expiration should be set when TTL is given")
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	data := TemplateData{Sender: "03abcdef0123", SenderName: "Alice", MessageBox: "inbox", MessageID: "m1", UnreadCount: 4}
+
+	tests := []struct {
+		name     string
+		text     string
+		expected string
+	}{
+		{"empty uses fallback", "", "New message in inbox"},
+		{"custom template", "{{.SenderName}} ({{.UnreadCount}} unread)", "Alice (4 unread)"},
+		{"broken template uses fallback", "{{.SenderName", "New message in inbox"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := RenderTemplate(tt.text, DefaultBodyTemplate, data)
+			if got != tt.expected {
+				t.Errorf("RenderTemplate(%q) = %q, expected %q", tt.text, got, tt.expected)
+			}
+		})
+	}
+
+	if err := ValidateTemplate("{{.Nope}}"); err == nil {
+		t.Error("ValidateTemplate should reject unknown fields")
+	}
+	if err := ValidateTemplate("{{.SenderName}} in {{.MessageBox}}"); err != nil {
+		t.Errorf("ValidateTemplate rejected a valid template: %v", err)
+	}
+}
+
+func TestChunkTokens(t *testing.T) {
+	tests := []struct {
+		name     string
+		count    int
+		size     int
+		expected []int
+	}{
+		{"no tokens", 0, 500, nil},
+		{"single batch", 3, 500, []int{3}},
+		{"exact batch", 500, 500, []int{500}},
+		{"split batches", 1201, 500, []int{500, 500, 201}},
+		{"non positive size", 4, 0, []int{4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens := make([]string, tt.count)
+			for i := range tokens {
+				tokens[i] = fmt.Sprintf("token-%d", i)
+			}
+
+			batches := chunkTokens(tokens, tt.size)
+			if len(batches) != len(tt.expected) {
+				t.Fatalf("got %d batches, expected %d", len(batches), len(tt.expected))
+			}
+
+			next := 0
+			for i, b := range batches {
+				if len(b) != tt.expected[i] {
+					t.Errorf("batch %d has %d tokens, expected %d", i, len(b), tt.expected[i])
+				}
+				for _, tok := range b {
+					if tok != tokens[next] {
+						t.Errorf("token order broken at %d: got %q, expected %q", next, tok, tokens[next])
+					}
+					next++
+				}
+			}
+		})
+	}
+}
+
+func TestPartitionBatchResponse(t *testing.T) {
+	tokens := []string{"ok-1", "fail-1", "ok-2"}
+	resp := &messaging.BatchResponse{
+		SuccessCount: 2,
+		FailureCount: 1,
+		Responses: []*messaging.SendResponse{
+			{Success: true, MessageID: "m1"},
+			{Success: false, Error: &testError{msg: "transient"}},
+			{Success: true, MessageID: "m2"},
+		},
+	}
+
+	sent, invalid := partitionBatchResponse(tokens, resp)
+
+	if len(sent) != 2 || sent[0] != "ok-1" || sent[1] != "ok-2" {
+		t.Errorf("sent = %v, expected [ok-1 ok-2]", sent)
+	}
+	// a generic error is not an invalid-token error, so nothing should be deactivated
+	if len(invalid) != 0 {
+		t.Errorf("invalid = %v, expected none", invalid)
+	}
+}
+
+func TestDeliveryRecords(t *testing.T) {
+	payload := FCMPayload{MessageID: "msg-1", MessageBox: "inbox"}
+	batch := []string{"tok-a", "tok-b"}
+	deviceIDs := map[string]int{"tok-a": 7}
+
+	t.Run("per token responses", func(t *testing.T) {
+		resp := &messaging.BatchResponse{
+			Responses: []*messaging.SendResponse{
+				{Success: true, MessageID: "m1"},
+				{Success: false, Error: &testError{msg: "boom"}},
+			},
+		}
+
+		records := deliveryRecords("recipient", payload, batch, deviceIDs, resp, nil, 150*time.Millisecond)
+		if len(records) != 2 {
+			t.Fatalf("expected 2 records, got %d", len(records))
+		}
+		if records[0].Status != "sent" || !records[0].DeviceRegistrationID.Valid || records[0].DeviceRegistrationID.Int64 != 7 {
+			t.Errorf("unexpected first record: %+v", records[0])
+		}
+		if records[1].Status != "failed" || records[1].ErrorCode.String != "UNKNOWN" || records[1].ErrorMessage.String != "boom" {
+			t.Errorf("unexpected second record: %+v", records[1])
+		}
+		if records[1].DeviceRegistrationID.Valid {
+			t.Error("unknown token should not have a device id")
+		}
+		for _, r := range records {
+			if r.Provider != PROVIDER_FCM || r.LatencyMs != 150 || r.MessageID.String != "msg-1" || r.MessageBox.String != "inbox" {
+				t.Errorf("record missing common fields: %+v", r)
+			}
+		}
+	})
+
+	t.Run("whole batch error", func(t *testing.T) {
+		records := deliveryRecords("recipient", payload, batch, deviceIDs, nil, context.DeadlineExceeded, time.Second)
+		for _, r := range records {
+			if r.Status != "failed" || r.ErrorCode.String != "TIMEOUT" {
+				t.Errorf("expected TIMEOUT failure, got %+v", r)
+			}
+		}
+	})
+}
+
 func TestSendFCMNotification_NotEnabled(t *testing.T) {
 	// Save original client and reset after test
 	originalClient := client
@@ -257,3 +448,36 @@ func TestSendFCMNotificationResult(t *testing.T) {
 		t.Errorf("Error = %q, expected %q", failResult.Error, "some error")
 	}
 }
+
+func TestBuildChallengeMessage(t *testing.T) {
+	expiresAt := time.Now().Add(10 * time.Minute)
+	msg := buildChallengeMessage("token-1", "nonce-abc", expiresAt)
+
+	if msg.Token != "token-1" {
+		t.Errorf("expected token-1, got %s", msg.Token)
+	}
+	if msg.Notification != nil {
+		t.Error("challenge must be data-only")
+	}
+	if msg.Data["type"] != DEVICE_CHALLENGE_TYPE || msg.Data["nonce"] != "nonce-abc" {
+		t.Errorf("unexpected data: %v", msg.Data)
+	}
+	if msg.APNS.Headers["apns-push-type"] != "background" || !msg.APNS.Payload.Aps.ContentAvailable {
+		t.Error("expected APNs background push")
+	}
+	if msg.APNS.Payload.CustomData["nonce"] != "nonce-abc" {
+		t.Error("expected nonce in APNs custom data")
+	}
+	if msg.Android.TTL == nil || *msg.Android.TTL <= 0 || *msg.Android.TTL > 10*time.Minute {
+		t.Errorf("unexpected android TTL: %v", msg.Android.TTL)
+	}
+}
+
+func TestSendDeviceChallenge_NotEnabled(t *testing.T) {
+	if IsEnabled() {
+		t.Skip("FCM client configured")
+	}
+	if err := SendDeviceChallenge("token", "nonce", time.Now().Add(time.Minute)); err == nil {
+		t.Error("expected error when FCM is not configured")
+	}
+}
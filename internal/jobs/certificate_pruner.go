@@ -0,0 +1,32 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/bsv-blockchain/go-message-box-server/internal/logger"
+	"github.com/bsv-blockchain/go-message-box-server/pkg/db"
+)
+
+// RunCertificatePruner periodically deletes sender certificates that have expired.
+// It runs once immediately, removing certificates kept before they expired, and then every interval
+// until ctx is cancelled.
+func RunCertificatePruner(ctx context.Context, database *db.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := database.DeleteExpiredSenderCertificates(time.Now())
+		if err != nil {
+			logger.Error("[JOBS] Failed to delete expired certificates", "error", err)
+		} else if n > 0 {
+			logger.Log("[JOBS] Deleted expired certificates", "count", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
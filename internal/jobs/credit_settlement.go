@@ -0,0 +1,42 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/bsv-blockchain/go-message-box-server/internal/logger"
+)
+
+// CreditSettler pays recipients the fees senders debited from prepaid credit.
+type CreditSettler interface {
+	SettleRecipientCredits(ctx context.Context, minAmount int64) (int, error)
+}
+
+// RunCreditSettlement periodically pays recipients owed at least minAmount satoshis of credited fees,
+// one payment per recipient per run. It runs every interval until ctx is cancelled; a zero interval disables it.
+func RunCreditSettlement(ctx context.Context, settler CreditSettler, minAmount int64, interval time.Duration) {
+	if interval <= 0 {
+		logger.Log("[JOBS] Credit settlement disabled")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n, err := settler.SettleRecipientCredits(ctx, minAmount)
+		if err != nil {
+			logger.Error("[JOBS] Failed to settle recipient credits", "error", err)
+			continue
+		}
+		if n > 0 {
+			logger.Log("[JOBS] Settled recipient credits", "recipients", n)
+		}
+	}
+}
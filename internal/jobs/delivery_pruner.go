@@ -0,0 +1,38 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/bsv-blockchain/go-message-box-server/internal/logger"
+	"github.com/bsv-blockchain/go-message-box-server/pkg/db"
+)
+
+// RunDeliveryPruner periodically deletes push delivery records older than maxAge.
+// It runs once immediately and then every interval until ctx is cancelled.
+// A non-positive maxAge or interval disables the job.
+func RunDeliveryPruner(ctx context.Context, database *db.DB, maxAge, interval time.Duration) {
+	if maxAge <= 0 || interval <= 0 {
+		logger.Log("[JOBS] Push delivery pruning disabled")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		cutoff := time.Now().Add(-maxAge)
+		n, err := database.DeleteNotificationDeliveriesBefore(cutoff)
+		if err != nil {
+			logger.Error("[JOBS] Failed to delete old push deliveries", "error", err)
+		} else if n > 0 {
+			logger.Log("[JOBS] Deleted old push deliveries", "count", n, "cutoff", cutoff)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/bsv-blockchain/go-message-box-server/internal/logger"
+	"github.com/bsv-blockchain/go-message-box-server/pkg/db"
+)
+
+// RunDevicePruner periodically deactivates device registrations whose last use is older than staleAfter
+// and removes expired device ownership challenges.
+// It runs once immediately and then every interval until ctx is cancelled.
+// A non-positive staleAfter or interval disables the job.
+func RunDevicePruner(ctx context.Context, database *db.DB, staleAfter, interval time.Duration) {
+	if staleAfter <= 0 || interval <= 0 {
+		logger.Log("[JOBS] Stale device pruning disabled")
+		return
+	}
+
+	logger.Log("[JOBS] Stale device pruning enabled", "staleAfter", staleAfter, "interval", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		pruneStaleDevices(database, staleAfter)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func pruneStaleDevices(database *db.DB, staleAfter time.Duration) {
+	cutoff := time.Now().Add(-staleAfter)
+	n, err := database.DeactivateStaleDevices(cutoff)
+	if err != nil {
+		logger.Error("[JOBS] Failed to deactivate stale devices", "error", err)
+		return
+	}
+	if n > 0 {
+		logger.Log("[JOBS] Deactivated stale devices", "count", n, "cutoff", cutoff)
+	}
+
+	n, err = database.DeleteExpiredDeviceChallenges(time.Now())
+	if err != nil {
+		logger.Error("[JOBS] Failed to delete expired device challenges", "error", err)
+		return
+	}
+	if n > 0 {
+		logger.Log("[JOBS] Deleted expired device challenges", "count", n)
+	}
+}
@@ -0,0 +1,33 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/bsv-blockchain/go-message-box-server/internal/logger"
+	"github.com/bsv-blockchain/go-message-box-server/pkg/db"
+)
+
+// RunQuotePruner periodically deletes redeemed quotes that have expired.
+// It runs every interval until ctx is cancelled.
+func RunQuotePruner(ctx context.Context, database *db.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n, err := database.DeleteExpiredQuotes(time.Now())
+		if err != nil {
+			logger.Error("[JOBS] Failed to delete expired quotes", "error", err)
+			continue
+		}
+		if n > 0 {
+			logger.Log("[JOBS] Deleted expired quotes", "count", n)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/bsv-blockchain/go-message-box-server/internal/logger"
+	"github.com/bsv-blockchain/go-message-box-server/pkg/db"
+)
+
+// RunRateCounterPruner periodically deletes rate limit and route free tier counters of windows that have ended.
+// It runs every interval until ctx is cancelled.
+func RunRateCounterPruner(ctx context.Context, database *db.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n, err := database.DeleteExpiredRateCounters(time.Now())
+		if err != nil {
+			logger.Error("[JOBS] Failed to delete expired rate counters", "error", err)
+		} else if n > 0 {
+			logger.Log("[JOBS] Deleted expired rate counters", "count", n)
+		}
+
+		n, err = database.DeleteExpiredRouteCounters(time.Now())
+		if err != nil {
+			logger.Error("[JOBS] Failed to delete expired route counters", "error", err)
+		} else if n > 0 {
+			logger.Log("[JOBS] Deleted expired route counters", "count", n)
+		}
+	}
+}
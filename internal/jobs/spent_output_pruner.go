@@ -0,0 +1,74 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/bsv-blockchain/go-message-box-server/internal/logger"
+	"github.com/bsv-blockchain/go-message-box-server/pkg/db"
+	"github.com/bsv-blockchain/go-wallet-toolbox/pkg/wdk"
+)
+
+// spentOutputBatch is how many payment transactions are looked up per run.
+const spentOutputBatch = 100
+
+// TxStatusSource reports how many blocks deep transactions are.
+type TxStatusSource interface {
+	GetStatusForTxIDs(ctx context.Context, txIDs []string) (*wdk.GetStatusForTxIDsResult, error)
+}
+
+// RunSpentOutputPruner periodically deletes the spent payment outputs of transactions at least confirmations
+// deep. sendMessage rejects such transactions, so their outputs can no longer be replayed.
+// It runs every interval until ctx is cancelled. A nil source or non-positive confirmations disables the job.
+func RunSpentOutputPruner(ctx context.Context, database *db.DB, src TxStatusSource, confirmations int, interval time.Duration) {
+	if src == nil || confirmations <= 0 {
+		logger.Log("[JOBS] Spent payment output pruning disabled")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pruneSpentOutputs(ctx, database, src, confirmations)
+	}
+}
+
+func pruneSpentOutputs(ctx context.Context, database *db.DB, src TxStatusSource, confirmations int) {
+	txids, err := database.SpentOutputTxIDsToCheck(time.Now(), spentOutputBatch)
+	if err != nil {
+		logger.Error("[JOBS] Failed to list spent payment outputs", "error", err)
+		return
+	}
+	if len(txids) == 0 {
+		return
+	}
+
+	res, err := src.GetStatusForTxIDs(ctx, txids)
+	if err != nil {
+		logger.Error("[JOBS] Failed to get payment tx status", "error", err)
+		return
+	}
+
+	var buried []string
+	for _, r := range res.Results {
+		if r.Depth != nil && *r.Depth >= confirmations {
+			buried = append(buried, r.TxID)
+		}
+	}
+
+	n, err := database.DeleteSpentOutputs(buried)
+	if err != nil {
+		logger.Error("[JOBS] Failed to delete spent payment outputs", "error", err)
+		return
+	}
+	if n > 0 {
+		logger.Log("[JOBS] Deleted spent payment outputs", "count", n)
+	}
+}
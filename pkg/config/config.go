@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // Config holds the application configuration loaded from environment variables.
@@ -23,9 +25,62 @@ type Config struct {
 	FirebaseServiceAccountJSON string
 	FirebaseServiceAccountPath string
 
+	// Devices whose last push is older than DeviceStaleAfter are deactivated every DevicePruneInterval (0 disables)
+	DeviceStaleAfter    time.Duration
+	DevicePruneInterval time.Duration
+
+	// Push delivery records older than NotificationDeliveryRetention are deleted (0 keeps them forever)
+	NotificationDeliveryRetention time.Duration
+
+	// Used proofs of work older than ProofOfWorkRetention are deleted (0 keeps them forever)
+	ProofOfWorkRetention time.Duration
+
+	// What happens when an FCM token is registered by a different identity: "reject" or "challenge"
+	DeviceTokenTransferPolicy string
+
 	// Wallet
 	WalletStorageURL string
 	BSVNetwork       string
+
+	// Admin identity keys allowed to use operator endpoints
+	AdminIdentityKeys []string
+
+	// Recipient fees for boxes where the recipient has set no rule, e.g. "notifications=10,app.*=5"
+	RecipientFeeDefaults []RecipientFeeDefault
+
+	// How long a signed delivery quote can be redeemed by sendMessage
+	QuoteTTL time.Duration
+
+	// Payments at least this many blocks deep are refused as replays; 0 disables replay pruning
+	PaymentReplayConfirmations int
+
+	// How often recipient fees paid from sender credit are settled, and the least amount paid out to a recipient
+	CreditSettlementInterval  time.Duration
+	CreditSettlementMinAmount int
+
+	// Spam reports from this many different recipients within SpamReportWindow raise a sender's fees by
+	// SpamFeeMultiplier, require at least SpamMinRecipientFee per recipient, or ban them; 0 disables a level,
+	// and every level is disabled by default
+	SpamReportWindow     time.Duration
+	SpamRaiseFeesAt      int
+	SpamFeeMultiplier    int
+	SpamRequirePaymentAt int
+	SpamMinRecipientFee  int
+	SpamBanAt            int
+
+	// Identity certificates the auth handshake requests from every client, as base64 type IDs mapped to the
+	// fields to reveal, e.g. "<type>:email|country"; certifiers may be empty to accept any certifier
+	AuthCertificateTypes map[string][]string
+	AuthCertifiers       []string
+
+	// How long a presented identity certificate is kept before the sender must present it again
+	SenderCertificateTTL time.Duration
+}
+
+// RecipientFeeDefault is a default recipient fee for a message box or glob pattern (-1 blocks).
+type RecipientFeeDefault struct {
+	MessageBox string
+	Fee        int
 }
 
 // Load reads configuration from environment variables.
@@ -43,12 +98,94 @@ func Load() (*Config, error) {
 		FirebaseProjectID:          os.Getenv("FIREBASE_PROJECT_ID"),
 		FirebaseServiceAccountJSON: os.Getenv("FIREBASE_SERVICE_ACCOUNT_JSON"),
 		FirebaseServiceAccountPath: os.Getenv("FIREBASE_SERVICE_ACCOUNT_PATH"),
+
+		DeviceTokenTransferPolicy: getEnv("DEVICE_TOKEN_TRANSFER_POLICY", "challenge"),
+
+		AdminIdentityKeys: getEnvList("ADMIN_IDENTITY_KEYS"),
+		AuthCertifiers:    getEnvList("AUTH_CERTIFIERS"),
 	}
 
 	if cfg.ServerPrivateKey == "" {
 		return nil, fmt.Errorf("SERVER_PRIVATE_KEY is not defined in environment variables")
 	}
 
+	var err error
+	if cfg.RecipientFeeDefaults, err = parseRecipientFeeDefaults(getEnv("RECIPIENT_FEE_DEFAULTS", "notifications=10")); err != nil {
+		return nil, err
+	}
+	if cfg.AuthCertificateTypes, err = parseCertificateTypes(os.Getenv("AUTH_CERTIFICATE_TYPES")); err != nil {
+		return nil, err
+	}
+
+	if cfg.DeviceTokenTransferPolicy != "reject" && cfg.DeviceTokenTransferPolicy != "challenge" {
+		return nil, fmt.Errorf("DEVICE_TOKEN_TRANSFER_POLICY must be one of: reject, challenge")
+	}
+
+	if cfg.DeviceStaleAfter, err = getEnvDuration("DEVICE_STALE_AFTER", 60*24*time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.DevicePruneInterval, err = getEnvDuration("DEVICE_PRUNE_INTERVAL", 24*time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.NotificationDeliveryRetention, err = getEnvDuration("NOTIFICATION_DELIVERY_RETENTION", 30*24*time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.ProofOfWorkRetention, err = getEnvDuration("PROOF_OF_WORK_RETENTION", 30*24*time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.QuoteTTL, err = getEnvDuration("QUOTE_TTL", 5*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.QuoteTTL <= 0 {
+		return nil, fmt.Errorf("QUOTE_TTL must be positive")
+	}
+	if cfg.PaymentReplayConfirmations, err = getEnvInt("PAYMENT_REPLAY_CONFIRMATIONS", 6); err != nil {
+		return nil, err
+	}
+	if cfg.PaymentReplayConfirmations < 0 {
+		return nil, fmt.Errorf("PAYMENT_REPLAY_CONFIRMATIONS must not be negative")
+	}
+	if cfg.CreditSettlementInterval, err = getEnvDuration("CREDIT_SETTLEMENT_INTERVAL", time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.CreditSettlementMinAmount, err = getEnvInt("CREDIT_SETTLEMENT_MIN_AMOUNT", 100); err != nil {
+		return nil, err
+	}
+	if cfg.CreditSettlementMinAmount < 1 {
+		return nil, fmt.Errorf("CREDIT_SETTLEMENT_MIN_AMOUNT must be at least 1")
+	}
+	if cfg.SenderCertificateTTL, err = getEnvDuration("SENDER_CERTIFICATE_TTL", 30*24*time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.SenderCertificateTTL <= 0 {
+		return nil, fmt.Errorf("SENDER_CERTIFICATE_TTL must be positive")
+	}
+	if cfg.SpamReportWindow, err = getEnvDuration("SPAM_REPORT_WINDOW", 30*24*time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.SpamReportWindow <= 0 {
+		return nil, fmt.Errorf("SPAM_REPORT_WINDOW must be positive")
+	}
+	for _, v := range []struct {
+		key      string
+		dst      *int
+		fallback int
+		min      int
+	}{
+		{"SPAM_RAISE_FEES_AT", &cfg.SpamRaiseFeesAt, 0, 0},
+		{"SPAM_FEE_MULTIPLIER", &cfg.SpamFeeMultiplier, 10, 1},
+		{"SPAM_REQUIRE_PAYMENT_AT", &cfg.SpamRequirePaymentAt, 0, 0},
+		{"SPAM_MIN_RECIPIENT_FEE", &cfg.SpamMinRecipientFee, 100, 1},
+		{"SPAM_BAN_AT", &cfg.SpamBanAt, 0, 0},
+	} {
+		if *v.dst, err = getEnvInt(v.key, v.fallback); err != nil {
+			return nil, err
+		}
+		if *v.dst < v.min {
+			return nil, fmt.Errorf("%s must be at least %d", v.key, v.min)
+		}
+	}
+
 	port := getEnv("PORT", "")
 	if port == "" {
 		port = getEnv("HTTP_PORT", "")
@@ -71,9 +208,95 @@ func Load() (*Config, error) {
 	return cfg, nil
 }
 
+// LoadDatabase reads only the database settings, for CLI commands that don't start the server.
+func LoadDatabase() *Config {
+	return &Config{
+		DBDriver: getEnv("DB_DRIVER", "sqlite3"),
+		DBSource: getEnv("DB_SOURCE", "messagebox.db"),
+	}
+}
+
 func getEnv(key, fallback string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
 	}
 	return fallback
 }
+
+// getEnvList reads a comma-separated list, dropping empty entries.
+func getEnvList(key string) []string {
+	var out []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// parseRecipientFeeDefaults parses comma-separated box=fee pairs.
+func parseRecipientFeeDefaults(v string) ([]RecipientFeeDefault, error) {
+	var out []RecipientFeeDefault
+	for _, pair := range strings.Split(v, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		box, feeStr, ok := strings.Cut(pair, "=")
+		box = strings.TrimSpace(box)
+		fee, err := strconv.Atoi(strings.TrimSpace(feeStr))
+		if !ok || box == "" || err != nil || fee < -1 {
+			return nil, fmt.Errorf("RECIPIENT_FEE_DEFAULTS entry %q must look like box=fee with fee -1 or more", pair)
+		}
+		out = append(out, RecipientFeeDefault{MessageBox: box, Fee: fee})
+	}
+	return out, nil
+}
+
+// parseCertificateTypes parses comma-separated type:field|field entries.
+func parseCertificateTypes(v string) (map[string][]string, error) {
+	out := make(map[string][]string)
+	for _, entry := range strings.Split(v, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		certType, fieldList, _ := strings.Cut(entry, ":")
+		certType = strings.TrimSpace(certType)
+		if certType == "" {
+			return nil, fmt.Errorf("AUTH_CERTIFICATE_TYPES entry %q must look like type:field|field", entry)
+		}
+		fields := []string{}
+		for _, f := range strings.Split(fieldList, "|") {
+			if f = strings.TrimSpace(f); f != "" {
+				fields = append(fields, f)
+			}
+		}
+		out[certType] = fields
+	}
+	return out, nil
+}
+
+// getEnvDuration parses a Go duration (e.g. "720h"); "0" disables the related feature.
+func getEnvDuration(key string, fallback time.Duration) (time.Duration, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a duration like 720h: %w", key, err)
+	}
+	return d, nil
+}
+
+// getEnvInt parses an integer, returning fallback when the variable is unset.
+func getEnvInt(key string, fallback int) (int, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer: %w", key, err)
+	}
+	return n, nil
+}
@@ -0,0 +1,229 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// SenderCertificate represents a row in sender_certificates: a BRC-52 identity certificate a sender presented
+// to the server, with the names of the fields it revealed to the server. Field values are not kept. A newer
+// certificate of the same type from the same certifier replaces it.
+type SenderCertificate struct {
+	ID              int
+	Sender          string
+	Certifier       string
+	CertificateType string // base64 type ID
+	SerialNumber    string
+	Fields          []string  // names of the fields revealed to the server
+	ExpiresAt       time.Time // the sender presents the certificate again to keep it
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+// CertificateRequirement represents a row in certificate_requirements: the certificate a recipient requires
+// of senders into one of their boxes.
+type CertificateRequirement struct {
+	ID              int
+	Recipient       string
+	MessageBox      string
+	Certifiers      []string // accepted certifier keys, any one of them will do
+	CertificateType string   // required base64 type ID, empty for any type
+	Fields          []string // fields the certificate must reveal to the server
+	VerifiedFee     *int     // fee for verified senders; nil when unverified senders cannot deliver at all
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+// SatisfiedBy reports whether one of certs meets the requirement.
+func (r *CertificateRequirement) SatisfiedBy(certs []SenderCertificate) bool {
+	for _, c := range certs {
+		if !slices.Contains(r.Certifiers, c.Certifier) {
+			continue
+		}
+		if r.CertificateType != "" && c.CertificateType != r.CertificateType {
+			continue
+		}
+		if r.revealsFields(c) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *CertificateRequirement) revealsFields(c SenderCertificate) bool {
+	for _, f := range r.Fields {
+		if !slices.Contains(c.Fields, f) {
+			return false
+		}
+	}
+	return true
+}
+
+// SaveSenderCertificate upserts a certificate presented by its sender.
+func (d *DB) SaveSenderCertificate(c SenderCertificate) error {
+	fields, err := json.Marshal(c.Fields)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	_, err = d.exec(
+		`INSERT INTO sender_certificates (sender, certifier, certificate_type, serial_number, fields, expires_at, created_at, updated_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(sender, certifier, certificate_type) DO UPDATE SET serial_number = ?, fields = ?, expires_at = ?, updated_at = ?`,
+		c.Sender, c.Certifier, c.CertificateType, c.SerialNumber, string(fields), c.ExpiresAt, now, now,
+		c.SerialNumber, string(fields), c.ExpiresAt, now,
+	)
+	return err
+}
+
+// ListSenderCertificates returns the certificates sender presented that have not expired by now, ordered by
+// certifier and type.
+func (d *DB) ListSenderCertificates(sender string, now time.Time) ([]SenderCertificate, error) {
+	rows, err := d.query(
+		`SELECT id, sender, certifier, certificate_type, serial_number, fields, expires_at, created_at, updated_at
+		 FROM sender_certificates WHERE sender = ? AND expires_at > ? ORDER BY certifier ASC, certificate_type ASC`,
+		sender, now,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []SenderCertificate
+	for rows.Next() {
+		var c SenderCertificate
+		var fields string
+		if err := rows.Scan(&c.ID, &c.Sender, &c.Certifier, &c.CertificateType, &c.SerialNumber, &fields, &c.ExpiresAt, &c.CreatedAt, &c.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(fields), &c.Fields); err != nil {
+			return nil, fmt.Errorf("invalid fields for certificate %d: %w", c.ID, err)
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// DeleteSenderCertificates deletes the certificate of sender with serialNumber, or all of sender's
+// certificates if serialNumber is empty. Returns how many were deleted.
+func (d *DB) DeleteSenderCertificates(sender, serialNumber string) (int64, error) {
+	query, args := `DELETE FROM sender_certificates WHERE sender = ?`, []any{sender}
+	if serialNumber != "" {
+		query += ` AND serial_number = ?`
+		args = append(args, serialNumber)
+	}
+	res, err := d.exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DeleteExpiredSenderCertificates removes certificates that expired before now, and those kept before
+// certificates expired. Returns how many were deleted.
+func (d *DB) DeleteExpiredSenderCertificates(now time.Time) (int64, error) {
+	res, err := d.exec(`DELETE FROM sender_certificates WHERE expires_at IS NULL OR expires_at <= ?`, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// SetCertificateRequirement upserts the certificate requirement of r.Recipient for r.MessageBox.
+func (d *DB) SetCertificateRequirement(r CertificateRequirement) error {
+	certifiers, err := json.Marshal(r.Certifiers)
+	if err != nil {
+		return err
+	}
+	var fields sql.NullString
+	if len(r.Fields) > 0 {
+		b, err := json.Marshal(r.Fields)
+		if err != nil {
+			return err
+		}
+		fields = sql.NullString{String: string(b), Valid: true}
+	}
+	now := time.Now()
+	_, err = d.exec(
+		`INSERT INTO certificate_requirements (recipient, message_box, certifiers, certificate_type, fields, verified_fee, created_at, updated_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(recipient, message_box) DO UPDATE SET certifiers = ?, certificate_type = ?, fields = ?, verified_fee = ?, updated_at = ?`,
+		r.Recipient, r.MessageBox, string(certifiers), r.CertificateType, fields, r.VerifiedFee, now, now,
+		string(certifiers), r.CertificateType, fields, r.VerifiedFee, now,
+	)
+	return err
+}
+
+// DeleteCertificateRequirement removes the certificate requirement of recipient for messageBox. Returns false if none existed.
+func (d *DB) DeleteCertificateRequirement(recipient, messageBox string) (bool, error) {
+	res, err := d.exec(`DELETE FROM certificate_requirements WHERE recipient = ? AND message_box = ?`, recipient, messageBox)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetCertificateRequirement returns the certificate requirement of recipient for messageBox, or nil if there is none.
+func (d *DB) GetCertificateRequirement(recipient, messageBox string) (*CertificateRequirement, error) {
+	rows, err := d.query(
+		`SELECT id, recipient, message_box, certifiers, certificate_type, fields, verified_fee, created_at, updated_at
+		 FROM certificate_requirements WHERE recipient = ? AND message_box = ?`,
+		recipient, messageBox,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reqs, err := scanCertificateRequirements(rows)
+	if err != nil || len(reqs) == 0 {
+		return nil, err
+	}
+	return &reqs[0], nil
+}
+
+// ListCertificateRequirements returns every certificate requirement of recipient, ordered by box.
+func (d *DB) ListCertificateRequirements(recipient string) ([]CertificateRequirement, error) {
+	rows, err := d.query(
+		`SELECT id, recipient, message_box, certifiers, certificate_type, fields, verified_fee, created_at, updated_at
+		 FROM certificate_requirements WHERE recipient = ? ORDER BY message_box ASC`,
+		recipient,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanCertificateRequirements(rows)
+}
+
+func scanCertificateRequirements(rows *sql.Rows) ([]CertificateRequirement, error) {
+	var out []CertificateRequirement
+	for rows.Next() {
+		var r CertificateRequirement
+		var certifiers string
+		var fields sql.NullString
+		var verifiedFee sql.NullInt64
+		if err := rows.Scan(&r.ID, &r.Recipient, &r.MessageBox, &certifiers, &r.CertificateType, &fields, &verifiedFee, &r.CreatedAt, &r.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(certifiers), &r.Certifiers); err != nil {
+			return nil, fmt.Errorf("invalid certifiers for requirement %d: %w", r.ID, err)
+		}
+		if fields.Valid && fields.String != "" {
+			if err := json.Unmarshal([]byte(fields.String), &r.Fields); err != nil {
+				return nil, fmt.Errorf("invalid fields for requirement %d: %w", r.ID, err)
+			}
+		}
+		if verifiedFee.Valid {
+			v := int(verifiedFee.Int64)
+			r.VerifiedFee = &v
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,442 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrInsufficientCredit is returned when a debit exceeds the credit balance; nothing is debited.
+var ErrInsufficientCredit = errors.New("insufficient credit balance")
+
+// Credit entry kinds recorded in credit_entries. Amounts are positive for credits and negative for debits.
+const (
+	CreditKindDeposit      = "deposit"       // payment internalized by the server wallet
+	CreditKindDeliveryFee  = "delivery_fee"  // server delivery fee of a send
+	CreditKindRecipientFee = "recipient_fee" // recipient fee of a send, owed to the recipient until settled
+	CreditKindWithdrawal   = "withdrawal"    // unused credit paid back to the account holder
+	CreditKindReversal     = "reversal"      // debits returned because the send or withdrawal failed
+)
+
+// Settlement states of recipient_fee entries.
+const (
+	CreditSettlementHeld     = "held"     // debited by a send that is not stored yet
+	CreditSettlementPending  = "pending"  // owed to the recipient
+	CreditSettlementSettling = "settling" // claimed by a settlement payout whose payment is being made
+	CreditSettlementSettled  = "settled"  // paid to the recipient in a payout
+	CreditSettlementReversed = "reversed" // returned to the sender; also marks other reversed debits
+)
+
+// Credit payout kinds recorded in credit_payouts.
+const (
+	CreditPayoutSettlement = "settlement" // recipient fees owed to a recipient
+	CreditPayoutWithdrawal = "withdrawal" // unused credit returned to a sender
+)
+
+// PaymentStatusCredited marks a payment debited from the sender's credit balance instead of paid by a transaction.
+const PaymentStatusCredited = "credited"
+
+// CreditDebit is one fee debited from a credit balance.
+type CreditDebit struct {
+	Kind       string
+	Amount     int64 // satoshis, positive
+	Recipient  sql.NullString
+	MessageBox sql.NullString
+	MessageID  sql.NullString
+}
+
+// CreditEntry represents a row in credit_entries.
+type CreditEntry struct {
+	ID               int64
+	IdentityKey      string
+	Kind             string
+	Amount           int64
+	BalanceAfter     int64
+	TxID             sql.NullString
+	Recipient        sql.NullString
+	MessageBox       sql.NullString
+	MessageID        sql.NullString
+	SettlementStatus sql.NullString
+	PayoutID         sql.NullInt64
+	CreatedAt        time.Time
+}
+
+// CreditPayout represents a row in credit_payouts. Payment holds the JSON of the payment handed to the payee.
+// A settlement payout has an empty TxID until its payment is made.
+type CreditPayout struct {
+	ID        int64
+	Payee     string
+	Kind      string
+	Amount    int64
+	TxID      string
+	Payment   string
+	CreatedAt time.Time
+}
+
+// CreditSettlement is the total owed to a recipient by pending entries.
+type CreditSettlement struct {
+	Recipient string
+	Amount    int64
+}
+
+// DepositCredit adds a deposit to the balance of identityKey and returns the new balance.
+func (d *DB) DepositCredit(identityKey string, amount int64, txid string) (int64, error) {
+	var balance int64
+	err := d.withTx(func(t *tx) error {
+		now := time.Now()
+		if err := adjustCredit(t, identityKey, amount, now); err != nil {
+			return err
+		}
+		if err := t.queryRow(`SELECT balance FROM credit_accounts WHERE identity_key = ?`, identityKey).Scan(&balance); err != nil {
+			return err
+		}
+		_, err := t.exec(
+			`INSERT INTO credit_entries (identity_key, kind, amount, balance_after, txid, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+			identityKey, CreditKindDeposit, amount, balance, txid, now,
+		)
+		return err
+	})
+	return balance, err
+}
+
+// DebitCredit debits every fee from the balance of identityKey together, or returns ErrInsufficientCredit.
+// Recipient fees are held until released with the messages (see InsertMessages) or ReverseCreditDebits.
+// Returns the ids of the new entries and the new balance.
+func (d *DB) DebitCredit(identityKey string, debits []CreditDebit) ([]int64, int64, error) {
+	var total int64
+	for _, c := range debits {
+		total += c.Amount
+	}
+
+	var ids []int64
+	var balance int64
+	err := d.withTx(func(t *tx) error {
+		now := time.Now()
+		res, err := t.exec(
+			`UPDATE credit_accounts SET balance = balance - ?, updated_at = ? WHERE identity_key = ? AND balance >= ?`,
+			total, now, identityKey, total,
+		)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrInsufficientCredit
+		}
+		if err := t.queryRow(`SELECT balance FROM credit_accounts WHERE identity_key = ?`, identityKey).Scan(&balance); err != nil {
+			return err
+		}
+
+		after := balance + total
+		for _, c := range debits {
+			after -= c.Amount
+			var settlement sql.NullString
+			if c.Kind == CreditKindRecipientFee {
+				settlement = sql.NullString{String: CreditSettlementHeld, Valid: true}
+			}
+			var id int64
+			if err := t.queryRow(
+				`INSERT INTO credit_entries (identity_key, kind, amount, balance_after, recipient, message_box, message_id, settlement_status, created_at)
+				 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+				 RETURNING id`,
+				identityKey, c.Kind, -c.Amount, after, c.Recipient, c.MessageBox, c.MessageID, settlement, now,
+			).Scan(&id); err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return ids, balance, nil
+}
+
+// releaseCreditDebits marks the held recipient fees of entryIDs as owed to their recipients.
+func releaseCreditDebits(ex execer, entryIDs []int64) error {
+	if len(entryIDs) == 0 {
+		return nil
+	}
+	args := []any{CreditSettlementPending, CreditSettlementHeld}
+	for _, id := range entryIDs {
+		args = append(args, id)
+	}
+	_, err := ex.exec(
+		`UPDATE credit_entries SET settlement_status = ? WHERE settlement_status = ? AND id IN (`+placeholders(len(entryIDs))+`)`,
+		args...,
+	)
+	return err
+}
+
+// ReverseCreditDebits returns the debits of entryIDs to the balance of identityKey with a reversal entry,
+// and marks them reversed. Debits already reversed and recipient fees no longer held are skipped.
+// Returns the new balance.
+func (d *DB) ReverseCreditDebits(identityKey string, entryIDs []int64) (int64, error) {
+	var balance int64
+	err := d.withTx(func(t *tx) error {
+		var total int64
+		for _, id := range entryIDs {
+			res, err := t.exec(
+				`UPDATE credit_entries SET settlement_status = ?
+				 WHERE id = ? AND identity_key = ? AND amount < 0 AND (settlement_status IS NULL OR settlement_status = ?)`,
+				CreditSettlementReversed, id, identityKey, CreditSettlementHeld,
+			)
+			if err != nil {
+				return err
+			}
+			if n, err := res.RowsAffected(); err != nil {
+				return err
+			} else if n == 0 {
+				continue
+			}
+			var amount int64
+			if err := t.queryRow(`SELECT amount FROM credit_entries WHERE id = ?`, id).Scan(&amount); err != nil {
+				return err
+			}
+			total -= amount
+		}
+
+		now := time.Now()
+		if total == 0 {
+			return t.queryRow(`SELECT COALESCE(MAX(balance), 0) FROM credit_accounts WHERE identity_key = ?`, identityKey).Scan(&balance)
+		}
+		if err := adjustCredit(t, identityKey, total, now); err != nil {
+			return err
+		}
+		if err := t.queryRow(`SELECT balance FROM credit_accounts WHERE identity_key = ?`, identityKey).Scan(&balance); err != nil {
+			return err
+		}
+		_, err := t.exec(
+			`INSERT INTO credit_entries (identity_key, kind, amount, balance_after, created_at) VALUES (?, ?, ?, ?, ?)`,
+			identityKey, CreditKindReversal, total, balance, now,
+		)
+		return err
+	})
+	return balance, err
+}
+
+// CreditBalance returns the balance of identityKey and the recipient fees still owed to it.
+func (d *DB) CreditBalance(identityKey string) (int64, int64, error) {
+	var balance, owed int64
+	err := d.queryRow(`SELECT balance FROM credit_accounts WHERE identity_key = ?`, identityKey).Scan(&balance)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, 0, err
+	}
+	err = d.queryRow(
+		`SELECT COALESCE(SUM(-amount), 0) FROM credit_entries WHERE recipient = ? AND kind = ? AND settlement_status = ?`,
+		identityKey, CreditKindRecipientFee, CreditSettlementPending,
+	).Scan(&owed)
+	return balance, owed, err
+}
+
+// ListCreditEntries returns the ledger of identityKey, newest first.
+func (d *DB) ListCreditEntries(identityKey string, limit int) ([]CreditEntry, error) {
+	rows, err := d.query(
+		`SELECT id, identity_key, kind, amount, balance_after, txid, recipient, message_box, message_id, settlement_status, payout_id, created_at
+		 FROM credit_entries WHERE identity_key = ? ORDER BY created_at DESC, id DESC LIMIT ?`,
+		identityKey, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []CreditEntry
+	for rows.Next() {
+		var e CreditEntry
+		if err := rows.Scan(&e.ID, &e.IdentityKey, &e.Kind, &e.Amount, &e.BalanceAfter, &e.TxID, &e.Recipient, &e.MessageBox, &e.MessageID, &e.SettlementStatus, &e.PayoutID, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// PendingCreditSettlements returns up to limit recipients owed at least minAmount, largest first.
+func (d *DB) PendingCreditSettlements(minAmount int64, limit int) ([]CreditSettlement, error) {
+	rows, err := d.query(
+		`SELECT recipient, SUM(-amount) FROM credit_entries
+		 WHERE kind = ? AND settlement_status = ?
+		 GROUP BY recipient HAVING SUM(-amount) >= ?
+		 ORDER BY SUM(-amount) DESC, recipient ASC LIMIT ?`,
+		CreditKindRecipientFee, CreditSettlementPending, minAmount, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []CreditSettlement
+	for rows.Next() {
+		var s CreditSettlement
+		if err := rows.Scan(&s.Recipient, &s.Amount); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// InsertCreditPayout records a withdrawal payout.
+func (d *DB) InsertCreditPayout(p CreditPayout) (int64, error) {
+	var id int64
+	err := d.queryRow(
+		`INSERT INTO credit_payouts (payee, kind, amount, txid, payment, created_at) VALUES (?, ?, ?, ?, ?, ?) RETURNING id`,
+		p.Payee, p.Kind, p.Amount, p.TxID, p.Payment, time.Now(),
+	).Scan(&id)
+	return id, err
+}
+
+// errSettlementClaimed rolls back a settlement whose entries another run claimed first.
+var errSettlementClaimed = errors.New("recipient fees claimed by another settlement")
+
+// StartCreditSettlement claims every pending recipient fee owed to recipient for a new settlement payout,
+// marking exactly those entries settling so no other run pays them. The payout is completed with
+// CompleteCreditSettlement once its payment is made, or cancelled with CancelCreditSettlement.
+// Returns the payout id and amount, or a zero id if less than minAmount is owed or another run claimed the fees.
+func (d *DB) StartCreditSettlement(recipient string, minAmount int64) (int64, int64, error) {
+	var payoutID, amount int64
+	err := d.withTx(func(t *tx) error {
+		rows, err := t.query(
+			`SELECT id, amount FROM credit_entries WHERE recipient = ? AND kind = ? AND settlement_status = ?`,
+			recipient, CreditKindRecipientFee, CreditSettlementPending,
+		)
+		if err != nil {
+			return err
+		}
+		var ids []any
+		for rows.Next() {
+			var id, a int64
+			if err := rows.Scan(&id, &a); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+			amount -= a
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(ids) == 0 || amount < minAmount {
+			return nil
+		}
+
+		if err := t.queryRow(
+			`INSERT INTO credit_payouts (payee, kind, amount, txid, payment, created_at) VALUES (?, ?, ?, '', '', ?) RETURNING id`,
+			recipient, CreditPayoutSettlement, amount, time.Now(),
+		).Scan(&payoutID); err != nil {
+			return err
+		}
+		res, err := t.exec(
+			`UPDATE credit_entries SET settlement_status = ?, payout_id = ?
+			 WHERE settlement_status = ? AND id IN (`+placeholders(len(ids))+`)`,
+			append([]any{CreditSettlementSettling, payoutID, CreditSettlementPending}, ids...)...,
+		)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n != int64(len(ids)) {
+			return errSettlementClaimed
+		}
+		return nil
+	})
+	if errors.Is(err, errSettlementClaimed) {
+		return 0, 0, nil
+	}
+	if err != nil || payoutID == 0 {
+		return 0, 0, err
+	}
+	return payoutID, amount, nil
+}
+
+// CompleteCreditSettlement records the payment of a settlement payout and marks the fees it claimed settled.
+func (d *DB) CompleteCreditSettlement(payoutID int64, txid, payment string) error {
+	return d.withTx(func(t *tx) error {
+		if _, err := t.exec(`UPDATE credit_payouts SET txid = ?, payment = ? WHERE id = ?`, txid, payment, payoutID); err != nil {
+			return err
+		}
+		_, err := t.exec(
+			`UPDATE credit_entries SET settlement_status = ? WHERE payout_id = ? AND settlement_status = ?`,
+			CreditSettlementSettled, payoutID, CreditSettlementSettling,
+		)
+		return err
+	})
+}
+
+// CancelCreditSettlement returns the fees claimed by a settlement payout whose payment could not be made
+// to pending, and removes the payout.
+func (d *DB) CancelCreditSettlement(payoutID int64) error {
+	return d.withTx(func(t *tx) error {
+		if _, err := t.exec(
+			`UPDATE credit_entries SET settlement_status = ?, payout_id = NULL WHERE payout_id = ? AND settlement_status = ?`,
+			CreditSettlementPending, payoutID, CreditSettlementSettling,
+		); err != nil {
+			return err
+		}
+		_, err := t.exec(`DELETE FROM credit_payouts WHERE id = ? AND txid = ''`, payoutID)
+		return err
+	})
+}
+
+// ListUnpaidCreditSettlements returns settlement payouts started before cutoff whose payment was never recorded,
+// oldest first. They are left settling when the server stops between StartCreditSettlement and
+// CompleteCreditSettlement or CancelCreditSettlement, and are resolved by an operator.
+func (d *DB) ListUnpaidCreditSettlements(cutoff time.Time) ([]CreditPayout, error) {
+	rows, err := d.query(
+		`SELECT id, payee, kind, amount, txid, payment, created_at FROM credit_payouts
+		 WHERE kind = ? AND txid = '' AND created_at < ? ORDER BY created_at ASC, id ASC`,
+		CreditPayoutSettlement, cutoff,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []CreditPayout
+	for rows.Next() {
+		var p CreditPayout
+		if err := rows.Scan(&p.ID, &p.Payee, &p.Kind, &p.Amount, &p.TxID, &p.Payment, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// ListCreditPayouts returns the payouts made to payee, newest first. Settlements still being paid are left out.
+func (d *DB) ListCreditPayouts(payee string, limit int) ([]CreditPayout, error) {
+	rows, err := d.query(
+		`SELECT id, payee, kind, amount, txid, payment, created_at FROM credit_payouts
+		 WHERE payee = ? AND txid <> '' ORDER BY created_at DESC, id DESC LIMIT ?`,
+		payee, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []CreditPayout
+	for rows.Next() {
+		var p CreditPayout
+		if err := rows.Scan(&p.ID, &p.Payee, &p.Kind, &p.Amount, &p.TxID, &p.Payment, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// adjustCredit adds amount to the balance of identityKey, creating the account if needed.
+func adjustCredit(t *tx, identityKey string, amount int64, now time.Time) error {
+	_, err := t.exec(
+		`INSERT INTO credit_accounts (identity_key, balance, created_at, updated_at) VALUES (?, ?, ?, ?)
+		 ON CONFLICT(identity_key) DO UPDATE SET balance = credit_accounts.balance + ?, updated_at = ?`,
+		identityKey, amount, now, now, amount, now,
+	)
+	return err
+}
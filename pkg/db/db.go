@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"strings"
+	"time"
 
 	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
@@ -12,7 +13,8 @@ import (
 // DB wraps the sql.DB connection.
 type DB struct {
 	*sql.DB
-	driver string
+	driver      string
+	defaultFees []DefaultFeePolicy
 }
 
 // New opens a database connection.
@@ -24,7 +26,7 @@ func New(driver, source string) (*DB, error) {
 	if err := conn.Ping(); err != nil {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
-	return &DB{DB: conn, driver: driver}, nil
+	return &DB{DB: conn, driver: driver, defaultFees: DefaultFeePolicies}, nil
 }
 
 // rebind converts ? placeholders to $1, $2, ... for postgres.
@@ -45,6 +47,14 @@ func (d *DB) rebind(query string) string {
 	return buf.String()
 }
 
+// placeholders returns n comma-separated ? placeholders for use in an IN (...) clause.
+func placeholders(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return strings.Repeat("?,", n-1) + "?"
+}
+
 // exec wraps sql.DB.Exec with placeholder rebinding.
 func (d *DB) exec(query string, args ...any) (sql.Result, error) {
 	return d.DB.Exec(d.rebind(query), args...)
@@ -60,6 +70,50 @@ func (d *DB) query(query string, args ...any) (*sql.Rows, error) {
 	return d.DB.Query(d.rebind(query), args...)
 }
 
+// execer is implemented by both DB and tx, for writes that may run inside a transaction.
+type execer interface {
+	exec(query string, args ...any) (sql.Result, error)
+}
+
+// querier is implemented by both *DB and *tx for reads that may run inside a transaction.
+type querier interface {
+	query(query string, args ...any) (*sql.Rows, error)
+}
+
+// tx wraps sql.Tx with the same placeholder rebinding helpers as DB.
+type tx struct {
+	*sql.Tx
+	d *DB
+}
+
+// exec wraps sql.Tx.Exec with placeholder rebinding.
+func (t *tx) exec(query string, args ...any) (sql.Result, error) {
+	return t.Tx.Exec(t.d.rebind(query), args...)
+}
+
+// queryRow wraps sql.Tx.QueryRow with placeholder rebinding.
+func (t *tx) queryRow(query string, args ...any) *sql.Row {
+	return t.Tx.QueryRow(t.d.rebind(query), args...)
+}
+
+// query wraps sql.Tx.Query with placeholder rebinding.
+func (t *tx) query(query string, args ...any) (*sql.Rows, error) {
+	return t.Tx.Query(t.d.rebind(query), args...)
+}
+
+// withTx runs fn in a transaction, committing if fn succeeds and rolling back otherwise.
+func (d *DB) withTx(fn func(t *tx) error) error {
+	sqlTx, err := d.DB.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(&tx{Tx: sqlTx, d: d}); err != nil {
+		_ = sqlTx.Rollback()
+		return err
+	}
+	return sqlTx.Commit()
+}
+
 // Migrate runs all migrations to bring the schema up to date.
 func (d *DB) Migrate() error {
 	var migrations []string
@@ -75,20 +129,167 @@ func (d *DB) Migrate() error {
 			return fmt.Errorf("migration failed: %s: %w", m[:min(60, len(m))], err)
 		}
 	}
+
+	// Columns are added before indexes and seeds, which may refer to them
+	for _, c := range columnMigrations(d.driver) {
+		if err := d.addColumnIfMissing(c.table, c.column, c.definition); err != nil {
+			return fmt.Errorf("migration failed: add %s.%s: %w", c.table, c.column, err)
+		}
+	}
+
+	for _, m := range commonMigrations() {
+		if _, err := d.DB.Exec(m); err != nil {
+			return fmt.Errorf("migration failed: %s: %w", m[:min(60, len(m))], err)
+		}
+	}
+
+	for _, m := range dataMigrations() {
+		if err := d.runDataMigration(m); err != nil {
+			return fmt.Errorf("migration failed: %s: %w", m.name, err)
+		}
+	}
 	return nil
 }
 
+// dataMigration rewrites existing rows once; data_migrations records which ones have run.
+type dataMigration struct {
+	name string
+	stmt string
+}
+
+// dataMigrations lists one-time data fixes, applied in order.
+func dataMigrations() []dataMigration {
+	return []dataMigration{
+		{
+			// Reads used to store the default fee as a box-wide rule. Only untouched rows holding exactly
+			// the old default are removed, so the configured default policy applies to them instead.
+			name: "remove_auto_created_default_permissions",
+			stmt: `DELETE FROM message_permissions
+				WHERE sender IS NULL AND is_pattern = FALSE AND created_at = updated_at
+				AND expires_at IS NULL AND max_messages IS NULL
+				AND fee_per_kb = 0 AND large_payload_threshold = 0 AND large_payload_fee = 0
+				AND recipient_fee = CASE WHEN message_box = 'notifications' THEN 10 ELSE 0 END`,
+		},
+	}
+}
+
+// runDataMigration applies m unless it has already run.
+func (d *DB) runDataMigration(m dataMigration) error {
+	return d.withTx(func(t *tx) error {
+		var n int
+		if err := t.queryRow(`SELECT COUNT(*) FROM data_migrations WHERE name = ?`, m.name).Scan(&n); err != nil || n > 0 {
+			return err
+		}
+		if _, err := t.exec(m.stmt); err != nil {
+			return err
+		}
+		_, err := t.exec(`INSERT INTO data_migrations (name, applied_at) VALUES (?, ?)`, m.name, time.Now())
+		return err
+	})
+}
+
+// columnMigration adds a column to a table created by an earlier release.
+type columnMigration struct {
+	table      string
+	column     string
+	definition string
+}
+
+// columnMigrations lists columns added after their table was first created.
+// CREATE TABLE statements already include them, so these only affect existing databases.
+func columnMigrations(driver string) []columnMigration {
+	ts := "DATETIME"
+	if driver == "postgres" {
+		ts = "TIMESTAMP"
+	}
+	return []columnMigration{
+		{"message_permissions", "expires_at", ts},
+		{"message_permissions", "max_messages", "INTEGER"},
+		{"message_permissions", "messages_used", "INTEGER NOT NULL DEFAULT 0"},
+		{"message_permissions", "fee_per_kb", "INTEGER NOT NULL DEFAULT 0"},
+		{"message_permissions", "large_payload_threshold", "INTEGER NOT NULL DEFAULT 0"},
+		{"message_permissions", "large_payload_fee", "INTEGER NOT NULL DEFAULT 0"},
+		{"message_permissions", "is_pattern", "BOOLEAN NOT NULL DEFAULT FALSE"},
+		{"message_permissions", "pow_difficulty", "INTEGER NOT NULL DEFAULT 0"},
+		{"sender_certificates", "expires_at", ts},
+	}
+}
+
+// addColumnIfMissing adds a column unless the table already has it.
+func (d *DB) addColumnIfMissing(table, column, definition string) error {
+	if d.driver == "postgres" {
+		_, err := d.DB.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s`, table, column, definition))
+		return err
+	}
+
+	rows, err := d.DB.Query(fmt.Sprintf(`PRAGMA table_info(%s)`, table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid     int
+			name    string
+			colType string
+			notNull int
+			dflt    sql.NullString
+			pk      int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = d.DB.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition))
+	return err
+}
+
 func commonMigrations() []string {
 	return []string{
-		`INSERT INTO server_fees (message_box, delivery_fee) VALUES ('notifications', 10) ON CONFLICT DO NOTHING`,
-		`INSERT INTO server_fees (message_box, delivery_fee) VALUES ('inbox', 0) ON CONFLICT DO NOTHING`,
-		`INSERT INTO server_fees (message_box, delivery_fee) VALUES ('payment_inbox', 0) ON CONFLICT DO NOTHING`,
+		// seed fees only until an operator has managed them, so removed boxes stay removed
+		`INSERT INTO server_fees (message_box, delivery_fee) SELECT 'notifications', 10 WHERE NOT EXISTS (SELECT 1 FROM server_fee_changes) ON CONFLICT DO NOTHING`,
+		`INSERT INTO server_fees (message_box, delivery_fee) SELECT 'inbox', 0 WHERE NOT EXISTS (SELECT 1 FROM server_fee_changes) ON CONFLICT DO NOTHING`,
+		`INSERT INTO server_fees (message_box, delivery_fee) SELECT 'payment_inbox', 0 WHERE NOT EXISTS (SELECT 1 FROM server_fee_changes) ON CONFLICT DO NOTHING`,
+		`CREATE INDEX IF NOT EXISTS idx_server_fee_changes_box ON server_fee_changes(message_box)`,
+		`CREATE INDEX IF NOT EXISTS idx_redeemed_quotes_expires ON redeemed_quotes(expires_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_payments_recipient_created ON payments(recipient, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_payments_kind_created ON payments(kind, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_payments_txid ON payments(txid)`,
+		`CREATE INDEX IF NOT EXISTS idx_payments_recipient_message ON payments(recipient, message_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_refunds_payee_created ON refunds(payee, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_spent_payment_outputs_checked ON spent_payment_outputs(checked_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_credit_entries_identity_created ON credit_entries(identity_key, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_credit_entries_settlement ON credit_entries(settlement_status, recipient)`,
+		`CREATE INDEX IF NOT EXISTS idx_credit_payouts_payee_created ON credit_payouts(payee, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_spam_reports_sender_created ON spam_reports(sender, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_subscriptions_recipient_sender_box ON subscriptions(recipient, sender, message_box)`,
+		`CREATE INDEX IF NOT EXISTS idx_subscriptions_recipient_message ON subscriptions(recipient, message_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_sender_certificates_sender ON sender_certificates(sender)`,
+		`CREATE INDEX IF NOT EXISTS idx_sender_certificates_expires ON sender_certificates(expires_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_used_proofs_of_work_created ON used_proofs_of_work(created_at)`,
 		`CREATE INDEX IF NOT EXISTS idx_message_permissions_recipient ON message_permissions(recipient)`,
 		`CREATE INDEX IF NOT EXISTS idx_message_permissions_recipient_box ON message_permissions(recipient, message_box)`,
 		`CREATE INDEX IF NOT EXISTS idx_message_permissions_box ON message_permissions(message_box)`,
 		`CREATE INDEX IF NOT EXISTS idx_message_permissions_sender ON message_permissions(sender)`,
+		`CREATE INDEX IF NOT EXISTS idx_message_permissions_recipient_pattern ON message_permissions(recipient, is_pattern)`,
 		`CREATE INDEX IF NOT EXISTS idx_device_registrations_identity ON device_registrations(identity_key)`,
 		`CREATE INDEX IF NOT EXISTS idx_device_registrations_identity_active ON device_registrations(identity_key, active)`,
+		`CREATE INDEX IF NOT EXISTS idx_notification_preferences_identity ON notification_preferences(identity_key)`,
+		`CREATE INDEX IF NOT EXISTS idx_notification_deliveries_recipient_created ON notification_deliveries(recipient, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_notification_deliveries_created ON notification_deliveries(created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_message_rate_limits_recipient_box ON message_rate_limits(recipient, message_box)`,
+		`CREATE INDEX IF NOT EXISTS idx_message_rate_counters_window_end ON message_rate_counters(window_end)`,
+		`CREATE INDEX IF NOT EXISTS idx_route_request_counters_window_end ON route_request_counters(window_end)`,
+		`CREATE INDEX IF NOT EXISTS idx_device_ownership_transfers_previous ON device_ownership_transfers(previous_identity_key)`,
+		`CREATE INDEX IF NOT EXISTS idx_device_ownership_transfers_new ON device_ownership_transfers(new_identity_key)`,
 	}
 }
 
@@ -119,6 +320,14 @@ func sqliteMigrations() []string {
 			sender TEXT,
 			message_box TEXT NOT NULL,
 			recipient_fee INTEGER NOT NULL,
+			expires_at DATETIME,
+			max_messages INTEGER,
+			messages_used INTEGER NOT NULL DEFAULT 0,
+			fee_per_kb INTEGER NOT NULL DEFAULT 0,
+			large_payload_threshold INTEGER NOT NULL DEFAULT 0,
+			large_payload_fee INTEGER NOT NULL DEFAULT 0,
+			is_pattern BOOLEAN NOT NULL DEFAULT FALSE,
+			pow_difficulty INTEGER NOT NULL DEFAULT 0,
 			UNIQUE(recipient, sender, message_box)
 		)`,
 		`CREATE TABLE IF NOT EXISTS server_fees (
@@ -128,6 +337,175 @@ func sqliteMigrations() []string {
 			message_box TEXT NOT NULL UNIQUE,
 			delivery_fee INTEGER NOT NULL
 		)`,
+		`CREATE TABLE IF NOT EXISTS data_migrations (
+			name TEXT PRIMARY KEY,
+			applied_at DATETIME NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS credit_accounts (
+			identity_key TEXT PRIMARY KEY,
+			balance BIGINT NOT NULL DEFAULT 0,
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS credit_entries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			created_at DATETIME NOT NULL,
+			identity_key TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			amount BIGINT NOT NULL,
+			balance_after BIGINT NOT NULL,
+			txid TEXT,
+			recipient TEXT,
+			message_box TEXT,
+			message_id TEXT,
+			settlement_status TEXT,
+			payout_id BIGINT
+		)`,
+		`CREATE TABLE IF NOT EXISTS credit_payouts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			created_at DATETIME NOT NULL,
+			payee TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			amount BIGINT NOT NULL,
+			txid TEXT NOT NULL,
+			payment TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS route_prices (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			route TEXT NOT NULL,
+			message_box TEXT NOT NULL,
+			price INTEGER NOT NULL,
+			free_requests INTEGER NOT NULL DEFAULT 0,
+			window_seconds INTEGER NOT NULL DEFAULT 86400,
+			UNIQUE(route, message_box)
+		)`,
+		`CREATE TABLE IF NOT EXISTS spam_reports (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			created_at DATETIME NOT NULL,
+			reporter TEXT NOT NULL,
+			sender TEXT NOT NULL,
+			message_id TEXT NOT NULL,
+			message_box TEXT NOT NULL,
+			UNIQUE(reporter, message_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS subscription_offers (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			recipient TEXT NOT NULL,
+			message_box TEXT NOT NULL,
+			price INTEGER NOT NULL,
+			duration_days INTEGER NOT NULL,
+			UNIQUE(recipient, message_box)
+		)`,
+		`CREATE TABLE IF NOT EXISTS subscriptions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			created_at DATETIME NOT NULL,
+			recipient TEXT NOT NULL,
+			sender TEXT NOT NULL,
+			message_box TEXT NOT NULL,
+			message_id TEXT NOT NULL,
+			price INTEGER NOT NULL,
+			starts_at DATETIME NOT NULL,
+			expires_at DATETIME NOT NULL,
+			revoked_at DATETIME
+		)`,
+		`CREATE TABLE IF NOT EXISTS sender_certificates (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			sender TEXT NOT NULL,
+			certifier TEXT NOT NULL,
+			certificate_type TEXT NOT NULL,
+			serial_number TEXT NOT NULL,
+			fields TEXT NOT NULL,
+			UNIQUE(sender, certifier, certificate_type)
+		)`,
+		`CREATE TABLE IF NOT EXISTS certificate_requirements (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			recipient TEXT NOT NULL,
+			message_box TEXT NOT NULL,
+			certifiers TEXT NOT NULL,
+			certificate_type TEXT NOT NULL DEFAULT '',
+			fields TEXT,
+			verified_fee INTEGER,
+			UNIQUE(recipient, message_box)
+		)`,
+		`CREATE TABLE IF NOT EXISTS route_request_counters (
+			identity_key TEXT NOT NULL,
+			route TEXT NOT NULL,
+			message_box TEXT NOT NULL,
+			window_start BIGINT NOT NULL,
+			window_end DATETIME NOT NULL,
+			request_count INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (identity_key, route, message_box, window_start)
+		)`,
+		`CREATE TABLE IF NOT EXISTS redeemed_quotes (
+			quote_id TEXT PRIMARY KEY,
+			sender TEXT NOT NULL,
+			expires_at DATETIME NOT NULL,
+			redeemed_at DATETIME NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS spent_payment_outputs (
+			txid TEXT NOT NULL,
+			output_index INTEGER NOT NULL,
+			sender TEXT NOT NULL,
+			recipient TEXT,
+			message_box TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			checked_at DATETIME NOT NULL,
+			PRIMARY KEY (txid, output_index)
+		)`,
+		`CREATE TABLE IF NOT EXISTS used_proofs_of_work (
+			recipient TEXT NOT NULL,
+			digest TEXT NOT NULL,
+			sender TEXT NOT NULL,
+			message_id TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			PRIMARY KEY (recipient, digest)
+		)`,
+		`CREATE TABLE IF NOT EXISTS payments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			txid TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			sender TEXT NOT NULL,
+			recipient TEXT,
+			message_box TEXT NOT NULL,
+			message_id TEXT,
+			fee INTEGER NOT NULL,
+			amount INTEGER NOT NULL,
+			status TEXT NOT NULL,
+			error TEXT
+		)`,
+		`CREATE TABLE IF NOT EXISTS refunds (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			payment_id INTEGER NOT NULL,
+			payer TEXT NOT NULL,
+			payee TEXT NOT NULL,
+			message_box TEXT NOT NULL,
+			message_id TEXT,
+			reason TEXT NOT NULL,
+			amount INTEGER NOT NULL,
+			txid TEXT,
+			payment TEXT,
+			status TEXT NOT NULL,
+			error TEXT
+		)`,
+		`CREATE TABLE IF NOT EXISTS server_fee_changes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			message_box TEXT NOT NULL,
+			previous_fee INTEGER,
+			new_fee INTEGER,
+			changed_by TEXT NOT NULL
+		)`,
 		`CREATE TABLE IF NOT EXISTS device_registrations (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
@@ -139,8 +517,89 @@ func sqliteMigrations() []string {
 			last_used DATETIME,
 			active BOOLEAN DEFAULT TRUE
 		)`,
+		`CREATE TABLE IF NOT EXISTS notification_preferences (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			identity_key TEXT NOT NULL,
+			message_box TEXT NOT NULL,
+			mode TEXT NOT NULL,
+			allowed_senders TEXT,
+			quiet_hours_start TEXT,
+			quiet_hours_end TEXT,
+			timezone TEXT,
+			UNIQUE(identity_key, message_box)
+		)`,
+		`CREATE TABLE IF NOT EXISTS notification_payload_settings (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			identity_key TEXT NOT NULL,
+			message_box TEXT NOT NULL,
+			title_template TEXT,
+			body_template TEXT,
+			silent BOOLEAN DEFAULT FALSE,
+			badge BOOLEAN DEFAULT FALSE,
+			collapse_key TEXT,
+			ttl_seconds INTEGER,
+			UNIQUE(identity_key, message_box)
+		)`,
+		`CREATE TABLE IF NOT EXISTS notification_deliveries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			recipient TEXT NOT NULL,
+			device_registration_id INTEGER,
+			message_id TEXT,
+			message_box TEXT,
+			provider TEXT NOT NULL,
+			status TEXT NOT NULL,
+			error_code TEXT,
+			error_message TEXT,
+			latency_ms INTEGER NOT NULL DEFAULT 0
+		)`,
+		`CREATE TABLE IF NOT EXISTS message_rate_limits (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			recipient TEXT NOT NULL,
+			sender TEXT,
+			message_box TEXT NOT NULL,
+			max_messages INTEGER NOT NULL,
+			window_seconds INTEGER NOT NULL,
+			UNIQUE(recipient, sender, message_box)
+		)`,
+		`CREATE TABLE IF NOT EXISTS message_rate_counters (
+			recipient TEXT NOT NULL,
+			scope TEXT NOT NULL,
+			message_box TEXT NOT NULL,
+			window_seconds INTEGER NOT NULL,
+			window_start BIGINT NOT NULL,
+			window_end DATETIME NOT NULL,
+			message_count INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (recipient, scope, message_box, window_seconds, window_start)
+		)`,
+		`CREATE TABLE IF NOT EXISTS device_token_challenges (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			fcm_token TEXT NOT NULL,
+			identity_key TEXT NOT NULL,
+			nonce_hash TEXT NOT NULL,
+			device_id TEXT,
+			platform TEXT,
+			expires_at DATETIME NOT NULL,
+			UNIQUE(fcm_token, identity_key)
+		)`,
+		`CREATE TABLE IF NOT EXISTS device_ownership_transfers (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			device_registration_id INTEGER NOT NULL,
+			fcm_token_suffix TEXT NOT NULL,
+			previous_identity_key TEXT NOT NULL,
+			new_identity_key TEXT NOT NULL,
+			method TEXT NOT NULL
+		)`,
 	}
-	return append(tables, commonMigrations()...)
+	return tables
 }
 
 func postgresMigrations() []string {
@@ -170,6 +629,14 @@ func postgresMigrations() []string {
 			sender TEXT,
 			message_box TEXT NOT NULL,
 			recipient_fee INTEGER NOT NULL,
+			expires_at TIMESTAMP,
+			max_messages INTEGER,
+			messages_used INTEGER NOT NULL DEFAULT 0,
+			fee_per_kb INTEGER NOT NULL DEFAULT 0,
+			large_payload_threshold INTEGER NOT NULL DEFAULT 0,
+			large_payload_fee INTEGER NOT NULL DEFAULT 0,
+			is_pattern BOOLEAN NOT NULL DEFAULT FALSE,
+			pow_difficulty INTEGER NOT NULL DEFAULT 0,
 			UNIQUE(recipient, sender, message_box)
 		)`,
 		`CREATE TABLE IF NOT EXISTS server_fees (
@@ -179,6 +646,175 @@ func postgresMigrations() []string {
 			message_box TEXT NOT NULL UNIQUE,
 			delivery_fee INTEGER NOT NULL
 		)`,
+		`CREATE TABLE IF NOT EXISTS data_migrations (
+			name TEXT PRIMARY KEY,
+			applied_at TIMESTAMP NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS credit_accounts (
+			identity_key TEXT PRIMARY KEY,
+			balance BIGINT NOT NULL DEFAULT 0,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS credit_entries (
+			id SERIAL PRIMARY KEY,
+			created_at TIMESTAMP NOT NULL,
+			identity_key TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			amount BIGINT NOT NULL,
+			balance_after BIGINT NOT NULL,
+			txid TEXT,
+			recipient TEXT,
+			message_box TEXT,
+			message_id TEXT,
+			settlement_status TEXT,
+			payout_id BIGINT
+		)`,
+		`CREATE TABLE IF NOT EXISTS credit_payouts (
+			id SERIAL PRIMARY KEY,
+			created_at TIMESTAMP NOT NULL,
+			payee TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			amount BIGINT NOT NULL,
+			txid TEXT NOT NULL,
+			payment TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS route_prices (
+			id SERIAL PRIMARY KEY,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			route TEXT NOT NULL,
+			message_box TEXT NOT NULL,
+			price INTEGER NOT NULL,
+			free_requests INTEGER NOT NULL DEFAULT 0,
+			window_seconds INTEGER NOT NULL DEFAULT 86400,
+			UNIQUE(route, message_box)
+		)`,
+		`CREATE TABLE IF NOT EXISTS spam_reports (
+			id SERIAL PRIMARY KEY,
+			created_at TIMESTAMP NOT NULL,
+			reporter TEXT NOT NULL,
+			sender TEXT NOT NULL,
+			message_id TEXT NOT NULL,
+			message_box TEXT NOT NULL,
+			UNIQUE(reporter, message_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS subscription_offers (
+			id SERIAL PRIMARY KEY,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			recipient TEXT NOT NULL,
+			message_box TEXT NOT NULL,
+			price INTEGER NOT NULL,
+			duration_days INTEGER NOT NULL,
+			UNIQUE(recipient, message_box)
+		)`,
+		`CREATE TABLE IF NOT EXISTS subscriptions (
+			id SERIAL PRIMARY KEY,
+			created_at TIMESTAMP NOT NULL,
+			recipient TEXT NOT NULL,
+			sender TEXT NOT NULL,
+			message_box TEXT NOT NULL,
+			message_id TEXT NOT NULL,
+			price INTEGER NOT NULL,
+			starts_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			revoked_at TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS sender_certificates (
+			id SERIAL PRIMARY KEY,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			sender TEXT NOT NULL,
+			certifier TEXT NOT NULL,
+			certificate_type TEXT NOT NULL,
+			serial_number TEXT NOT NULL,
+			fields TEXT NOT NULL,
+			UNIQUE(sender, certifier, certificate_type)
+		)`,
+		`CREATE TABLE IF NOT EXISTS certificate_requirements (
+			id SERIAL PRIMARY KEY,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			recipient TEXT NOT NULL,
+			message_box TEXT NOT NULL,
+			certifiers TEXT NOT NULL,
+			certificate_type TEXT NOT NULL DEFAULT '',
+			fields TEXT,
+			verified_fee INTEGER,
+			UNIQUE(recipient, message_box)
+		)`,
+		`CREATE TABLE IF NOT EXISTS route_request_counters (
+			identity_key TEXT NOT NULL,
+			route TEXT NOT NULL,
+			message_box TEXT NOT NULL,
+			window_start BIGINT NOT NULL,
+			window_end TIMESTAMP NOT NULL,
+			request_count INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (identity_key, route, message_box, window_start)
+		)`,
+		`CREATE TABLE IF NOT EXISTS redeemed_quotes (
+			quote_id TEXT PRIMARY KEY,
+			sender TEXT NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			redeemed_at TIMESTAMP NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS spent_payment_outputs (
+			txid TEXT NOT NULL,
+			output_index INTEGER NOT NULL,
+			sender TEXT NOT NULL,
+			recipient TEXT,
+			message_box TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			checked_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (txid, output_index)
+		)`,
+		`CREATE TABLE IF NOT EXISTS used_proofs_of_work (
+			recipient TEXT NOT NULL,
+			digest TEXT NOT NULL,
+			sender TEXT NOT NULL,
+			message_id TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (recipient, digest)
+		)`,
+		`CREATE TABLE IF NOT EXISTS payments (
+			id SERIAL PRIMARY KEY,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			txid TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			sender TEXT NOT NULL,
+			recipient TEXT,
+			message_box TEXT NOT NULL,
+			message_id TEXT,
+			fee INTEGER NOT NULL,
+			amount INTEGER NOT NULL,
+			status TEXT NOT NULL,
+			error TEXT
+		)`,
+		`CREATE TABLE IF NOT EXISTS refunds (
+			id SERIAL PRIMARY KEY,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			payment_id INTEGER NOT NULL,
+			payer TEXT NOT NULL,
+			payee TEXT NOT NULL,
+			message_box TEXT NOT NULL,
+			message_id TEXT,
+			reason TEXT NOT NULL,
+			amount INTEGER NOT NULL,
+			txid TEXT,
+			payment TEXT,
+			status TEXT NOT NULL,
+			error TEXT
+		)`,
+		`CREATE TABLE IF NOT EXISTS server_fee_changes (
+			id SERIAL PRIMARY KEY,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			message_box TEXT NOT NULL,
+			previous_fee INTEGER,
+			new_fee INTEGER,
+			changed_by TEXT NOT NULL
+		)`,
 		`CREATE TABLE IF NOT EXISTS device_registrations (
 			id SERIAL PRIMARY KEY,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
@@ -190,6 +826,87 @@ func postgresMigrations() []string {
 			last_used TIMESTAMP,
 			active BOOLEAN DEFAULT TRUE
 		)`,
+		`CREATE TABLE IF NOT EXISTS notification_preferences (
+			id SERIAL PRIMARY KEY,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			identity_key TEXT NOT NULL,
+			message_box TEXT NOT NULL,
+			mode TEXT NOT NULL,
+			allowed_senders TEXT,
+			quiet_hours_start TEXT,
+			quiet_hours_end TEXT,
+			timezone TEXT,
+			UNIQUE(identity_key, message_box)
+		)`,
+		`CREATE TABLE IF NOT EXISTS notification_payload_settings (
+			id SERIAL PRIMARY KEY,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			identity_key TEXT NOT NULL,
+			message_box TEXT NOT NULL,
+			title_template TEXT,
+			body_template TEXT,
+			silent BOOLEAN DEFAULT FALSE,
+			badge BOOLEAN DEFAULT FALSE,
+			collapse_key TEXT,
+			ttl_seconds INTEGER,
+			UNIQUE(identity_key, message_box)
+		)`,
+		`CREATE TABLE IF NOT EXISTS notification_deliveries (
+			id SERIAL PRIMARY KEY,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			recipient TEXT NOT NULL,
+			device_registration_id INTEGER,
+			message_id TEXT,
+			message_box TEXT,
+			provider TEXT NOT NULL,
+			status TEXT NOT NULL,
+			error_code TEXT,
+			error_message TEXT,
+			latency_ms INTEGER NOT NULL DEFAULT 0
+		)`,
+		`CREATE TABLE IF NOT EXISTS message_rate_limits (
+			id SERIAL PRIMARY KEY,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			recipient TEXT NOT NULL,
+			sender TEXT,
+			message_box TEXT NOT NULL,
+			max_messages INTEGER NOT NULL,
+			window_seconds INTEGER NOT NULL,
+			UNIQUE(recipient, sender, message_box)
+		)`,
+		`CREATE TABLE IF NOT EXISTS message_rate_counters (
+			recipient TEXT NOT NULL,
+			scope TEXT NOT NULL,
+			message_box TEXT NOT NULL,
+			window_seconds INTEGER NOT NULL,
+			window_start BIGINT NOT NULL,
+			window_end TIMESTAMP NOT NULL,
+			message_count INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (recipient, scope, message_box, window_seconds, window_start)
+		)`,
+		`CREATE TABLE IF NOT EXISTS device_token_challenges (
+			id SERIAL PRIMARY KEY,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			fcm_token TEXT NOT NULL,
+			identity_key TEXT NOT NULL,
+			nonce_hash TEXT NOT NULL,
+			device_id TEXT,
+			platform TEXT,
+			expires_at TIMESTAMP NOT NULL,
+			UNIQUE(fcm_token, identity_key)
+		)`,
+		`CREATE TABLE IF NOT EXISTS device_ownership_transfers (
+			id SERIAL PRIMARY KEY,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			device_registration_id INTEGER NOT NULL,
+			fcm_token_suffix TEXT NOT NULL,
+			previous_identity_key TEXT NOT NULL,
+			new_identity_key TEXT NOT NULL,
+			method TEXT NOT NULL
+		)`,
 	}
-	return append(tables, commonMigrations()...)
+	return tables
 }
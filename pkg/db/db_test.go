@@ -1,7 +1,12 @@
 package db
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
 	"testing"
+	"time"
 )
 
 func setupTestDB(t *testing.T) *DB {
@@ -77,6 +82,11 @@ func TestInsertAndListMessages(t *testing.T) {
 	if len(msgs) != 1 {
 		t.Fatal("duplicate message was inserted")
 	}
+
+	existing, err := d.ExistingMessageIDs([]string{"msg2", "msg1"})
+	if err != nil || !slices.Equal(existing, []string{"msg1"}) {
+		t.Fatalf("expected only msg1 to exist, got %v, %v", existing, err)
+	}
 }
 
 func TestAcknowledgeMessages(t *testing.T) {
@@ -199,3 +209,1671 @@ func TestDeviceRegistration(t *testing.T) {
 		t.Fatalf("expected 1 device, got %d", len(devices))
 	}
 }
+
+func TestBulkDeviceUpdates(t *testing.T) {
+	d := setupTestDB(t)
+
+	for _, tok := range []string{"token1", "token2", "token3"} {
+		if _, err := d.RegisterDevice("key1", tok, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := d.DeactivateDevices([]string{"token1", "token3"}); err != nil {
+		t.Fatal(err)
+	}
+
+	active, err := d.ListActiveDevices("key1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 1 || active[0].FCMToken != "token2" {
+		t.Fatalf("expected only token2 to remain active, got %v", active)
+	}
+
+	if err := d.UpdateDevicesLastUsed([]string{"token2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Empty batches are a no-op
+	if err := d.DeactivateDevices(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.UpdateDevicesLastUsed(nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNotificationPreferences(t *testing.T) {
+	d := setupTestDB(t)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// Defaults: only the notifications box pushes
+	push, err := d.ShouldUseFCMDelivery("r1", "s1", "notifications", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !push {
+		t.Fatal("expected push for notifications box by default")
+	}
+	push, _ = d.ShouldUseFCMDelivery("r1", "s1", "inbox", now)
+	if push {
+		t.Fatal("expected no push for inbox by default")
+	}
+
+	// Allowlist for inbox
+	if err := d.SetNotificationPreference("r1", "inbox", NotificationModeAllowlist, []string{"s1"}, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	push, _ = d.ShouldUseFCMDelivery("r1", "s1", "inbox", now)
+	if !push {
+		t.Fatal("expected push for allowlisted sender")
+	}
+	push, _ = d.ShouldUseFCMDelivery("r1", "s2", "inbox", now)
+	if push {
+		t.Fatal("expected no push for sender outside allowlist")
+	}
+
+	// Opt out of notifications box
+	if err := d.SetNotificationPreference("r1", "notifications", NotificationModeNone, nil, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	push, _ = d.ShouldUseFCMDelivery("r1", "s1", "notifications", now)
+	if push {
+		t.Fatal("expected no push after opting out")
+	}
+
+	prefs, err := d.ListNotificationPreferences("r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prefs) != 2 {
+		t.Fatalf("expected 2 preferences, got %d", len(prefs))
+	}
+	if prefs[0].MessageBox != "inbox" || len(prefs[0].AllowedSenders) != 1 {
+		t.Fatalf("unexpected inbox preference: %+v", prefs[0])
+	}
+}
+
+func TestNotificationQuietHours(t *testing.T) {
+	d := setupTestDB(t)
+
+	start, end, tz := "22:00", "07:00", "America/New_York"
+	if err := d.SetNotificationPreference("r1", "inbox", NotificationModeAlways, nil, &start, &end, &tz); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		now      time.Time
+		expected bool
+	}{
+		// New York is UTC-5 in January
+		{"before window", time.Date(2024, 1, 1, 21, 0, 0, 0, time.UTC), true},
+		{"late evening local", time.Date(2024, 1, 2, 3, 30, 0, 0, time.UTC), false},
+		{"early morning local", time.Date(2024, 1, 2, 11, 59, 0, 0, time.UTC), false},
+		{"window end", time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			push, err := d.ShouldUseFCMDelivery("r1", "anyone", "inbox", tt.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if push != tt.expected {
+				t.Errorf("ShouldUseFCMDelivery at %s = %v, expected %v", tt.now, push, tt.expected)
+			}
+		})
+	}
+
+	if err := ParseQuietHours("25:00", "07:00", "UTC"); err == nil {
+		t.Error("expected invalid start to be rejected")
+	}
+	if err := ParseQuietHours("22:00", "07:00", "Not/AZone"); err == nil {
+		t.Error("expected invalid timezone to be rejected")
+	}
+}
+
+func TestNotificationDeliveries(t *testing.T) {
+	d := setupTestDB(t)
+
+	err := d.InsertNotificationDeliveries([]NotificationDeliveryRecord{
+		{Recipient: "r1", Provider: "fcm", Status: DeliveryStatusSent, LatencyMs: 10},
+		{Recipient: "r1", Provider: "fcm", Status: DeliveryStatusFailed, ErrorCode: sql.NullString{String: "UNREGISTERED", Valid: true}},
+		{Recipient: "r2", Provider: "fcm", Status: DeliveryStatusFailed, ErrorCode: sql.NullString{String: "UNREGISTERED", Valid: true}},
+		{Recipient: "r2", Provider: "fcm", Status: DeliveryStatusFailed, ErrorCode: sql.NullString{String: "TIMEOUT", Valid: true}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := d.ListNotificationDeliveries("r1", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 deliveries for r1, got %d", len(records))
+	}
+
+	counts, err := d.CountDeliveryFailures(time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 2 {
+		t.Fatalf("expected 2 failure groups, got %d", len(counts))
+	}
+	if counts[0].ErrorCode != "UNREGISTERED" || counts[0].Count != 2 {
+		t.Fatalf("expected UNREGISTERED=2 first, got %+v", counts[0])
+	}
+
+	if n, err := d.DeleteNotificationDeliveriesBefore(time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Fatalf("expected recent deliveries to be kept, deleted %d, err %v", n, err)
+	}
+	if n, err := d.DeleteNotificationDeliveriesBefore(time.Now().Add(time.Second)); err != nil || n != 4 {
+		t.Fatalf("expected 4 deliveries deleted, got %d, err %v", n, err)
+	}
+}
+
+func TestDeviceManagement(t *testing.T) {
+	d := setupTestDB(t)
+
+	id, err := d.RegisterDevice("key1", "token1", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Other identities can't touch the device
+	label := "work-phone"
+	found, err := d.UpdateDeviceLabels("key2", int(id), &label, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found {
+		t.Fatal("expected update by another identity to find nothing")
+	}
+	found, err = d.DeleteDevice("key2", int(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found {
+		t.Fatal("expected delete by another identity to find nothing")
+	}
+
+	platform := "android"
+	found, err = d.UpdateDeviceLabels("key1", int(id), &label, &platform)
+	if err != nil || !found {
+		t.Fatalf("expected update to succeed, found=%v err=%v", found, err)
+	}
+
+	// Nil labels are left unchanged
+	if _, err := d.UpdateDeviceLabels("key1", int(id), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	dev, err := d.GetDevice("key1", int(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.DeviceID.String != label || dev.Platform.String != platform {
+		t.Fatalf("unexpected labels: %+v", dev)
+	}
+
+	found, err = d.DeleteDevice("key1", int(id))
+	if err != nil || !found {
+		t.Fatalf("expected delete to succeed, found=%v err=%v", found, err)
+	}
+	dev, err = d.GetDevice("key1", int(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev != nil {
+		t.Fatal("device should be gone")
+	}
+}
+
+func TestDeactivateStaleDevices(t *testing.T) {
+	d := setupTestDB(t)
+
+	if _, err := d.RegisterDevice("key1", "old-token", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Exec(`UPDATE device_registrations SET last_used = ? WHERE fcm_token = ?`, time.Now().Add(-90*24*time.Hour), "old-token"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.RegisterDevice("key1", "fresh-token", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := d.DeactivateStaleDevices(time.Now().Add(-60 * 24 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 stale device, got %d", n)
+	}
+
+	active, _ := d.ListActiveDevices("key1")
+	if len(active) != 1 || active[0].FCMToken != "fresh-token" {
+		t.Fatalf("expected only fresh-token active, got %v", active)
+	}
+}
+
+func TestDeviceTokenOwnership(t *testing.T) {
+	d := setupTestDB(t)
+
+	id, err := d.RegisterDevice("key1", "shared-token", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Re-registering by the owner keeps working
+	again, err := d.RegisterDevice("key1", "shared-token", nil, nil)
+	if err != nil || again != id {
+		t.Fatalf("expected owner re-registration to return %d, got %d (%v)", id, again, err)
+	}
+
+	// Another identity can't silently take the token
+	if _, err := d.RegisterDevice("key2", "shared-token", nil, nil); err != ErrDeviceTokenOwned {
+		t.Fatalf("expected ErrDeviceTokenOwned, got %v", err)
+	}
+	dev, err := d.GetDeviceByToken("shared-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.IdentityKey != "key1" {
+		t.Fatalf("expected token to stay with key1, got %s", dev.IdentityKey)
+	}
+
+	// Challenges are consumed on first use
+	expires := time.Now().Add(time.Minute)
+	if err := d.CreateDeviceChallenge("shared-token", "key2", "hash1", nil, nil, expires); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.CreateDeviceChallenge("shared-token", "key2", "hash2", nil, nil, expires); err != nil {
+		t.Fatal(err)
+	}
+	c, err := d.ConsumeDeviceChallenge("shared-token", "key2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c == nil || c.NonceHash != "hash2" {
+		t.Fatalf("expected latest challenge, got %+v", c)
+	}
+	if c, err := d.ConsumeDeviceChallenge("shared-token", "key2"); err != nil || c != nil {
+		t.Fatalf("expected challenge to be consumed, got %+v (%v)", c, err)
+	}
+
+	transfer, err := d.TransferDeviceOwnership("shared-token", "key2", sql.NullString{}, sql.NullString{}, TransferMethodChallenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transfer.PreviousIdentityKey != "key1" || transfer.DeviceRegistrationID != id {
+		t.Fatalf("unexpected transfer: %+v", transfer)
+	}
+	if dev, _ := d.GetDeviceByToken("shared-token"); dev.IdentityKey != "key2" {
+		t.Fatalf("expected token to move to key2, got %s", dev.IdentityKey)
+	}
+
+	for _, key := range []string{"key1", "key2"} {
+		audit, err := d.ListDeviceOwnershipTransfers(key, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(audit) != 1 || audit[0].Method != TransferMethodChallenge {
+			t.Fatalf("expected one audited transfer for %s, got %+v", key, audit)
+		}
+	}
+
+	if _, err := d.TransferDeviceOwnership("missing-token", "key2", sql.NullString{}, sql.NullString{}, TransferMethodChallenge); err != ErrDeviceNotFound {
+		t.Fatalf("expected ErrDeviceNotFound, got %v", err)
+	}
+}
+
+func TestDeleteExpiredDeviceChallenges(t *testing.T) {
+	d := setupTestDB(t)
+
+	if err := d.CreateDeviceChallenge("t1", "key1", "h", nil, nil, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.CreateDeviceChallenge("t2", "key1", "h", nil, nil, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := d.DeleteExpiredDeviceChallenges(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 expired challenge deleted, got %d", n)
+	}
+}
+
+func TestPermissionLimits(t *testing.T) {
+	d := setupTestDB(t)
+
+	if err := d.SetMessagePermission("r1", nil, "inbox", 50); err != nil {
+		t.Fatal(err)
+	}
+
+	// Free for 2 messages, then the box default applies
+	mbID, _ := d.EnsureMessageBox("r1", "inbox")
+	sender := "s1"
+	maxMessages := 2
+	if err := d.SetMessagePermissionRule("r1", &sender, "inbox", 0, PermissionLimits{MaxMessages: &maxMessages}, SizePricing{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range 2 {
+		res, err := d.ResolveRecipientFee("r1", "s1", "inbox", time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Fee != 0 || res.Permission == nil {
+			t.Fatalf("message %d: expected free sender rule, got %+v", i, res)
+		}
+		if remaining := res.Permission.RemainingMessages(); remaining == nil || *remaining != 2-i {
+			t.Fatalf("message %d: unexpected remaining %v", i, remaining)
+		}
+		msg := NewMessage{MessageID: fmt.Sprintf("m%d", i), MessageBoxID: mbID, Sender: "s1", Recipient: "r1", Body: `{}`, PermissionID: res.Permission.ID}
+		if _, err := d.InsertMessages([]NewMessage{msg}, nil); err != nil {
+			t.Fatalf("expected message %d to be counted, got %v", i, err)
+		}
+	}
+
+	res, err := d.ResolveRecipientFee("r1", "s1", "inbox", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Fee != 50 || res.Permission != nil {
+		t.Fatalf("expected box default after cap, got %+v", res)
+	}
+	perm, _ := d.GetPermission("r1", &sender, "inbox")
+	if _, err := d.InsertMessages([]NewMessage{{MessageID: "m2", MessageBoxID: mbID, Sender: "s1", Recipient: "r1", Body: `{}`, PermissionID: perm.ID}}, nil); !errors.Is(err, ErrPermissionUsedUp) {
+		t.Fatalf("expected exhausted rule not to be counted, got %v", err)
+	}
+
+	// Setting the rule again starts a new grant
+	if err := d.SetMessagePermissionRule("r1", &sender, "inbox", 0, PermissionLimits{MaxMessages: &maxMessages}, SizePricing{}); err != nil {
+		t.Fatal(err)
+	}
+	if fee, _ := d.GetRecipientFee("r1", "s1", "inbox"); fee != 0 {
+		t.Fatalf("expected reset grant to be free, got %d", fee)
+	}
+
+	// Expired rules fall back as well
+	expiresAt := time.Now().Add(time.Hour)
+	if err := d.SetMessagePermissionRule("r1", &sender, "inbox", 0, PermissionLimits{ExpiresAt: &expiresAt}, SizePricing{}); err != nil {
+		t.Fatal(err)
+	}
+	res, err = d.ResolveRecipientFee("r1", "s1", "inbox", time.Now())
+	if err != nil || res.Fee != 0 {
+		t.Fatalf("expected rule before expiry, got %+v (%v)", res, err)
+	}
+	res, err = d.ResolveRecipientFee("r1", "s1", "inbox", expiresAt.Add(time.Second))
+	if err != nil || res.Fee != 50 {
+		t.Fatalf("expected box default after expiry, got %+v (%v)", res, err)
+	}
+}
+
+func TestMigrateAddsPermissionLimitColumns(t *testing.T) {
+	d, err := New("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	d.SetMaxOpenConns(1)
+
+	// Table as created by releases before permission limits
+	if _, err := d.Exec(`CREATE TABLE message_permissions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		recipient TEXT NOT NULL,
+		sender TEXT,
+		message_box TEXT NOT NULL,
+		recipient_fee INTEGER NOT NULL,
+		UNIQUE(recipient, sender, message_box)
+	)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Exec(`INSERT INTO message_permissions (recipient, sender, message_box, recipient_fee) VALUES ('r1', 's1', 'inbox', 5)`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Running twice must be harmless
+	for range 2 {
+		if err := d.Migrate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sender := "s1"
+	perm, err := d.GetPermission("r1", &sender, "inbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm.RecipientFee != 5 || perm.MaxMessages.Valid || perm.ExpiresAt.Valid || perm.MessagesUsed != 0 {
+		t.Fatalf("unexpected migrated permission: %+v", perm)
+	}
+}
+
+func TestRateLimits(t *testing.T) {
+	d := setupTestDB(t)
+	// InsertMessages counts against the current window, so use a week-long one the test cannot straddle
+	const window = 7 * 24 * 3600
+	now := time.Now()
+
+	if st, err := d.CheckRateLimit("r1", "s1", "inbox", now); err != nil || st != nil {
+		t.Fatalf("expected no limit, got %+v (%v)", st, err)
+	}
+
+	// 2 per window from s1, 3 per window from anyone
+	sender := "s1"
+	if err := d.SetRateLimit("r1", &sender, "inbox", 2, window); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetRateLimit("r1", nil, "inbox", 3, window); err != nil {
+		t.Fatal(err)
+	}
+	mbID, _ := d.EnsureMessageBox("r1", "inbox")
+	send := func(id, sender string) error {
+		_, err := d.InsertMessages([]NewMessage{{MessageID: id, MessageBoxID: mbID, MessageBox: "inbox", Sender: sender, Recipient: "r1", Body: "x"}}, nil)
+		return err
+	}
+
+	for i := range 2 {
+		if err := send(fmt.Sprintf("m%d", i), "s1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	st, err := d.CheckRateLimit("r1", "s1", "inbox", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.Limited() || !st.SenderSpecific {
+		t.Fatalf("expected s1 to be limited by its own rule, got %+v", st)
+	}
+	if st.RetryAfter(now) <= 0 || st.RetryAfter(now) > window {
+		t.Fatalf("unexpected retry after %d", st.RetryAfter(now))
+	}
+	if err := send("m2", "s1"); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited for s1, got %v", err)
+	}
+
+	// Another sender only hits the box-wide limit, which has one message left
+	st, err = d.CheckRateLimit("r1", "s2", "inbox", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Limited() || st.Remaining != 1 || st.SenderSpecific {
+		t.Fatalf("expected 1 remaining on the box-wide limit, got %+v", st)
+	}
+	if err := send("m3", "s2"); err != nil {
+		t.Fatal(err)
+	}
+	if st, _ := d.CheckRateLimit("r1", "s2", "inbox", now); !st.Limited() {
+		t.Fatal("expected box-wide limit to be exhausted")
+	}
+	if err := send("m4", "s2"); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited past the limit, got %v", err)
+	}
+	if n, _ := d.CountMessages("r1", mbID); n != 3 {
+		t.Fatalf("expected rate limited messages not to be stored, got %d messages", n)
+	}
+
+	// The next window starts fresh
+	later := now.Add(window * time.Second)
+	if st, _ := d.CheckRateLimit("r1", "s1", "inbox", later); st.Limited() {
+		t.Fatal("expected limits to reset in the next window")
+	}
+
+	n, err := d.DeleteExpiredRateCounters(later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 expired counters, got %d", n)
+	}
+
+	limits, err := d.ListRateLimits("r1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(limits) != 2 || limits[0].Sender.Valid {
+		t.Fatalf("expected box-wide limit first, got %+v", limits)
+	}
+
+	removed, err := d.DeleteRateLimit("r1", &sender, "inbox")
+	if err != nil || !removed {
+		t.Fatalf("expected limit removed, removed=%v err=%v", removed, err)
+	}
+}
+
+func TestSizePricing(t *testing.T) {
+	pricing := SizePricing{FeePerKB: 2, LargePayloadThreshold: 4096, LargePayloadFee: 100}
+
+	tests := []struct {
+		base, size, expected int
+	}{
+		{10, 0, 10},
+		{10, 1, 12},
+		{10, 1024, 12},
+		{10, 1025, 14},
+		{10, 4096, 18},
+		{10, 4097, 10 + 5*2 + 100},
+		{-1, 10000, -1},
+	}
+	for _, tt := range tests {
+		if got := pricing.FeeFor(tt.base, tt.size); got != tt.expected {
+			t.Errorf("FeeFor(%d, %d) = %d, want %d", tt.base, tt.size, got, tt.expected)
+		}
+	}
+
+	if !(SizePricing{}).IsFlat() || pricing.IsFlat() {
+		t.Fatal("unexpected IsFlat")
+	}
+
+	// fees saturate instead of overflowing into a negative, free fee
+	huge := SizePricing{FeePerKB: MaxFee, LargePayloadThreshold: 1, LargePayloadFee: MaxFee}
+	if got := huge.FeeFor(MaxFee, 1<<20); got != MaxFee {
+		t.Errorf("expected the fee to saturate at %d, got %d", MaxFee, got)
+	}
+	if got := (SizePricing{LargePayloadThreshold: 1, LargePayloadFee: MaxFee}).FeeFor(10, 2); got != MaxFee {
+		t.Errorf("expected the large payload fee to saturate at %d, got %d", MaxFee, got)
+	}
+
+	d := setupTestDB(t)
+	sender := "s1"
+	if err := d.SetMessagePermissionRule("r1", &sender, "inbox", 5, PermissionLimits{}, SizePricing{FeePerKB: 3}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := d.ResolveRecipientFee("r1", "s1", "inbox", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Fee != 5 || res.FeeFor(2048) != 11 {
+		t.Fatalf("unexpected fees: base %d, 2KB %d", res.Fee, res.FeeFor(2048))
+	}
+
+	// A flat update clears the pricing
+	if err := d.SetMessagePermission("r1", &sender, "inbox", 5); err != nil {
+		t.Fatal(err)
+	}
+	perm, _ := d.GetPermission("r1", &sender, "inbox")
+	if !perm.SizePricing.IsFlat() {
+		t.Fatalf("expected flat pricing, got %+v", perm.SizePricing)
+	}
+}
+
+func TestPermissionPatterns(t *testing.T) {
+	d := setupTestDB(t)
+	sender := "s1"
+
+	// Box-wide: block everything under app.*, but allow app.chat.* for a fee
+	if err := d.SetMessagePermission("r1", nil, "app.*", -1); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetMessagePermission("r1", nil, "app.chat.*", 20); err != nil {
+		t.Fatal(err)
+	}
+	// s1 may use any app box for free
+	if err := d.SetMessagePermission("r1", &sender, "app.*", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		sender, box string
+		expected    int
+	}{
+		{"s2", "app.files", -1},
+		{"s2", "app.chat.room1", 20},
+		{"s1", "app.chat.room1", 0},
+		{"s1", "app.files", 0},
+	}
+	for _, tt := range tests {
+		fee, err := d.GetRecipientFee("r1", tt.sender, tt.box)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fee != tt.expected {
+			t.Errorf("%s -> %s: expected %d, got %d", tt.sender, tt.box, tt.expected, fee)
+		}
+	}
+
+	// An exact box-wide rule beats any box-wide pattern
+	if err := d.SetMessagePermission("r1", nil, "app.files", 7); err != nil {
+		t.Fatal(err)
+	}
+	if fee, _ := d.GetRecipientFee("r1", "s2", "app.files"); fee != 7 {
+		t.Fatalf("expected exact rule to win, got %d", fee)
+	}
+
+	// Pattern matches must not auto-create exact defaults that would shadow them
+	if _, err := d.GetRecipientFee("r1", "s2", "app.chat.room2"); err != nil {
+		t.Fatal(err)
+	}
+	if perm, _ := d.GetPermission("r1", nil, "app.chat.room2"); perm != nil {
+		t.Fatal("expected no default row for a box covered by a pattern")
+	}
+
+	// Listing by box shows applying rules in precedence order and marks the winners
+	perms, total, err := d.ListPermissions("r1", strPtr("app.chat.room1"), 100, 0, "desc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 {
+		t.Fatalf("expected 3 matching rules, got %d", total)
+	}
+	if perms[0].MessageBox != "app.chat.*" || !perms[0].Effective {
+		t.Fatalf("expected app.chat.* to be the effective box-wide rule, got %+v", perms[0])
+	}
+	if perms[1].MessageBox != "app.*" || perms[1].Effective {
+		t.Fatalf("expected shadowed app.* rule second, got %+v", perms[1])
+	}
+	if !perms[2].Sender.Valid || !perms[2].Effective || !perms[2].IsPattern {
+		t.Fatalf("expected effective sender pattern rule last, got %+v", perms[2])
+	}
+}
+
+func TestValidateBoxPattern(t *testing.T) {
+	if !IsBoxPattern("app.*") || IsBoxPattern("inbox") {
+		t.Fatal("unexpected IsBoxPattern")
+	}
+	if err := ValidateBoxPattern("app.[a-"); err == nil {
+		t.Fatal("expected malformed pattern to be rejected")
+	}
+	if err := ValidateBoxPattern("app.?x*"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func strPtr(s string) *string { return &s }
+
+func TestBulkPermissions(t *testing.T) {
+	d := setupTestDB(t)
+	sender := "s1"
+	max := 5
+
+	rules := []PermissionRule{
+		{MessageBox: "inbox", RecipientFee: 10},
+		{Sender: &sender, MessageBox: "inbox", RecipientFee: 0, Limits: PermissionLimits{MaxMessages: &max}},
+		{MessageBox: "files", RecipientFee: 5, Pricing: SizePricing{FeePerKB: 2}},
+	}
+	if err := d.SetMessagePermissionRules("r1", rules); err != nil {
+		t.Fatal(err)
+	}
+
+	perms, err := d.ExportPermissions("r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(perms) != 3 {
+		t.Fatalf("expected 3 exported rules, got %d", len(perms))
+	}
+
+	// Re-applying updates in place
+	rules[0].RecipientFee = 15
+	if err := d.SetMessagePermissionRules("r1", rules[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if fee, _ := d.GetRecipientFee("r1", "s2", "inbox"); fee != 15 {
+		t.Fatalf("expected updated fee 15, got %d", fee)
+	}
+
+	found, err := d.DeletePermission("r1", &sender, "inbox")
+	if err != nil || !found {
+		t.Fatalf("expected sender rule to be deleted, found=%v err=%v", found, err)
+	}
+	if fee, _ := d.GetRecipientFee("r1", sender, "inbox"); fee != 15 {
+		t.Fatalf("expected fallback to box-wide fee 15, got %d", fee)
+	}
+	if found, _ := d.DeletePermission("r1", &sender, "inbox"); found {
+		t.Fatal("expected second delete to report not found")
+	}
+}
+
+func TestServerFees(t *testing.T) {
+	d := setupTestDB(t)
+
+	// Unknown boxes are free until a default fee is set
+	if fee, _ := d.GetServerDeliveryFee("custom"); fee != 0 {
+		t.Fatalf("expected 0, got %d", fee)
+	}
+	if _, err := d.SetServerDeliveryFee(DefaultServerFeeBox, 3, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if fee, _ := d.GetServerDeliveryFee("custom"); fee != 3 {
+		t.Fatalf("expected default fee 3, got %d", fee)
+	}
+	if fee, _ := d.GetServerDeliveryFee("inbox"); fee != 0 {
+		t.Fatalf("expected seeded inbox fee 0 to beat the default, got %d", fee)
+	}
+
+	previous, err := d.SetServerDeliveryFee("notifications", 25, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if previous == nil || *previous != 10 {
+		t.Fatalf("expected previous seeded fee 10, got %v", previous)
+	}
+
+	if previous, err = d.DeleteServerDeliveryFee("inbox", "admin"); err != nil || previous == nil {
+		t.Fatalf("expected inbox fee to be removed, got %v, %v", previous, err)
+	}
+	if previous, _ = d.DeleteServerDeliveryFee("inbox", "admin"); previous != nil {
+		t.Fatal("expected nothing to remove the second time")
+	}
+
+	// Seeds must not come back once fees are managed
+	if err := d.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if fee, _ := d.GetServerDeliveryFee("inbox"); fee != 3 {
+		t.Fatalf("expected removed inbox to use the default fee, got %d", fee)
+	}
+
+	changes, err := d.ListServerFeeChanges(nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 3 {
+		t.Fatalf("expected 3 changes, got %d", len(changes))
+	}
+	if changes[0].MessageBox != "inbox" || changes[0].NewFee.Valid || !changes[0].PreviousFee.Valid {
+		t.Fatalf("expected latest change to be the inbox removal, got %+v", changes[0])
+	}
+
+	box := "notifications"
+	changes, _ = d.ListServerFeeChanges(&box, 10)
+	if len(changes) != 1 || changes[0].NewFee.Int64 != 25 || changes[0].ChangedBy != "admin" {
+		t.Fatalf("unexpected notifications history: %+v", changes)
+	}
+}
+
+func TestDefaultFeePolicies(t *testing.T) {
+	d := setupTestDB(t)
+
+	if err := d.SetDefaultFeePolicies([]DefaultFeePolicy{
+		{MessageBox: "app.*", Fee: 5},
+		{MessageBox: "app.chat.*", Fee: 1},
+		{MessageBox: "app.admin", Fee: -1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		box      string
+		expected int
+	}{
+		{"app.files", 5},
+		{"app.chat.room1", 1},
+		{"app.admin", -1},
+		{"notifications", 0},
+	}
+	for _, tt := range tests {
+		res, err := d.ResolveRecipientFee("r1", "s1", tt.box, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Fee != tt.expected {
+			t.Errorf("%s: expected %d, got %d", tt.box, tt.expected, res.Fee)
+		}
+	}
+
+	// Reads must not store the default, so a policy change applies to everyone
+	perms, total, err := d.ListPermissions("r1", nil, 100, 0, "desc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 0 || len(perms) != 0 {
+		t.Fatalf("expected no stored permissions, got %d", total)
+	}
+	if err := d.SetDefaultFeePolicies([]DefaultFeePolicy{{MessageBox: "app.*", Fee: 8}}); err != nil {
+		t.Fatal(err)
+	}
+	if fee, _ := d.GetRecipientFee("r1", "s1", "app.files"); fee != 8 {
+		t.Fatalf("expected updated default 8, got %d", fee)
+	}
+
+	// A recipient's own rule beats any default
+	if err := d.SetMessagePermission("r1", nil, "app.files", 0); err != nil {
+		t.Fatal(err)
+	}
+	if fee, _ := d.GetRecipientFee("r1", "s1", "app.files"); fee != 0 {
+		t.Fatalf("expected recipient rule 0, got %d", fee)
+	}
+
+	if err := d.SetDefaultFeePolicies([]DefaultFeePolicy{{MessageBox: "app.[a-", Fee: 1}}); err == nil {
+		t.Fatal("expected malformed pattern to be rejected")
+	}
+}
+
+func TestMigrateRemovesAutoCreatedDefaults(t *testing.T) {
+	d, err := New("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	d.SetMaxOpenConns(1)
+
+	if _, err := d.Exec(`CREATE TABLE message_permissions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		recipient TEXT NOT NULL,
+		sender TEXT,
+		message_box TEXT NOT NULL,
+		recipient_fee INTEGER NOT NULL,
+		UNIQUE(recipient, sender, message_box)
+	)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Exec(`INSERT INTO message_permissions (recipient, sender, message_box, recipient_fee, updated_at) VALUES
+		('r1', NULL, 'notifications', 10, CURRENT_TIMESTAMP),
+		('r1', NULL, 'inbox', 0, CURRENT_TIMESTAMP),
+		('r1', NULL, 'files', 0, '2030-01-01 00:00:00'),
+		('r1', NULL, 'paid', 25, CURRENT_TIMESTAMP),
+		('r1', 's1', 'inbox', 0, CURRENT_TIMESTAMP)`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, total, err := d.ListPermissions("r1", nil, 100, 0, "desc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 {
+		t.Fatalf("expected the edited, non-default and sender rules to remain, got %d", total)
+	}
+
+	// Runs only once: a rule set later with the same values survives restarts
+	if err := d.SetMessagePermission("r1", nil, "inbox", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if perm, _ := d.GetPermission("r1", nil, "inbox"); perm == nil {
+		t.Fatal("expected rule created after the migration to be kept")
+	}
+}
+
+func TestRedeemQuote(t *testing.T) {
+	d := setupTestDB(t)
+
+	ok, err := d.RedeemQuote("q1", "sender1", time.Now().Add(time.Minute))
+	if err != nil || !ok {
+		t.Fatalf("expected first redemption to succeed, got %v, %v", ok, err)
+	}
+	ok, err = d.RedeemQuote("q1", "sender1", time.Now().Add(time.Minute))
+	if err != nil || ok {
+		t.Fatalf("expected second redemption to be refused, got %v, %v", ok, err)
+	}
+
+	if _, err := d.RedeemQuote("q2", "sender1", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	n, err := d.DeleteExpiredQuotes(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 expired quote deleted, got %d", n)
+	}
+}
+
+func TestPayments(t *testing.T) {
+	d := setupTestDB(t)
+
+	records := []PaymentRecord{
+		{TxID: "tx1", Kind: PaymentKindDeliveryFee, Sender: "sender1", MessageBox: "notifications", Fee: 10, Amount: 10, Status: PaymentStatusInternalized},
+		{TxID: "tx2", Kind: PaymentKindDeliveryFee, Sender: "sender1", MessageBox: "notifications", Fee: 10, Amount: 12, Status: PaymentStatusInternalized},
+		{TxID: "tx3", Kind: PaymentKindDeliveryFee, Sender: "sender1", MessageBox: "inbox", Fee: 5, Amount: 5, Status: PaymentStatusRejected},
+		{TxID: "tx1", Kind: PaymentKindRecipientFee, Sender: "sender1", Recipient: sql.NullString{String: "recipient1", Valid: true}, MessageBox: "notifications", MessageID: sql.NullString{String: "m1", Valid: true}, Fee: 100, Amount: 100, Status: PaymentStatusRelayed},
+		{TxID: "tx2", Kind: PaymentKindRecipientFee, Sender: "sender1", Recipient: sql.NullString{String: "recipient1", Valid: true}, MessageBox: "inbox", MessageID: sql.NullString{String: "m2", Valid: true}, Fee: 50, Amount: 60, Status: PaymentStatusRelayed},
+		{TxID: "tx2", Kind: PaymentKindRecipientFee, Sender: "sender1", Recipient: sql.NullString{String: "recipient2", Valid: true}, MessageBox: "inbox", MessageID: sql.NullString{String: "m3", Valid: true}, Fee: 50, Amount: 50, Status: PaymentStatusRelayed},
+	}
+	for _, p := range records {
+		if _, err := d.InsertPayment(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	earned, totals, err := d.ListRecipientEarnings("recipient1", PaymentFilter{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(earned) != 1 || totals.Count != 2 || totals.Amount != 160 {
+		t.Fatalf("expected 1 of 2 payments totalling 160, got %d, %+v", len(earned), totals)
+	}
+	if _, totals, _ = d.ListRecipientEarnings("recipient1", PaymentFilter{MessageBox: "inbox"}, 10); totals.Amount != 60 {
+		t.Fatalf("expected 60 earned in inbox, got %+v", totals)
+	}
+	if _, totals, _ = d.ListRecipientEarnings("recipient1", PaymentFilter{Since: time.Now().Add(time.Hour)}, 10); totals.Count != 0 {
+		t.Fatalf("expected no earnings in the future, got %+v", totals)
+	}
+
+	revenue, err := d.Revenue(PaymentKindDeliveryFee, PaymentFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revenue) != 1 || revenue[0].MessageBox != "notifications" || revenue[0].Count != 2 || revenue[0].Amount != 22 {
+		t.Fatalf("expected only internalized notifications revenue of 22, got %+v", revenue)
+	}
+}
+
+func TestInsertMessagesAtomic(t *testing.T) {
+	d := setupTestDB(t)
+	mbID, _ := d.EnsureMessageBox("recipient1", "inbox")
+
+	if err := d.InsertMessage("dup", mbID, "sender1", "recipient1", `{}`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.DepositCredit("sender1", 100, "deposit-tx"); err != nil {
+		t.Fatal(err)
+	}
+	held, _, err := d.DebitCredit("sender1", []CreditDebit{{Kind: CreditKindRecipientFee, Amount: 30, Recipient: sql.NullString{String: "recipient1", Valid: true}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = d.InsertMessages([]NewMessage{
+		{MessageID: "new", MessageBoxID: mbID, Sender: "sender1", Recipient: "recipient1", Body: `{}`},
+		{MessageID: "dup", MessageBoxID: mbID, Sender: "sender1", Recipient: "recipient1", Body: `{}`},
+	}, held)
+	if !errors.Is(err, ErrDuplicateMessage) {
+		t.Fatalf("expected ErrDuplicateMessage, got %v", err)
+	}
+	if msgs, _ := d.ListMessages("recipient1", mbID); len(msgs) != 1 {
+		t.Fatalf("expected the batch to be rolled back, got %d messages", len(msgs))
+	}
+	if _, owed, _ := d.CreditBalance("recipient1"); owed != 0 {
+		t.Fatalf("expected the credited fee to stay held, got %d owed", owed)
+	}
+
+	// stored messages release their credited fees in the same transaction
+	if _, err := d.InsertMessages([]NewMessage{{MessageID: "new", MessageBoxID: mbID, Sender: "sender1", Recipient: "recipient1", Body: `{}`}}, held); err != nil {
+		t.Fatal(err)
+	}
+	if _, owed, _ := d.CreditBalance("recipient1"); owed != 30 {
+		t.Fatalf("expected 30 owed to recipient1, got %d", owed)
+	}
+}
+
+func TestProofOfWorkReuse(t *testing.T) {
+	d := setupTestDB(t)
+	mbID, _ := d.EnsureMessageBox("recipient1", "inbox")
+	msg := NewMessage{MessageID: "m1", MessageBoxID: mbID, Sender: "sender1", Recipient: "recipient1", Body: `{}`, ProofDigest: "abcd"}
+
+	if _, err := d.InsertMessages([]NewMessage{msg}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if used, err := d.ProofOfWorkUsed("recipient1", "abcd"); err != nil || !used {
+		t.Fatalf("expected the proof to be recorded, got %v, %v", used, err)
+	}
+	if used, _ := d.ProofOfWorkUsed("recipient2", "abcd"); used {
+		t.Fatal("expected the proof to be recorded for its recipient only")
+	}
+
+	// acknowledging the message frees its messageId but not its proof
+	if _, err := d.AcknowledgeMessages("recipient1", []string{"m1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.InsertMessages([]NewMessage{msg}, nil); !errors.Is(err, ErrProofOfWorkUsed) {
+		t.Fatalf("expected a reused proof to be rejected, got %v", err)
+	}
+	if n, _ := d.CountMessages("recipient1", mbID); n != 0 {
+		t.Fatalf("expected the message with a reused proof not to be stored, %d stored", n)
+	}
+
+	// pruning only removes proofs used before the cutoff
+	if n, err := d.DeleteUsedProofsOfWorkBefore(time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Fatalf("expected a recent proof to be kept, got %d, %v", n, err)
+	}
+	if n, err := d.DeleteUsedProofsOfWorkBefore(time.Now().Add(time.Second)); err != nil || n != 1 {
+		t.Fatalf("expected the proof to be pruned, got %d, %v", n, err)
+	}
+	if used, _ := d.ProofOfWorkUsed("recipient1", "abcd"); used {
+		t.Fatal("expected a pruned proof to be usable again")
+	}
+}
+
+func TestInsertMessagesLimits(t *testing.T) {
+	d := setupTestDB(t)
+	mbID, _ := d.EnsureMessageBox("recipient1", "inbox")
+
+	sender := "sender1"
+	maxMessages := 1
+	if err := d.SetMessagePermissionRule("recipient1", &sender, "inbox", 0, PermissionLimits{MaxMessages: &maxMessages}, SizePricing{}); err != nil {
+		t.Fatal(err)
+	}
+	perm, _ := d.GetPermission("recipient1", &sender, "inbox")
+
+	// two sends resolved the rule before either was stored; only one fits the cap
+	if _, err := d.InsertMessages([]NewMessage{{MessageID: "m1", MessageBoxID: mbID, Sender: sender, Recipient: "recipient1", Body: `{}`, PermissionID: perm.ID}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	_, err := d.InsertMessages([]NewMessage{{MessageID: "m2", MessageBoxID: mbID, Sender: sender, Recipient: "recipient1", Body: `{}`, PermissionID: perm.ID}}, nil)
+	if !errors.Is(err, ErrPermissionUsedUp) {
+		t.Fatalf("expected ErrPermissionUsedUp, got %v", err)
+	}
+	if msgs, _ := d.ListMessages("recipient1", mbID); len(msgs) != 1 {
+		t.Fatalf("expected the second message not to be stored, got %d messages", len(msgs))
+	}
+
+	// rate limits are counted with the message and rejected once used up
+	if err := d.SetRateLimit("recipient1", nil, "inbox", 2, 3600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.InsertMessages([]NewMessage{{MessageID: "m3", MessageBoxID: mbID, MessageBox: "inbox", Sender: sender, Recipient: "recipient1", Body: `{}`}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	_, err = d.InsertMessages([]NewMessage{
+		{MessageID: "m4", MessageBoxID: mbID, MessageBox: "inbox", Sender: sender, Recipient: "recipient1", Body: `{}`},
+		{MessageID: "m5", MessageBoxID: mbID, MessageBox: "inbox", Sender: sender, Recipient: "recipient1", Body: `{}`},
+	}, nil)
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited, got %v", err)
+	}
+	if st, _ := d.CheckRateLimit("recipient1", sender, "inbox", time.Now()); st == nil || st.Remaining != 1 {
+		t.Fatalf("expected the rolled back batch not to be counted, got %+v", st)
+	}
+	if _, err := d.DeleteRateLimit("recipient1", nil, "inbox"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a failed insert gives the use back
+	if err := d.SetMessagePermissionRule("recipient1", &sender, "inbox", 0, PermissionLimits{MaxMessages: &maxMessages}, SizePricing{}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = d.InsertMessages([]NewMessage{{MessageID: "m1", MessageBoxID: mbID, Sender: sender, Recipient: "recipient1", Body: `{}`, PermissionID: perm.ID}}, nil)
+	if !errors.Is(err, ErrDuplicateMessage) {
+		t.Fatalf("expected ErrDuplicateMessage, got %v", err)
+	}
+	if perm, _ = d.GetPermission("recipient1", &sender, "inbox"); perm.MessagesUsed != 0 {
+		t.Fatalf("expected the use to be rolled back, got %d used", perm.MessagesUsed)
+	}
+}
+
+func TestRefunds(t *testing.T) {
+	d := setupTestDB(t)
+
+	paymentID, err := d.InsertPayment(PaymentRecord{
+		TxID: "tx1", Kind: PaymentKindRecipientFee, Sender: "sender1", Recipient: sql.NullString{String: "recipient1", Valid: true},
+		MessageBox: "inbox", MessageID: sql.NullString{String: "m1", Valid: true}, Fee: 100, Amount: 100, Status: PaymentStatusRelayed,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := d.GetRelayedPayment("recipient1", "m1")
+	if err != nil || p == nil || p.ID != paymentID {
+		t.Fatalf("expected relayed payment %d, got %+v, %v", paymentID, p, err)
+	}
+
+	// A failed attempt is recorded without marking the payment refunded
+	failed := RefundRecord{PaymentID: paymentID, Payer: "recipient1", Payee: "sender1", MessageBox: "inbox", Reason: RefundReasonRejected, Amount: 100, Status: RefundStatusFailed}
+	if _, err := d.InsertRefund(failed); err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := d.GetRelayedPayment("recipient1", "m1"); p == nil {
+		t.Fatal("expected payment to remain relayed after a failed refund")
+	}
+
+	sent := failed
+	sent.Status = RefundStatusSent
+	sent.TxID = sql.NullString{String: "refund-tx", Valid: true}
+	if _, err := d.InsertRefund(sent); err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := d.GetRelayedPayment("recipient1", "m1"); p != nil {
+		t.Fatal("expected refunded payment to no longer be relayed")
+	}
+	if _, err := d.InsertRefund(sent); !errors.Is(err, ErrAlreadyRefunded) {
+		t.Fatalf("expected ErrAlreadyRefunded, got %v", err)
+	}
+
+	refunds, err := d.ListRefunds("sender1", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refunds) != 2 || refunds[0].Status != RefundStatusSent || refunds[0].TxID.String != "refund-tx" {
+		t.Fatalf("expected the sent and failed refunds, newest first, got %+v", refunds)
+	}
+}
+
+func TestSpentPaymentOutputs(t *testing.T) {
+	d := setupTestDB(t)
+
+	recipient := sql.NullString{String: "recipient1", Valid: true}
+	outputs := []SpentOutput{
+		{TxID: "tx1", OutputIndex: 0, Sender: "sender1", MessageBox: "inbox"},
+		{TxID: "tx1", OutputIndex: 1, Sender: "sender1", Recipient: recipient, MessageBox: "inbox"},
+	}
+	replayed, err := d.ClaimPaymentOutputs(outputs)
+	if err != nil || len(replayed) != 0 {
+		t.Fatalf("expected first claim to succeed, got %+v, %v", replayed, err)
+	}
+
+	// Reusing one output rejects the whole claim
+	again := []SpentOutput{
+		{TxID: "tx1", OutputIndex: 1, Sender: "sender2", Recipient: recipient, MessageBox: "other"},
+		{TxID: "tx1", OutputIndex: 2, Sender: "sender2", Recipient: recipient, MessageBox: "other"},
+	}
+	replayed, err = d.ClaimPaymentOutputs(again)
+	if err != nil || len(replayed) != 1 || replayed[0].OutputIndex != 1 {
+		t.Fatalf("expected output 1 to be replayed, got %+v, %v", replayed, err)
+	}
+	if replayed, _ := d.ClaimPaymentOutputs(again[1:]); len(replayed) != 0 {
+		t.Fatal("expected output 2 to be unclaimed after a rejected claim")
+	}
+
+	// Released outputs can be claimed again
+	if err := d.ReleasePaymentOutputs(outputs[1:]); err != nil {
+		t.Fatal(err)
+	}
+	if replayed, _ := d.ClaimPaymentOutputs(outputs[1:]); len(replayed) != 0 {
+		t.Fatal("expected released output to be claimable")
+	}
+
+	if _, err := d.ClaimPaymentOutputs([]SpentOutput{{TxID: "tx2", Sender: "sender1", MessageBox: "inbox"}}); err != nil {
+		t.Fatal(err)
+	}
+	txids, err := d.SpentOutputTxIDsToCheck(time.Now().Add(time.Hour), 1)
+	if err != nil || len(txids) != 1 {
+		t.Fatalf("expected one txid, got %v, %v", txids, err)
+	}
+	next, err := d.SpentOutputTxIDsToCheck(time.Now().Add(time.Hour), 1)
+	if err != nil || len(next) != 1 || next[0] == txids[0] {
+		t.Fatalf("expected the other txid after checking %v, got %v, %v", txids, next, err)
+	}
+
+	n, err := d.DeleteSpentOutputs([]string{"tx1"})
+	if err != nil || n != 3 {
+		t.Fatalf("expected 3 outputs deleted, got %d, %v", n, err)
+	}
+	if replayed, _ := d.ClaimPaymentOutputs(outputs); len(replayed) != 0 {
+		t.Fatal("expected deleted outputs to be claimable")
+	}
+}
+
+func TestRoutePrices(t *testing.T) {
+	d := setupTestDB(t)
+
+	if p, err := d.GetRoutePrice("/listMessages", "inbox"); err != nil || p != nil {
+		t.Fatalf("expected no price, got %+v, %v", p, err)
+	}
+	if err := d.SetRoutePrice("/listMessages", DefaultRoutePriceBox, 5, 2, 3600); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetRoutePrice("/listMessages", "heavy", 20, 0, 3600); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := d.HasRoutePrices("/listMessages"); !ok {
+		t.Fatal("expected /listMessages to have prices")
+	}
+	if ok, _ := d.HasRoutePrices("/permissions/quote"); ok {
+		t.Fatal("expected /permissions/quote to have no prices")
+	}
+
+	p, err := d.GetRoutePrice("/listMessages", "heavy")
+	if err != nil || p == nil || p.Price != 20 {
+		t.Fatalf("expected the box price, got %+v, %v", p, err)
+	}
+	p, err = d.GetRoutePrice("/listMessages", "inbox")
+	if err != nil || p == nil || p.Price != 5 || p.MessageBox != DefaultRoutePriceBox {
+		t.Fatalf("expected the default price, got %+v, %v", p, err)
+	}
+
+	// Two free requests per window, counted per caller
+	now := time.Now()
+	for i, want := range []bool{true, true, false} {
+		if free, err := d.UseFreeRouteRequest(p, "caller1", now); err != nil || free != want {
+			t.Fatalf("request %d: expected free=%v, got %v, %v", i, want, free, err)
+		}
+	}
+	if free, _ := d.UseFreeRouteRequest(p, "caller2", now); !free {
+		t.Fatal("expected another caller to have free requests")
+	}
+	if free, _ := d.UseFreeRouteRequest(p, "caller1", now.Add(time.Hour)); !free {
+		t.Fatal("expected free requests in the next window")
+	}
+
+	n, err := d.DeleteExpiredRouteCounters(now.Add(3 * time.Hour))
+	if err != nil || n != 3 {
+		t.Fatalf("expected 3 expired counters, got %d, %v", n, err)
+	}
+
+	if ok, err := d.DeleteRoutePrice("/listMessages", "heavy"); err != nil || !ok {
+		t.Fatalf("expected price to be deleted, got %v, %v", ok, err)
+	}
+	if ok, _ := d.DeleteRoutePrice("/listMessages", "heavy"); ok {
+		t.Fatal("expected nothing to delete")
+	}
+	prices, err := d.ListRoutePrices()
+	if err != nil || len(prices) != 1 || prices[0].MessageBox != DefaultRoutePriceBox {
+		t.Fatalf("expected only the default price, got %+v, %v", prices, err)
+	}
+}
+
+func TestCredits(t *testing.T) {
+	d := setupTestDB(t)
+
+	if _, _, err := d.DebitCredit("sender1", []CreditDebit{{Kind: CreditKindDeliveryFee, Amount: 1}}); !errors.Is(err, ErrInsufficientCredit) {
+		t.Fatalf("expected ErrInsufficientCredit without an account, got %v", err)
+	}
+	balance, err := d.DepositCredit("sender1", 100, "deposit-tx")
+	if err != nil || balance != 100 {
+		t.Fatalf("expected balance 100, got %d, %v", balance, err)
+	}
+
+	box := sql.NullString{String: "inbox", Valid: true}
+	debits := []CreditDebit{
+		{Kind: CreditKindDeliveryFee, Amount: 10, MessageBox: box},
+		{Kind: CreditKindRecipientFee, Amount: 30, Recipient: sql.NullString{String: "recipient1", Valid: true}, MessageBox: box, MessageID: sql.NullString{String: "m1", Valid: true}},
+	}
+	if _, _, err := d.DebitCredit("sender1", append(debits, CreditDebit{Kind: CreditKindDeliveryFee, Amount: 61})); !errors.Is(err, ErrInsufficientCredit) {
+		t.Fatalf("expected ErrInsufficientCredit, got %v", err)
+	}
+
+	// A failed send is reversed once
+	ids, balance, err := d.DebitCredit("sender1", debits)
+	if err != nil || len(ids) != 2 || balance != 60 {
+		t.Fatalf("expected two entries and balance 60, got %v, %d, %v", ids, balance, err)
+	}
+	if balance, err = d.ReverseCreditDebits("sender1", ids); err != nil || balance != 100 {
+		t.Fatalf("expected balance 100 after reversal, got %d, %v", balance, err)
+	}
+	if balance, _ = d.ReverseCreditDebits("sender1", ids); balance != 100 {
+		t.Fatalf("expected a second reversal to change nothing, got %d", balance)
+	}
+
+	// A stored send owes the recipient fee until it is settled
+	ids, _, err = d.DebitCredit("sender1", debits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mbID, _ := d.EnsureMessageBox("recipient1", "inbox")
+	send := func(id string, held []int64) error {
+		_, err := d.InsertMessages([]NewMessage{{MessageID: id, MessageBoxID: mbID, MessageBox: "inbox", Sender: "sender1", Recipient: "recipient1", Body: "x"}}, held)
+		return err
+	}
+	if err := send("m1", ids); err != nil {
+		t.Fatal(err)
+	}
+	if balance, _ = d.ReverseCreditDebits("sender1", ids[1:]); balance != 60 {
+		t.Fatalf("expected released recipient fee not to be reversed, got balance %d", balance)
+	}
+	if _, owed, err := d.CreditBalance("recipient1"); err != nil || owed != 30 {
+		t.Fatalf("expected 30 owed to recipient1, got %d, %v", owed, err)
+	}
+
+	if pending, _ := d.PendingCreditSettlements(31, 10); len(pending) != 0 {
+		t.Fatalf("expected nothing above the minimum, got %+v", pending)
+	}
+	pending, err := d.PendingCreditSettlements(1, 10)
+	if err != nil || len(pending) != 1 || pending[0].Recipient != "recipient1" || pending[0].Amount != 30 {
+		t.Fatalf("expected 30 pending for recipient1, got %+v, %v", pending, err)
+	}
+
+	// a failed payment returns the fees to pending
+	payoutID, amount, err := d.StartCreditSettlement("recipient1", 1)
+	if err != nil || payoutID == 0 || amount != 30 {
+		t.Fatalf("expected a settlement of 30, got %d, %d, %v", payoutID, amount, err)
+	}
+	if id, _, _ := d.StartCreditSettlement("recipient1", 1); id != 0 {
+		t.Fatal("expected claimed fees not to be settled twice")
+	}
+	if payouts, _ := d.ListCreditPayouts("recipient1", 10); len(payouts) != 0 {
+		t.Fatalf("expected an unpaid settlement to be hidden, got %+v", payouts)
+	}
+	if unpaid, _ := d.ListUnpaidCreditSettlements(time.Now().Add(-time.Hour)); len(unpaid) != 0 {
+		t.Fatalf("expected a recent settlement not to be reported, got %+v", unpaid)
+	}
+	unpaid, err := d.ListUnpaidCreditSettlements(time.Now().Add(time.Second))
+	if err != nil || len(unpaid) != 1 || unpaid[0].ID != payoutID || unpaid[0].Amount != 30 {
+		t.Fatalf("expected the unpaid settlement, got %+v, %v", unpaid, err)
+	}
+	if err := d.CancelCreditSettlement(payoutID); err != nil {
+		t.Fatal(err)
+	}
+
+	payoutID, _, err = d.StartCreditSettlement("recipient1", 1)
+	if err != nil || payoutID == 0 {
+		t.Fatalf("expected the cancelled fees to be settled again, got %d, %v", payoutID, err)
+	}
+	// a fee released while the payment is made waits for the next settlement
+	later, _, err := d.DebitCredit("sender1", debits[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := send("m2", later); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.CompleteCreditSettlement(payoutID, "settle-tx", "{}"); err != nil {
+		t.Fatal(err)
+	}
+	if _, owed, _ := d.CreditBalance("recipient1"); owed != 30 {
+		t.Fatalf("expected only the later fee to be owed, got %d", owed)
+	}
+	payoutID, _, err = d.StartCreditSettlement("recipient1", 1)
+	if err != nil || payoutID == 0 {
+		t.Fatalf("expected the later fee to be settled, got %d, %v", payoutID, err)
+	}
+	if err := d.CompleteCreditSettlement(payoutID, "settle-tx-2", "{}"); err != nil {
+		t.Fatal(err)
+	}
+	if _, owed, _ := d.CreditBalance("recipient1"); owed != 0 {
+		t.Fatalf("expected nothing owed after settlement, got %d", owed)
+	}
+	payouts, err := d.ListCreditPayouts("recipient1", 10)
+	if err != nil || len(payouts) != 2 || payouts[1].TxID != "settle-tx" || payouts[1].Amount != 30 {
+		t.Fatalf("expected both settlement payouts, got %+v, %v", payouts, err)
+	}
+
+	entries, err := d.ListCreditEntries("sender1", 10)
+	if err != nil || len(entries) != 7 {
+		t.Fatalf("expected 7 ledger entries, got %d, %v", len(entries), err)
+	}
+	if entries[0].Kind != CreditKindRecipientFee || entries[0].BalanceAfter != 30 || entries[0].SettlementStatus.String != CreditSettlementSettled {
+		t.Fatalf("unexpected latest entry %+v", entries[0])
+	}
+}
+
+func TestSubscriptions(t *testing.T) {
+	d := setupTestDB(t)
+	recipient, sender := "recipient1", "sender1"
+
+	if o, err := d.GetSubscriptionOffer(recipient, "inbox"); err != nil || o != nil {
+		t.Fatalf("expected no offer, got %+v, %v", o, err)
+	}
+	if err := d.SetMessagePermission(recipient, nil, "inbox", 100); err != nil {
+		t.Fatal(err)
+	}
+	// the recipient's own rule for the sender outlives the subscription
+	maxMessages := 5
+	if err := d.SetMessagePermissionRule(recipient, &sender, "inbox", 200, PermissionLimits{MaxMessages: &maxMessages}, SizePricing{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetSubscriptionOffer(recipient, "inbox", 5000, 30); err != nil {
+		t.Fatal(err)
+	}
+	offer, err := d.GetSubscriptionOffer(recipient, "inbox")
+	if err != nil || offer == nil || offer.Price != 5000 || offer.DurationDays != 30 {
+		t.Fatalf("unexpected offer %+v, %v", offer, err)
+	}
+
+	// A message buying the offer grants free access until it expires
+	mbID, _ := d.EnsureMessageBox(recipient, "inbox")
+	buy := func(messageID string) (*SubscriptionRecord, error) {
+		subs, err := d.InsertMessages([]NewMessage{{MessageID: messageID, MessageBoxID: mbID, MessageBox: "inbox", Sender: sender, Recipient: recipient, Body: `{}`, Subscription: offer}}, nil)
+		if err != nil {
+			return nil, err
+		}
+		return subs[0], nil
+	}
+	before := time.Now()
+	first, err := buy("msg1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := first.StartsAt
+	if now.Before(before) || now.After(time.Now()) || !first.ExpiresAt.Equal(now.Add(30*24*time.Hour)) {
+		t.Fatalf("expected 30 days of access from the purchase, got %v until %v", first.StartsAt, first.ExpiresAt)
+	}
+	res, err := d.ResolveRecipientFee(recipient, sender, "inbox", now)
+	if err != nil || res.Fee != 0 || res.Subscription == nil || res.Permission != nil || !res.SenderSpecific() {
+		t.Fatalf("expected subscriber to message for free, got %+v, %v", res, err)
+	}
+	res, _ = d.ResolveRecipientFee(recipient, sender, "inbox", first.ExpiresAt)
+	if res.Fee != 200 || res.Permission == nil || res.Permission.MaxMessages.Int64 != 5 {
+		t.Fatalf("expected the sender rule once the subscription expired, got %+v", res)
+	}
+
+	// Buying again extends the running subscription
+	second, err := buy("msg2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !second.StartsAt.Equal(first.ExpiresAt) || !second.ExpiresAt.Equal(first.ExpiresAt.Add(30*24*time.Hour)) {
+		t.Fatalf("expected the second purchase to extend the first, got %+v", second)
+	}
+
+	// Revoking one purchase takes its time off the grant
+	revoked, err := d.RevokeSubscription(recipient, "msg2", now)
+	if err != nil || revoked == nil || !revoked.RevokedAt.Valid {
+		t.Fatalf("expected subscription to be revoked, got %+v, %v", revoked, err)
+	}
+	if res, _ := d.ResolveRecipientFee(recipient, sender, "inbox", first.ExpiresAt); res.Subscription != nil {
+		t.Fatalf("expected access to end with the first purchase, got %+v", res.Subscription)
+	}
+	if again, _ := d.RevokeSubscription(recipient, "msg2", now); again != nil {
+		t.Fatal("expected a revoked subscription not to be revoked again")
+	}
+
+	// revoking the first purchase moves a later one up
+	third, err := buy("msg3")
+	if err != nil || !third.StartsAt.Equal(first.ExpiresAt) {
+		t.Fatalf("expected the third purchase to follow the first, got %+v, %v", third, err)
+	}
+	if _, err := d.RevokeSubscription(recipient, "msg1", now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	res, _ = d.ResolveRecipientFee(recipient, sender, "inbox", now.Add(time.Hour))
+	if res.Subscription == nil || res.Subscription.MessageID != "msg3" || !res.Subscription.ExpiresAt.Equal(first.ExpiresAt) {
+		t.Fatalf("expected the third purchase to run in place of the first, got %+v", res.Subscription)
+	}
+	if _, err := d.RevokeSubscription(recipient, "msg3", now); err != nil {
+		t.Fatal(err)
+	}
+	if fee, _ := d.GetRecipientFee(recipient, sender, "inbox"); fee != 200 {
+		t.Fatalf("expected the sender rule after revocation, got %d", fee)
+	}
+
+	// a failed insert grants nothing
+	if sub, err := buy("msg4"); err != nil || sub == nil || sub.MessageID != "msg4" {
+		t.Fatalf("expected the insert to grant the subscription, got %+v, %v", sub, err)
+	}
+	bought := NewMessage{MessageID: "msg5", MessageBoxID: mbID, MessageBox: "inbox", Sender: sender, Recipient: recipient, Body: `{}`, Subscription: offer}
+	if _, err := d.InsertMessages([]NewMessage{bought, {MessageID: "msg4", MessageBoxID: mbID, Sender: sender, Recipient: recipient, Body: `{}`}}, nil); !errors.Is(err, ErrDuplicateMessage) {
+		t.Fatalf("expected a duplicate message error, got %v", err)
+	}
+	if res, _ := d.ResolveRecipientFee(recipient, sender, "inbox", time.Now()); res.Subscription == nil || res.Subscription.MessageID != "msg4" {
+		t.Fatalf("expected only the stored message's subscription, got %+v", res.Subscription)
+	}
+
+	// a block wins over a running subscription
+	if err := d.SetMessagePermission(recipient, &sender, "inbox", -1); err != nil {
+		t.Fatal(err)
+	}
+	if fee, _ := d.GetRecipientFee(recipient, sender, "inbox"); fee != -1 {
+		t.Fatalf("expected a blocked subscriber to stay blocked, got %d", fee)
+	}
+
+	offers, _ := d.ListSubscriptionOffers(recipient)
+	if len(offers) != 1 {
+		t.Fatalf("expected 1 offer, got %d", len(offers))
+	}
+	if ok, err := d.DeleteSubscriptionOffer(recipient, "inbox"); err != nil || !ok {
+		t.Fatalf("expected offer to be deleted, got %v, %v", ok, err)
+	}
+}
+
+func TestSpamReports(t *testing.T) {
+	d := setupTestDB(t)
+	now := time.Now()
+
+	for i, recipient := range []string{"recipient1", "recipient1", "recipient2"} {
+		mbID, err := d.EnsureMessageBox(recipient, "inbox")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.InsertMessage(fmt.Sprintf("spam-%d", i), mbID, "spammer", recipient, `{}`); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if r, err := d.ReportSpam("recipient2", "spam-0", now); err != nil || r != nil {
+		t.Fatalf("expected another recipient's message not to be found, got %+v, %v", r, err)
+	}
+	r, err := d.ReportSpam("recipient1", "spam-0", now)
+	if err != nil || r == nil || r.Sender != "spammer" || r.MessageBox != "inbox" {
+		t.Fatalf("unexpected report %+v, %v", r, err)
+	}
+	mbID, _ := d.GetMessageBoxID("recipient1", "inbox")
+	if n, _ := d.CountMessages("recipient1", mbID); n != 1 {
+		t.Fatalf("expected the reported message to be deleted, %d left", n)
+	}
+	if _, err := d.ReportSpam("recipient1", "spam-0", now); !errors.Is(err, ErrAlreadyReported) {
+		t.Fatalf("expected a repeat report to be rejected, got %v", err)
+	}
+	if _, err := d.ReportSpam("recipient1", "spam-1", now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.ReportSpam("recipient2", "spam-2", now); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reports are counted per reporting recipient
+	if n, err := d.CountSpamReporters("spammer", now.Add(-time.Hour)); err != nil || n != 2 {
+		t.Fatalf("expected 2 reporters, got %d, %v", n, err)
+	}
+	if n, _ := d.CountSpamReporters("spammer", now.Add(time.Hour)); n != 0 {
+		t.Fatalf("expected old reports not to count, got %d", n)
+	}
+
+	if n, err := d.ClearSpamReports("spammer"); err != nil || n != 3 {
+		t.Fatalf("expected 3 reports to be cleared, got %d, %v", n, err)
+	}
+}
+
+func TestPowDifficulty(t *testing.T) {
+	d := setupTestDB(t)
+
+	if err := d.SetPermissionRule("r1", PermissionRule{MessageBox: "inbox", PowDifficulty: 20}); err != nil {
+		t.Fatal(err)
+	}
+	sender := "s1"
+	if err := d.SetPermissionRule("r1", PermissionRule{Sender: &sender, MessageBox: "inbox"}); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := d.ResolveRecipientFee("r1", "s2", "inbox", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Fee != 0 || res.PowDifficulty() != 20 {
+		t.Fatalf("expected a free box requiring 20 bits, got fee %d, difficulty %d", res.Fee, res.PowDifficulty())
+	}
+
+	// a sender-specific rule replaces the box-wide requirement
+	res, err = d.ResolveRecipientFee("r1", "s1", "inbox", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.PowDifficulty() != 0 {
+		t.Fatalf("expected no proof for the trusted sender, got %d", res.PowDifficulty())
+	}
+
+	perm, err := d.GetPermission("r1", nil, "inbox")
+	if err != nil || perm == nil || perm.PowDifficulty != 20 {
+		t.Fatalf("unexpected permission %+v, %v", perm, err)
+	}
+	if res := (&FeeResolution{}); res.PowDifficulty() != 0 {
+		t.Fatal("expected no difficulty without a matching rule")
+	}
+}
+
+func TestCertificates(t *testing.T) {
+	d := setupTestDB(t)
+	now := time.Now()
+	expiresAt := now.Add(24 * time.Hour)
+
+	if err := d.SaveSenderCertificate(SenderCertificate{
+		Sender: "s1", Certifier: "c1", CertificateType: "kyc", SerialNumber: "serial-1",
+		Fields: []string{"country"}, ExpiresAt: expiresAt,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// a newer certificate of the same type from the same certifier replaces the earlier one
+	if err := d.SaveSenderCertificate(SenderCertificate{
+		Sender: "s1", Certifier: "c1", CertificateType: "kyc", SerialNumber: "serial-2",
+		Fields: []string{"country", "email"}, ExpiresAt: expiresAt,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	certs, err := d.ListSenderCertificates("s1", now)
+	if err != nil || len(certs) != 1 || certs[0].SerialNumber != "serial-2" || !slices.Equal(certs[0].Fields, []string{"country", "email"}) {
+		t.Fatalf("unexpected certificates %+v, %v", certs, err)
+	}
+	if expired, _ := d.ListSenderCertificates("s1", expiresAt); len(expired) != 0 {
+		t.Fatalf("expected an expired certificate not to count, got %+v", expired)
+	}
+
+	if r, err := d.GetCertificateRequirement("r1", "business"); err != nil || r != nil {
+		t.Fatalf("expected no requirement, got %+v, %v", r, err)
+	}
+	fee := 0
+	if err := d.SetCertificateRequirement(CertificateRequirement{
+		Recipient: "r1", MessageBox: "business", Certifiers: []string{"c2", "c1"}, Fields: []string{"email"}, VerifiedFee: &fee,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	req, err := d.GetCertificateRequirement("r1", "business")
+	if err != nil || req == nil || len(req.Certifiers) != 2 || req.VerifiedFee == nil || *req.VerifiedFee != 0 {
+		t.Fatalf("unexpected requirement %+v, %v", req, err)
+	}
+
+	tests := []struct {
+		name     string
+		req      CertificateRequirement
+		expected bool
+	}{
+		{"any type", CertificateRequirement{Certifiers: []string{"c1"}}, true},
+		{"matching type and fields", CertificateRequirement{Certifiers: []string{"c1"}, CertificateType: "kyc", Fields: []string{"country", "email"}}, true},
+		{"other certifier", CertificateRequirement{Certifiers: []string{"c2"}}, false},
+		{"other type", CertificateRequirement{Certifiers: []string{"c1"}, CertificateType: "employee"}, false},
+		{"unrevealed field", CertificateRequirement{Certifiers: []string{"c1"}, Fields: []string{"name"}}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.req.SatisfiedBy(certs); got != tt.expected {
+			t.Errorf("%s: SatisfiedBy = %v, want %v", tt.name, got, tt.expected)
+		}
+	}
+
+	// replacing a requirement can turn unverified senders away again
+	if err := d.SetCertificateRequirement(CertificateRequirement{Recipient: "r1", MessageBox: "business", Certifiers: []string{"c1"}}); err != nil {
+		t.Fatal(err)
+	}
+	reqs, err := d.ListCertificateRequirements("r1")
+	if err != nil || len(reqs) != 1 || reqs[0].VerifiedFee != nil || reqs[0].Fields != nil {
+		t.Fatalf("unexpected requirements %+v, %v", reqs, err)
+	}
+	if found, err := d.DeleteCertificateRequirement("r1", "business"); err != nil || !found {
+		t.Fatalf("expected the requirement to be deleted, found=%v err=%v", found, err)
+	}
+
+	// senders delete their certificates, one or all; expired ones are pruned
+	for _, serial := range []string{"serial-3", "serial-4"} {
+		if err := d.SaveSenderCertificate(SenderCertificate{
+			Sender: "s2", Certifier: "c1", CertificateType: serial, SerialNumber: serial, Fields: []string{"email"}, ExpiresAt: expiresAt,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := d.DeleteSenderCertificates("s2", "serial-3"); err != nil || n != 1 {
+		t.Fatalf("expected one certificate to be deleted, got %d, %v", n, err)
+	}
+	if n, err := d.DeleteSenderCertificates("s2", ""); err != nil || n != 1 {
+		t.Fatalf("expected the remaining certificate to be deleted, got %d, %v", n, err)
+	}
+	if n, err := d.DeleteExpiredSenderCertificates(now); err != nil || n != 0 {
+		t.Fatalf("expected no certificate to have expired, got %d, %v", n, err)
+	}
+	if n, err := d.DeleteExpiredSenderCertificates(expiresAt); err != nil || n != 1 {
+		t.Fatalf("expected the expired certificate to be deleted, got %d, %v", n, err)
+	}
+}
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"fmt"
+	"path"
+	"sort"
+)
+
+// DefaultFeePolicy is an operator-defined recipient fee for a box (or glob pattern), applied when
+// the recipient has no rule of their own. It is resolved at read time and never stored per user.
+type DefaultFeePolicy struct {
+	MessageBox string
+	Fee        int
+}
+
+// DefaultFeePolicies are used until SetDefaultFeePolicies is called; they match the historical
+// behaviour of charging 10 sats for notifications and nothing elsewhere.
+var DefaultFeePolicies = []DefaultFeePolicy{{MessageBox: "notifications", Fee: 10}}
+
+// SetDefaultFeePolicies replaces the default recipient fee policies.
+// Boxes matching no policy are free.
+func (d *DB) SetDefaultFeePolicies(policies []DefaultFeePolicy) error {
+	sorted := make([]DefaultFeePolicy, 0, len(policies))
+	for _, p := range policies {
+		if p.MessageBox == "" {
+			return fmt.Errorf("default fee policy requires a message box")
+		}
+		if err := ValidateBoxPattern(p.MessageBox); err != nil {
+			return fmt.Errorf("default fee policy %q: %w", p.MessageBox, err)
+		}
+		if p.Fee < -1 {
+			return fmt.Errorf("default fee policy %q: fee must be -1 (blocked) or more", p.MessageBox)
+		}
+		sorted = append(sorted, p)
+	}
+
+	// exact boxes first, then the more specific pattern, same as permission rules
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i].MessageBox, sorted[j].MessageBox
+		if IsBoxPattern(a) != IsBoxPattern(b) {
+			return !IsBoxPattern(a)
+		}
+		ap, al := patternSpecificity(a)
+		bp, bl := patternSpecificity(b)
+		if ap != bp {
+			return ap > bp
+		}
+		if al != bl {
+			return al > bl
+		}
+		return a < b
+	})
+
+	d.defaultFees = sorted
+	return nil
+}
+
+// DefaultRecipientFee returns the default recipient fee for messageBox and the policy that set it,
+// nil with a fee of 0 when no policy matches.
+func (d *DB) DefaultRecipientFee(messageBox string) (int, *DefaultFeePolicy) {
+	for i := range d.defaultFees {
+		p := &d.defaultFees[i]
+		if ok, _ := path.Match(p.MessageBox, messageBox); ok {
+			return p.Fee, p
+		}
+	}
+	return 0, nil
+}
@@ -0,0 +1,180 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Methods recorded in device_ownership_transfers.
+const (
+	TransferMethodChallenge = "challenge"
+)
+
+// ErrDeviceNotFound is returned when no device registration exists for a token.
+var ErrDeviceNotFound = errors.New("device not found")
+
+// DeviceChallengeRecord represents a row in device_token_challenges.
+type DeviceChallengeRecord struct {
+	ID          int
+	FCMToken    string
+	IdentityKey string
+	NonceHash   string
+	DeviceID    sql.NullString
+	Platform    sql.NullString
+	ExpiresAt   time.Time
+	CreatedAt   time.Time
+}
+
+// DeviceOwnershipTransferRecord represents a row in device_ownership_transfers.
+type DeviceOwnershipTransferRecord struct {
+	ID                   int
+	DeviceRegistrationID int64
+	FCMTokenSuffix       string
+	PreviousIdentityKey  string
+	NewIdentityKey       string
+	Method               string
+	CreatedAt            time.Time
+}
+
+// GetDeviceByToken returns the device registration for an FCM token, or nil if none exists.
+func (d *DB) GetDeviceByToken(fcmToken string) (*DeviceRecord, error) {
+	var dev DeviceRecord
+	err := d.queryRow(
+		`SELECT id, identity_key, fcm_token, device_id, platform, active, created_at, updated_at, last_used
+		 FROM device_registrations WHERE fcm_token = ?`,
+		fcmToken,
+	).Scan(&dev.ID, &dev.IdentityKey, &dev.FCMToken, &dev.DeviceID, &dev.Platform, &dev.Active, &dev.CreatedAt, &dev.UpdatedAt, &dev.LastUsed)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &dev, nil
+}
+
+// CreateDeviceChallenge stores a pending ownership challenge for a token claimed by identityKey,
+// replacing any earlier challenge by the same identity for the same token.
+func (d *DB) CreateDeviceChallenge(fcmToken, identityKey, nonceHash string, deviceID, platform *string, expiresAt time.Time) error {
+	now := time.Now()
+	_, err := d.exec(
+		`INSERT INTO device_token_challenges (fcm_token, identity_key, nonce_hash, device_id, platform, expires_at, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(fcm_token, identity_key) DO UPDATE SET nonce_hash = ?, device_id = ?, platform = ?, expires_at = ?, created_at = ?`,
+		fcmToken, identityKey, nonceHash, deviceID, platform, expiresAt, now,
+		nonceHash, deviceID, platform, expiresAt, now,
+	)
+	return err
+}
+
+// ConsumeDeviceChallenge removes and returns the pending challenge for a token and identity.
+// A challenge can be answered only once. Returns nil if no challenge is pending.
+func (d *DB) ConsumeDeviceChallenge(fcmToken, identityKey string) (*DeviceChallengeRecord, error) {
+	var c DeviceChallengeRecord
+	err := d.withTx(func(t *tx) error {
+		err := t.queryRow(
+			`SELECT id, fcm_token, identity_key, nonce_hash, device_id, platform, expires_at, created_at
+			 FROM device_token_challenges WHERE fcm_token = ? AND identity_key = ?`,
+			fcmToken, identityKey,
+		).Scan(&c.ID, &c.FCMToken, &c.IdentityKey, &c.NonceHash, &c.DeviceID, &c.Platform, &c.ExpiresAt, &c.CreatedAt)
+		if err != nil {
+			return err
+		}
+		_, err = t.exec(`DELETE FROM device_token_challenges WHERE id = ?`, c.ID)
+		return err
+	})
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// DeleteExpiredDeviceChallenges removes challenges that expired before now.
+func (d *DB) DeleteExpiredDeviceChallenges(now time.Time) (int64, error) {
+	res, err := d.exec(`DELETE FROM device_token_challenges WHERE expires_at < ?`, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// TransferDeviceOwnership moves a device registration to newIdentityKey and records the transfer
+// in the audit table, atomically. Returns the updated registration and the previous owner.
+func (d *DB) TransferDeviceOwnership(fcmToken, newIdentityKey string, deviceID, platform sql.NullString, method string) (*DeviceOwnershipTransferRecord, error) {
+	now := time.Now()
+	rec := &DeviceOwnershipTransferRecord{
+		NewIdentityKey: newIdentityKey,
+		Method:         method,
+		CreatedAt:      now,
+	}
+
+	err := d.withTx(func(t *tx) error {
+		err := t.queryRow(
+			`SELECT id, identity_key FROM device_registrations WHERE fcm_token = ?`,
+			fcmToken,
+		).Scan(&rec.DeviceRegistrationID, &rec.PreviousIdentityKey)
+		if err == sql.ErrNoRows {
+			return ErrDeviceNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = t.exec(
+			`UPDATE device_registrations SET identity_key = ?, device_id = ?, platform = ?, active = TRUE, last_used = ?, updated_at = ?
+			 WHERE id = ?`,
+			newIdentityKey, deviceID, platform, now, now, rec.DeviceRegistrationID,
+		)
+		if err != nil {
+			return err
+		}
+
+		rec.FCMTokenSuffix = tokenSuffix(fcmToken)
+		_, err = t.exec(
+			`INSERT INTO device_ownership_transfers (device_registration_id, fcm_token_suffix, previous_identity_key, new_identity_key, method, created_at)
+			 VALUES (?, ?, ?, ?, ?, ?)`,
+			rec.DeviceRegistrationID, rec.FCMTokenSuffix, rec.PreviousIdentityKey, newIdentityKey, method, now,
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// ListDeviceOwnershipTransfers returns transfers where identityKey lost or gained a device, newest first.
+func (d *DB) ListDeviceOwnershipTransfers(identityKey string, limit int) ([]DeviceOwnershipTransferRecord, error) {
+	rows, err := d.query(
+		`SELECT id, device_registration_id, fcm_token_suffix, previous_identity_key, new_identity_key, method, created_at
+		 FROM device_ownership_transfers WHERE previous_identity_key = ? OR new_identity_key = ?
+		 ORDER BY created_at DESC, id DESC LIMIT ?`,
+		identityKey, identityKey, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []DeviceOwnershipTransferRecord
+	for rows.Next() {
+		var r DeviceOwnershipTransferRecord
+		if err := rows.Scan(&r.ID, &r.DeviceRegistrationID, &r.FCMTokenSuffix, &r.PreviousIdentityKey, &r.NewIdentityKey, &r.Method, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// tokenSuffix keeps only the last 10 characters of a token for audit records.
+func tokenSuffix(token string) string {
+	if len(token) <= 10 {
+		return token
+	}
+	return token[len(token)-10:]
+}
@@ -0,0 +1,113 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// Push delivery statuses recorded in notification_deliveries.
+const (
+	DeliveryStatusSent   = "sent"
+	DeliveryStatusFailed = "failed"
+)
+
+// NotificationDeliveryRecord represents a row in notification_deliveries (one push attempt to one device).
+type NotificationDeliveryRecord struct {
+	ID                   int
+	Recipient            string
+	DeviceRegistrationID sql.NullInt64
+	MessageID            sql.NullString
+	MessageBox           sql.NullString
+	Provider             string
+	Status               string
+	ErrorCode            sql.NullString
+	ErrorMessage         sql.NullString
+	LatencyMs            int64
+	CreatedAt            time.Time
+}
+
+// DeliveryFailureCount is the number of failed push attempts for a provider and error code.
+type DeliveryFailureCount struct {
+	Provider  string
+	ErrorCode string
+	Count     int
+}
+
+// InsertNotificationDeliveries records a batch of push attempts in a single statement.
+func (d *DB) InsertNotificationDeliveries(records []NotificationDeliveryRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	values := make([]string, 0, len(records))
+	args := make([]any, 0, len(records)*10)
+	for _, r := range records {
+		values = append(values, "("+placeholders(10)+")")
+		args = append(args, r.Recipient, r.DeviceRegistrationID, r.MessageID, r.MessageBox, r.Provider, r.Status, r.ErrorCode, r.ErrorMessage, r.LatencyMs, now)
+	}
+
+	_, err := d.exec(
+		`INSERT INTO notification_deliveries (recipient, device_registration_id, message_id, message_box, provider, status, error_code, error_message, latency_ms, created_at)
+		 VALUES `+strings.Join(values, ", "),
+		args...,
+	)
+	return err
+}
+
+// ListNotificationDeliveries returns the most recent push attempts for a recipient, newest first.
+func (d *DB) ListNotificationDeliveries(recipient string, limit int) ([]NotificationDeliveryRecord, error) {
+	rows, err := d.query(
+		`SELECT id, recipient, device_registration_id, message_id, message_box, provider, status, error_code, error_message, latency_ms, created_at
+		 FROM notification_deliveries WHERE recipient = ? ORDER BY created_at DESC, id DESC LIMIT ?`,
+		recipient, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []NotificationDeliveryRecord
+	for rows.Next() {
+		var r NotificationDeliveryRecord
+		if err := rows.Scan(&r.ID, &r.Recipient, &r.DeviceRegistrationID, &r.MessageID, &r.MessageBox, &r.Provider, &r.Status, &r.ErrorCode, &r.ErrorMessage, &r.LatencyMs, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// CountDeliveryFailures aggregates failed push attempts since a point in time by provider and error code.
+func (d *DB) CountDeliveryFailures(since time.Time) ([]DeliveryFailureCount, error) {
+	rows, err := d.query(
+		`SELECT provider, COALESCE(error_code, 'UNKNOWN') AS code, COUNT(*) FROM notification_deliveries
+		 WHERE status = ? AND created_at >= ?
+		 GROUP BY provider, code ORDER BY COUNT(*) DESC, provider ASC, code ASC`,
+		DeliveryStatusFailed, since,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []DeliveryFailureCount
+	for rows.Next() {
+		var c DeliveryFailureCount
+		if err := rows.Scan(&c.Provider, &c.ErrorCode, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// DeleteNotificationDeliveriesBefore removes push attempts recorded before cutoff.
+func (d *DB) DeleteNotificationDeliveriesBefore(cutoff time.Time) (int64, error) {
+	res, err := d.exec(`DELETE FROM notification_deliveries WHERE created_at < ?`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
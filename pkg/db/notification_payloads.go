@@ -0,0 +1,51 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// NotificationPayloadRecord represents a row in notification_payload_settings.
+type NotificationPayloadRecord struct {
+	ID            int
+	IdentityKey   string
+	MessageBox    string
+	TitleTemplate sql.NullString
+	BodyTemplate  sql.NullString
+	Silent        bool
+	Badge         bool
+	CollapseKey   sql.NullString
+	TTLSeconds    sql.NullInt64
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// SetNotificationPayload upserts the push payload settings of an identity for a message box.
+func (d *DB) SetNotificationPayload(p NotificationPayloadRecord) error {
+	now := time.Now()
+	_, err := d.exec(
+		`INSERT INTO notification_payload_settings (identity_key, message_box, title_template, body_template, silent, badge, collapse_key, ttl_seconds, created_at, updated_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(identity_key, message_box) DO UPDATE SET title_template = ?, body_template = ?, silent = ?, badge = ?, collapse_key = ?, ttl_seconds = ?, updated_at = ?`,
+		p.IdentityKey, p.MessageBox, p.TitleTemplate, p.BodyTemplate, p.Silent, p.Badge, p.CollapseKey, p.TTLSeconds, now, now,
+		p.TitleTemplate, p.BodyTemplate, p.Silent, p.Badge, p.CollapseKey, p.TTLSeconds, now,
+	)
+	return err
+}
+
+// GetNotificationPayload returns the stored payload settings for an identity and box, or nil if none are stored.
+func (d *DB) GetNotificationPayload(identityKey, messageBox string) (*NotificationPayloadRecord, error) {
+	var p NotificationPayloadRecord
+	err := d.queryRow(
+		`SELECT id, identity_key, message_box, title_template, body_template, silent, badge, collapse_key, ttl_seconds, created_at, updated_at
+		 FROM notification_payload_settings WHERE identity_key = ? AND message_box = ?`,
+		identityKey, messageBox,
+	).Scan(&p.ID, &p.IdentityKey, &p.MessageBox, &p.TitleTemplate, &p.BodyTemplate, &p.Silent, &p.Badge, &p.CollapseKey, &p.TTLSeconds, &p.CreatedAt, &p.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
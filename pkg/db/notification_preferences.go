@@ -0,0 +1,213 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Notification modes control whether a message box triggers push notifications.
+const (
+	NotificationModeNone      = "none"      // never push
+	NotificationModeAlways    = "always"    // push for every sender
+	NotificationModeAllowlist = "allowlist" // push only for senders in AllowedSenders
+)
+
+// quietHoursLayout is the clock format used for quiet hours boundaries.
+const quietHoursLayout = "15:04"
+
+// NotificationPreferenceRecord represents a row in notification_preferences.
+type NotificationPreferenceRecord struct {
+	ID              int
+	IdentityKey     string
+	MessageBox      string
+	Mode            string
+	AllowedSenders  []string
+	QuietHoursStart sql.NullString
+	QuietHoursEnd   sql.NullString
+	Timezone        sql.NullString
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+// IsValidNotificationMode reports whether mode is one of the supported notification modes.
+func IsValidNotificationMode(mode string) bool {
+	switch mode {
+	case NotificationModeNone, NotificationModeAlways, NotificationModeAllowlist:
+		return true
+	}
+	return false
+}
+
+// ParseQuietHours validates quiet hours boundaries ("HH:MM") and an IANA timezone name.
+func ParseQuietHours(start, end, timezone string) error {
+	if _, err := time.Parse(quietHoursLayout, start); err != nil {
+		return fmt.Errorf("invalid quiet hours start %q: expected HH:MM", start)
+	}
+	if _, err := time.Parse(quietHoursLayout, end); err != nil {
+		return fmt.Errorf("invalid quiet hours end %q: expected HH:MM", end)
+	}
+	if _, err := time.LoadLocation(timezone); err != nil {
+		return fmt.Errorf("invalid timezone %q", timezone)
+	}
+	return nil
+}
+
+// defaultNotificationMode returns the mode used when an identity never set a preference for a box.
+func defaultNotificationMode(messageBox string) string {
+	if messageBox == "notifications" {
+		return NotificationModeAlways
+	}
+	return NotificationModeNone
+}
+
+// DefaultNotificationPreference returns the implicit preference for a box without a stored row.
+func DefaultNotificationPreference(identityKey, messageBox string) *NotificationPreferenceRecord {
+	return &NotificationPreferenceRecord{
+		IdentityKey: identityKey,
+		MessageBox:  messageBox,
+		Mode:        defaultNotificationMode(messageBox),
+	}
+}
+
+// InQuietHours reports whether now falls inside the preference's quiet hours window,
+// evaluated in the identity's timezone. Windows may wrap midnight (e.g. 22:00-07:00).
+func (p *NotificationPreferenceRecord) InQuietHours(now time.Time) bool {
+	if !p.QuietHoursStart.Valid || !p.QuietHoursEnd.Valid {
+		return false
+	}
+
+	start, err := time.Parse(quietHoursLayout, p.QuietHoursStart.String)
+	if err != nil {
+		return false
+	}
+	end, err := time.Parse(quietHoursLayout, p.QuietHoursEnd.String)
+	if err != nil {
+		return false
+	}
+
+	loc := time.UTC
+	if p.Timezone.Valid {
+		if l, err := time.LoadLocation(p.Timezone.String); err == nil {
+			loc = l
+		}
+	}
+
+	local := now.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	startMin := start.Hour()*60 + start.Minute()
+	endMin := end.Hour()*60 + end.Minute()
+
+	switch {
+	case startMin == endMin:
+		return false
+	case startMin < endMin:
+		return minute >= startMin && minute < endMin
+	default:
+		return minute >= startMin || minute < endMin
+	}
+}
+
+// AllowsPush reports whether a message from sender should trigger a push at time now.
+func (p *NotificationPreferenceRecord) AllowsPush(sender string, now time.Time) bool {
+	switch p.Mode {
+	case NotificationModeAlways:
+	case NotificationModeAllowlist:
+		if !slices.Contains(p.AllowedSenders, sender) {
+			return false
+		}
+	default:
+		return false
+	}
+	return !p.InQuietHours(now)
+}
+
+// SetNotificationPreference upserts the notification preference of an identity for a message box.
+func (d *DB) SetNotificationPreference(identityKey, messageBox, mode string, allowedSenders []string, quietHoursStart, quietHoursEnd, timezone *string) error {
+	now := time.Now()
+
+	var senders sql.NullString
+	if len(allowedSenders) > 0 {
+		b, err := json.Marshal(allowedSenders)
+		if err != nil {
+			return err
+		}
+		senders = sql.NullString{String: string(b), Valid: true}
+	}
+
+	_, err := d.exec(
+		`INSERT INTO notification_preferences (identity_key, message_box, mode, allowed_senders, quiet_hours_start, quiet_hours_end, timezone, created_at, updated_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(identity_key, message_box) DO UPDATE SET mode = ?, allowed_senders = ?, quiet_hours_start = ?, quiet_hours_end = ?, timezone = ?, updated_at = ?`,
+		identityKey, messageBox, mode, senders, quietHoursStart, quietHoursEnd, timezone, now, now,
+		mode, senders, quietHoursStart, quietHoursEnd, timezone, now,
+	)
+	return err
+}
+
+// GetNotificationPreference returns the stored preference for an identity and box, or nil if none is stored.
+func (d *DB) GetNotificationPreference(identityKey, messageBox string) (*NotificationPreferenceRecord, error) {
+	rows, err := d.query(
+		`SELECT id, identity_key, message_box, mode, allowed_senders, quiet_hours_start, quiet_hours_end, timezone, created_at, updated_at
+		 FROM notification_preferences WHERE identity_key = ? AND message_box = ?`,
+		identityKey, messageBox,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prefs, err := scanNotificationPreferences(rows)
+	if err != nil || len(prefs) == 0 {
+		return nil, err
+	}
+	return &prefs[0], nil
+}
+
+// ListNotificationPreferences returns all stored preferences for an identity, ordered by message box.
+func (d *DB) ListNotificationPreferences(identityKey string) ([]NotificationPreferenceRecord, error) {
+	rows, err := d.query(
+		`SELECT id, identity_key, message_box, mode, allowed_senders, quiet_hours_start, quiet_hours_end, timezone, created_at, updated_at
+		 FROM notification_preferences WHERE identity_key = ? ORDER BY message_box ASC`,
+		identityKey,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanNotificationPreferences(rows)
+}
+
+// ShouldUseFCMDelivery checks whether a message from sender into the recipient's message box
+// should trigger a push notification, honouring the recipient's stored preference.
+func (d *DB) ShouldUseFCMDelivery(recipient, sender, messageBox string, now time.Time) (bool, error) {
+	pref, err := d.GetNotificationPreference(recipient, messageBox)
+	if err != nil {
+		return false, err
+	}
+	if pref == nil {
+		pref = DefaultNotificationPreference(recipient, messageBox)
+	}
+	return pref.AllowsPush(sender, now), nil
+}
+
+func scanNotificationPreferences(rows *sql.Rows) ([]NotificationPreferenceRecord, error) {
+	var prefs []NotificationPreferenceRecord
+	for rows.Next() {
+		var p NotificationPreferenceRecord
+		var senders sql.NullString
+		if err := rows.Scan(&p.ID, &p.IdentityKey, &p.MessageBox, &p.Mode, &senders, &p.QuietHoursStart, &p.QuietHoursEnd, &p.Timezone, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if senders.Valid && senders.String != "" {
+			if err := json.Unmarshal([]byte(senders.String), &p.AllowedSenders); err != nil {
+				return nil, fmt.Errorf("invalid allowed_senders for preference %d: %w", p.ID, err)
+			}
+		}
+		prefs = append(prefs, p)
+	}
+	return prefs, rows.Err()
+}
@@ -0,0 +1,138 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Payment kinds recorded in payments.
+const (
+	PaymentKindDeliveryFee  = "delivery_fee"  // paid to the server and internalized by its wallet
+	PaymentKindRecipientFee = "recipient_fee" // relayed to the recipient inside the stored message
+	PaymentKindRouteFee     = "route_fee"     // charged by the payment middleware for a priced route
+)
+
+// Payment statuses recorded in payments.
+const (
+	PaymentStatusInternalized = "internalized"
+	PaymentStatusRejected     = "rejected" // the server wallet did not accept the output
+	PaymentStatusFailed       = "failed"   // internalization returned an error
+	PaymentStatusRelayed      = "relayed"
+)
+
+// PaymentRecord represents a row in payments: one fee paid by a sender for one send.
+// Delivery fees have no recipient or message id, since one send may reach several recipients.
+type PaymentRecord struct {
+	ID         int64
+	TxID       string
+	Kind       string
+	Sender     string
+	Recipient  sql.NullString
+	MessageBox string
+	MessageID  sql.NullString
+	Fee        int   // satoshis required
+	Amount     int64 // satoshis paid by the outputs
+	Status     string
+	Error      sql.NullString
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// PaymentFilter narrows payment listings; zero values match everything.
+type PaymentFilter struct {
+	MessageBox string
+	Since      time.Time
+	Until      time.Time
+}
+
+// PaymentTotals sums the amounts of matching payments.
+type PaymentTotals struct {
+	MessageBox string // empty for an overall total
+	Count      int
+	Amount     int64
+}
+
+// InsertPayment records a payment and returns its id.
+func (d *DB) InsertPayment(p PaymentRecord) (int64, error) {
+	now := time.Now()
+	var id int64
+	err := d.queryRow(
+		`INSERT INTO payments (txid, kind, sender, recipient, message_box, message_id, fee, amount, status, error, created_at, updated_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		 RETURNING id`,
+		p.TxID, p.Kind, p.Sender, p.Recipient, p.MessageBox, p.MessageID, p.Fee, p.Amount, p.Status, p.Error, now, now,
+	).Scan(&id)
+	return id, err
+}
+
+// ListRecipientEarnings returns recipient fees relayed to a recipient, newest first,
+// along with the totals over every matching payment.
+func (d *DB) ListRecipientEarnings(recipient string, f PaymentFilter, limit int) ([]PaymentRecord, PaymentTotals, error) {
+	where, args := f.where(`kind = ? AND recipient = ?`, PaymentKindRecipientFee, recipient)
+
+	var totals PaymentTotals
+	if err := d.queryRow(`SELECT COUNT(*), COALESCE(SUM(amount), 0) FROM payments WHERE `+where, args...).Scan(&totals.Count, &totals.Amount); err != nil {
+		return nil, totals, err
+	}
+
+	rows, err := d.query(
+		`SELECT id, txid, kind, sender, recipient, message_box, message_id, fee, amount, status, error, created_at, updated_at
+		 FROM payments WHERE `+where+` ORDER BY created_at DESC, id DESC LIMIT ?`,
+		append(args, limit)...,
+	)
+	if err != nil {
+		return nil, totals, err
+	}
+	defer rows.Close()
+
+	var records []PaymentRecord
+	for rows.Next() {
+		var p PaymentRecord
+		if err := rows.Scan(&p.ID, &p.TxID, &p.Kind, &p.Sender, &p.Recipient, &p.MessageBox, &p.MessageID, &p.Fee, &p.Amount, &p.Status, &p.Error, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			return nil, totals, err
+		}
+		records = append(records, p)
+	}
+	return records, totals, rows.Err()
+}
+
+// Revenue sums the payments of kind internalized by the server wallet or debited from credit, per message box.
+func (d *DB) Revenue(kind string, f PaymentFilter) ([]PaymentTotals, error) {
+	where, args := f.where(`kind = ? AND status IN (?, ?)`, kind, PaymentStatusInternalized, PaymentStatusCredited)
+	rows, err := d.query(
+		`SELECT message_box, COUNT(*), COALESCE(SUM(amount), 0) FROM payments WHERE `+where+`
+		 GROUP BY message_box ORDER BY message_box ASC`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var totals []PaymentTotals
+	for rows.Next() {
+		var t PaymentTotals
+		if err := rows.Scan(&t.MessageBox, &t.Count, &t.Amount); err != nil {
+			return nil, err
+		}
+		totals = append(totals, t)
+	}
+	return totals, rows.Err()
+}
+
+func (f PaymentFilter) where(base string, args ...any) (string, []any) {
+	where := base
+	if f.MessageBox != "" {
+		where += ` AND message_box = ?`
+		args = append(args, f.MessageBox)
+	}
+	if !f.Since.IsZero() {
+		where += ` AND created_at >= ?`
+		args = append(args, f.Since)
+	}
+	if !f.Until.IsZero() {
+		where += ` AND created_at < ?`
+		args = append(args, f.Until)
+	}
+	return where, args
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// PermissionRule is one permission to write, as used by bulk upserts.
+type PermissionRule struct {
+	Sender        *string // nil for a box-wide rule
+	MessageBox    string
+	RecipientFee  int
+	Limits        PermissionLimits
+	Pricing       SizePricing
+	PowDifficulty int // leading zero bits required of a proof of work, 0 for none
+}
+
+// SetMessagePermissionRules upserts all rules in a single transaction; if any write fails, none is applied.
+func (d *DB) SetMessagePermissionRules(recipient string, rules []PermissionRule) error {
+	now := time.Now()
+	return d.withTx(func(t *tx) error {
+		for _, rule := range rules {
+			if err := upsertPermission(t, recipient, rule, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeletePermission removes a sender-specific or box-wide permission. Returns false if none existed.
+func (d *DB) DeletePermission(recipient string, sender *string, messageBox string) (bool, error) {
+	var res sql.Result
+	var err error
+	if sender == nil {
+		res, err = d.exec(`DELETE FROM message_permissions WHERE recipient = ? AND sender IS NULL AND message_box = ?`, recipient, messageBox)
+	} else {
+		res, err = d.exec(`DELETE FROM message_permissions WHERE recipient = ? AND sender = ? AND message_box = ?`, recipient, *sender, messageBox)
+	}
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ExportPermissions returns every permission of a recipient, ordered by box and sender.
+func (d *DB) ExportPermissions(recipient string) ([]PermissionRecord, error) {
+	return d.scanPermissions(
+		`SELECT id, recipient, sender, message_box, recipient_fee, expires_at, max_messages, messages_used,
+		 fee_per_kb, large_payload_threshold, large_payload_fee, pow_difficulty, is_pattern, created_at, updated_at
+		 FROM message_permissions WHERE recipient = ?
+		 ORDER BY message_box ASC, CASE WHEN sender IS NULL THEN 0 ELSE 1 END, sender ASC`,
+		recipient,
+	)
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// PermissionLimits bounds how long and for how many messages a permission rule applies.
+// Nil fields mean unlimited.
+type PermissionLimits struct {
+	ExpiresAt   *time.Time
+	MaxMessages *int
+}
+
+func (l PermissionLimits) nullable() (sql.NullTime, sql.NullInt64) {
+	var expiresAt sql.NullTime
+	if l.ExpiresAt != nil {
+		expiresAt = sql.NullTime{Time: *l.ExpiresAt, Valid: true}
+	}
+	var maxMessages sql.NullInt64
+	if l.MaxMessages != nil {
+		maxMessages = sql.NullInt64{Int64: int64(*l.MaxMessages), Valid: true}
+	}
+	return expiresAt, maxMessages
+}
+
+// FeeResolution is the outcome of resolving a recipient fee.
+// Fee is the base fee (-1 blocked), Pricing adds size-based charges on top of it.
+// Rule is the rule that matched, nil when a default applied; Permission is the same rule
+// when it is sender-specific, nil when a box-wide rule or default was used.
+// Default is the operator policy used when no rule matched, nil if none matched either.
+// Subscription is the sender's running subscription when it made the box free; no rule applies then.
+type FeeResolution struct {
+	Fee          int
+	Pricing      SizePricing
+	Rule         *PermissionRecord
+	Permission   *PermissionRecord
+	Default      *DefaultFeePolicy
+	Subscription *SubscriptionRecord
+}
+
+// SenderSpecific reports whether the recipient chose the fee for this sender, with a sender-specific rule
+// or a subscription they sold.
+func (r *FeeResolution) SenderSpecific() bool {
+	return r.Permission != nil || r.Subscription != nil
+}
+
+// FeeFor returns the recipient fee for a message body of bodySize bytes, or -1 if blocked.
+func (r *FeeResolution) FeeFor(bodySize int) int {
+	return r.Pricing.FeeFor(r.Fee, bodySize)
+}
+
+// PowDifficulty returns the leading zero bits the matched rule requires of a proof of work, 0 when none is required.
+func (r *FeeResolution) PowDifficulty() int {
+	if r.Rule == nil {
+		return 0
+	}
+	return r.Rule.PowDifficulty
+}
+
+// ActiveAt reports whether the rule still applies at now, i.e. it has neither expired nor used up its messages.
+func (p *PermissionRecord) ActiveAt(now time.Time) bool {
+	if p.ExpiresAt.Valid && !now.Before(p.ExpiresAt.Time) {
+		return false
+	}
+	if p.MaxMessages.Valid && int64(p.MessagesUsed) >= p.MaxMessages.Int64 {
+		return false
+	}
+	return true
+}
+
+// RemainingMessages returns how many more messages the rule allows, or nil when it is not capped.
+func (p *PermissionRecord) RemainingMessages() *int {
+	if !p.MaxMessages.Valid {
+		return nil
+	}
+	remaining := max(int(p.MaxMessages.Int64)-p.MessagesUsed, 0)
+	return &remaining
+}
+
+// consumePermissionMessage counts one delivered message against a capped permission rule.
+// Returns false if the rule was already used up, e.g. by a concurrent send.
+func consumePermissionMessage(ex execer, id int) (bool, error) {
+	res, err := ex.exec(
+		`UPDATE message_permissions SET messages_used = messages_used + 1
+		 WHERE id = ? AND (max_messages IS NULL OR messages_used < max_messages)`,
+		id,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
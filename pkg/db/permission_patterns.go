@@ -0,0 +1,120 @@
+package db
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// boxPatternChars are the glob metacharacters that make a message box name a pattern.
+const boxPatternChars = "*?["
+
+// IsBoxPattern reports whether a permission's message box is a glob pattern rather than an exact name.
+func IsBoxPattern(messageBox string) bool {
+	return strings.ContainsAny(messageBox, boxPatternChars)
+}
+
+// ValidateBoxPattern checks that a message box pattern is well-formed.
+func ValidateBoxPattern(pattern string) error {
+	_, err := path.Match(pattern, "")
+	return err
+}
+
+// Matches reports whether the rule applies to messageBox, by exact name or glob pattern.
+func (p *PermissionRecord) Matches(messageBox string) bool {
+	if !p.IsPattern {
+		return p.MessageBox == messageBox
+	}
+	ok, _ := path.Match(p.MessageBox, messageBox)
+	return ok
+}
+
+// patternSpecificity ranks patterns: a longer literal prefix wins, then more literal characters overall.
+func patternSpecificity(pattern string) (prefix, literals int) {
+	prefix = strings.IndexAny(pattern, boxPatternChars)
+	if prefix < 0 {
+		prefix = len(pattern)
+	}
+	for _, c := range pattern {
+		if !strings.ContainsRune(boxPatternChars+"]", c) {
+			literals++
+		}
+	}
+	return prefix, literals
+}
+
+// morePrecedent reports whether rule a takes precedence over rule b. From most to least specific:
+// sender-specific before box-wide, exact box before pattern, then the more specific pattern,
+// with the pattern text as a final tie-breaker so resolution is deterministic.
+func morePrecedent(a, b *PermissionRecord) bool {
+	if a.Sender.Valid != b.Sender.Valid {
+		return a.Sender.Valid
+	}
+	if a.IsPattern != b.IsPattern {
+		return !a.IsPattern
+	}
+	ap, al := patternSpecificity(a.MessageBox)
+	bp, bl := patternSpecificity(b.MessageBox)
+	if ap != bp {
+		return ap > bp
+	}
+	if al != bl {
+		return al > bl
+	}
+	return a.MessageBox < b.MessageBox
+}
+
+// sortByPrecedence orders rules from most to least precedent.
+func sortByPrecedence(rules []PermissionRecord) {
+	sort.SliceStable(rules, func(i, j int) bool {
+		return morePrecedent(&rules[i], &rules[j])
+	})
+}
+
+// MatchingPermissions returns the rules of recipient that apply to messageBox, most precedent first.
+// With a sender, its sender-specific rules are included; box-wide rules are always included.
+func (d *DB) MatchingPermissions(recipient, sender, messageBox string) ([]PermissionRecord, error) {
+	query := `SELECT id, recipient, sender, message_box, recipient_fee, expires_at, max_messages, messages_used,
+		fee_per_kb, large_payload_threshold, large_payload_fee, pow_difficulty, is_pattern, created_at, updated_at
+		FROM message_permissions WHERE recipient = ? AND (message_box = ? OR is_pattern = TRUE)`
+	args := []any{recipient, messageBox}
+	if sender != "" {
+		query += ` AND (sender IS NULL OR sender = ?)`
+		args = append(args, sender)
+	} else {
+		query += ` AND sender IS NULL`
+	}
+
+	rules, err := d.scanPermissions(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	matched := rules[:0]
+	for _, r := range rules {
+		if r.Matches(messageBox) {
+			matched = append(matched, r)
+		}
+	}
+	sortByPrecedence(matched)
+	return matched, nil
+}
+
+func (d *DB) scanPermissions(query string, args ...any) ([]PermissionRecord, error) {
+	rows, err := d.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var perms []PermissionRecord
+	for rows.Next() {
+		var p PermissionRecord
+		if err := rows.Scan(&p.ID, &p.Recipient, &p.Sender, &p.MessageBox, &p.RecipientFee, &p.ExpiresAt, &p.MaxMessages, &p.MessagesUsed,
+			&p.FeePerKB, &p.LargePayloadThreshold, &p.LargePayloadFee, &p.PowDifficulty, &p.IsPattern, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		perms = append(perms, p)
+	}
+	return perms, rows.Err()
+}
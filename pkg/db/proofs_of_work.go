@@ -0,0 +1,49 @@
+package db
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrProofOfWorkUsed is returned when a message carries a proof of work another message already used.
+var ErrProofOfWorkUsed = errors.New("proof of work already used")
+
+// ProofOfWorkUsed reports whether a stored message already used the proof with this digest for recipient.
+func (d *DB) ProofOfWorkUsed(recipient, digest string) (bool, error) {
+	var n int
+	err := d.queryRow(
+		`SELECT COUNT(*) FROM used_proofs_of_work WHERE recipient = ? AND digest = ?`,
+		recipient, digest,
+	).Scan(&n)
+	return n > 0, err
+}
+
+// recordProofOfWork marks the proof with this digest as used for recipient. Proofs are kept after the
+// message is acknowledged, until DeleteUsedProofsOfWorkBefore prunes them, so a messageId freed by the ack
+// cannot be sent again with the same proof. Returns ErrProofOfWorkUsed if the proof was already recorded.
+func recordProofOfWork(ex execer, recipient, sender, messageID, digest string, now time.Time) error {
+	res, err := ex.exec(
+		`INSERT INTO used_proofs_of_work (recipient, digest, sender, message_id, created_at) VALUES (?, ?, ?, ?, ?)
+		 ON CONFLICT (recipient, digest) DO NOTHING`,
+		recipient, digest, sender, messageID, now,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrProofOfWorkUsed
+	}
+	return nil
+}
+
+// DeleteUsedProofsOfWorkBefore removes proofs of work used before cutoff. Once removed, a proof can be used
+// again for an acknowledged messageId.
+func (d *DB) DeleteUsedProofsOfWorkBefore(cutoff time.Time) (int64, error) {
+	res, err := d.exec(`DELETE FROM used_proofs_of_work WHERE created_at < ?`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -3,12 +3,20 @@ package db
 import (
 	"database/sql"
 	"errors"
+	"fmt"
+	"sort"
 	"time"
 )
 
 // ErrDuplicateMessage is returned when a message with the same ID already exists.
 var ErrDuplicateMessage = errors.New("duplicate message")
 
+// ErrPermissionUsedUp is returned when a message would exceed the message cap of its sender rule.
+var ErrPermissionUsedUp = errors.New("permission message cap reached")
+
+// ErrDeviceTokenOwned is returned when an FCM token is already registered to another identity.
+var ErrDeviceTokenOwned = errors.New("device token owned by another identity")
+
 // MessageBoxRecord represents a row in the messageBox table.
 type MessageBoxRecord struct {
 	MessageBoxID int
@@ -34,8 +42,16 @@ type PermissionRecord struct {
 	Sender       sql.NullString
 	MessageBox   string
 	RecipientFee int
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
+	ExpiresAt    sql.NullTime  // rule stops applying after this time
+	MaxMessages  sql.NullInt64 // rule stops applying after this many messages
+	MessagesUsed int
+	IsPattern    bool // MessageBox is a glob pattern such as "app.*"
+	// Leading zero bits required of a proof of work with each message, 0 for none
+	PowDifficulty int
+	Effective     bool // set by ListPermissions filtered by box: this rule wins for its sender
+	SizePricing
+	CreatedAt time.Time
+	UpdatedAt time.Time
 }
 
 // DeviceRecord represents a row in device_registrations.
@@ -80,11 +96,114 @@ func (d *DB) GetMessageBoxID(identityKey, boxType string) (int64, error) {
 
 // InsertMessage inserts a message. Returns ErrDuplicateMessage if the messageId already exists.
 func (d *DB) InsertMessage(messageID string, messageBoxID int64, sender, recipient, body string) error {
+	return insertMessage(d, NewMessage{MessageID: messageID, MessageBoxID: messageBoxID, Sender: sender, Recipient: recipient, Body: body}, time.Now())
+}
+
+// ExistingMessageIDs returns those of messageIDs that are already stored.
+func (d *DB) ExistingMessageIDs(messageIDs []string) ([]string, error) {
+	if len(messageIDs) == 0 {
+		return nil, nil
+	}
+	args := make([]any, len(messageIDs))
+	for i, id := range messageIDs {
+		args[i] = id
+	}
+	rows, err := d.query(`SELECT messageId FROM messages WHERE messageId IN (`+placeholders(len(messageIDs))+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		out = append(out, id)
+	}
+	return out, rows.Err()
+}
+
+// NewMessage is a message to store with InsertMessages.
+type NewMessage struct {
+	MessageID    string
+	MessageBoxID int64
+	MessageBox   string // box type, whose rate limits the message is counted against
+	Sender       string
+	Recipient    string
+	Body         string
+	PermissionID int                      // sender rule the message is counted against, 0 for none
+	Subscription *SubscriptionOfferRecord // offer the message buys for its sender, nil for none
+	ProofDigest  string                   // hex digest of the sender's proof of work, empty for none
+}
+
+// InsertMessages stores all messages in one transaction: either every message is stored or none is.
+// Each message is counted against its PermissionID and the rate limits of its recipient, records its proof
+// of work and grants the Subscription it buys in the same transaction. Recipient fees the sender paid from
+// credit for them (heldCredit) are released to the recipients with them.
+// Returns the subscription granted for each message (nil where none was bought), or an error wrapping
+// ErrDuplicateMessage if any messageId already exists, ErrPermissionUsedUp if a concurrent send used up the
+// message cap of a rule, ErrRateLimited if it used up a rate limit, or ErrProofOfWorkUsed if a proof of
+// work was already used.
+func (d *DB) InsertMessages(msgs []NewMessage, heldCredit []int64) ([]*SubscriptionRecord, error) {
 	now := time.Now()
-	res, err := d.exec(
+	subs := make([]*SubscriptionRecord, len(msgs))
+	err := d.withTx(func(t *tx) error {
+		if err := releaseCreditDebits(t, heldCredit); err != nil {
+			return err
+		}
+		for i, m := range msgs {
+			if m.PermissionID != 0 {
+				ok, err := consumePermissionMessage(t, m.PermissionID)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					return fmt.Errorf("%w: %d", ErrPermissionUsedUp, m.PermissionID)
+				}
+			}
+			if err := countRateLimitedMessage(t, m.Recipient, m.Sender, m.MessageBox, now); err != nil {
+				if errors.Is(err, ErrRateLimited) {
+					return fmt.Errorf("%w: %s", ErrRateLimited, m.Recipient)
+				}
+				return err
+			}
+			if err := insertMessage(t, m, now); err != nil {
+				if errors.Is(err, ErrDuplicateMessage) {
+					return fmt.Errorf("%w: %s", ErrDuplicateMessage, m.MessageID)
+				}
+				return err
+			}
+			if m.ProofDigest != "" {
+				if err := recordProofOfWork(t, m.Recipient, m.Sender, m.MessageID, m.ProofDigest, now); err != nil {
+					if errors.Is(err, ErrProofOfWorkUsed) {
+						return fmt.Errorf("%w: %s", ErrProofOfWorkUsed, m.Recipient)
+					}
+					return err
+				}
+			}
+			if m.Subscription != nil {
+				sub, err := grantSubscription(t, m.Subscription, m.Sender, m.MessageID, now)
+				if err != nil {
+					return err
+				}
+				subs[i] = sub
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
+func insertMessage(ex execer, m NewMessage, now time.Time) error {
+	res, err := ex.exec(
 		`INSERT INTO messages (messageId, messageBoxId, sender, recipient, body, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)
 		 ON CONFLICT (messageId) DO NOTHING`,
-		messageID, messageBoxID, sender, recipient, body, now, now,
+		m.MessageID, m.MessageBoxID, m.Sender, m.Recipient, m.Body, now, now,
 	)
 	if err != nil {
 		return err
@@ -121,6 +240,16 @@ func (d *DB) ListMessages(recipient string, messageBoxID int64) ([]MessageRecord
 	return msgs, rows.Err()
 }
 
+// CountMessages returns the number of unacknowledged messages for a recipient in a messageBox.
+func (d *DB) CountMessages(recipient string, messageBoxID int64) (int, error) {
+	var count int
+	err := d.queryRow(
+		`SELECT COUNT(*) FROM messages WHERE recipient = ? AND messageBoxId = ?`,
+		recipient, messageBoxID,
+	).Scan(&count)
+	return count, err
+}
+
 // AcknowledgeMessages deletes messages by IDs for a recipient. Returns count deleted.
 func (d *DB) AcknowledgeMessages(recipient string, messageIDs []string) (int64, error) {
 	if len(messageIDs) == 0 {
@@ -145,9 +274,14 @@ func (d *DB) AcknowledgeMessages(recipient string, messageIDs []string) (int64,
 }
 
 // GetServerDeliveryFee returns the server delivery fee for a message box type.
+// Boxes without their own fee use the default fee, or 0 when none is set.
 func (d *DB) GetServerDeliveryFee(messageBox string) (int, error) {
 	var fee int
-	err := d.queryRow(`SELECT delivery_fee FROM server_fees WHERE message_box = ?`, messageBox).Scan(&fee)
+	err := d.queryRow(
+		`SELECT delivery_fee FROM server_fees WHERE message_box = ? OR message_box = ?
+		 ORDER BY CASE WHEN message_box = ? THEN 0 ELSE 1 END LIMIT 1`,
+		messageBox, DefaultServerFeeBox, messageBox,
+	).Scan(&fee)
 	if err == sql.ErrNoRows {
 		return 0, nil
 	}
@@ -157,65 +291,88 @@ func (d *DB) GetServerDeliveryFee(messageBox string) (int, error) {
 // GetRecipientFee returns the recipient fee with hierarchical fallback.
 // Returns: fee value (-1=blocked, 0=allow, >0=sats required)
 func (d *DB) GetRecipientFee(recipient, sender, messageBox string) (int, error) {
-	// Try sender-specific first
-	if sender != "" {
-		var fee int
-		err := d.queryRow(
-			`SELECT recipient_fee FROM message_permissions WHERE recipient = ? AND sender = ? AND message_box = ?`,
-			recipient, sender, messageBox,
-		).Scan(&fee)
-		if err == nil {
-			return fee, nil
-		}
-		if err != sql.ErrNoRows {
-			return 0, err
-		}
+	res, err := d.ResolveRecipientFee(recipient, sender, messageBox, time.Now())
+	if err != nil {
+		return 0, err
 	}
+	return res.Fee, nil
+}
 
-	// Try box-wide default
-	var fee int
-	err := d.queryRow(
-		`SELECT recipient_fee FROM message_permissions WHERE recipient = ? AND sender IS NULL AND message_box = ?`,
-		recipient, messageBox,
-	).Scan(&fee)
-	if err == nil {
-		return fee, nil
+// ResolveRecipientFee returns the recipient's base fee and pricing, and the permission rule it came from.
+// Rules are tried in precedence order (see MatchingPermissions); a sender-specific rule that has expired
+// or used up its messages is skipped in favour of the next one. A running subscription of the sender makes
+// the box free unless the sender is blocked.
+// Use FeeResolution.FeeFor to price a message of a given size.
+func (d *DB) ResolveRecipientFee(recipient, sender, messageBox string, now time.Time) (*FeeResolution, error) {
+	res, err := d.resolveRuleFee(recipient, sender, messageBox, now)
+	if err != nil || res.Fee < 0 {
+		return res, err
 	}
-	if err != sql.ErrNoRows {
-		return 0, err
+	sub, err := d.runningSubscription(recipient, sender, messageBox, now)
+	if err != nil || sub == nil {
+		return res, err
 	}
+	return &FeeResolution{Subscription: sub}, nil
+}
 
-	// Auto-create box-wide default
-	defaultFee := smartDefaultFee(messageBox)
-	now := time.Now()
-	_, err = d.exec(
-		`INSERT INTO message_permissions (recipient, sender, message_box, recipient_fee, created_at, updated_at) VALUES (?, NULL, ?, ?, ?, ?)
-		 ON CONFLICT DO NOTHING`,
-		recipient, messageBox, defaultFee, now, now,
-	)
+func (d *DB) resolveRuleFee(recipient, sender, messageBox string, now time.Time) (*FeeResolution, error) {
+	rules, err := d.MatchingPermissions(recipient, sender, messageBox)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	return defaultFee, nil
-}
-
-func smartDefaultFee(messageBox string) int {
-	if messageBox == "notifications" {
-		return 10
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.ActiveAt(now) {
+			continue
+		}
+		res := &FeeResolution{Fee: rule.RecipientFee, Pricing: rule.SizePricing, Rule: rule}
+		if rule.Sender.Valid {
+			res.Permission = rule
+		}
+		return res, nil
 	}
-	return 0
+
+	// No rule matched, the operator default applies without storing anything
+	fee, policy := d.DefaultRecipientFee(messageBox)
+	return &FeeResolution{Fee: fee, Default: policy}, nil
 }
 
-// SetMessagePermission upserts a permission record.
+// SetMessagePermission upserts a flat-fee permission record without expiry or message cap.
 func (d *DB) SetMessagePermission(recipient string, sender *string, messageBox string, recipientFee int) error {
-	now := time.Now()
+	return d.SetMessagePermissionRule(recipient, sender, messageBox, recipientFee, PermissionLimits{}, SizePricing{})
+}
+
+// SetMessagePermissionRule upserts a permission record with optional limits and size-based pricing.
+// Setting a permission starts a new grant, so the used message count is reset.
+func (d *DB) SetMessagePermissionRule(recipient string, sender *string, messageBox string, recipientFee int, limits PermissionLimits, pricing SizePricing) error {
+	return d.SetPermissionRule(recipient, PermissionRule{
+		Sender:       sender,
+		MessageBox:   messageBox,
+		RecipientFee: recipientFee,
+		Limits:       limits,
+		Pricing:      pricing,
+	})
+}
+
+// SetPermissionRule upserts a single permission rule. Like SetMessagePermissionRule, it resets the used message count.
+func (d *DB) SetPermissionRule(recipient string, rule PermissionRule) error {
+	return upsertPermission(d, recipient, rule, time.Now())
+}
+
+// upsertPermission writes one permission rule through ex, which is either the DB or a transaction.
+func upsertPermission(ex execer, recipient string, rule PermissionRule, now time.Time) error {
+	expiresAt, maxMessages := rule.Limits.nullable()
+	perKB, threshold, surcharge := rule.Pricing.FeePerKB, rule.Pricing.LargePayloadThreshold, rule.Pricing.LargePayloadFee
+	isPattern := IsBoxPattern(rule.MessageBox)
 
 	// NULL != NULL in unique constraints for both SQLite and PostgreSQL, so we need special handling
-	if sender == nil {
+	if rule.Sender == nil {
 		// Try update first
-		res, err := d.exec(
-			`UPDATE message_permissions SET recipient_fee = ?, updated_at = ? WHERE recipient = ? AND sender IS NULL AND message_box = ?`,
-			recipientFee, now, recipient, messageBox,
+		res, err := ex.exec(
+			`UPDATE message_permissions SET recipient_fee = ?, expires_at = ?, max_messages = ?, messages_used = 0,
+			 fee_per_kb = ?, large_payload_threshold = ?, large_payload_fee = ?, pow_difficulty = ?, updated_at = ?
+			 WHERE recipient = ? AND sender IS NULL AND message_box = ?`,
+			rule.RecipientFee, expiresAt, maxMessages, perKB, threshold, surcharge, rule.PowDifficulty, now, recipient, rule.MessageBox,
 		)
 		if err != nil {
 			return err
@@ -225,19 +382,24 @@ func (d *DB) SetMessagePermission(recipient string, sender *string, messageBox s
 			return nil
 		}
 		// Insert
-		_, err = d.exec(
-			`INSERT INTO message_permissions (recipient, sender, message_box, recipient_fee, created_at, updated_at) VALUES (?, NULL, ?, ?, ?, ?)`,
-			recipient, messageBox, recipientFee, now, now,
+		_, err = ex.exec(
+			`INSERT INTO message_permissions (recipient, sender, message_box, recipient_fee, expires_at, max_messages,
+			 fee_per_kb, large_payload_threshold, large_payload_fee, pow_difficulty, is_pattern, created_at, updated_at)
+			 VALUES (?, NULL, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			recipient, rule.MessageBox, rule.RecipientFee, expiresAt, maxMessages, perKB, threshold, surcharge, rule.PowDifficulty, isPattern, now, now,
 		)
 		return err
 	}
 
 	// For non-null sender, ON CONFLICT works fine
-	_, err := d.exec(
-		`INSERT INTO message_permissions (recipient, sender, message_box, recipient_fee, created_at, updated_at)
-		 VALUES (?, ?, ?, ?, ?, ?)
-		 ON CONFLICT(recipient, sender, message_box) DO UPDATE SET recipient_fee = ?, updated_at = ?`,
-		recipient, *sender, messageBox, recipientFee, now, now, recipientFee, now,
+	_, err := ex.exec(
+		`INSERT INTO message_permissions (recipient, sender, message_box, recipient_fee, expires_at, max_messages,
+		 fee_per_kb, large_payload_threshold, large_payload_fee, pow_difficulty, is_pattern, created_at, updated_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(recipient, sender, message_box) DO UPDATE SET recipient_fee = ?, expires_at = ?, max_messages = ?, messages_used = 0,
+		 fee_per_kb = ?, large_payload_threshold = ?, large_payload_fee = ?, pow_difficulty = ?, updated_at = ?`,
+		recipient, *rule.Sender, rule.MessageBox, rule.RecipientFee, expiresAt, maxMessages, perKB, threshold, surcharge, rule.PowDifficulty, isPattern, now, now,
+		rule.RecipientFee, expiresAt, maxMessages, perKB, threshold, surcharge, rule.PowDifficulty, now,
 	)
 	return err
 }
@@ -248,14 +410,14 @@ func (d *DB) GetPermission(recipient string, sender *string, messageBox string)
 	var err error
 	if sender != nil {
 		err = d.queryRow(
-			`SELECT id, recipient, sender, message_box, recipient_fee, created_at, updated_at FROM message_permissions WHERE recipient = ? AND sender = ? AND message_box = ?`,
+			`SELECT id, recipient, sender, message_box, recipient_fee, expires_at, max_messages, messages_used, fee_per_kb, large_payload_threshold, large_payload_fee, pow_difficulty, is_pattern, created_at, updated_at FROM message_permissions WHERE recipient = ? AND sender = ? AND message_box = ?`,
 			recipient, *sender, messageBox,
-		).Scan(&p.ID, &p.Recipient, &p.Sender, &p.MessageBox, &p.RecipientFee, &p.CreatedAt, &p.UpdatedAt)
+		).Scan(&p.ID, &p.Recipient, &p.Sender, &p.MessageBox, &p.RecipientFee, &p.ExpiresAt, &p.MaxMessages, &p.MessagesUsed, &p.FeePerKB, &p.LargePayloadThreshold, &p.LargePayloadFee, &p.PowDifficulty, &p.IsPattern, &p.CreatedAt, &p.UpdatedAt)
 	} else {
 		err = d.queryRow(
-			`SELECT id, recipient, sender, message_box, recipient_fee, created_at, updated_at FROM message_permissions WHERE recipient = ? AND sender IS NULL AND message_box = ?`,
+			`SELECT id, recipient, sender, message_box, recipient_fee, expires_at, max_messages, messages_used, fee_per_kb, large_payload_threshold, large_payload_fee, pow_difficulty, is_pattern, created_at, updated_at FROM message_permissions WHERE recipient = ? AND sender IS NULL AND message_box = ?`,
 			recipient, messageBox,
-		).Scan(&p.ID, &p.Recipient, &p.Sender, &p.MessageBox, &p.RecipientFee, &p.CreatedAt, &p.UpdatedAt)
+		).Scan(&p.ID, &p.Recipient, &p.Sender, &p.MessageBox, &p.RecipientFee, &p.ExpiresAt, &p.MaxMessages, &p.MessagesUsed, &p.FeePerKB, &p.LargePayloadThreshold, &p.LargePayloadFee, &p.PowDifficulty, &p.IsPattern, &p.CreatedAt, &p.UpdatedAt)
 	}
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -267,60 +429,92 @@ func (d *DB) GetPermission(recipient string, sender *string, messageBox string)
 }
 
 // ListPermissions returns permissions for a recipient with optional filtering and pagination.
+// Filtering by message box returns every rule that applies to it, pattern rules included, ordered by
+// sender and then precedence, with Effective set on the rule that wins for each sender.
 func (d *DB) ListPermissions(recipient string, messageBox *string, limit, offset int, sortOrder string) ([]PermissionRecord, int, error) {
-	// Count query
-	countQuery := `SELECT COUNT(*) FROM message_permissions WHERE recipient = ?`
-	countArgs := []any{recipient}
 	if messageBox != nil {
-		countQuery += ` AND message_box = ?`
-		countArgs = append(countArgs, *messageBox)
+		return d.listMatchingPermissions(recipient, *messageBox, limit, offset)
 	}
 
 	var total int
-	if err := d.queryRow(countQuery, countArgs...).Scan(&total); err != nil {
+	if err := d.queryRow(`SELECT COUNT(*) FROM message_permissions WHERE recipient = ?`, recipient).Scan(&total); err != nil {
 		return nil, 0, err
 	}
 
-	// Data query
-	query := `SELECT id, recipient, sender, message_box, recipient_fee, created_at, updated_at FROM message_permissions WHERE recipient = ?`
-	args := []any{recipient}
-	if messageBox != nil {
-		query += ` AND message_box = ?`
-		args = append(args, *messageBox)
+	query := `SELECT id, recipient, sender, message_box, recipient_fee, expires_at, max_messages, messages_used,
+		fee_per_kb, large_payload_threshold, large_payload_fee, pow_difficulty, is_pattern, created_at, updated_at
+		FROM message_permissions WHERE recipient = ?
+		ORDER BY message_box ASC, CASE WHEN sender IS NULL THEN 0 ELSE 1 END, sender ASC, created_at ` + sortOrder + `
+		LIMIT ? OFFSET ?`
+	perms, err := d.scanPermissions(query, recipient, limit, offset)
+	if err != nil {
+		return nil, 0, err
 	}
-	query += ` ORDER BY message_box ASC, CASE WHEN sender IS NULL THEN 0 ELSE 1 END, sender ASC, created_at ` + sortOrder
-	query += ` LIMIT ? OFFSET ?`
-	args = append(args, limit, offset)
+	return perms, total, nil
+}
 
-	rows, err := d.query(query, args...)
+func (d *DB) listMatchingPermissions(recipient, messageBox string, limit, offset int) ([]PermissionRecord, int, error) {
+	rules, err := d.scanPermissions(
+		`SELECT id, recipient, sender, message_box, recipient_fee, expires_at, max_messages, messages_used,
+		 fee_per_kb, large_payload_threshold, large_payload_fee, pow_difficulty, is_pattern, created_at, updated_at
+		 FROM message_permissions WHERE recipient = ? AND (message_box = ? OR is_pattern = TRUE)`,
+		recipient, messageBox,
+	)
 	if err != nil {
 		return nil, 0, err
 	}
-	defer rows.Close()
 
-	var perms []PermissionRecord
-	for rows.Next() {
-		var p PermissionRecord
-		if err := rows.Scan(&p.ID, &p.Recipient, &p.Sender, &p.MessageBox, &p.RecipientFee, &p.CreatedAt, &p.UpdatedAt); err != nil {
-			return nil, 0, err
+	var matched []PermissionRecord
+	for _, r := range rules {
+		if r.Matches(messageBox) {
+			matched = append(matched, r)
 		}
-		perms = append(perms, p)
 	}
-	return perms, total, rows.Err()
+	sort.SliceStable(matched, func(i, j int) bool {
+		a, b := &matched[i], &matched[j]
+		if a.Sender.Valid != b.Sender.Valid {
+			return !a.Sender.Valid
+		}
+		if a.Sender.String != b.Sender.String {
+			return a.Sender.String < b.Sender.String
+		}
+		return morePrecedent(a, b)
+	})
+
+	now := time.Now()
+	won := make(map[sql.NullString]bool)
+	for i := range matched {
+		if !won[matched[i].Sender] && matched[i].ActiveAt(now) {
+			matched[i].Effective = true
+			won[matched[i].Sender] = true
+		}
+	}
+
+	total := len(matched)
+	if offset >= total {
+		return nil, total, nil
+	}
+	return matched[offset:min(offset+limit, total)], total, nil
 }
 
 // RegisterDevice inserts or updates a device registration.
+// Returns ErrDeviceTokenOwned if the token is already registered to a different identity;
+// ownership is never moved silently, see TransferDeviceOwnership.
 func (d *DB) RegisterDevice(identityKey, fcmToken string, deviceID, platform *string) (int64, error) {
 	now := time.Now()
 	var id int64
 	err := d.queryRow(
 		`INSERT INTO device_registrations (identity_key, fcm_token, device_id, platform, created_at, updated_at, active, last_used)
 		 VALUES (?, ?, ?, ?, ?, ?, TRUE, ?)
-		 ON CONFLICT(fcm_token) DO UPDATE SET identity_key = ?, device_id = ?, platform = ?, updated_at = ?, active = TRUE, last_used = ?
+		 ON CONFLICT(fcm_token) DO UPDATE SET device_id = ?, platform = ?, updated_at = ?, active = TRUE, last_used = ?
+		 WHERE device_registrations.identity_key = ?
 		 RETURNING id`,
 		identityKey, fcmToken, deviceID, platform, now, now, now,
-		identityKey, deviceID, platform, now, now,
+		deviceID, platform, now, now, identityKey,
 	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, ErrDeviceTokenOwned
+	}
 	return id, err
 }
 
@@ -371,30 +565,93 @@ func (d *DB) ListActiveDevices(identityKey string) ([]DeviceRecord, error) {
 	return devices, rows.Err()
 }
 
-// UpdateDeviceLastUsed updates the last_used timestamp for a device.
-func (d *DB) UpdateDeviceLastUsed(fcmToken string) error {
-	_, err := d.exec(
-		`UPDATE device_registrations SET last_used = ?, updated_at = ? WHERE fcm_token = ?`,
-		time.Now(),
-		time.Now(),
-		fcmToken,
+// GetDevice returns a device registration owned by identityKey, or nil if it doesn't exist.
+func (d *DB) GetDevice(identityKey string, id int) (*DeviceRecord, error) {
+	var dev DeviceRecord
+	err := d.queryRow(
+		`SELECT id, identity_key, fcm_token, device_id, platform, active, created_at, updated_at, last_used
+		 FROM device_registrations WHERE id = ? AND identity_key = ?`,
+		id, identityKey,
+	).Scan(&dev.ID, &dev.IdentityKey, &dev.FCMToken, &dev.DeviceID, &dev.Platform, &dev.Active, &dev.CreatedAt, &dev.UpdatedAt, &dev.LastUsed)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &dev, nil
+}
+
+// UpdateDeviceLabels updates the deviceId and/or platform labels of a device owned by identityKey.
+// Nil values leave the existing label unchanged. Returns false if no such device exists.
+func (d *DB) UpdateDeviceLabels(identityKey string, id int, deviceID, platform *string) (bool, error) {
+	res, err := d.exec(
+		`UPDATE device_registrations SET device_id = COALESCE(?, device_id), platform = COALESCE(?, platform), updated_at = ?
+		 WHERE id = ? AND identity_key = ?`,
+		deviceID, platform, time.Now(), id, identityKey,
 	)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
 
-	return err
+// DeleteDevice removes a device registration owned by identityKey. Returns false if no such device exists.
+func (d *DB) DeleteDevice(identityKey string, id int) (bool, error) {
+	res, err := d.exec(`DELETE FROM device_registrations WHERE id = ? AND identity_key = ?`, id, identityKey)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
 }
 
-// DeactivateDevice marks a device as inactive (invalid token).
-func (d *DB) DeactivateDevice(fcmToken string) error {
+// DeactivateStaleDevices marks active devices as inactive when they have not been used since cutoff.
+// Devices that were never used fall back to their last update time. Returns the number deactivated.
+func (d *DB) DeactivateStaleDevices(cutoff time.Time) (int64, error) {
+	res, err := d.exec(
+		`UPDATE device_registrations SET active = FALSE, updated_at = ?
+		 WHERE active = TRUE AND COALESCE(last_used, updated_at) < ?`,
+		time.Now(), cutoff,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// UpdateDevicesLastUsed updates the last_used timestamp for a batch of devices in a single statement.
+func (d *DB) UpdateDevicesLastUsed(fcmTokens []string) error {
+	if len(fcmTokens) == 0 {
+		return nil
+	}
+	now := time.Now()
+	args := []any{now, now}
+	for _, t := range fcmTokens {
+		args = append(args, t)
+	}
 	_, err := d.exec(
-		`UPDATE device_registrations SET active = FALSE, updated_at = ? WHERE fcm_token = ?`,
-		time.Now(),
-		fcmToken,
+		`UPDATE device_registrations SET last_used = ?, updated_at = ? WHERE fcm_token IN (`+placeholders(len(fcmTokens))+`)`,
+		args...,
 	)
 
 	return err
 }
 
-// ShouldUseFCMDelivery checks if FCM delivery should be used for this message box.
-func ShouldUseFCMDelivery(messageBox string) bool {
-	return messageBox == "notifications"
+// DeactivateDevices marks a batch of devices as inactive (invalid tokens) in a single statement.
+func (d *DB) DeactivateDevices(fcmTokens []string) error {
+	if len(fcmTokens) == 0 {
+		return nil
+	}
+	args := []any{time.Now()}
+	for _, t := range fcmTokens {
+		args = append(args, t)
+	}
+	_, err := d.exec(
+		`UPDATE device_registrations SET active = FALSE, updated_at = ? WHERE fcm_token IN (`+placeholders(len(fcmTokens))+`)`,
+		args...,
+	)
+
+	return err
 }
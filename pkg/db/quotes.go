@@ -0,0 +1,27 @@
+package db
+
+import "time"
+
+// RedeemQuote marks a signed quote as used so it cannot lock a price twice.
+// Returns false if the quote was already redeemed. Rows are kept until the quote expires.
+func (d *DB) RedeemQuote(quoteID, sender string, expiresAt time.Time) (bool, error) {
+	res, err := d.exec(
+		`INSERT INTO redeemed_quotes (quote_id, sender, expires_at, redeemed_at) VALUES (?, ?, ?, ?)
+		 ON CONFLICT (quote_id) DO NOTHING`,
+		quoteID, sender, expiresAt, time.Now(),
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// DeleteExpiredQuotes removes redeemed quotes that expired before now; they can no longer be presented.
+func (d *DB) DeleteExpiredQuotes(now time.Time) (int64, error) {
+	res, err := d.exec(`DELETE FROM redeemed_quotes WHERE expires_at < ?`, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
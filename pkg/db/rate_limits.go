@@ -0,0 +1,233 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrRateLimited is returned when a message would exceed a rate limit; it is not counted.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// rateScopeAll is the counter scope of a box-wide rate limit, which counts messages from every sender.
+const rateScopeAll = "*"
+
+// RateLimitRecord represents a row in message_rate_limits.
+// A NULL sender limits all senders together into the box.
+type RateLimitRecord struct {
+	ID            int
+	Recipient     string
+	Sender        sql.NullString
+	MessageBox    string
+	MaxMessages   int
+	WindowSeconds int
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// RateLimitStatus is the state of the most restrictive rate limit applying to a sender.
+type RateLimitStatus struct {
+	Limit          int
+	WindowSeconds  int
+	Remaining      int
+	ResetAt        time.Time
+	SenderSpecific bool
+}
+
+// Limited reports whether no more messages are allowed in the current window.
+func (s *RateLimitStatus) Limited() bool {
+	return s.Remaining <= 0
+}
+
+// RetryAfter returns how long until the current window resets, rounded up to whole seconds.
+func (s *RateLimitStatus) RetryAfter(now time.Time) int {
+	secs := int(s.ResetAt.Sub(now).Seconds() + 0.999)
+	return max(secs, 1)
+}
+
+// scope returns the counter scope a limit is counted under.
+func (r *RateLimitRecord) scope() string {
+	if r.Sender.Valid {
+		return r.Sender.String
+	}
+	return rateScopeAll
+}
+
+// window returns the fixed window containing now as (start unix seconds, end time).
+func (r *RateLimitRecord) window(now time.Time) (int64, time.Time) {
+	size := int64(r.WindowSeconds)
+	start := now.Unix() / size * size
+	return start, time.Unix(start+size, 0)
+}
+
+// SetRateLimit upserts a rate limit of maxMessages per windowSeconds.
+func (d *DB) SetRateLimit(recipient string, sender *string, messageBox string, maxMessages, windowSeconds int) error {
+	now := time.Now()
+
+	// NULL != NULL in unique constraints, same handling as SetMessagePermission
+	if sender == nil {
+		res, err := d.exec(
+			`UPDATE message_rate_limits SET max_messages = ?, window_seconds = ?, updated_at = ?
+			 WHERE recipient = ? AND sender IS NULL AND message_box = ?`,
+			maxMessages, windowSeconds, now, recipient, messageBox,
+		)
+		if err != nil {
+			return err
+		}
+		if affected, _ := res.RowsAffected(); affected > 0 {
+			return nil
+		}
+		_, err = d.exec(
+			`INSERT INTO message_rate_limits (recipient, sender, message_box, max_messages, window_seconds, created_at, updated_at)
+			 VALUES (?, NULL, ?, ?, ?, ?, ?)`,
+			recipient, messageBox, maxMessages, windowSeconds, now, now,
+		)
+		return err
+	}
+
+	_, err := d.exec(
+		`INSERT INTO message_rate_limits (recipient, sender, message_box, max_messages, window_seconds, created_at, updated_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(recipient, sender, message_box) DO UPDATE SET max_messages = ?, window_seconds = ?, updated_at = ?`,
+		recipient, *sender, messageBox, maxMessages, windowSeconds, now, now,
+		maxMessages, windowSeconds, now,
+	)
+	return err
+}
+
+// DeleteRateLimit removes a rate limit. Returns false if none existed.
+func (d *DB) DeleteRateLimit(recipient string, sender *string, messageBox string) (bool, error) {
+	var res sql.Result
+	var err error
+	if sender == nil {
+		res, err = d.exec(`DELETE FROM message_rate_limits WHERE recipient = ? AND sender IS NULL AND message_box = ?`, recipient, messageBox)
+	} else {
+		res, err = d.exec(`DELETE FROM message_rate_limits WHERE recipient = ? AND sender = ? AND message_box = ?`, recipient, *sender, messageBox)
+	}
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ListRateLimits returns a recipient's rate limits, optionally filtered by message box.
+func (d *DB) ListRateLimits(recipient string, messageBox *string) ([]RateLimitRecord, error) {
+	query := `SELECT id, recipient, sender, message_box, max_messages, window_seconds, created_at, updated_at
+		FROM message_rate_limits WHERE recipient = ?`
+	args := []any{recipient}
+	if messageBox != nil {
+		query += ` AND message_box = ?`
+		args = append(args, *messageBox)
+	}
+	query += ` ORDER BY message_box ASC, CASE WHEN sender IS NULL THEN 0 ELSE 1 END, sender ASC`
+	return scanRateLimits(d, query, args...)
+}
+
+// applicableRateLimits returns the sender-specific and box-wide limits that apply to sender.
+func applicableRateLimits(q querier, recipient, sender, messageBox string) ([]RateLimitRecord, error) {
+	return scanRateLimits(q,
+		`SELECT id, recipient, sender, message_box, max_messages, window_seconds, created_at, updated_at
+		 FROM message_rate_limits WHERE recipient = ? AND message_box = ? AND (sender IS NULL OR sender = ?)`,
+		recipient, messageBox, sender,
+	)
+}
+
+func scanRateLimits(q querier, query string, args ...any) ([]RateLimitRecord, error) {
+	rows, err := q.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []RateLimitRecord
+	for rows.Next() {
+		var r RateLimitRecord
+		if err := rows.Scan(&r.ID, &r.Recipient, &r.Sender, &r.MessageBox, &r.MaxMessages, &r.WindowSeconds, &r.CreatedAt, &r.UpdatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// CheckRateLimit returns the most restrictive rate limit state for a sender, or nil if no limit applies.
+// When several limits are exhausted, the one that resets last is returned.
+func (d *DB) CheckRateLimit(recipient, sender, messageBox string, now time.Time) (*RateLimitStatus, error) {
+	limits, err := applicableRateLimits(d, recipient, sender, messageBox)
+	if err != nil {
+		return nil, err
+	}
+
+	var worst *RateLimitStatus
+	for _, l := range limits {
+		start, end := l.window(now)
+		var count int
+		err := d.queryRow(
+			`SELECT message_count FROM message_rate_counters
+			 WHERE recipient = ? AND scope = ? AND message_box = ? AND window_seconds = ? AND window_start = ?`,
+			recipient, l.scope(), messageBox, l.WindowSeconds, start,
+		).Scan(&count)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+
+		st := &RateLimitStatus{
+			Limit:          l.MaxMessages,
+			WindowSeconds:  l.WindowSeconds,
+			Remaining:      max(l.MaxMessages-count, 0),
+			ResetAt:        end,
+			SenderSpecific: l.Sender.Valid,
+		}
+		switch {
+		case worst == nil:
+			worst = st
+		case st.Limited() && (!worst.Limited() || st.ResetAt.After(worst.ResetAt)):
+			worst = st
+		case !st.Limited() && !worst.Limited() && st.Remaining < worst.Remaining:
+			worst = st
+		}
+	}
+	return worst, nil
+}
+
+// countRateLimitedMessage counts one delivered message against every rate limit that applies to sender.
+// It increments the window counter of each limit only while it is below the limit, so concurrent sends cannot
+// both take the last message of a window, and returns ErrRateLimited if any of them has no message left.
+func countRateLimitedMessage(t *tx, recipient, sender, messageBox string, now time.Time) error {
+	limits, err := applicableRateLimits(t, recipient, sender, messageBox)
+	if err != nil {
+		return err
+	}
+	for _, l := range limits {
+		if l.MaxMessages <= 0 {
+			return ErrRateLimited
+		}
+		start, end := l.window(now)
+		res, err := t.exec(
+			`INSERT INTO message_rate_counters (recipient, scope, message_box, window_seconds, window_start, window_end, message_count)
+			 VALUES (?, ?, ?, ?, ?, ?, 1)
+			 ON CONFLICT(recipient, scope, message_box, window_seconds, window_start) DO UPDATE SET message_count = message_rate_counters.message_count + 1
+			 WHERE message_rate_counters.message_count < ?`,
+			recipient, l.scope(), messageBox, l.WindowSeconds, start, end, l.MaxMessages,
+		)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrRateLimited
+		}
+	}
+	return nil
+}
+
+// DeleteExpiredRateCounters removes counters of windows that ended before now.
+func (d *DB) DeleteExpiredRateCounters(now time.Time) (int64, error) {
+	res, err := d.exec(`DELETE FROM message_rate_counters WHERE window_end < ?`, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
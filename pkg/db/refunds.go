@@ -0,0 +1,118 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrAlreadyRefunded is returned when a sent refund is recorded for a payment that was already refunded.
+var ErrAlreadyRefunded = errors.New("payment already refunded")
+
+// Refund reasons recorded in refunds.
+const (
+	RefundReasonDeliveryFailed = "delivery_failed"       // the server took its fee but could not store the message
+	RefundReasonRejected       = "rejected_by_recipient" // the recipient returned their fee
+)
+
+// Refund statuses recorded in refunds.
+const (
+	RefundStatusSent   = "sent"
+	RefundStatusFailed = "failed" // the refund transaction could not be created
+)
+
+// PaymentStatusRefunded marks a payment that was paid back to the sender.
+const PaymentStatusRefunded = "refunded"
+
+// RefundRecord represents a row in refunds: a payment returned from payer to payee (the original sender).
+// Payment holds the JSON of the refund as it is handed to the payee for internalization.
+type RefundRecord struct {
+	ID         int64
+	PaymentID  int64
+	Payer      string
+	Payee      string
+	MessageBox string
+	MessageID  sql.NullString
+	Reason     string
+	Amount     int64
+	TxID       sql.NullString
+	Payment    sql.NullString
+	Status     string
+	Error      sql.NullString
+	CreatedAt  time.Time
+}
+
+// InsertRefund records a refund and, when it was sent, marks the refunded payment.
+// Returns ErrAlreadyRefunded, recording nothing, if a sent refund already exists for the payment.
+func (d *DB) InsertRefund(r RefundRecord) (int64, error) {
+	now := time.Now()
+	var id int64
+	err := d.withTx(func(t *tx) error {
+		if err := t.queryRow(
+			`INSERT INTO refunds (payment_id, payer, payee, message_box, message_id, reason, amount, txid, payment, status, error, created_at)
+			 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			 RETURNING id`,
+			r.PaymentID, r.Payer, r.Payee, r.MessageBox, r.MessageID, r.Reason, r.Amount, r.TxID, r.Payment, r.Status, r.Error, now,
+		).Scan(&id); err != nil {
+			return err
+		}
+		if r.Status != RefundStatusSent {
+			return nil
+		}
+		res, err := t.exec(
+			`UPDATE payments SET status = ?, updated_at = ? WHERE id = ? AND status <> ?`,
+			PaymentStatusRefunded, now, r.PaymentID, PaymentStatusRefunded,
+		)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrAlreadyRefunded
+		}
+		return nil
+	})
+	return id, err
+}
+
+// ListRefunds returns the refunds paid to payee, newest first.
+func (d *DB) ListRefunds(payee string, limit int) ([]RefundRecord, error) {
+	rows, err := d.query(
+		`SELECT id, payment_id, payer, payee, message_box, message_id, reason, amount, txid, payment, status, error, created_at
+		 FROM refunds WHERE payee = ? ORDER BY created_at DESC, id DESC LIMIT ?`,
+		payee, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []RefundRecord
+	for rows.Next() {
+		var r RefundRecord
+		if err := rows.Scan(&r.ID, &r.PaymentID, &r.Payer, &r.Payee, &r.MessageBox, &r.MessageID, &r.Reason, &r.Amount, &r.TxID, &r.Payment, &r.Status, &r.Error, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// GetRelayedPayment returns the recipient fee relayed to recipient with a message, or nil if there is none
+// or it was already refunded.
+func (d *DB) GetRelayedPayment(recipient, messageID string) (*PaymentRecord, error) {
+	var p PaymentRecord
+	err := d.queryRow(
+		`SELECT id, txid, kind, sender, recipient, message_box, message_id, fee, amount, status, error, created_at, updated_at
+		 FROM payments WHERE kind = ? AND recipient = ? AND message_id = ? AND status = ?`,
+		PaymentKindRecipientFee, recipient, messageID, PaymentStatusRelayed,
+	).Scan(&p.ID, &p.TxID, &p.Kind, &p.Sender, &p.Recipient, &p.MessageBox, &p.MessageID, &p.Fee, &p.Amount, &p.Status, &p.Error, &p.CreatedAt, &p.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
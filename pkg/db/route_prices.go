@@ -0,0 +1,134 @@
+package db
+
+import "time"
+
+// DefaultRoutePriceBox is the route_prices entry used for boxes without their own price on a route.
+const DefaultRoutePriceBox = "*"
+
+// RoutePriceRecord represents a row in route_prices: the satoshis charged per request to a route,
+// after FreeRequests free requests per caller in each window of WindowSeconds.
+type RoutePriceRecord struct {
+	ID            int
+	Route         string
+	MessageBox    string
+	Price         int
+	FreeRequests  int
+	WindowSeconds int
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// window returns the fixed window containing now as (start unix seconds, end time).
+func (r *RoutePriceRecord) window(now time.Time) (int64, time.Time) {
+	size := int64(r.WindowSeconds)
+	start := now.Unix() / size * size
+	return start, time.Unix(start+size, 0)
+}
+
+// ListRoutePrices returns all route prices, ordered by route and box.
+func (d *DB) ListRoutePrices() ([]RoutePriceRecord, error) {
+	return d.scanRoutePrices(
+		`SELECT id, route, message_box, price, free_requests, window_seconds, created_at, updated_at
+		 FROM route_prices ORDER BY route ASC, message_box ASC`,
+	)
+}
+
+// HasRoutePrices reports whether any price is set for route, so callers can skip resolving the message box.
+func (d *DB) HasRoutePrices(route string) (bool, error) {
+	var n int
+	if err := d.queryRow(`SELECT COUNT(*) FROM route_prices WHERE route = ?`, route).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// GetRoutePrice returns the price of route for messageBox, falling back to the route's default entry.
+// Returns nil if neither is set.
+func (d *DB) GetRoutePrice(route, messageBox string) (*RoutePriceRecord, error) {
+	prices, err := d.scanRoutePrices(
+		`SELECT id, route, message_box, price, free_requests, window_seconds, created_at, updated_at
+		 FROM route_prices WHERE route = ? AND message_box IN (?, ?)`,
+		route, messageBox, DefaultRoutePriceBox,
+	)
+	if err != nil {
+		return nil, err
+	}
+	var found *RoutePriceRecord
+	for i := range prices {
+		if found == nil || prices[i].MessageBox != DefaultRoutePriceBox {
+			found = &prices[i]
+		}
+	}
+	return found, nil
+}
+
+// SetRoutePrice upserts the price of route for messageBox.
+func (d *DB) SetRoutePrice(route, messageBox string, price, freeRequests, windowSeconds int) error {
+	now := time.Now()
+	_, err := d.exec(
+		`INSERT INTO route_prices (route, message_box, price, free_requests, window_seconds, created_at, updated_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(route, message_box) DO UPDATE SET price = ?, free_requests = ?, window_seconds = ?, updated_at = ?`,
+		route, messageBox, price, freeRequests, windowSeconds, now, now,
+		price, freeRequests, windowSeconds, now,
+	)
+	return err
+}
+
+// DeleteRoutePrice removes the price of route for messageBox. Returns false if none existed.
+func (d *DB) DeleteRoutePrice(route, messageBox string) (bool, error) {
+	res, err := d.exec(`DELETE FROM route_prices WHERE route = ? AND message_box = ?`, route, messageBox)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// UseFreeRouteRequest counts one request by identityKey against the free tier of p.
+// Returns false, counting nothing, if the free requests of the current window are used up.
+func (d *DB) UseFreeRouteRequest(p *RoutePriceRecord, identityKey string, now time.Time) (bool, error) {
+	if p.FreeRequests <= 0 {
+		return false, nil
+	}
+	start, end := p.window(now)
+	res, err := d.exec(
+		`INSERT INTO route_request_counters (identity_key, route, message_box, window_start, window_end, request_count)
+		 VALUES (?, ?, ?, ?, ?, 1)
+		 ON CONFLICT(identity_key, route, message_box, window_start) DO UPDATE SET request_count = route_request_counters.request_count + 1
+		 WHERE route_request_counters.request_count < ?`,
+		identityKey, p.Route, p.MessageBox, start, end, p.FreeRequests,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// DeleteExpiredRouteCounters removes free tier counters of windows that ended before now.
+func (d *DB) DeleteExpiredRouteCounters(now time.Time) (int64, error) {
+	res, err := d.exec(`DELETE FROM route_request_counters WHERE window_end < ?`, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (d *DB) scanRoutePrices(query string, args ...any) ([]RoutePriceRecord, error) {
+	rows, err := d.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []RoutePriceRecord
+	for rows.Next() {
+		var r RoutePriceRecord
+		if err := rows.Scan(&r.ID, &r.Route, &r.MessageBox, &r.Price, &r.FreeRequests, &r.WindowSeconds, &r.CreatedAt, &r.UpdatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,150 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// DefaultServerFeeBox is the server_fees entry used for boxes without their own delivery fee.
+const DefaultServerFeeBox = "*"
+
+// ServerFeeRecord represents a row in server_fees.
+type ServerFeeRecord struct {
+	ID          int
+	MessageBox  string
+	DeliveryFee int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// ServerFeeChangeRecord represents a row in server_fee_changes.
+// PreviousFee is NULL when the fee was created, NewFee is NULL when it was removed.
+type ServerFeeChangeRecord struct {
+	ID          int
+	MessageBox  string
+	PreviousFee sql.NullInt64
+	NewFee      sql.NullInt64
+	ChangedBy   string
+	CreatedAt   time.Time
+}
+
+// ListServerFees returns all delivery fees, including the default fee if set.
+func (d *DB) ListServerFees() ([]ServerFeeRecord, error) {
+	rows, err := d.query(
+		`SELECT id, message_box, delivery_fee, created_at, updated_at FROM server_fees ORDER BY message_box`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []ServerFeeRecord
+	for rows.Next() {
+		var rec ServerFeeRecord
+		if err := rows.Scan(&rec.ID, &rec.MessageBox, &rec.DeliveryFee, &rec.CreatedAt, &rec.UpdatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+// SetServerDeliveryFee upserts the delivery fee of a box and records the change.
+// Returns the previous fee, nil if the box had none.
+func (d *DB) SetServerDeliveryFee(messageBox string, fee int, changedBy string) (*int, error) {
+	var previous *int
+	err := d.withTx(func(t *tx) error {
+		var err error
+		if previous, err = currentServerFee(t, messageBox); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if previous != nil {
+			_, err = t.exec(`UPDATE server_fees SET delivery_fee = ?, updated_at = ? WHERE message_box = ?`, fee, now, messageBox)
+		} else {
+			_, err = t.exec(
+				`INSERT INTO server_fees (message_box, delivery_fee, created_at, updated_at) VALUES (?, ?, ?, ?)`,
+				messageBox, fee, now, now,
+			)
+		}
+		if err != nil {
+			return err
+		}
+		return recordServerFeeChange(t, messageBox, previous, &fee, changedBy, now)
+	})
+	return previous, err
+}
+
+// DeleteServerDeliveryFee removes the delivery fee of a box and records the change.
+// Returns the removed fee, nil if the box had none.
+func (d *DB) DeleteServerDeliveryFee(messageBox, changedBy string) (*int, error) {
+	var previous *int
+	err := d.withTx(func(t *tx) error {
+		var err error
+		if previous, err = currentServerFee(t, messageBox); err != nil || previous == nil {
+			return err
+		}
+		if _, err := t.exec(`DELETE FROM server_fees WHERE message_box = ?`, messageBox); err != nil {
+			return err
+		}
+		return recordServerFeeChange(t, messageBox, previous, nil, changedBy, time.Now())
+	})
+	return previous, err
+}
+
+// ListServerFeeChanges returns the most recent fee changes, optionally for one box.
+func (d *DB) ListServerFeeChanges(messageBox *string, limit int) ([]ServerFeeChangeRecord, error) {
+	query := `SELECT id, message_box, previous_fee, new_fee, changed_by, created_at FROM server_fee_changes`
+	var args []any
+	if messageBox != nil {
+		query += ` WHERE message_box = ?`
+		args = append(args, *messageBox)
+	}
+	query += ` ORDER BY created_at DESC, id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := d.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []ServerFeeChangeRecord
+	for rows.Next() {
+		var rec ServerFeeChangeRecord
+		if err := rows.Scan(&rec.ID, &rec.MessageBox, &rec.PreviousFee, &rec.NewFee, &rec.ChangedBy, &rec.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+// currentServerFee returns the fee stored for exactly this box, nil if none.
+func currentServerFee(t *tx, messageBox string) (*int, error) {
+	var fee int
+	err := t.queryRow(`SELECT delivery_fee FROM server_fees WHERE message_box = ?`, messageBox).Scan(&fee)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &fee, nil
+}
+
+func recordServerFeeChange(t *tx, messageBox string, previous, fee *int, changedBy string, now time.Time) error {
+	_, err := t.exec(
+		`INSERT INTO server_fee_changes (message_box, previous_fee, new_fee, changed_by, created_at) VALUES (?, ?, ?, ?, ?)`,
+		messageBox, nullableInt(previous), nullableInt(fee), changedBy, now,
+	)
+	return err
+}
+
+func nullableInt(v *int) any {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
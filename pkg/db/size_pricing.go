@@ -0,0 +1,41 @@
+package db
+
+// MaxFee is the largest fee in satoshis a rule can charge: every satoshi there will ever be. Fees are
+// capped at it rather than allowed to overflow into a negative, and so free, fee.
+const MaxFee = 2_100_000_000_000_000
+
+// SizePricing adds size-based charges on top of a permission's flat recipient fee.
+// Zero values mean no extra charge.
+type SizePricing struct {
+	FeePerKB              int // satoshis per started KB (1024 bytes) of message body
+	LargePayloadThreshold int // bodies larger than this many bytes pay LargePayloadFee, 0 disables
+	LargePayloadFee       int
+}
+
+// IsFlat reports whether the price does not depend on the message size.
+func (p SizePricing) IsFlat() bool {
+	return p.FeePerKB == 0 && (p.LargePayloadThreshold == 0 || p.LargePayloadFee == 0)
+}
+
+// FeeFor returns baseFee plus the size-based charges for a body of bodySize bytes, at most MaxFee.
+// A blocked rule (baseFee -1) stays blocked.
+func (p SizePricing) FeeFor(baseFee, bodySize int) int {
+	if baseFee < 0 {
+		return baseFee
+	}
+	fee := min(baseFee, MaxFee)
+	if p.FeePerKB > 0 && bodySize > 0 {
+		kb := (bodySize + 1023) / 1024
+		if kb > (MaxFee-fee)/p.FeePerKB {
+			return MaxFee
+		}
+		fee += kb * p.FeePerKB
+	}
+	if p.LargePayloadThreshold > 0 && bodySize > p.LargePayloadThreshold && p.LargePayloadFee > 0 {
+		if p.LargePayloadFee > MaxFee-fee {
+			return MaxFee
+		}
+		fee += p.LargePayloadFee
+	}
+	return fee
+}
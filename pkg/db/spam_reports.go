@@ -0,0 +1,92 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrAlreadyReported is returned when a recipient reports the same message twice.
+var ErrAlreadyReported = errors.New("message already reported")
+
+// SpamReport represents a row in spam_reports.
+type SpamReport struct {
+	ID         int64
+	Reporter   string
+	Sender     string
+	MessageID  string
+	MessageBox string
+	CreatedAt  time.Time
+}
+
+// ReportSpam records that reporter received the message messageID as spam and deletes the message.
+// Returns ErrAlreadyReported if reporter reported the message before (the report outlives the message),
+// or nil if reporter has no such message.
+func (d *DB) ReportSpam(reporter, messageID string, now time.Time) (*SpamReport, error) {
+	r := SpamReport{Reporter: reporter, MessageID: messageID, CreatedAt: now}
+	err := d.withTx(func(t *tx) error {
+		var reported int
+		err := t.queryRow(
+			`SELECT 1 FROM spam_reports WHERE reporter = ? AND message_id = ?`,
+			reporter, messageID,
+		).Scan(&reported)
+		if err == nil {
+			return ErrAlreadyReported
+		}
+		if err != sql.ErrNoRows {
+			return err
+		}
+
+		if err := t.queryRow(
+			`SELECT m.sender, mb.type FROM messages m JOIN messageBox mb ON mb.messageBoxId = m.messageBoxId
+			 WHERE m.recipient = ? AND m.messageId = ?`,
+			reporter, messageID,
+		).Scan(&r.Sender, &r.MessageBox); err != nil {
+			return err
+		}
+
+		res, err := t.exec(
+			`INSERT INTO spam_reports (reporter, sender, message_id, message_box, created_at) VALUES (?, ?, ?, ?, ?)
+			 ON CONFLICT(reporter, message_id) DO NOTHING`,
+			reporter, r.Sender, messageID, r.MessageBox, now,
+		)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrAlreadyReported
+		}
+
+		_, err = t.exec(`DELETE FROM messages WHERE recipient = ? AND messageId = ?`, reporter, messageID)
+		return err
+	})
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// CountSpamReporters returns how many different recipients reported sender since the given time.
+// Counting reporters rather than reports keeps a single recipient from ruining a sender's reputation.
+func (d *DB) CountSpamReporters(sender string, since time.Time) (int, error) {
+	var n int
+	err := d.queryRow(
+		`SELECT COUNT(DISTINCT reporter) FROM spam_reports WHERE sender = ? AND created_at >= ?`,
+		sender, since,
+	).Scan(&n)
+	return n, err
+}
+
+// ClearSpamReports deletes every report against sender. Returns how many were deleted.
+func (d *DB) ClearSpamReports(sender string) (int64, error) {
+	res, err := d.exec(`DELETE FROM spam_reports WHERE sender = ?`, sender)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
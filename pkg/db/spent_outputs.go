@@ -0,0 +1,111 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// SpentOutput is a payment transaction output used by a send. Recipient is empty for the server's delivery fee.
+type SpentOutput struct {
+	TxID        string
+	OutputIndex uint32
+	Sender      string
+	Recipient   sql.NullString
+	MessageBox  string
+}
+
+var errOutputsReplayed = errors.New("payment outputs already spent")
+
+// ClaimPaymentOutputs records outputs as spent so no later send can pay with them again.
+// If any output was already spent, nothing is claimed and the already spent outputs are returned.
+func (d *DB) ClaimPaymentOutputs(outputs []SpentOutput) ([]SpentOutput, error) {
+	now := time.Now()
+	var replayed []SpentOutput
+	err := d.withTx(func(t *tx) error {
+		for _, o := range outputs {
+			res, err := t.exec(
+				`INSERT INTO spent_payment_outputs (txid, output_index, sender, recipient, message_box, created_at, checked_at)
+				 VALUES (?, ?, ?, ?, ?, ?, ?)
+				 ON CONFLICT (txid, output_index) DO NOTHING`,
+				o.TxID, o.OutputIndex, o.Sender, o.Recipient, o.MessageBox, now, now,
+			)
+			if err != nil {
+				return err
+			}
+			if n, err := res.RowsAffected(); err != nil {
+				return err
+			} else if n == 0 {
+				replayed = append(replayed, o)
+			}
+		}
+		if len(replayed) > 0 {
+			return errOutputsReplayed
+		}
+		return nil
+	})
+	if errors.Is(err, errOutputsReplayed) {
+		return replayed, nil
+	}
+	return nil, err
+}
+
+// ReleasePaymentOutputs forgets claimed outputs whose send was not completed, so the sender can pay with them again.
+func (d *DB) ReleasePaymentOutputs(outputs []SpentOutput) error {
+	return d.withTx(func(t *tx) error {
+		for _, o := range outputs {
+			if _, err := t.exec(`DELETE FROM spent_payment_outputs WHERE txid = ? AND output_index = ?`, o.TxID, o.OutputIndex); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SpentOutputTxIDsToCheck returns up to limit txids of spent outputs, least recently checked first,
+// and marks them as checked now so the next call moves on to others.
+func (d *DB) SpentOutputTxIDsToCheck(now time.Time, limit int) ([]string, error) {
+	rows, err := d.query(
+		`SELECT txid FROM spent_payment_outputs GROUP BY txid ORDER BY MIN(checked_at) ASC, txid ASC LIMIT ?`,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	var txids []string
+	for rows.Next() {
+		var txid string
+		if err := rows.Scan(&txid); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		txids = append(txids, txid)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(txids) == 0 {
+		return txids, err
+	}
+
+	args := []any{now}
+	for _, txid := range txids {
+		args = append(args, txid)
+	}
+	_, err = d.exec(`UPDATE spent_payment_outputs SET checked_at = ? WHERE txid IN (`+placeholders(len(txids))+`)`, args...)
+	return txids, err
+}
+
+// DeleteSpentOutputs removes the spent outputs of txids, once their depth makes a replay impossible.
+func (d *DB) DeleteSpentOutputs(txids []string) (int64, error) {
+	if len(txids) == 0 {
+		return 0, nil
+	}
+	args := make([]any, len(txids))
+	for i, txid := range txids {
+		args[i] = txid
+	}
+	res, err := d.exec(`DELETE FROM spent_payment_outputs WHERE txid IN (`+placeholders(len(txids))+`)`, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,227 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// MaxSubscriptionDays caps how long a single subscription purchase grants free access.
+const MaxSubscriptionDays = 365
+
+// SubscriptionOfferRecord represents a row in subscription_offers: a recipient selling free access
+// to one of their boxes for DurationDays at Price satoshis.
+type SubscriptionOfferRecord struct {
+	ID           int
+	Recipient    string
+	MessageBox   string
+	Price        int
+	DurationDays int
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// Duration returns how long one purchase of the offer grants free access.
+func (o *SubscriptionOfferRecord) Duration() time.Duration {
+	return time.Duration(o.DurationDays) * 24 * time.Hour
+}
+
+// SubscriptionRecord represents a row in subscriptions: one purchase of an offer, paid by the message MessageID.
+type SubscriptionRecord struct {
+	ID         int64
+	Recipient  string
+	Sender     string
+	MessageBox string
+	MessageID  string
+	Price      int
+	StartsAt   time.Time
+	ExpiresAt  time.Time
+	RevokedAt  sql.NullTime
+	CreatedAt  time.Time
+}
+
+// SetSubscriptionOffer upserts the subscription offer of recipient for messageBox.
+func (d *DB) SetSubscriptionOffer(recipient, messageBox string, price, durationDays int) error {
+	now := time.Now()
+	_, err := d.exec(
+		`INSERT INTO subscription_offers (recipient, message_box, price, duration_days, created_at, updated_at)
+		 VALUES (?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(recipient, message_box) DO UPDATE SET price = ?, duration_days = ?, updated_at = ?`,
+		recipient, messageBox, price, durationDays, now, now,
+		price, durationDays, now,
+	)
+	return err
+}
+
+// DeleteSubscriptionOffer removes the subscription offer of recipient for messageBox. Returns false if none existed.
+// Subscriptions already bought keep running until they expire.
+func (d *DB) DeleteSubscriptionOffer(recipient, messageBox string) (bool, error) {
+	res, err := d.exec(`DELETE FROM subscription_offers WHERE recipient = ? AND message_box = ?`, recipient, messageBox)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetSubscriptionOffer returns the subscription offer of recipient for messageBox, or nil if there is none.
+func (d *DB) GetSubscriptionOffer(recipient, messageBox string) (*SubscriptionOfferRecord, error) {
+	var o SubscriptionOfferRecord
+	err := d.queryRow(
+		`SELECT id, recipient, message_box, price, duration_days, created_at, updated_at
+		 FROM subscription_offers WHERE recipient = ? AND message_box = ?`,
+		recipient, messageBox,
+	).Scan(&o.ID, &o.Recipient, &o.MessageBox, &o.Price, &o.DurationDays, &o.CreatedAt, &o.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// ListSubscriptionOffers returns every subscription offer of recipient, ordered by box.
+func (d *DB) ListSubscriptionOffers(recipient string) ([]SubscriptionOfferRecord, error) {
+	rows, err := d.query(
+		`SELECT id, recipient, message_box, price, duration_days, created_at, updated_at
+		 FROM subscription_offers WHERE recipient = ? ORDER BY message_box ASC`,
+		recipient,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []SubscriptionOfferRecord
+	for rows.Next() {
+		var o SubscriptionOfferRecord
+		if err := rows.Scan(&o.ID, &o.Recipient, &o.MessageBox, &o.Price, &o.DurationDays, &o.CreatedAt, &o.UpdatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
+
+// runningSubscription returns the unrevoked subscription of sender to the box that covers now, or nil.
+func (d *DB) runningSubscription(recipient, sender, messageBox string, now time.Time) (*SubscriptionRecord, error) {
+	var sub SubscriptionRecord
+	err := d.queryRow(
+		`SELECT id, recipient, sender, message_box, message_id, price, starts_at, expires_at, created_at
+		 FROM subscriptions
+		 WHERE recipient = ? AND sender = ? AND message_box = ? AND revoked_at IS NULL AND starts_at <= ? AND expires_at > ?
+		 ORDER BY expires_at DESC LIMIT 1`,
+		recipient, sender, messageBox, now, now,
+	).Scan(&sub.ID, &sub.Recipient, &sub.Sender, &sub.MessageBox, &sub.MessageID, &sub.Price, &sub.StartsAt, &sub.ExpiresAt, &sub.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+// grantSubscription records a purchase of offer by sender, paid by the message messageID. The sender can
+// message the box for free until the subscription expires (see ResolveRecipientFee); the recipient's own
+// permission rules are left as they are. Buying again while a subscription runs extends it.
+func grantSubscription(t *tx, offer *SubscriptionOfferRecord, sender, messageID string, now time.Time) (*SubscriptionRecord, error) {
+	sub := &SubscriptionRecord{
+		Recipient:  offer.Recipient,
+		Sender:     sender,
+		MessageBox: offer.MessageBox,
+		MessageID:  messageID,
+		Price:      offer.Price,
+		StartsAt:   now,
+		CreatedAt:  now,
+	}
+	var runningUntil time.Time
+	err := t.queryRow(
+		`SELECT expires_at FROM subscriptions
+		 WHERE recipient = ? AND sender = ? AND message_box = ? AND revoked_at IS NULL AND expires_at > ?
+		 ORDER BY expires_at DESC LIMIT 1`,
+		offer.Recipient, sender, offer.MessageBox, now,
+	).Scan(&runningUntil)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if err == nil {
+		sub.StartsAt = runningUntil
+	}
+	sub.ExpiresAt = sub.StartsAt.Add(offer.Duration())
+
+	if err := t.queryRow(
+		`INSERT INTO subscriptions (recipient, sender, message_box, message_id, price, starts_at, expires_at, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		 RETURNING id`,
+		sub.Recipient, sub.Sender, sub.MessageBox, sub.MessageID, sub.Price, sub.StartsAt, sub.ExpiresAt, now,
+	).Scan(&sub.ID); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// RevokeSubscription revokes the subscription recipient sold with the message messageID, e.g. because the
+// payment was refunded. Purchases queued after it move up by its duration, so its time is taken off the
+// sender's access. Returns nil if no unrevoked subscription was paid by that message.
+func (d *DB) RevokeSubscription(recipient, messageID string, now time.Time) (*SubscriptionRecord, error) {
+	var sub SubscriptionRecord
+	err := d.withTx(func(t *tx) error {
+		err := t.queryRow(
+			`SELECT id, recipient, sender, message_box, message_id, price, starts_at, expires_at, created_at
+			 FROM subscriptions WHERE recipient = ? AND message_id = ? AND revoked_at IS NULL`,
+			recipient, messageID,
+		).Scan(&sub.ID, &sub.Recipient, &sub.Sender, &sub.MessageBox, &sub.MessageID, &sub.Price, &sub.StartsAt, &sub.ExpiresAt, &sub.CreatedAt)
+		if err != nil {
+			return err
+		}
+		if _, err := t.exec(`UPDATE subscriptions SET revoked_at = ? WHERE id = ?`, now, sub.ID); err != nil {
+			return err
+		}
+		sub.RevokedAt = sql.NullTime{Time: now, Valid: true}
+
+		rows, err := t.query(
+			`SELECT id, starts_at, expires_at FROM subscriptions
+			 WHERE recipient = ? AND sender = ? AND message_box = ? AND revoked_at IS NULL AND starts_at >= ?`,
+			sub.Recipient, sub.Sender, sub.MessageBox, sub.ExpiresAt,
+		)
+		if err != nil {
+			return err
+		}
+		type queued struct {
+			id              int64
+			starts, expires time.Time
+		}
+		var later []queued
+		for rows.Next() {
+			var q queued
+			if err := rows.Scan(&q.id, &q.starts, &q.expires); err != nil {
+				rows.Close()
+				return err
+			}
+			later = append(later, q)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		duration := sub.ExpiresAt.Sub(sub.StartsAt)
+		for _, q := range later {
+			if _, err := t.exec(
+				`UPDATE subscriptions SET starts_at = ?, expires_at = ? WHERE id = ?`,
+				q.starts.Add(-duration), q.expires.Add(-duration), q.id,
+			); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
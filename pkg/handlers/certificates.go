@@ -0,0 +1,448 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bsv-blockchain/go-message-box-server/internal/logger"
+	"github.com/bsv-blockchain/go-message-box-server/pkg/db"
+	"github.com/bsv-blockchain/go-sdk/auth/certificates"
+	"github.com/bsv-blockchain/go-sdk/auth/utils"
+	ec "github.com/bsv-blockchain/go-sdk/primitives/ec"
+	sdk "github.com/bsv-blockchain/go-sdk/wallet"
+)
+
+const (
+	maxPresentedCertificates = 10
+	maxRequiredCertifiers    = 20
+)
+
+// DefaultCertificateTTL is how long a presented certificate is kept. The server does not check certificates
+// for revocation, so senders present them again to show they still hold them.
+const DefaultCertificateTTL = 30 * 24 * time.Hour
+
+// PresentCertificates godoc
+// @Summary      Present identity certificates
+// @Description  Verifies BRC-52 identity certificates of the caller and keeps them, so recipients who require a certificate on a box (see /permissions/certificates/set) accept the caller's messages.
+// @Description  Each certificate must be about the caller, signed by its certifier, and carry a keyring revealing at least one field to the server. A newer certificate of the same type from the same certifier replaces the earlier one.
+// @Description  Only the names of the revealed fields are kept, not their values. Certificates are kept until expiresAt; presenting a certificate again renews it. Certificates requested by the server during the auth handshake are kept the same way.
+// @Tags         Messages
+// @Accept       json
+// @Produce      json
+// @Param        request body PresentCertificatesRequest true "Certificates to present"
+// @Success      200  {object}  ListCertificatesResponse "All certificates of the caller"
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /certificates [post]
+func (s *Server) PresentCertificates(w http.ResponseWriter, r *http.Request) {
+	identityKey := getIdentityKey(r)
+	if identityKey == "" {
+		writeError(w, 401, "ERR_AUTHENTICATION_REQUIRED", "Authentication required.")
+		return
+	}
+	if s.wallet == nil {
+		writeError(w, 400, "ERR_CERTIFICATES_NOT_SUPPORTED", "Identity certificates are not supported by this server.")
+		return
+	}
+
+	var req PresentCertificatesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, 400, "ERR_INVALID_JSON", "Invalid JSON body")
+		return
+	}
+	if len(req.Certificates) == 0 || len(req.Certificates) > maxPresentedCertificates {
+		writeError(w, 400, "ERR_INVALID_REQUEST", fmt.Sprintf("certificates must contain between 1 and %d entries.", maxPresentedCertificates))
+		return
+	}
+
+	sender, err := ec.PublicKeyFromString(identityKey)
+	if err != nil {
+		writeError(w, 400, "ERR_INVALID_PUBLIC_KEY", "Invalid identity key.")
+		return
+	}
+
+	verified := make([]db.SenderCertificate, 0, len(req.Certificates))
+	for i, raw := range req.Certificates {
+		var cert certificates.VerifiableCertificate
+		if err := json.Unmarshal(raw, &cert); err != nil {
+			writeError(w, 400, "ERR_INVALID_CERTIFICATE", fmt.Sprintf("Certificate %d is not a valid certificate.", i))
+			return
+		}
+		c, err := s.verifyCertificate(r.Context(), sender, &cert)
+		if err != nil {
+			writeError(w, 400, "ERR_INVALID_CERTIFICATE", fmt.Sprintf("Certificate %d could not be verified: %v", i, err))
+			return
+		}
+		verified = append(verified, c)
+	}
+
+	for _, c := range verified {
+		if err := s.DB.SaveSenderCertificate(c); err != nil {
+			logger.Error("failed to save certificate", "error", err)
+			writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to save certificates.")
+			return
+		}
+	}
+
+	certs, err := s.DB.ListSenderCertificates(identityKey, time.Now())
+	if err != nil {
+		logger.Error("failed to list certificates", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to list certificates.")
+		return
+	}
+	out := make([]CertificateDetail, 0, len(certs))
+	for _, c := range certs {
+		out = append(out, toCertificateDetail(c))
+	}
+	writeJSON(w, 200, ListCertificatesResponse{
+		Status:       "success",
+		Description:  fmt.Sprintf("%d certificate(s) verified.", len(verified)),
+		Certificates: out,
+	})
+}
+
+// ListCertificates godoc
+// @Summary      List your identity certificates
+// @Description  Returns the identity certificates the caller presented that have not expired, with the names of the fields they reveal to the server.
+// @Tags         Messages
+// @Produce      json
+// @Success      200  {object}  ListCertificatesResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /certificates [get]
+func (s *Server) ListCertificates(w http.ResponseWriter, r *http.Request) {
+	identityKey := getIdentityKey(r)
+	if identityKey == "" {
+		writeError(w, 401, "ERR_AUTHENTICATION_REQUIRED", "Authentication required.")
+		return
+	}
+
+	certs, err := s.DB.ListSenderCertificates(identityKey, time.Now())
+	if err != nil {
+		logger.Error("failed to list certificates", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to list certificates.")
+		return
+	}
+
+	out := []CertificateDetail{}
+	for _, c := range certs {
+		out = append(out, toCertificateDetail(c))
+	}
+	writeJSON(w, 200, ListCertificatesResponse{Status: "success", Certificates: out})
+}
+
+// DeleteCertificates godoc
+// @Summary      Delete your identity certificates
+// @Description  Deletes the caller's certificate with serialNumber, or all of the caller's certificates without it. Recipients who require a certificate then turn the caller away until they present one again.
+// @Tags         Messages
+// @Produce      json
+// @Param        serialNumber query string false "Serial number of the certificate to delete"
+// @Success      200  {object}  DeleteCertificatesResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /certificates [delete]
+func (s *Server) DeleteCertificates(w http.ResponseWriter, r *http.Request) {
+	identityKey := getIdentityKey(r)
+	if identityKey == "" {
+		writeError(w, 401, "ERR_AUTHENTICATION_REQUIRED", "Authentication required.")
+		return
+	}
+
+	n, err := s.DB.DeleteSenderCertificates(identityKey, strings.TrimSpace(r.URL.Query().Get("serialNumber")))
+	if err != nil {
+		logger.Error("failed to delete certificates", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to delete certificates.")
+		return
+	}
+	writeJSON(w, 200, DeleteCertificatesResponse{Status: "success", DeletedCertificates: n})
+}
+
+// SetCertificateRequirement godoc
+// @Summary      Require an identity certificate on a message box
+// @Description  Asks senders into a box for a BRC-52 identity certificate (see /certificates) from one of certifiers, optionally of certificateType and revealing fields to the server.
+// @Description  Without verifiedFee, senders without such a certificate cannot deliver (ERR_CERTIFICATE_REQUIRED). With verifiedFee, they pay the box's usual fee and verified senders pay verifiedFee when it is lower.
+// @Description  Sender-specific permissions are the recipient's own choice and bypass the requirement. Use an empty certifiers list to remove it.
+// @Tags         Permissions
+// @Accept       json
+// @Produce      json
+// @Param        request body SetCertificateRequirementRequest true "Certificate requirement"
+// @Success      200  {object}  SetCertificateRequirementResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /permissions/certificates/set [post]
+func (s *Server) SetCertificateRequirement(w http.ResponseWriter, r *http.Request) {
+	identityKey := getIdentityKey(r)
+	if identityKey == "" {
+		writeError(w, 401, "ERR_AUTHENTICATION_REQUIRED", "Authentication required.")
+		return
+	}
+
+	var req SetCertificateRequirementRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, 400, "ERR_INVALID_JSON", "Invalid JSON body")
+		return
+	}
+
+	if code, desc := validateCertificateRequirement(req); code != "" {
+		writeError(w, 400, code, desc)
+		return
+	}
+
+	if len(req.Certifiers) == 0 {
+		if _, err := s.DB.DeleteCertificateRequirement(identityKey, req.MessageBox); err != nil {
+			logger.Error("failed to delete certificate requirement", "error", err)
+			writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to update certificate requirement.")
+			return
+		}
+		writeJSON(w, 200, SetCertificateRequirementResponse{
+			Status:      "success",
+			Description: fmt.Sprintf("Senders to %s no longer need a certificate.", req.MessageBox),
+		})
+		return
+	}
+
+	if err := s.DB.SetCertificateRequirement(db.CertificateRequirement{
+		Recipient:       identityKey,
+		MessageBox:      req.MessageBox,
+		Certifiers:      normalizeCertifiers(req.Certifiers),
+		CertificateType: req.CertificateType,
+		Fields:          req.Fields,
+		VerifiedFee:     req.VerifiedFee,
+	}); err != nil {
+		logger.Error("failed to set certificate requirement", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to update certificate requirement.")
+		return
+	}
+
+	desc := fmt.Sprintf("Only senders with a certificate from one of %d certifier(s) can now deliver to %s.", len(req.Certifiers), req.MessageBox)
+	if req.VerifiedFee != nil {
+		desc = fmt.Sprintf("Senders with a certificate from one of %d certifier(s) now pay at most %d satoshis to %s.", len(req.Certifiers), *req.VerifiedFee, req.MessageBox)
+	}
+	writeJSON(w, 200, SetCertificateRequirementResponse{Status: "success", Description: desc})
+}
+
+// ListCertificateRequirements godoc
+// @Summary      List certificate requirements
+// @Description  Returns the certificate requirements of the authenticated identity.
+// @Tags         Permissions
+// @Produce      json
+// @Success      200  {object}  ListCertificateRequirementsResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /permissions/certificates/list [get]
+func (s *Server) ListCertificateRequirements(w http.ResponseWriter, r *http.Request) {
+	identityKey := getIdentityKey(r)
+	if identityKey == "" {
+		writeError(w, 401, "ERR_AUTHENTICATION_REQUIRED", "Authentication required.")
+		return
+	}
+
+	reqs, err := s.DB.ListCertificateRequirements(identityKey)
+	if err != nil {
+		logger.Error("failed to list certificate requirements", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to list certificate requirements.")
+		return
+	}
+
+	out := []CertificateRequirementDetail{}
+	for _, req := range reqs {
+		out = append(out, CertificateRequirementDetail{
+			MessageBox:      req.MessageBox,
+			Certifiers:      req.Certifiers,
+			CertificateType: req.CertificateType,
+			Fields:          req.Fields,
+			VerifiedFee:     req.VerifiedFee,
+			UpdatedAt:       req.UpdatedAt.Format("2006-01-02T15:04:05.000Z"),
+		})
+	}
+	writeJSON(w, 200, ListCertificateRequirementsResponse{Status: "success", Requirements: out})
+}
+
+// CertificatesReceived keeps the certificates a sender presented during the auth handshake.
+// It is registered with the auth middleware; failing to keep them does not fail the handshake.
+func (s *Server) CertificatesReceived(ctx context.Context, sender *ec.PublicKey, certs []*certificates.VerifiableCertificate) error {
+	if s.wallet == nil {
+		return nil
+	}
+	for _, cert := range certs {
+		c, err := s.verifyCertificate(ctx, sender, cert)
+		if err != nil {
+			logger.Warn("ignoring handshake certificate", "sender", sender.ToDERHex(), "error", err)
+			continue
+		}
+		if err := s.DB.SaveSenderCertificate(c); err != nil {
+			logger.Error("failed to save certificate", "error", err)
+		}
+	}
+	return nil
+}
+
+// RequestedCertificates builds the certificates the auth middleware requests from every client during the handshake.
+// types maps base64 certificate type IDs to the fields to reveal; certifiers may be empty to accept any certifier.
+func RequestedCertificates(certifiers []string, types map[string][]string) (*utils.RequestedCertificateSet, error) {
+	set := &utils.RequestedCertificateSet{CertificateTypes: make(utils.RequestedCertificateTypeIDAndFieldList)}
+	for _, k := range certifiers {
+		pub, err := ec.PublicKeyFromString(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certifier %q: %w", k, err)
+		}
+		set.Certifiers = append(set.Certifiers, pub)
+	}
+	for t, fields := range types {
+		id, err := sdk.StringBase64(t).ToArray()
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate type %q: %w", t, err)
+		}
+		set.CertificateTypes[id] = fields
+	}
+	return set, nil
+}
+
+// verifyCertificate checks that cert is about sender and signed by its certifier, and decrypts the fields
+// it reveals to the server to learn which ones it reveals.
+func (s *Server) verifyCertificate(ctx context.Context, sender *ec.PublicKey, cert *certificates.VerifiableCertificate) (db.SenderCertificate, error) {
+	if utils.IsEmptyPublicKey(cert.Certifier) {
+		return db.SenderCertificate{}, fmt.Errorf("certificate has no certifier")
+	}
+	if err := utils.ValidateCertificate(ctx, s.wallet, cert, sender, nil); err != nil {
+		return db.SenderCertificate{}, err
+	}
+	// keep only which fields were revealed; the values are personal data the server does not need
+	fields := make([]string, 0, len(cert.DecryptedFields))
+	for f, v := range cert.DecryptedFields {
+		if v != "" {
+			fields = append(fields, f)
+		}
+	}
+	slices.Sort(fields)
+	return db.SenderCertificate{
+		Sender:          sender.ToDERHex(),
+		Certifier:       cert.Certifier.ToDERHex(),
+		CertificateType: string(cert.Type),
+		SerialNumber:    string(cert.SerialNumber),
+		Fields:          fields,
+		ExpiresAt:       time.Now().Add(s.certificateTTL),
+	}, nil
+}
+
+// validateCertificateRequirement checks a SetCertificateRequirementRequest.
+// Returns an error code and description when invalid.
+func validateCertificateRequirement(req SetCertificateRequirementRequest) (string, string) {
+	if req.MessageBox == "" {
+		return "ERR_INVALID_REQUEST", "messageBox (string) and certifiers (array) are required."
+	}
+	if db.IsBoxPattern(req.MessageBox) {
+		return "ERR_INVALID_MESSAGEBOX", "Certificates are required for a single box, not a pattern."
+	}
+	if len(req.Certifiers) > maxRequiredCertifiers {
+		return "ERR_INVALID_REQUEST", fmt.Sprintf("certifiers must contain at most %d keys.", maxRequiredCertifiers)
+	}
+	for _, k := range req.Certifiers {
+		if !isValidPubKey(k) {
+			return "ERR_INVALID_PUBLIC_KEY", fmt.Sprintf("Invalid certifier key: %s", k)
+		}
+	}
+	if req.CertificateType != "" {
+		if b, err := base64.StdEncoding.DecodeString(req.CertificateType); err != nil || len(b) != 32 {
+			return "ERR_INVALID_CERTIFICATE_TYPE", "certificateType must be a base64 encoded 32 byte type ID."
+		}
+	}
+	for _, f := range req.Fields {
+		if strings.TrimSpace(f) == "" || len(f) > 50 {
+			return "ERR_INVALID_REQUEST", "fields must be non-empty names of at most 50 bytes."
+		}
+	}
+	if req.VerifiedFee != nil && *req.VerifiedFee < 0 {
+		return "ERR_INVALID_FEE", "verifiedFee must be a non-negative number of satoshis."
+	}
+	return "", ""
+}
+
+// normalizeCertifiers returns validated certifier keys in compressed DER hex, the form certificates are
+// stored in, so a key typed in another case or encoding still matches its certificates.
+func normalizeCertifiers(keys []string) []string {
+	out := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pub, err := ec.PublicKeyFromString(k)
+		if err != nil {
+			continue // rejected by validateCertificateRequirement
+		}
+		if key := pub.ToDERHex(); !slices.Contains(out, key) {
+			out = append(out, key)
+		}
+	}
+	return out
+}
+
+// certificateCheck is the outcome of checking a sender's certificates against a recipient's requirement.
+type certificateCheck struct {
+	requirement *db.CertificateRequirement // nil when the box has none or a sender-specific rule or subscription applies
+	verified    bool
+}
+
+// checkCertificates checks senderCerts against the recipient's requirement for messageBox.
+func (s *Server) checkCertificates(recipient, messageBox string, res *db.FeeResolution, senderCerts []db.SenderCertificate) (certificateCheck, error) {
+	if res.SenderSpecific() {
+		return certificateCheck{}, nil
+	}
+	req, err := s.DB.GetCertificateRequirement(recipient, messageBox)
+	if err != nil || req == nil {
+		return certificateCheck{}, err
+	}
+	return certificateCheck{requirement: req, verified: req.SatisfiedBy(senderCerts)}, nil
+}
+
+// allowed reports whether the sender may deliver under the requirement.
+func (c certificateCheck) allowed() bool {
+	return c.requirement == nil || c.verified || c.requirement.VerifiedFee != nil
+}
+
+// recipientFee lowers fee to the requirement's verified fee for verified senders. Blocks stay blocks.
+func (c certificateCheck) recipientFee(fee int) int {
+	if fee < 0 || !c.verified || c.requirement.VerifiedFee == nil {
+		return fee
+	}
+	return min(fee, *c.requirement.VerifiedFee)
+}
+
+// quote reports the requirement in a quote, nil when there is none.
+func (c certificateCheck) quote() *CertificateQuote {
+	if c.requirement == nil {
+		return nil
+	}
+	return &CertificateQuote{
+		Certifiers:      c.requirement.Certifiers,
+		CertificateType: c.requirement.CertificateType,
+		Fields:          c.requirement.Fields,
+		VerifiedFee:     c.requirement.VerifiedFee,
+		Verified:        c.verified,
+	}
+}
+
+func toCertificateDetail(c db.SenderCertificate) CertificateDetail {
+	fields := c.Fields
+	if fields == nil {
+		fields = []string{}
+	}
+	return CertificateDetail{
+		Certifier:       c.Certifier,
+		CertificateType: c.CertificateType,
+		SerialNumber:    c.SerialNumber,
+		Fields:          fields,
+		ExpiresAt:       c.ExpiresAt.UTC().Format("2006-01-02T15:04:05.000Z"),
+		UpdatedAt:       c.UpdatedAt.Format("2006-01-02T15:04:05.000Z"),
+	}
+}
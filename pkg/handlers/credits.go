@@ -0,0 +1,485 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bsv-blockchain/go-message-box-server/internal/logger"
+	"github.com/bsv-blockchain/go-message-box-server/pkg/db"
+	sdk "github.com/bsv-blockchain/go-sdk/wallet"
+)
+
+// creditSettlementBatch is how many recipients are paid per settlement run.
+const creditSettlementBatch = 100
+
+// creditSettlementStaleAfter is how long a settlement can stay unpaid before it is reported as interrupted.
+const creditSettlementStaleAfter = time.Hour
+
+// DepositCredit godoc
+// @Summary      Deposit credit
+// @Description  Adds a payment to the caller's credit balance. Every output must be a wallet payment to the server whose remittance names the caller; the server wallet internalizes them all and credits their total.
+// @Description  Sends with useCredit then debit delivery and recipient fees from the balance instead of carrying a payment. Outputs can only be deposited once. If the depth of the payment transaction cannot be checked, the deposit fails with 503 ERR_PAYMENT_STATUS_UNAVAILABLE.
+// @Tags         Credits
+// @Accept       json
+// @Produce      json
+// @Param        request body DepositCreditRequest true "Payment to deposit"
+// @Success      200  {object}  DepositCreditResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      409  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Failure      503  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /credits/deposit [post]
+func (s *Server) DepositCredit(w http.ResponseWriter, r *http.Request) {
+	identityKey := getIdentityKey(r)
+	if identityKey == "" {
+		writeError(w, 401, "ERR_AUTHENTICATION_REQUIRED", "Authentication required.")
+		return
+	}
+	if s.wallet == nil {
+		writeError(w, 400, "ERR_CREDITS_NOT_SUPPORTED", "Credit deposits are not supported by this server.")
+		return
+	}
+
+	var req DepositCreditRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, 400, "ERR_INVALID_JSON", "Invalid JSON body")
+		return
+	}
+	if req.Payment == nil || len(req.Payment.Tx) == 0 || len(req.Payment.Outputs) == 0 {
+		writeError(w, 400, "ERR_MISSING_PAYMENT_TX", "Payment transaction data is required for a deposit.")
+		return
+	}
+
+	paymentTx, err := parsePaymentTx(req.Payment.Tx)
+	if err != nil {
+		writeError(w, 400, "ERR_INVALID_PAYMENT_TX", fmt.Sprintf("Invalid payment transaction: %v", err))
+		return
+	}
+
+	txid := paymentTx.TxID().String()
+	seen := make(map[uint32]bool)
+	sdkOutputs := make([]sdk.InternalizeOutput, 0, len(req.Payment.Outputs))
+	spent := make([]db.SpentOutput, 0, len(req.Payment.Outputs))
+	for _, out := range req.Payment.Outputs {
+		if code, desc := validateDepositOutput(out, identityKey, len(paymentTx.Outputs), seen); code != "" {
+			writeError(w, 400, code, desc)
+			return
+		}
+		seen[out.OutputIndex] = true
+		sdkOutput, err := toSDKInternalizeOutput(out)
+		if err != nil {
+			writeError(w, 400, "ERR_INVALID_PAYMENT_OUTPUT", fmt.Sprintf("Invalid payment output: %v", err))
+			return
+		}
+		sdkOutputs = append(sdkOutputs, sdkOutput)
+		spent = append(spent, db.SpentOutput{TxID: txid, OutputIndex: out.OutputIndex, Sender: identityKey})
+	}
+	amount := outputsAmount(paymentTx, req.Payment.Outputs)
+	if amount <= 0 {
+		writeError(w, 400, "ERR_INSUFFICIENT_PAYMENT", "The deposit outputs carry no satoshis.")
+		return
+	}
+
+	if status, omErr := s.checkPaymentDepth(r.Context(), txid); omErr != nil {
+		writeError(w, status, omErr.Code, omErr.Description)
+		return
+	}
+	replayed, err := s.DB.ClaimPaymentOutputs(spent)
+	if err != nil {
+		logger.Error("failed to claim deposit outputs", "error", err)
+		writeError(w, 500, "ERR_INTERNAL", "An internal error has occurred.")
+		return
+	}
+	if len(replayed) > 0 {
+		writeError(w, 409, "ERR_PAYMENT_REPLAYED", fmt.Sprintf("Payment outputs were already used: %s", replayedOutpoints(replayed)))
+		return
+	}
+
+	description := req.Payment.Description
+	if description == "" {
+		description = "MessageBox credit deposit"
+	}
+	result, err := s.wallet.InternalizeAction(r.Context(), sdk.InternalizeActionArgs{
+		Tx:          req.Payment.Tx,
+		Outputs:     sdkOutputs,
+		Description: description,
+		Labels:      req.Payment.Labels,
+	}, "messagebox-server")
+	if err != nil {
+		logger.Error("failed to internalize deposit", "error", err, "txid", txid)
+		s.releaseOutputs(spent, false)
+		writeError(w, 500, "ERR_INTERNALIZE_FAILED", fmt.Sprintf("Failed to internalize payment: %v", err))
+		return
+	}
+	if !result.Accepted {
+		s.releaseOutputs(spent, false)
+		writeError(w, 400, "ERR_INSUFFICIENT_PAYMENT", "Payment was not accepted by the server.")
+		return
+	}
+
+	balance, err := s.DB.DepositCredit(identityKey, amount, txid)
+	if err != nil {
+		// the server holds the deposit; the txid lets operators credit it by hand
+		logger.Error("failed to record credit deposit", "error", err, "txid", txid, "amount", amount, "identityKey", identityKey)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "The deposit was received but could not be credited.")
+		return
+	}
+
+	writeJSON(w, 200, DepositCreditResponse{
+		Status:  "success",
+		TxID:    txid,
+		Amount:  amount,
+		Balance: balance,
+	})
+}
+
+// GetCreditBalance godoc
+// @Summary      Get credit balance
+// @Description  Returns the caller's credit balance and the recipient fees paid to the caller from other senders' credit that are waiting for the next settlement.
+// @Tags         Credits
+// @Produce      json
+// @Success      200  {object}  CreditBalanceResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /credits/balance [get]
+func (s *Server) GetCreditBalance(w http.ResponseWriter, r *http.Request) {
+	identityKey := getIdentityKey(r)
+	if identityKey == "" {
+		writeError(w, 401, "ERR_AUTHENTICATION_REQUIRED", "Authentication required.")
+		return
+	}
+
+	balance, owed, err := s.DB.CreditBalance(identityKey)
+	if err != nil {
+		logger.Error("failed to get credit balance", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to retrieve credit balance.")
+		return
+	}
+
+	writeJSON(w, 200, CreditBalanceResponse{
+		Status:          "success",
+		Balance:         balance,
+		PendingEarnings: owed,
+	})
+}
+
+// ListCreditLedger godoc
+// @Summary      List credit ledger
+// @Description  Returns the deposits, fees, withdrawals and reversals of the caller's credit balance, newest first.
+// @Tags         Credits
+// @Produce      json
+// @Param        limit query int false "Maximum number of results (1-500, default 50)"
+// @Success      200  {object}  CreditLedgerResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /credits/ledger [get]
+func (s *Server) ListCreditLedger(w http.ResponseWriter, r *http.Request) {
+	identityKey := getIdentityKey(r)
+	if identityKey == "" {
+		writeError(w, 401, "ERR_AUTHENTICATION_REQUIRED", "Authentication required.")
+		return
+	}
+
+	limit, err := parseLimit(r, 50, 500)
+	if err != nil {
+		writeError(w, 400, "ERR_INVALID_LIMIT", err.Error())
+		return
+	}
+
+	entries, err := s.DB.ListCreditEntries(identityKey, limit)
+	if err != nil {
+		logger.Error("failed to list credit entries", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to retrieve credit ledger.")
+		return
+	}
+
+	out := []CreditEntryOut{}
+	for _, e := range entries {
+		out = append(out, CreditEntryOut{
+			ID:               e.ID,
+			Kind:             e.Kind,
+			Amount:           e.Amount,
+			BalanceAfter:     e.BalanceAfter,
+			TxID:             e.TxID.String,
+			Recipient:        e.Recipient.String,
+			MessageBox:       e.MessageBox.String,
+			MessageID:        e.MessageID.String,
+			SettlementStatus: e.SettlementStatus.String,
+			CreatedAt:        e.CreatedAt.Format("2006-01-02T15:04:05.000Z"),
+		})
+	}
+
+	writeJSON(w, 200, CreditLedgerResponse{Status: "success", Entries: out})
+}
+
+// WithdrawCredit godoc
+// @Summary      Withdraw credit
+// @Description  Pays unused credit back to the caller from the server wallet. The payout includes the payment to pass to the wallet's internalizeAction; it is also listed by /credits/payouts.
+// @Tags         Credits
+// @Accept       json
+// @Produce      json
+// @Param        request body WithdrawCreditRequest false "Amount to withdraw, the whole balance by default"
+// @Success      200  {object}  WithdrawCreditResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      402  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /credits/withdraw [post]
+func (s *Server) WithdrawCredit(w http.ResponseWriter, r *http.Request) {
+	identityKey := getIdentityKey(r)
+	if identityKey == "" {
+		writeError(w, 401, "ERR_AUTHENTICATION_REQUIRED", "Authentication required.")
+		return
+	}
+	if s.wallet == nil {
+		writeError(w, 400, "ERR_CREDITS_NOT_SUPPORTED", "Credit withdrawals are not supported by this server.")
+		return
+	}
+
+	var req WithdrawCreditRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, 400, "ERR_INVALID_JSON", "Invalid JSON body")
+			return
+		}
+	}
+
+	var amount int64
+	if req.Amount != nil {
+		amount = *req.Amount
+		if amount <= 0 {
+			writeError(w, 400, "ERR_INVALID_AMOUNT", "amount must be a positive number of satoshis.")
+			return
+		}
+	} else {
+		balance, _, err := s.DB.CreditBalance(identityKey)
+		if err != nil {
+			logger.Error("failed to get credit balance", "error", err)
+			writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to retrieve credit balance.")
+			return
+		}
+		amount = balance
+	}
+	if amount <= 0 {
+		writeError(w, 402, "ERR_INSUFFICIENT_CREDIT", "There is no credit to withdraw.")
+		return
+	}
+
+	entries, balance, err := s.DB.DebitCredit(identityKey, []db.CreditDebit{{Kind: db.CreditKindWithdrawal, Amount: amount}})
+	if errors.Is(err, db.ErrInsufficientCredit) {
+		writeError(w, 402, "ERR_INSUFFICIENT_CREDIT", fmt.Sprintf("The credit balance does not cover %d satoshis.", amount))
+		return
+	}
+	if err != nil {
+		logger.Error("failed to debit withdrawal", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to withdraw credit.")
+		return
+	}
+
+	payment, txid, err := s.createServerPayment(r.Context(), identityKey, amount, "MessageBox credit withdrawal", "Credit withdrawal", "messagebox credit")
+	if err != nil {
+		logger.Error("failed to create withdrawal payment", "error", err, "identityKey", identityKey)
+		s.reverseCreditDebits(identityKey, entries)
+		writeError(w, 500, "ERR_WITHDRAWAL_FAILED", "Failed to create the withdrawal payment.")
+		return
+	}
+
+	payout := s.recordCreditPayout(db.CreditPayout{Payee: identityKey, Kind: db.CreditPayoutWithdrawal, Amount: amount, TxID: txid}, payment)
+	writeJSON(w, 200, WithdrawCreditResponse{
+		Status:  "success",
+		Balance: balance,
+		Payout:  toCreditPayoutOut(payout, payment),
+	})
+}
+
+// ListCreditPayouts godoc
+// @Summary      List credit payouts
+// @Description  Returns payments made to the caller from credit, newest first: settlements of recipient fees that senders paid from their credit, and the caller's withdrawals.
+// @Description  Each payout includes the payment to pass to the wallet's internalizeAction.
+// @Tags         Credits
+// @Produce      json
+// @Param        limit query int false "Maximum number of results (1-500, default 50)"
+// @Success      200  {object}  ListCreditPayoutsResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /credits/payouts [get]
+func (s *Server) ListCreditPayouts(w http.ResponseWriter, r *http.Request) {
+	identityKey := getIdentityKey(r)
+	if identityKey == "" {
+		writeError(w, 401, "ERR_AUTHENTICATION_REQUIRED", "Authentication required.")
+		return
+	}
+
+	limit, err := parseLimit(r, 50, 500)
+	if err != nil {
+		writeError(w, 400, "ERR_INVALID_LIMIT", err.Error())
+		return
+	}
+
+	payouts, err := s.DB.ListCreditPayouts(identityKey, limit)
+	if err != nil {
+		logger.Error("failed to list credit payouts", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to retrieve payouts.")
+		return
+	}
+
+	out := []CreditPayoutOut{}
+	for _, p := range payouts {
+		payment := &Payment{}
+		if err := json.Unmarshal([]byte(p.Payment), payment); err != nil {
+			logger.Error("failed to decode stored payout", "error", err, "payoutId", p.ID)
+			payment = nil
+		}
+		out = append(out, toCreditPayoutOut(p, payment))
+	}
+
+	writeJSON(w, 200, ListCreditPayoutsResponse{Status: "success", Payouts: out})
+}
+
+// SettleRecipientCredits pays recipients the fees senders debited from credit for them, once they are owed
+// at least minAmount. Each recipient gets one payment per run. Returns how many recipients were paid.
+func (s *Server) SettleRecipientCredits(ctx context.Context, minAmount int64) (int, error) {
+	if s.wallet == nil {
+		return 0, nil
+	}
+	s.reportStaleCreditSettlements()
+	pending, err := s.DB.PendingCreditSettlements(max(minAmount, 1), creditSettlementBatch)
+	if err != nil {
+		return 0, err
+	}
+
+	paid := 0
+	for _, p := range pending {
+		// the fees are claimed before paying, so a failed record or a concurrent run cannot pay them twice
+		payoutID, amount, err := s.DB.StartCreditSettlement(p.Recipient, max(minAmount, 1))
+		if err != nil {
+			logger.Error("failed to start settlement", "error", err, "recipient", p.Recipient)
+			continue
+		}
+		if payoutID == 0 {
+			continue
+		}
+		payment, txid, err := s.createServerPayment(ctx, p.Recipient, amount, "MessageBox recipient fee settlement", "Recipient fee settlement", "messagebox settlement")
+		if err != nil {
+			// the fees go back to pending and are retried on the next run
+			logger.Error("failed to create settlement payment", "error", err, "recipient", p.Recipient, "amount", amount)
+			if err := s.DB.CancelCreditSettlement(payoutID); err != nil {
+				logger.Error("failed to cancel settlement", "error", err, "payoutId", payoutID)
+			}
+			continue
+		}
+		paymentJSON, err := json.Marshal(payment)
+		if err == nil {
+			err = s.DB.CompleteCreditSettlement(payoutID, txid, string(paymentJSON))
+		}
+		if err != nil {
+			// the fees stay settling, so they are not paid again
+			logger.Error("failed to record settlement payout", "error", err, "payoutId", payoutID, "recipient", p.Recipient, "txid", txid, "amount", amount)
+		}
+		paid++
+	}
+	return paid, nil
+}
+
+// reportStaleCreditSettlements logs settlements left settling by a server that stopped while paying them.
+// Their payment may have been made, so they are never cancelled automatically; operators resolve them with
+// the "settlements" command.
+func (s *Server) reportStaleCreditSettlements() {
+	stale, err := s.DB.ListUnpaidCreditSettlements(time.Now().Add(-creditSettlementStaleAfter))
+	if err != nil {
+		logger.Error("failed to list unpaid settlements", "error", err)
+		return
+	}
+	for _, p := range stale {
+		logger.Error("settlement interrupted before its payment was recorded", "payoutId", p.ID, "recipient", p.Payee, "amount", p.Amount, "startedAt", p.CreatedAt)
+	}
+}
+
+// recordCreditPayout stores a withdrawal made by the server wallet. A payout that cannot be recorded was still
+// paid, so the failure is only logged with the txid for operators.
+func (s *Server) recordCreditPayout(p db.CreditPayout, payment *Payment) db.CreditPayout {
+	paymentJSON, err := json.Marshal(payment)
+	if err == nil {
+		p.Payment = string(paymentJSON)
+		p.ID, err = s.DB.InsertCreditPayout(p)
+	}
+	if err != nil {
+		logger.Error("failed to record credit payout", "error", err, "kind", p.Kind, "payee", p.Payee, "txid", p.TxID, "amount", p.Amount)
+	}
+	return p
+}
+
+// reverseCreditDebits returns debits of a send or withdrawal that did not complete to the balance.
+func (s *Server) reverseCreditDebits(identityKey string, entries []int64) {
+	if len(entries) == 0 {
+		return
+	}
+	if _, err := s.DB.ReverseCreditDebits(identityKey, entries); err != nil {
+		logger.Error("failed to reverse credit debits", "error", err, "identityKey", identityKey, "entries", entries)
+	}
+}
+
+// creditDebits lists the fees of a send paid from credit: the delivery fee and each recipient fee.
+func creditDebits(boxType string, deliveryFee int, feeRows []feeRow, messageIDs []string) []db.CreditDebit {
+	box := sql.NullString{String: boxType, Valid: true}
+	var debits []db.CreditDebit
+	if deliveryFee > 0 {
+		debits = append(debits, db.CreditDebit{Kind: db.CreditKindDeliveryFee, Amount: int64(deliveryFee), MessageBox: box})
+	}
+	for i, fr := range feeRows {
+		if fr.recipientFee > 0 {
+			debits = append(debits, db.CreditDebit{
+				Kind:       db.CreditKindRecipientFee,
+				Amount:     int64(fr.recipientFee),
+				Recipient:  sql.NullString{String: fr.recipient, Valid: true},
+				MessageBox: box,
+				MessageID:  sql.NullString{String: messageIDs[i], Valid: true},
+			})
+		}
+	}
+	return debits
+}
+
+// validateDepositOutput checks that a deposit output exists once and is a wallet payment from the depositor.
+func validateDepositOutput(out PaymentOutput, identityKey string, txOutputs int, seen map[uint32]bool) (string, string) {
+	if int(out.OutputIndex) >= txOutputs {
+		return "ERR_INVALID_PAYMENT_OUTPUT", fmt.Sprintf("Output %d does not exist in the payment transaction.", out.OutputIndex)
+	}
+	if seen[out.OutputIndex] {
+		return "ERR_INVALID_PAYMENT_OUTPUT", fmt.Sprintf("Output %d is listed more than once.", out.OutputIndex)
+	}
+	if out.Protocol != string(sdk.InternalizeProtocolWalletPayment) || out.PaymentRemittance == nil {
+		return "ERR_INVALID_PAYMENT_OUTPUT", "Deposit outputs must be wallet payments to the server."
+	}
+	if out.PaymentRemittance.SenderIdentityKey != identityKey {
+		return "ERR_INVALID_PAYMENT_OUTPUT", "Deposit outputs must name the depositor as sender."
+	}
+	return "", ""
+}
+
+func toCreditPayoutOut(p db.CreditPayout, payment *Payment) CreditPayoutOut {
+	out := CreditPayoutOut{
+		ID:      p.ID,
+		Kind:    p.Kind,
+		Amount:  p.Amount,
+		TxID:    p.TxID,
+		Payment: payment,
+	}
+	if !p.CreatedAt.IsZero() {
+		out.CreatedAt = p.CreatedAt.Format("2006-01-02T15:04:05.000Z")
+	}
+	return out
+}
@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/bsv-blockchain/go-message-box-server/internal/firebase"
+	"github.com/bsv-blockchain/go-message-box-server/internal/logger"
+	"github.com/bsv-blockchain/go-message-box-server/pkg/db"
+	sdk "github.com/bsv-blockchain/go-sdk/wallet"
+)
+
+// Policies for an FCM token that is already registered to a different identity.
+const (
+	DeviceTransferPolicyReject    = "reject"
+	DeviceTransferPolicyChallenge = "challenge"
+)
+
+// deviceChallengeTTL is how long a pushed ownership challenge can be answered.
+const deviceChallengeTTL = 10 * time.Minute
+
+// deviceTransferEventType is the type of the system message sent to both parties of a transfer.
+const deviceTransferEventType = "device_token_transferred"
+
+// DeviceTransferEvent is stored in the notifications box of both identities when a device changes owner.
+type DeviceTransferEvent struct {
+	Type                string `json:"type"`
+	FCMTokenSuffix      string `json:"fcmTokenSuffix"`
+	PreviousIdentityKey string `json:"previousIdentityKey"`
+	NewIdentityKey      string `json:"newIdentityKey"`
+	Method              string `json:"method"`
+	TransferredAt       string `json:"transferredAt"`
+}
+
+// handleOwnedDeviceToken responds to a registration of a token owned by another identity,
+// either rejecting it or pushing an ownership challenge to the device.
+func (s *Server) handleOwnedDeviceToken(w http.ResponseWriter, identityKey string, req RegisterDeviceRequest) {
+	if s.deviceTransferPolicy != DeviceTransferPolicyChallenge || !firebase.IsEnabled() {
+		writeError(w, 409, "ERR_DEVICE_TOKEN_OWNED", "This FCM token is registered to another identity.")
+		return
+	}
+
+	nonce, err := newChallengeNonce()
+	if err != nil {
+		logger.Error("failed to generate device challenge", "error", err)
+		writeError(w, 500, "ERR_INTERNAL", "An internal error has occurred.")
+		return
+	}
+
+	expiresAt := time.Now().Add(deviceChallengeTTL)
+	if err := s.DB.CreateDeviceChallenge(req.FCMToken, identityKey, hashChallengeNonce(nonce), req.DeviceID, req.Platform, expiresAt); err != nil {
+		logger.Error("failed to store device challenge", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to register device.")
+		return
+	}
+
+	if err := firebase.SendDeviceChallenge(req.FCMToken, nonce, expiresAt); err != nil {
+		logger.Error("failed to push device challenge", "error", err)
+		writeError(w, 502, "ERR_DEVICE_CHALLENGE_FAILED", "Failed to deliver the ownership challenge to the device.")
+		return
+	}
+
+	writeJSON(w, 202, DeviceChallengeResponse{
+		Status:      "error",
+		Code:        "ERR_DEVICE_CHALLENGE_REQUIRED",
+		Description: "This token is registered to another identity. A challenge was pushed to the device; resubmit with challengeNonce.",
+		ExpiresAt:   expiresAt.UTC().Format("2006-01-02T15:04:05.000Z"),
+	})
+}
+
+// claimDeviceToken answers a pushed challenge and, if the nonce matches, moves the token to identityKey.
+func (s *Server) claimDeviceToken(w http.ResponseWriter, r *http.Request, identityKey string, req RegisterDeviceRequest) {
+	challenge, err := s.DB.ConsumeDeviceChallenge(req.FCMToken, identityKey)
+	if err != nil {
+		logger.Error("failed to load device challenge", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to register device.")
+		return
+	}
+	if challenge == nil || time.Now().After(challenge.ExpiresAt) ||
+		subtle.ConstantTimeCompare([]byte(challenge.NonceHash), []byte(hashChallengeNonce(*req.ChallengeNonce))) != 1 {
+		writeError(w, 403, "ERR_INVALID_CHALLENGE", "The challenge nonce is invalid or has expired.")
+		return
+	}
+
+	deviceID, platform := challenge.DeviceID, challenge.Platform
+	if req.DeviceID != nil {
+		deviceID = nullString(req.DeviceID)
+	}
+	if req.Platform != nil {
+		platform = nullString(req.Platform)
+	}
+
+	transfer, err := s.DB.TransferDeviceOwnership(req.FCMToken, identityKey, deviceID, platform, db.TransferMethodChallenge)
+	if errors.Is(err, db.ErrDeviceNotFound) {
+		// the previous owner removed the token meanwhile, a plain registration is enough
+		id, err := s.DB.RegisterDevice(identityKey, req.FCMToken, req.DeviceID, req.Platform)
+		if err != nil {
+			logger.Error("failed to register device", "error", err)
+			writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to register device.")
+			return
+		}
+		writeJSON(w, 200, RegisterDeviceResponse{
+			Status:   "success",
+			Message:  "Device registered successfully for push notifications",
+			DeviceID: id,
+		})
+		return
+	}
+	if err != nil {
+		logger.Error("failed to transfer device ownership", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to register device.")
+		return
+	}
+
+	if transfer.PreviousIdentityKey != identityKey {
+		s.notifyDeviceTransfer(r.Context(), transfer)
+	}
+
+	writeJSON(w, 200, RegisterDeviceResponse{
+		Status:   "success",
+		Message:  "Device ownership transferred and registered for push notifications",
+		DeviceID: transfer.DeviceRegistrationID,
+	})
+}
+
+// notifyDeviceTransfer stores a system message in the notifications box of both the previous
+// and the new owner, pushing it when their preferences allow. Failures are logged only.
+func (s *Server) notifyDeviceTransfer(ctx context.Context, t *db.DeviceOwnershipTransferRecord) {
+	const box = "notifications"
+
+	sender := s.serverIdentityKey(ctx)
+	event := DeviceTransferEvent{
+		Type:                deviceTransferEventType,
+		FCMTokenSuffix:      t.FCMTokenSuffix,
+		PreviousIdentityKey: t.PreviousIdentityKey,
+		NewIdentityKey:      t.NewIdentityKey,
+		Method:              t.Method,
+		TransferredAt:       t.CreatedAt.UTC().Format("2006-01-02T15:04:05.000Z"),
+	}
+	eventBytes, _ := json.Marshal(event)
+	bodyBytes, _ := json.Marshal(map[string]any{"message": json.RawMessage(eventBytes)})
+
+	for _, recipient := range []string{t.PreviousIdentityKey, t.NewIdentityKey} {
+		mbID, err := s.DB.EnsureMessageBox(recipient, box)
+		if err != nil {
+			logger.Error("failed to ensure notifications box", "error", err, "recipient", recipient)
+			continue
+		}
+
+		msgID, err := newChallengeNonce()
+		if err != nil {
+			logger.Error("failed to generate message id", "error", err)
+			continue
+		}
+
+		if err := s.DB.InsertMessage(msgID, mbID, sender, recipient, string(bodyBytes)); err != nil {
+			logger.Error("failed to store device transfer notice", "error", err, "recipient", recipient)
+			continue
+		}
+
+		usePush, err := s.DB.ShouldUseFCMDelivery(recipient, sender, box, time.Now())
+		if err != nil {
+			logger.Error("failed to evaluate notification preference", "error", err, "recipient", recipient)
+		}
+		if usePush {
+			payload := s.buildFCMPayload(recipient, sender, box, mbID, msgID, nil)
+			go firebase.SendFCMNotification(s.DB, recipient, payload)
+		}
+	}
+}
+
+// serverIdentityKey returns the server wallet's identity key, used as sender of system messages.
+func (s *Server) serverIdentityKey(ctx context.Context) string {
+	if s.wallet == nil {
+		return ""
+	}
+	res, err := s.wallet.GetPublicKey(ctx, sdk.GetPublicKeyArgs{IdentityKey: true}, "messagebox-server")
+	if err != nil || res.PublicKey == nil {
+		logger.Error("failed to get server identity key", "error", err)
+		return ""
+	}
+	return res.PublicKey.ToDERHex()
+}
+
+// newChallengeNonce returns 32 random bytes as hex.
+func newChallengeNonce() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashChallengeNonce hashes a nonce so that only its digest is stored.
+func hashChallengeNonce(nonce string) string {
+	sum := sha256.Sum256([]byte(nonce))
+	return hex.EncodeToString(sum[:])
+}
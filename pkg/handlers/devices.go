@@ -2,22 +2,34 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
 
 	"github.com/bsv-blockchain/go-message-box-server/internal/logger"
+	"github.com/bsv-blockchain/go-message-box-server/pkg/db"
 )
 
+// validPlatforms lists the accepted values for a device platform label.
+var validPlatforms = map[string]bool{"ios": true, "android": true, "web": true}
+
 // RegisterDevice godoc
 // @Summary      Register a device for push notifications
 // @Description  Registers a device with an FCM token for receiving push notifications. Supports iOS, Android, and web platforms.
+// @Description  A token already registered to another identity is rejected (409), or, under the challenge policy, a nonce is pushed to the device (202)
+// @Description  and the registration must be resubmitted with challengeNonce to transfer ownership. Both identities are notified of a transfer.
 // @Tags         Devices
 // @Accept       json
 // @Produce      json
 // @Param        request body RegisterDeviceRequest true "Device registration details"
 // @Success      200  {object}  RegisterDeviceResponse
+// @Success      202  {object}  DeviceChallengeResponse
 // @Failure      400  {object}  ErrorResponse
 // @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
+// @Failure      409  {object}  ErrorResponse
 // @Failure      500  {object}  ErrorResponse
+// @Failure      502  {object}  ErrorResponse
 // @Security     BSVAuth
 // @Router       /registerDevice [post]
 func (s *Server) RegisterDevice(w http.ResponseWriter, r *http.Request) {
@@ -38,13 +50,21 @@ func (s *Server) RegisterDevice(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	validPlatforms := map[string]bool{"ios": true, "android": true, "web": true}
 	if req.Platform != nil && !validPlatforms[*req.Platform] {
 		writeError(w, 400, "ERR_INVALID_PLATFORM", "platform must be one of: ios, android, web")
 		return
 	}
 
+	if req.ChallengeNonce != nil {
+		s.claimDeviceToken(w, r, identityKey, req)
+		return
+	}
+
 	id, err := s.DB.RegisterDevice(identityKey, req.FCMToken, req.DeviceID, req.Platform)
+	if errors.Is(err, db.ErrDeviceTokenOwned) {
+		s.handleOwnedDeviceToken(w, identityKey, req)
+		return
+	}
 	if err != nil {
 		logger.Error("failed to register device", "error", err)
 		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to register device.")
@@ -84,28 +104,7 @@ func (s *Server) ListDevices(w http.ResponseWriter, r *http.Request) {
 
 	var out []DeviceOut
 	for _, d := range devices {
-		token := d.FCMToken
-		if len(token) > 10 {
-			token = "..." + token[len(token)-10:]
-		}
-		dev := DeviceOut{
-			ID:        d.ID,
-			FCMToken:  token,
-			Active:    d.Active,
-			CreatedAt: d.CreatedAt.Format("2006-01-02T15:04:05.000Z"),
-			UpdatedAt: d.UpdatedAt.Format("2006-01-02T15:04:05.000Z"),
-		}
-		if d.DeviceID.Valid {
-			dev.DeviceID = &d.DeviceID.String
-		}
-		if d.Platform.Valid {
-			dev.Platform = &d.Platform.String
-		}
-		if d.LastUsed.Valid {
-			lu := d.LastUsed.Time.Format("2006-01-02T15:04:05.000Z")
-			dev.LastUsed = lu
-		}
-		out = append(out, dev)
+		out = append(out, toDeviceOut(d))
 	}
 
 	if out == nil {
@@ -117,3 +116,137 @@ func (s *Server) ListDevices(w http.ResponseWriter, r *http.Request) {
 		Devices: out,
 	})
 }
+
+// UpdateDevice godoc
+// @Summary      Update device labels
+// @Description  Updates the deviceId and/or platform labels of a device registered by the authenticated identity. Omitted fields are left unchanged.
+// @Tags         Devices
+// @Accept       json
+// @Produce      json
+// @Param        id path int true "Device registration id"
+// @Param        request body UpdateDeviceRequest true "Labels to update"
+// @Success      200  {object}  UpdateDeviceResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /devices/{id} [patch]
+func (s *Server) UpdateDevice(w http.ResponseWriter, r *http.Request) {
+	identityKey := getIdentityKey(r)
+	if identityKey == "" {
+		writeError(w, 401, "ERR_AUTHENTICATION_REQUIRED", "Authentication required.")
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		writeError(w, 400, "ERR_INVALID_DEVICE_ID", "Device id must be a positive integer.")
+		return
+	}
+
+	var req UpdateDeviceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, 400, "ERR_INVALID_JSON", "Invalid JSON body")
+		return
+	}
+
+	if req.DeviceID == nil && req.Platform == nil {
+		writeError(w, 400, "ERR_INVALID_REQUEST", "Provide deviceId and/or platform to update.")
+		return
+	}
+
+	if req.Platform != nil && !validPlatforms[*req.Platform] {
+		writeError(w, 400, "ERR_INVALID_PLATFORM", "platform must be one of: ios, android, web")
+		return
+	}
+
+	found, err := s.DB.UpdateDeviceLabels(identityKey, id, req.DeviceID, req.Platform)
+	if err != nil {
+		logger.Error("failed to update device", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to update device.")
+		return
+	}
+	if !found {
+		writeError(w, 404, "ERR_DEVICE_NOT_FOUND", "Device not found.")
+		return
+	}
+
+	dev, err := s.DB.GetDevice(identityKey, id)
+	if err != nil || dev == nil {
+		logger.Error("failed to load updated device", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to retrieve device.")
+		return
+	}
+
+	writeJSON(w, 200, UpdateDeviceResponse{
+		Status: "success",
+		Device: toDeviceOut(*dev),
+	})
+}
+
+// DeleteDevice godoc
+// @Summary      Unregister a device
+// @Description  Removes a device registered by the authenticated identity so it no longer receives push notifications (e.g. on logout).
+// @Tags         Devices
+// @Produce      json
+// @Param        id path int true "Device registration id"
+// @Success      200  {object}  SuccessResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /devices/{id} [delete]
+func (s *Server) DeleteDevice(w http.ResponseWriter, r *http.Request) {
+	identityKey := getIdentityKey(r)
+	if identityKey == "" {
+		writeError(w, 401, "ERR_AUTHENTICATION_REQUIRED", "Authentication required.")
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		writeError(w, 400, "ERR_INVALID_DEVICE_ID", "Device id must be a positive integer.")
+		return
+	}
+
+	found, err := s.DB.DeleteDevice(identityKey, id)
+	if err != nil {
+		logger.Error("failed to delete device", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to delete device.")
+		return
+	}
+	if !found {
+		writeError(w, 404, "ERR_DEVICE_NOT_FOUND", "Device not found.")
+		return
+	}
+
+	writeJSON(w, 200, SuccessResponse{Status: "success"})
+}
+
+// toDeviceOut converts a device record into its response representation with a masked token.
+func toDeviceOut(d db.DeviceRecord) DeviceOut {
+	token := d.FCMToken
+	if len(token) > 10 {
+		token = "..." + token[len(token)-10:]
+	}
+	dev := DeviceOut{
+		ID:        d.ID,
+		FCMToken:  token,
+		Active:    d.Active,
+		CreatedAt: d.CreatedAt.Format("2006-01-02T15:04:05.000Z"),
+		UpdatedAt: d.UpdatedAt.Format("2006-01-02T15:04:05.000Z"),
+	}
+	if d.DeviceID.Valid {
+		dev.DeviceID = &d.DeviceID.String
+	}
+	if d.Platform.Valid {
+		dev.Platform = &d.Platform.String
+	}
+	if d.LastUsed.Valid {
+		lu := d.LastUsed.Time.Format("2006-01-02T15:04:05.000Z")
+		dev.LastUsed = lu
+	}
+	return dev
+}
@@ -3,12 +3,26 @@ package handlers
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"net/http"
 	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/bsv-blockchain/go-message-box-server/pkg/db"
+	"github.com/bsv-blockchain/go-sdk/auth/certificates"
+	ec "github.com/bsv-blockchain/go-sdk/primitives/ec"
+	"github.com/bsv-blockchain/go-sdk/script"
+	"github.com/bsv-blockchain/go-sdk/transaction"
+	"github.com/bsv-blockchain/go-sdk/transaction/template/p2pkh"
+	sdk "github.com/bsv-blockchain/go-sdk/wallet"
+	"github.com/bsv-blockchain/go-wallet-toolbox/pkg/wdk"
 )
 
 // mockIdentityKey is used for tests - we bypass the middleware auth
@@ -108,6 +122,86 @@ func TestAcknowledgeHandler_NoAuth(t *testing.T) {
 	}
 }
 
+func TestNotificationPreferenceHandler_NoAuth(t *testing.T) {
+	srv := setupTestServer(t)
+
+	body, _ := json.Marshal(map[string]any{"messageBox": "inbox", "mode": "always"})
+	req := httptest.NewRequest("POST", "/notificationPreferences/set", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	srv.SetNotificationPreference(w, req)
+
+	if w.Code != 401 {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+}
+
+func TestNotificationFailuresHandler_NoAuth(t *testing.T) {
+	srv := setupTestServer(t)
+
+	req := httptest.NewRequest("GET", "/admin/notifications/failures", nil)
+	w := httptest.NewRecorder()
+	srv.GetNotificationFailures(w, req)
+
+	if w.Code != 401 {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+}
+
+func TestBuildFCMPayload(t *testing.T) {
+	srv := setupTestServer(t)
+
+	mbID, err := srv.DB.EnsureMessageBox(mockIdentityKey, "inbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.DB.InsertMessage("m1", mbID, "sender123", mockIdentityKey, `{}`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Defaults
+	payload := srv.buildFCMPayload(mockIdentityKey, "sender123456789", "inbox", mbID, "m1", nil)
+	if payload.Title != "New Message" || payload.Body != "New message in inbox" {
+		t.Fatalf("unexpected default title/body: %q / %q", payload.Title, payload.Body)
+	}
+	if payload.Originator != "sender123456789" || payload.MessageBox != "inbox" {
+		t.Fatal("originator and message box must be set for the iOS NSE")
+	}
+	if payload.Badge != nil {
+		t.Fatal("badge should be off by default")
+	}
+
+	title := "{{.SenderName}}"
+	body := "{{.UnreadCount}} unread in {{.MessageBox}}"
+	err = srv.DB.SetNotificationPayload(db.NotificationPayloadRecord{
+		IdentityKey:   mockIdentityKey,
+		MessageBox:    "inbox",
+		TitleTemplate: nullString(&title),
+		BodyTemplate:  nullString(&body),
+		Badge:         true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ttl := 60
+	payload = srv.buildFCMPayload(mockIdentityKey, "sender123456789", "inbox", mbID, "m1", &NotificationOptions{
+		SenderName: "Alice",
+		Silent:     true,
+		TTLSeconds: &ttl,
+	})
+	if payload.Title != "Alice" || payload.Body != "1 unread in inbox" {
+		t.Fatalf("unexpected templated title/body: %q / %q", payload.Title, payload.Body)
+	}
+	if payload.Badge == nil || *payload.Badge != 1 {
+		t.Fatal("expected badge of 1")
+	}
+	if !payload.Silent || payload.TTL.Seconds() != 60 {
+		t.Fatal("per-send silent and ttl options should apply")
+	}
+}
+
 func TestPermissionsFlow(t *testing.T) {
 	srv := setupTestServer(t)
 
@@ -156,13 +250,13 @@ func TestQuoteFlow(t *testing.T) {
 		t.Fatalf("expected 10, got %d", fee)
 	}
 
-	// Get recipient fee (will auto-create default for notifications = 10)
+	// Get recipient fee (default policy for notifications = 10)
 	rf, err := srv.DB.GetRecipientFee(mockIdentityKey, "somesender", "notifications")
 	if err != nil {
 		t.Fatal(err)
 	}
 	if rf != 10 {
-		t.Fatalf("expected 10 (smart default), got %d", rf)
+		t.Fatalf("expected 10 (default policy), got %d", rf)
 	}
 }
 
@@ -185,5 +279,1285 @@ func TestInsertDuplicateMessage(t *testing.T) {
 	}
 }
 
+func TestDuplicateMessageIDs(t *testing.T) {
+	srv := setupTestServer(t)
+
+	mbID, _ := srv.DB.EnsureMessageBox(mockIdentityKey, "inbox")
+	if err := srv.DB.InsertMessage("stored", mbID, "sender123", mockIdentityKey, `{}`); err != nil {
+		t.Fatal(err)
+	}
+
+	// checked before any fee is taken, so a resent message costs the sender nothing
+	if dups, err := srv.duplicateMessageIDs([]string{"new1", "new2"}); err != nil || len(dups) != 0 {
+		t.Fatalf("expected no duplicates, got %v, %v", dups, err)
+	}
+	dups, err := srv.duplicateMessageIDs([]string{"new1", "stored", "new1", "new1"})
+	if err != nil || !slices.Equal(dups, []string{"new1", "stored"}) {
+		t.Fatalf("expected the repeated and the stored id, got %v, %v", dups, err)
+	}
+}
+
 // suppress unused import
 var _ = context.Background
+
+func TestClaimDeviceToken(t *testing.T) {
+	srv := setupTestServer(t)
+	const previousOwner = "previous-owner"
+
+	if _, err := srv.DB.RegisterDevice(previousOwner, "shared-token", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	nonce := "pushed-nonce"
+	if err := srv.DB.CreateDeviceChallenge("shared-token", mockIdentityKey, hashChallengeNonce(nonce), nil, nil, time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A wrong nonce is rejected and burns the challenge
+	wrong := "guess"
+	w := httptest.NewRecorder()
+	srv.claimDeviceToken(w, httptest.NewRequest("POST", "/registerDevice", nil), mockIdentityKey,
+		RegisterDeviceRequest{FCMToken: "shared-token", ChallengeNonce: &wrong})
+	if w.Code != 403 {
+		t.Fatalf("expected 403, got %d", w.Code)
+	}
+
+	if err := srv.DB.CreateDeviceChallenge("shared-token", mockIdentityKey, hashChallengeNonce(nonce), nil, nil, time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	srv.claimDeviceToken(w, httptest.NewRequest("POST", "/registerDevice", nil), mockIdentityKey,
+		RegisterDeviceRequest{FCMToken: "shared-token", ChallengeNonce: &nonce})
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	dev, err := srv.DB.GetDeviceByToken("shared-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.IdentityKey != mockIdentityKey {
+		t.Fatalf("expected token to move to the claimant, got %s", dev.IdentityKey)
+	}
+
+	// Both parties get a notice in their notifications box
+	for _, key := range []string{previousOwner, mockIdentityKey} {
+		mbID, err := srv.DB.GetMessageBoxID(key, "notifications")
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs, err := srv.DB.ListMessages(key, mbID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msgs) != 1 {
+			t.Fatalf("expected 1 notice for %s, got %d", key, len(msgs))
+		}
+		var body struct {
+			Message DeviceTransferEvent `json:"message"`
+		}
+		if err := json.Unmarshal([]byte(msgs[0].Body), &body); err != nil {
+			t.Fatal(err)
+		}
+		if body.Message.Type != deviceTransferEventType || body.Message.PreviousIdentityKey != previousOwner {
+			t.Fatalf("unexpected notice: %+v", body.Message)
+		}
+	}
+}
+
+func TestHandleOwnedDeviceToken_Reject(t *testing.T) {
+	srv := setupTestServer(t)
+	srv.deviceTransferPolicy = DeviceTransferPolicyChallenge
+
+	// Without FCM the challenge can't be delivered, so the request is rejected
+	w := httptest.NewRecorder()
+	srv.handleOwnedDeviceToken(w, mockIdentityKey, RegisterDeviceRequest{FCMToken: "shared-token"})
+	if w.Code != 409 {
+		t.Fatalf("expected 409, got %d", w.Code)
+	}
+}
+
+func TestParsePermissionLimits(t *testing.T) {
+	sender := mockIdentityKey
+	future := time.Now().Add(24 * time.Hour).UTC().Format(time.RFC3339)
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	bad := "next week"
+	five, zero := 5, 0
+
+	tests := []struct {
+		name string
+		req  SetPermissionRequest
+		code string
+	}{
+		{"no limits", SetPermissionRequest{}, ""},
+		{"sender with both", SetPermissionRequest{Sender: &sender, ExpiresAt: &future, MaxMessages: &five}, ""},
+		{"box-wide", SetPermissionRequest{MaxMessages: &five}, "ERR_INVALID_REQUEST"},
+		{"past expiry", SetPermissionRequest{Sender: &sender, ExpiresAt: &past}, "ERR_INVALID_EXPIRES_AT"},
+		{"bad expiry", SetPermissionRequest{Sender: &sender, ExpiresAt: &bad}, "ERR_INVALID_EXPIRES_AT"},
+		{"zero messages", SetPermissionRequest{Sender: &sender, MaxMessages: &zero}, "ERR_INVALID_MAX_MESSAGES"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, code, _ := parsePermissionLimits(tt.req)
+			if code != tt.code {
+				t.Fatalf("expected %q, got %q", tt.code, code)
+			}
+		})
+	}
+}
+
+func TestRateLimitHandlers_NoAuth(t *testing.T) {
+	srv := setupTestServer(t)
+
+	w := httptest.NewRecorder()
+	srv.SetRateLimit(w, httptest.NewRequest("POST", "/permissions/rateLimits/set", bytes.NewBufferString(`{}`)))
+	if w.Code != 401 {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	srv.ListRateLimits(w, httptest.NewRequest("GET", "/permissions/rateLimits/list", nil))
+	if w.Code != 401 {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+}
+
+func TestQuoteLimits_RateLimit(t *testing.T) {
+	srv := setupTestServer(t)
+	now := time.Now()
+
+	if err := srv.DB.SetRateLimit(mockIdentityKey, nil, "inbox", 1, 3600); err != nil {
+		t.Fatal(err)
+	}
+	res, err := srv.DB.ResolveRecipientFee(mockIdentityKey, "sender1", "inbox", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limits, err := srv.quoteLimits(res, mockIdentityKey, "sender1", "inbox", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits.RateLimit == nil || limits.RateLimit.Remaining != 1 || limits.RateLimit.RetryAfterSeconds != 0 {
+		t.Fatalf("unexpected rate limit quote: %+v", limits.RateLimit)
+	}
+
+	mbID, _ := srv.DB.EnsureMessageBox(mockIdentityKey, "inbox")
+	msg := db.NewMessage{MessageID: "m1", MessageBoxID: mbID, MessageBox: "inbox", Sender: "sender1", Recipient: mockIdentityKey, Body: "x"}
+	if _, err := srv.DB.InsertMessages([]db.NewMessage{msg}, nil); err != nil {
+		t.Fatal(err)
+	}
+	now = time.Now()
+	limits, err = srv.quoteLimits(res, mockIdentityKey, "sender1", "inbox", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits.RateLimit.Remaining != 0 || limits.RateLimit.RetryAfterSeconds <= 0 {
+		t.Fatalf("expected exhausted limit with retry after, got %+v", limits.RateLimit)
+	}
+}
+
+func TestParseSizePricing(t *testing.T) {
+	fee, blocked := 10, -1
+	two, big, neg, huge := 2, 65536, -5, db.MaxFee+1
+
+	tests := []struct {
+		name string
+		req  SetPermissionRequest
+		code string
+	}{
+		{"flat", SetPermissionRequest{RecipientFee: &fee}, ""},
+		{"per kb", SetPermissionRequest{RecipientFee: &fee, FeePerKB: &two}, ""},
+		{"large payload", SetPermissionRequest{RecipientFee: &fee, LargePayloadThreshold: &big, LargePayloadFee: &two}, ""},
+		{"threshold without fee", SetPermissionRequest{RecipientFee: &fee, LargePayloadThreshold: &big}, "ERR_INVALID_PRICING"},
+		{"negative", SetPermissionRequest{RecipientFee: &fee, FeePerKB: &neg}, "ERR_INVALID_PRICING"},
+		{"blocked", SetPermissionRequest{RecipientFee: &blocked, FeePerKB: &two}, "ERR_INVALID_PRICING"},
+		{"per kb too high", SetPermissionRequest{RecipientFee: &fee, FeePerKB: &huge}, "ERR_INVALID_PRICING"},
+		{"large payload fee too high", SetPermissionRequest{RecipientFee: &fee, LargePayloadThreshold: &big, LargePayloadFee: &huge}, "ERR_INVALID_PRICING"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, code, _ := parseSizePricing(tt.req)
+			if code != tt.code {
+				t.Fatalf("expected %q, got %q", tt.code, code)
+			}
+		})
+	}
+
+	for _, fee := range []int{-2, db.MaxFee + 1} {
+		if _, code, _ := parseSetPermission(SetPermissionRequest{MessageBox: "inbox", RecipientFee: &fee}); code != "ERR_INVALID_FEE" {
+			t.Errorf("recipientFee %d: expected ERR_INVALID_FEE, got %q", fee, code)
+		}
+	}
+}
+
+func TestPermissionBulkHandlers_NoAuth(t *testing.T) {
+	srv := setupTestServer(t)
+
+	w := httptest.NewRecorder()
+	srv.DeletePermission(w, httptest.NewRequest("DELETE", "/permissions?messageBox=inbox", nil))
+	if w.Code != 401 {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	srv.BulkSetPermissions(w, httptest.NewRequest("POST", "/permissions/bulkSet", bytes.NewBufferString(`{}`)))
+	if w.Code != 401 {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	srv.ExportPermissions(w, httptest.NewRequest("GET", "/permissions/export", nil))
+	if w.Code != 401 {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+}
+
+func TestParseBulkPermissions(t *testing.T) {
+	fee := 10
+	bad := "not-a-key"
+	entries := []SetPermissionRequest{
+		{MessageBox: "inbox", RecipientFee: &fee},
+		{MessageBox: "inbox"},
+		{MessageBox: "inbox", RecipientFee: &fee, Sender: &bad},
+		{MessageBox: "app.[a-", RecipientFee: &fee},
+	}
+
+	rules, errs := parseBulkPermissions(entries)
+	if len(rules) != 1 {
+		t.Fatalf("expected 1 valid rule, got %d", len(rules))
+	}
+	expected := []BulkPermissionEntryError{
+		{Index: 1, Code: "ERR_INVALID_REQUEST"},
+		{Index: 2, Code: "ERR_INVALID_PUBLIC_KEY"},
+		{Index: 3, Code: "ERR_INVALID_MESSAGEBOX_PATTERN"},
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got %+v", len(expected), errs)
+	}
+	for i, e := range expected {
+		if errs[i].Index != e.Index || errs[i].Code != e.Code {
+			t.Errorf("error %d: expected %d/%s, got %d/%s", i, e.Index, e.Code, errs[i].Index, errs[i].Code)
+		}
+	}
+}
+
+func TestExportEntryRoundTrip(t *testing.T) {
+	srv := setupTestServer(t)
+	sender := mockIdentityKey
+	max := 3
+	if err := srv.DB.SetMessagePermissionRules("recipient1", []db.PermissionRule{
+		{Sender: &sender, MessageBox: "inbox", RecipientFee: 0, Limits: db.PermissionLimits{MaxMessages: &max}},
+		{MessageBox: "files", RecipientFee: 5, Pricing: db.SizePricing{FeePerKB: 2}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	perms, err := srv.DB.ExportPermissions("recipient1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := make([]SetPermissionRequest, 0, len(perms))
+	for i := range perms {
+		entries = append(entries, toExportEntry(&perms[i]))
+	}
+	if _, errs := parseBulkPermissions(entries); len(errs) > 0 {
+		t.Fatalf("exported entries must be re-applicable, got %+v", errs)
+	}
+
+	w := httptest.NewRecorder()
+	if err := writePermissionsCSV(w, entries); err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(bytes.TrimSpace(w.Body.Bytes()), []byte("\n"))
+	if len(lines) != 3 || string(lines[0]) != "sender,messageBox,recipientFee,expiresAt,maxMessages,feePerKb,largePayloadThreshold,largePayloadFee,powDifficulty" {
+		t.Fatalf("unexpected CSV:\n%s", w.Body.String())
+	}
+}
+
+func TestServerFeeHandlers_NoAuth(t *testing.T) {
+	srv := setupTestServer(t)
+
+	w := httptest.NewRecorder()
+	srv.ListServerFees(w, httptest.NewRequest("GET", "/admin/serverFees", nil))
+	if w.Code != 401 {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	srv.SetServerFee(w, httptest.NewRequest("POST", "/admin/serverFees/set", bytes.NewBufferString(`{}`)))
+	if w.Code != 401 {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	srv.DeleteServerFee(w, httptest.NewRequest("DELETE", "/admin/serverFees?messageBox=inbox", nil))
+	if w.Code != 401 {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	srv.GetServerFeeHistory(w, httptest.NewRequest("GET", "/admin/serverFees/history", nil))
+	if w.Code != 401 {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+}
+
+func TestValidateServerFeeBox(t *testing.T) {
+	tests := []struct {
+		box  string
+		code string
+	}{
+		{"notifications", ""},
+		{"*", ""},
+		{"", "ERR_MISSING_PARAMETERS"},
+		{"app.*", "ERR_INVALID_MESSAGEBOX"},
+	}
+	for _, tt := range tests {
+		if code, _ := validateServerFeeBox(tt.box); code != tt.code {
+			t.Errorf("%q: expected %q, got %q", tt.box, tt.code, code)
+		}
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		query string
+		want  int
+		ok    bool
+	}{
+		{"", 50, true},
+		{"?limit=1", 1, true},
+		{"?limit=500", 500, true},
+		{"?limit=0", 0, false},
+		{"?limit=501", 0, false},
+		{"?limit=ten", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseLimit(httptest.NewRequest("GET", "/payments/earnings"+tt.query, nil), 50, 500)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("%q: got %d, %v", tt.query, got, err)
+		}
+	}
+}
+
+func TestVerifyRecipientPayments(t *testing.T) {
+	addr, err := script.NewAddressFromPublicKeyString(mockIdentityKey, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2pkhScript, err := p2pkh.Lock(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opReturn := script.NewFromBytes([]byte{script.OpFALSE, script.OpRETURN})
+
+	tx := transaction.NewTransaction()
+	tx.AddOutput(&transaction.TransactionOutput{Satoshis: 10, LockingScript: p2pkhScript})   // server fee
+	tx.AddOutput(&transaction.TransactionOutput{Satoshis: 1000, LockingScript: p2pkhScript}) // r1
+	tx.AddOutput(&transaction.TransactionOutput{Satoshis: 1, LockingScript: p2pkhScript})    // r2
+	tx.AddOutput(&transaction.TransactionOutput{Satoshis: 500, LockingScript: opReturn})
+
+	payment := func(index uint32) PaymentOutput {
+		return PaymentOutput{
+			OutputIndex:       index,
+			Protocol:          "wallet payment",
+			PaymentRemittance: &PaymentRemittance{SenderIdentityKey: mockIdentityKey},
+		}
+	}
+	feeRows := []feeRow{{recipient: "r1", recipientFee: 1000}, {recipient: "r2", recipientFee: 1000}}
+	serverIndex := uint32(0)
+
+	underpaid, err := verifyRecipientPayments(tx, mockIdentityKey, map[string][]PaymentOutput{
+		"r1": {payment(1)}, "r2": {payment(2)},
+	}, feeRows, &serverIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(underpaid) != 1 || underpaid[0].recipient != "r2" || underpaid[0].paid != 1 {
+		t.Fatalf("expected r2 to be underpaid with 1 sat, got %+v", underpaid)
+	}
+
+	tests := []struct {
+		name    string
+		outputs map[string][]PaymentOutput
+	}{
+		{"missing output", map[string][]PaymentOutput{"r1": {payment(9)}}},
+		{"server output reused", map[string][]PaymentOutput{"r1": {payment(0)}}},
+		{"output shared", map[string][]PaymentOutput{"r1": {payment(1)}, "r2": {payment(1)}}},
+		{"not p2pkh", map[string][]PaymentOutput{"r1": {payment(3)}}},
+		{"other sender", map[string][]PaymentOutput{"r1": {{OutputIndex: 1, Protocol: "wallet payment", PaymentRemittance: &PaymentRemittance{SenderIdentityKey: "02other"}}}}},
+		{"basket insertion", map[string][]PaymentOutput{"r1": {{OutputIndex: 1, Protocol: "basket insertion", InsertionRemittance: &InsertionRemittance{Basket: "fees"}}}}},
+		{"unknown protocol", map[string][]PaymentOutput{"r1": {{OutputIndex: 1, Protocol: "other", PaymentRemittance: &PaymentRemittance{SenderIdentityKey: mockIdentityKey}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := verifyRecipientPayments(tx, mockIdentityKey, tt.outputs, feeRows, &serverIndex)
+			var omErr *OutputMappingError
+			if !errors.As(err, &omErr) || omErr.Code != "ERR_INVALID_PAYMENT_OUTPUT" {
+				t.Fatalf("expected ERR_INVALID_PAYMENT_OUTPUT, got %v", err)
+			}
+		})
+	}
+
+	if _, err := parsePaymentTx([]byte{1, 2, 3}); err == nil {
+		t.Fatal("expected malformed BEEF to be rejected")
+	}
+}
+
+func TestVerifyDeliveryFee(t *testing.T) {
+	tx := transaction.NewTransaction()
+	tx.AddOutput(&transaction.TransactionOutput{Satoshis: 1000, LockingScript: script.NewFromBytes([]byte{script.OpTRUE})})
+	tx.AddOutput(&transaction.TransactionOutput{Satoshis: 5, LockingScript: script.NewFromBytes([]byte{script.OpTRUE})})
+
+	declared := uint32(1)
+	payment := &Payment{
+		Outputs:                []PaymentOutput{{OutputIndex: 0}, {OutputIndex: 1}},
+		DeliveryFeeOutputIndex: &declared,
+	}
+
+	idx, err := deliveryFeeOutput(payment)
+	if err != nil || idx != 1 {
+		t.Fatalf("expected declared output at position 1, got %d, %v", idx, err)
+	}
+	var omErr *OutputMappingError
+	if err := verifyDeliveryFee(tx, payment.Outputs[idx], 10); !errors.As(err, &omErr) || omErr.Code != "ERR_INSUFFICIENT_DELIVERY_FEE" {
+		t.Fatalf("expected ERR_INSUFFICIENT_DELIVERY_FEE, got %v", err)
+	}
+	if err := verifyDeliveryFee(tx, payment.Outputs[idx], 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyDeliveryFee(tx, PaymentOutput{OutputIndex: 7}, 5); !errors.As(err, &omErr) || omErr.Code != "ERR_INVALID_PAYMENT_OUTPUT" {
+		t.Fatalf("expected ERR_INVALID_PAYMENT_OUTPUT, got %v", err)
+	}
+
+	// The declared server output is skipped when mapping recipient outputs positionally
+	mapped, err := buildPerRecipientOutputs(payment.Outputs, idx, []feeRow{{recipient: "r1", recipientFee: 100}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mapped["r1"]) != 1 || mapped["r1"][0].OutputIndex != 0 {
+		t.Fatalf("expected r1 to get output 0, got %+v", mapped["r1"])
+	}
+
+	missing := uint32(9)
+	payment.DeliveryFeeOutputIndex = &missing
+	if _, err := deliveryFeeOutput(payment); !errors.As(err, &omErr) || omErr.Code != "ERR_INVALID_PAYMENT_OUTPUT" {
+		t.Fatalf("expected undeclared index to be rejected, got %v", err)
+	}
+}
+
+func TestSignedQuotes(t *testing.T) {
+	d := setupTestServer(t).DB
+	key, err := ec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := sdk.NewCompletedProtoWallet(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(d, w, WithQuoteTTL(time.Minute))
+	ctx := context.Background()
+	now := time.Now()
+
+	id, expiresAt, err := s.issueQuote(ctx, mockIdentityKey, "inbox", 100, 5, []quotedRecipient{{Recipient: "recipient1", RecipientFee: 20}}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !expiresAt.After(now) {
+		t.Fatalf("expected expiry after now, got %v", expiresAt)
+	}
+
+	q, err := s.verifyQuote(ctx, id, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, ok := q.recipient("recipient1"); !ok || r.RecipientFee != 20 || q.DeliveryFee != 5 {
+		t.Fatalf("unexpected quote %+v", q)
+	}
+	if err := q.matches(mockIdentityKey, "inbox", []string{"recipient1"}, 80); err != nil {
+		t.Fatal(err)
+	}
+
+	var qErr *quoteError
+	if err := q.matches(mockIdentityKey, "inbox", []string{"recipient1"}, 101); !errors.As(err, &qErr) || qErr.code != "ERR_QUOTE_MISMATCH" {
+		t.Fatalf("expected ERR_QUOTE_MISMATCH for a larger body, got %v", err)
+	}
+	if err := q.matches(mockIdentityKey, "inbox", []string{"recipient2"}, 80); !errors.As(err, &qErr) || qErr.code != "ERR_QUOTE_MISMATCH" {
+		t.Fatalf("expected ERR_QUOTE_MISMATCH for other recipients, got %v", err)
+	}
+
+	if _, err := s.verifyQuote(ctx, id, now.Add(2*time.Minute)); !errors.As(err, &qErr) || qErr.code != "ERR_QUOTE_EXPIRED" {
+		t.Fatalf("expected ERR_QUOTE_EXPIRED, got %v", err)
+	}
+
+	// Changing a fee in the payload invalidates the signature
+	payload, sig, _ := strings.Cut(id, ".")
+	raw, _ := base64.RawURLEncoding.DecodeString(payload)
+	tampered := base64.RawURLEncoding.EncodeToString(bytes.Replace(raw, []byte(`"recipientFee":20`), []byte(`"recipientFee":0`), 1))
+	if _, err := s.verifyQuote(ctx, tampered+"."+sig, now); !errors.As(err, &qErr) || qErr.code != "ERR_INVALID_QUOTE" {
+		t.Fatalf("expected ERR_INVALID_QUOTE, got %v", err)
+	}
+	if _, err := s.verifyQuote(ctx, "garbage", now); !errors.As(err, &qErr) || qErr.code != "ERR_INVALID_QUOTE" {
+		t.Fatalf("expected ERR_INVALID_QUOTE, got %v", err)
+	}
+}
+
+func TestQuoteBoundToPermission(t *testing.T) {
+	d := setupTestServer(t).DB
+	recipient, sender := "recipient1", mockIdentityKey
+	if err := d.SetMessagePermission(recipient, nil, "inbox", 50); err != nil {
+		t.Fatal(err)
+	}
+	one := 1
+	if err := d.SetMessagePermissionRule(recipient, &sender, "inbox", 0, db.PermissionLimits{MaxMessages: &one}, db.SizePricing{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// two quotes taken while the capped rule has one free message left
+	res, err := d.ResolveRecipientFee(recipient, sender, "inbox", time.Now())
+	if err != nil || res.Permission == nil {
+		t.Fatalf("expected the capped rule, got %+v, %v", res, err)
+	}
+	first, second := newQuotedRecipient(recipient, 0, res), newQuotedRecipient(recipient, 0, res)
+	if !first.pricedBy(res) {
+		t.Fatal("expected the quote to hold while its rule applies")
+	}
+
+	// the first send uses up the cap
+	mbID, _ := d.EnsureMessageBox(recipient, "inbox")
+	if _, err := d.InsertMessages([]db.NewMessage{{MessageID: "m1", MessageBoxID: mbID, MessageBox: "inbox", Sender: sender, Recipient: recipient, Body: `{}`, PermissionID: first.PermissionID}}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the second quote's free fee no longer applies; a new quote prices the box-wide rule
+	res, err = d.ResolveRecipientFee(recipient, sender, "inbox", time.Now())
+	if err != nil || res.Permission != nil || res.Fee != 50 {
+		t.Fatalf("expected the box-wide rule once the cap is used up, got %+v, %v", res, err)
+	}
+	if second.pricedBy(res) {
+		t.Fatal("expected a quote priced by a used up rule to be stale")
+	}
+	if fresh := newQuotedRecipient(recipient, res.Fee, res); !fresh.pricedBy(res) {
+		t.Fatal("expected a new quote to hold")
+	}
+}
+
+func TestPaymentHandlers_NoAuth(t *testing.T) {
+	srv := setupTestServer(t)
+
+	w := httptest.NewRecorder()
+	srv.ListEarnings(w, httptest.NewRequest("GET", "/payments/earnings", nil))
+	if w.Code != 401 {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	srv.GetDeliveryFeeRevenue(w, httptest.NewRequest("GET", "/admin/payments/revenue", nil))
+	if w.Code != 401 {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+}
+
+func TestParsePaymentFilter(t *testing.T) {
+	f, code, _ := parsePaymentFilter(httptest.NewRequest("GET", "/payments/earnings?messageBox=inbox&since=2024-01-01T00:00:00Z", nil))
+	if code != "" || f.MessageBox != "inbox" || f.Since.IsZero() || !f.Until.IsZero() {
+		t.Fatalf("unexpected filter %+v, %s", f, code)
+	}
+	if _, code, _ := parsePaymentFilter(httptest.NewRequest("GET", "/payments/earnings?until=yesterday", nil)); code != "ERR_INVALID_TIME" {
+		t.Fatalf("expected ERR_INVALID_TIME, got %q", code)
+	}
+	if _, code, _ := parsePaymentFilter(httptest.NewRequest("GET", "/payments/earnings?since=2024-02-01T00:00:00Z&until=2024-01-01T00:00:00Z", nil)); code != "ERR_INVALID_TIME" {
+		t.Fatalf("expected ERR_INVALID_TIME for until before since, got %q", code)
+	}
+}
+
+func TestRefundHandlers_NoAuth(t *testing.T) {
+	srv := setupTestServer(t)
+
+	w := httptest.NewRecorder()
+	srv.RejectPayment(w, httptest.NewRequest("POST", "/payments/reject", bytes.NewBufferString(`{"messageId":"m1"}`)))
+	if w.Code != 401 {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	srv.ListRefunds(w, httptest.NewRequest("GET", "/payments/refunds", nil))
+	if w.Code != 401 {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+}
+
+func TestRefundLockingScript(t *testing.T) {
+	serverKey, _ := ec.NewPrivateKey()
+	payeeKey, _ := ec.NewPrivateKey()
+	serverWallet, _ := sdk.NewCompletedProtoWallet(serverKey)
+	payeeWallet, _ := sdk.NewCompletedProtoWallet(payeeKey)
+	srv := NewServer(setupTestServer(t).DB, serverWallet)
+	ctx := context.Background()
+
+	prefix, _ := newDerivationNonce()
+	suffix, _ := newDerivationNonce()
+	lockingScript, err := srv.refundLockingScript(ctx, payeeKey.PubKey(), prefix, suffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The payee derives the same key from the remittance, as its wallet does when internalizing
+	forSelf := true
+	derived, err := payeeWallet.GetPublicKey(ctx, sdk.GetPublicKeyArgs{
+		EncryptionArgs: sdk.EncryptionArgs{
+			ProtocolID:   walletPaymentProtocol,
+			KeyID:        prefix + " " + suffix,
+			Counterparty: sdk.Counterparty{Type: sdk.CounterpartyTypeOther, Counterparty: serverKey.PubKey()},
+		},
+		ForSelf: &forSelf,
+	}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, _ := script.NewAddressFromPublicKey(derived.PublicKey, false)
+	expected, _ := p2pkh.Lock(addr)
+	if !bytes.Equal(lockingScript.Bytes(), expected.Bytes()) {
+		t.Fatal("refund is not locked to the key the payee derives")
+	}
+}
+
+func TestClaimRefundOutputs(t *testing.T) {
+	srv := setupTestServer(t)
+	outputs := []PaymentOutput{{OutputIndex: 0}}
+	first := &db.PaymentRecord{ID: 1, Sender: "sender1", MessageBox: "inbox"}
+	second := &db.PaymentRecord{ID: 2, Sender: "sender1", MessageBox: "inbox"}
+
+	claimed, replayed, err := srv.claimRefundOutputs("refund-tx", mockIdentityKey, first, outputs)
+	if err != nil || len(replayed) != 0 || len(claimed) != 1 || claimed[0].Recipient.String != "sender1" {
+		t.Fatalf("expected the refund output to be claimed, got %+v %+v, %v", claimed, replayed, err)
+	}
+	// the same refund cannot pay for another payment from the sender
+	if again, replayed, err := srv.claimRefundOutputs("refund-tx", mockIdentityKey, second, outputs); err != nil || len(again) != 0 || len(replayed) != 1 {
+		t.Fatalf("expected the refund output to be replayed, got %+v %+v, %v", again, replayed, err)
+	}
+	// a refund that could not be recorded frees its outputs
+	srv.releaseOutputs(claimed, false)
+	if _, replayed, err := srv.claimRefundOutputs("refund-tx", mockIdentityKey, second, outputs); err != nil || len(replayed) != 0 {
+		t.Fatalf("expected released outputs to be claimable, got %+v, %v", replayed, err)
+	}
+}
+
+func TestWriteDeliveryFailure_RecordsRefund(t *testing.T) {
+	srv := setupTestServer(t)
+	paid := db.PaymentRecord{TxID: "tx1", Kind: db.PaymentKindDeliveryFee, Sender: mockIdentityKey, MessageBox: "inbox", Fee: 10, Amount: 10, Status: db.PaymentStatusInternalized}
+	id, err := srv.DB.InsertPayment(paid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paid.ID = id
+
+	w := httptest.NewRecorder()
+	srv.writeDeliveryFailure(w, httptest.NewRequest("POST", "/sendMessage", nil), 400, "ERR_DUPLICATE_MESSAGE", "Duplicate message.", &paid, nil)
+	if w.Code != 400 {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+	var resp DeliveryFailedError
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	// Without a wallet the refund cannot be created, but the attempt is returned and recorded for operators
+	if resp.Code != "ERR_DUPLICATE_MESSAGE" || resp.Refund == nil || resp.Refund.Status != db.RefundStatusFailed || resp.Refund.Amount != 10 {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	refunds, _ := srv.DB.ListRefunds(mockIdentityKey, 10)
+	if len(refunds) != 1 || refunds[0].Reason != db.RefundReasonDeliveryFailed {
+		t.Fatalf("expected a recorded delivery refund, got %+v", refunds)
+	}
+
+	w = httptest.NewRecorder()
+	srv.writeDeliveryFailure(w, httptest.NewRequest("POST", "/sendMessage", nil), 500, "ERR_INTERNAL", "An internal error has occurred.", nil, nil)
+	if bytes.Contains(w.Body.Bytes(), []byte("refund")) {
+		t.Fatal("expected no refund when no delivery fee was taken")
+	}
+}
+
+type fakeTxStatus map[string]int
+
+func (f fakeTxStatus) GetStatusForTxIDs(_ context.Context, txIDs []string) (*wdk.GetStatusForTxIDsResult, error) {
+	res := &wdk.GetStatusForTxIDsResult{}
+	for _, txid := range txIDs {
+		depth, ok := f[txid]
+		if !ok {
+			return nil, wdk.ErrNotFoundError
+		}
+		if depth < 0 {
+			return nil, errors.New("services unavailable")
+		}
+		res.Results = append(res.Results, wdk.TxStatusDetail{TxID: txid, Depth: &depth})
+	}
+	return res, nil
+}
+
+func TestPaymentReplay(t *testing.T) {
+	srv := NewServer(setupTestServer(t).DB, nil, WithPaymentReplayWindow(fakeTxStatus{"deep": 6, "shallow": 5, "down": -1}, 6))
+	ctx := context.Background()
+
+	if status, mErr := srv.checkPaymentDepth(ctx, "deep"); mErr == nil || status != 409 || mErr.Code != "ERR_PAYMENT_REPLAYED" {
+		t.Fatalf("expected ERR_PAYMENT_REPLAYED, got %d %+v", status, mErr)
+	}
+	if _, mErr := srv.checkPaymentDepth(ctx, "shallow"); mErr != nil {
+		t.Fatalf("expected shallow payment to pass, got %+v", mErr)
+	}
+	// a failed lookup refuses the payment rather than risk a pruned replay
+	if status, mErr := srv.checkPaymentDepth(ctx, "down"); mErr == nil || status != 503 || mErr.Code != "ERR_PAYMENT_STATUS_UNAVAILABLE" {
+		t.Fatalf("expected ERR_PAYMENT_STATUS_UNAVAILABLE, got %d %+v", status, mErr)
+	}
+	if _, mErr := srv.checkPaymentDepth(ctx, "unknown"); mErr != nil {
+		t.Fatalf("expected unknown payment to pass, got %+v", mErr)
+	}
+
+	outputs := []PaymentOutput{{OutputIndex: 0}, {OutputIndex: 1}}
+	spent := paymentSpentOutputs("tx1", mockIdentityKey, "inbox", outputs, 0, map[string][]PaymentOutput{"recipient1": outputs[1:]})
+	if len(spent) != 2 || spent[0].Recipient.Valid || spent[1].Recipient.String != "recipient1" || spent[1].OutputIndex != 1 {
+		t.Fatalf("unexpected spent outputs %+v", spent)
+	}
+	if _, err := srv.DB.ClaimPaymentOutputs(spent); err != nil {
+		t.Fatal(err)
+	}
+
+	// A stored delivery fee stays spent while recipient outputs are released
+	srv.releaseOutputs(spent, true)
+	replayed, err := srv.DB.ClaimPaymentOutputs(spent)
+	if err != nil || len(replayed) != 1 || replayed[0].OutputIndex != 0 {
+		t.Fatalf("expected only the delivery fee output to remain spent, got %+v, %v", replayed, err)
+	}
+	if got := replayedOutpoints(replayed); got != "tx1.0" {
+		t.Fatalf("unexpected outpoints %q", got)
+	}
+}
+
+func TestRoutePriceHandlers_NoAuth(t *testing.T) {
+	srv := setupTestServer(t)
+
+	w := httptest.NewRecorder()
+	srv.ListRoutePrices(w, httptest.NewRequest("GET", "/routePrices", nil))
+	if w.Code != 401 {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	srv.SetRoutePrice(w, httptest.NewRequest("POST", "/admin/routePrices/set", strings.NewReader(`{}`)))
+	if w.Code != 401 {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	srv.DeleteRoutePrice(w, httptest.NewRequest("DELETE", "/admin/routePrices?route=/listMessages&messageBox=inbox", nil))
+	if w.Code != 401 {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+}
+
+func TestValidateRoutePrice(t *testing.T) {
+	tests := []struct {
+		route, box, code string
+	}{
+		{"/listMessages", "inbox", ""},
+		{"/permissions/quote", "*", ""},
+		{"", "inbox", "ERR_MISSING_PARAMETERS"},
+		{"/admin/serverFees", "inbox", "ERR_INVALID_ROUTE"},
+		{"/listMessages", "app.*", "ERR_INVALID_MESSAGEBOX"},
+	}
+	for _, tt := range tests {
+		if code, _ := validateRoutePrice(tt.route, tt.box); code != tt.code {
+			t.Errorf("validateRoutePrice(%q, %q) = %q, want %q", tt.route, tt.box, code, tt.code)
+		}
+	}
+}
+
+func TestRoutePriceCalculator(t *testing.T) {
+	srv := setupTestServer(t)
+	price := srv.RoutePriceCalculator("/api")
+
+	listMessages := func(box string) int {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/api/listMessages", strings.NewReader(`{"messageBox":"`+box+`"}`))
+		p, err := price(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The handler must still be able to read the body
+		var body ListMessagesRequest
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil || body.MessageBox != box {
+			t.Fatalf("expected body to be readable after pricing, got %+v, %v", body, err)
+		}
+		return p
+	}
+
+	if p := listMessages("heavy"); p != 0 {
+		t.Fatalf("expected unpriced route to be free, got %d", p)
+	}
+
+	if err := srv.DB.SetRoutePrice("/listMessages", "heavy", 20, 1, 3600); err != nil {
+		t.Fatal(err)
+	}
+	if p := listMessages("heavy"); p != 0 {
+		t.Fatalf("expected the free request first, got %d", p)
+	}
+	if p := listMessages("heavy"); p != 20 {
+		t.Fatalf("expected 20 after the free tier, got %d", p)
+	}
+	if p := listMessages("inbox"); p != 0 {
+		t.Fatalf("expected other boxes to stay free, got %d", p)
+	}
+
+	if err := srv.DB.SetRoutePrice("/permissions/quote", db.DefaultRoutePriceBox, 3, 0, 3600); err != nil {
+		t.Fatal(err)
+	}
+	p, err := price(httptest.NewRequest("GET", "/api/permissions/quote?recipient=x&messageBox=inbox", nil))
+	if err != nil || p != 3 {
+		t.Fatalf("expected the default quote price, got %d, %v", p, err)
+	}
+}
+
+func TestCreditHandlers_NoAuth(t *testing.T) {
+	srv := setupTestServer(t)
+
+	for _, tc := range []struct {
+		name    string
+		handler func(w http.ResponseWriter, r *http.Request)
+		method  string
+		path    string
+	}{
+		{"deposit", srv.DepositCredit, "POST", "/credits/deposit"},
+		{"balance", srv.GetCreditBalance, "GET", "/credits/balance"},
+		{"ledger", srv.ListCreditLedger, "GET", "/credits/ledger"},
+		{"withdraw", srv.WithdrawCredit, "POST", "/credits/withdraw"},
+		{"payouts", srv.ListCreditPayouts, "GET", "/credits/payouts"},
+	} {
+		w := httptest.NewRecorder()
+		tc.handler(w, httptest.NewRequest(tc.method, tc.path, strings.NewReader(`{}`)))
+		if w.Code != 401 {
+			t.Errorf("%s: expected 401, got %d", tc.name, w.Code)
+		}
+	}
+}
+
+func TestCreditDebits(t *testing.T) {
+	rows := []feeRow{
+		{recipient: "recipient1", recipientFee: 5, allowed: true},
+		{recipient: "recipient2", recipientFee: 0, allowed: true},
+	}
+	debits := creditDebits("inbox", 10, rows, []string{"m1", "m2"})
+	if len(debits) != 2 {
+		t.Fatalf("expected the delivery fee and one recipient fee, got %+v", debits)
+	}
+	if debits[0].Kind != db.CreditKindDeliveryFee || debits[0].Amount != 10 {
+		t.Errorf("unexpected delivery fee debit %+v", debits[0])
+	}
+	if debits[1].Kind != db.CreditKindRecipientFee || debits[1].Amount != 5 || debits[1].Recipient.String != "recipient1" || debits[1].MessageID.String != "m1" {
+		t.Errorf("unexpected recipient fee debit %+v", debits[1])
+	}
+}
+
+func TestValidateDepositOutput(t *testing.T) {
+	payment := func(index uint32, sender string) PaymentOutput {
+		return PaymentOutput{
+			OutputIndex:       index,
+			Protocol:          string(sdk.InternalizeProtocolWalletPayment),
+			PaymentRemittance: &PaymentRemittance{SenderIdentityKey: sender},
+		}
+	}
+	seen := map[uint32]bool{1: true}
+	tests := []struct {
+		name string
+		out  PaymentOutput
+		code string
+	}{
+		{"valid", payment(0, mockIdentityKey), ""},
+		{"missing output", payment(2, mockIdentityKey), "ERR_INVALID_PAYMENT_OUTPUT"},
+		{"listed twice", payment(1, mockIdentityKey), "ERR_INVALID_PAYMENT_OUTPUT"},
+		{"other sender", payment(0, "02other"), "ERR_INVALID_PAYMENT_OUTPUT"},
+		{"basket insertion", PaymentOutput{OutputIndex: 0, Protocol: string(sdk.InternalizeProtocolBasketInsertion)}, "ERR_INVALID_PAYMENT_OUTPUT"},
+	}
+	for _, tt := range tests {
+		if code, _ := validateDepositOutput(tt.out, mockIdentityKey, 2, seen); code != tt.code {
+			t.Errorf("%s: got %q, want %q", tt.name, code, tt.code)
+		}
+	}
+}
+
+func TestSettleRecipientCredits_NoWallet(t *testing.T) {
+	srv := setupTestServer(t)
+	if _, err := srv.DB.DepositCredit(mockIdentityKey, 100, "tx"); err != nil {
+		t.Fatal(err)
+	}
+	ids, _, err := srv.DB.DebitCredit(mockIdentityKey, creditDebits("inbox", 0, []feeRow{{recipient: "recipient1", recipientFee: 50, allowed: true}}, []string{"m1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mbID, _ := srv.DB.EnsureMessageBox("recipient1", "inbox")
+	msg := db.NewMessage{MessageID: "m1", MessageBoxID: mbID, MessageBox: "inbox", Sender: mockIdentityKey, Recipient: "recipient1", Body: "x"}
+	if _, err := srv.DB.InsertMessages([]db.NewMessage{msg}, ids); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a wallet nothing is paid and the fees stay owed
+	if n, err := srv.SettleRecipientCredits(context.Background(), 1); err != nil || n != 0 {
+		t.Fatalf("expected no settlement, got %d, %v", n, err)
+	}
+	if _, owed, _ := srv.DB.CreditBalance("recipient1"); owed != 50 {
+		t.Fatalf("expected 50 still owed, got %d", owed)
+	}
+}
+
+func TestSubscriptionHandlers_NoAuth(t *testing.T) {
+	srv := setupTestServer(t)
+
+	w := httptest.NewRecorder()
+	srv.SetSubscriptionOffer(w, httptest.NewRequest("POST", "/permissions/subscriptions/set", strings.NewReader(`{}`)))
+	if w.Code != 401 {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	srv.ListSubscriptionOffers(w, httptest.NewRequest("GET", "/permissions/subscriptions/list", nil))
+	if w.Code != 401 {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+}
+
+func TestValidateSubscriptionOffer(t *testing.T) {
+	intPtr := func(v int) *int { return &v }
+	tests := []struct {
+		req  SetSubscriptionOfferRequest
+		days int
+		code string
+	}{
+		{SetSubscriptionOfferRequest{MessageBox: "inbox", Price: intPtr(5000)}, 30, ""},
+		{SetSubscriptionOfferRequest{MessageBox: "inbox", Price: intPtr(0), DurationDays: intPtr(7)}, 7, ""},
+		{SetSubscriptionOfferRequest{MessageBox: "inbox"}, 0, "ERR_INVALID_REQUEST"},
+		{SetSubscriptionOfferRequest{MessageBox: "app.*", Price: intPtr(5000)}, 0, "ERR_INVALID_MESSAGEBOX"},
+		{SetSubscriptionOfferRequest{MessageBox: "inbox", Price: intPtr(-1)}, 0, "ERR_INVALID_FEE"},
+		{SetSubscriptionOfferRequest{MessageBox: "inbox", Price: intPtr(5000), DurationDays: intPtr(0)}, 0, "ERR_INVALID_DURATION"},
+		{SetSubscriptionOfferRequest{MessageBox: "inbox", Price: intPtr(5000), DurationDays: intPtr(366)}, 0, "ERR_INVALID_DURATION"},
+	}
+	for i, tt := range tests {
+		if days, code, _ := validateSubscriptionOffer(tt.req); code != tt.code || days != tt.days {
+			t.Errorf("case %d: got %d, %q, want %d, %q", i, days, code, tt.days, tt.code)
+		}
+	}
+}
+
+func TestQuoteLimitsSubscription(t *testing.T) {
+	srv := setupTestServer(t)
+	sender := "sender123"
+	now := time.Now()
+
+	quote := func() *SubscriptionQuote {
+		t.Helper()
+		res, err := srv.DB.ResolveRecipientFee(mockIdentityKey, sender, "inbox", now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		limits, err := srv.quoteLimits(res, mockIdentityKey, sender, "inbox", now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return limits.Subscription
+	}
+
+	if q := quote(); q != nil {
+		t.Fatalf("expected no subscription without an offer, got %+v", q)
+	}
+	if err := srv.DB.SetSubscriptionOffer(mockIdentityKey, "inbox", 5000, 30); err != nil {
+		t.Fatal(err)
+	}
+	if q := quote(); q == nil || q.Price != 5000 || q.DurationDays != 30 {
+		t.Fatalf("expected the offer in the quote, got %+v", q)
+	}
+
+	// Blocked senders are not offered a subscription
+	if err := srv.DB.SetMessagePermission(mockIdentityKey, &sender, "inbox", -1); err != nil {
+		t.Fatal(err)
+	}
+	if q := quote(); q != nil {
+		t.Fatalf("expected no subscription for a blocked sender, got %+v", q)
+	}
+}
+
+func TestReputationHandlers_NoAuth(t *testing.T) {
+	srv := setupTestServer(t)
+
+	w := httptest.NewRecorder()
+	srv.ReportSpam(w, httptest.NewRequest("POST", "/report", strings.NewReader(`{"messageId":"m1"}`)))
+	if w.Code != 401 {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	srv.GetReputation(w, httptest.NewRequest("GET", "/reputation", nil))
+	if w.Code != 401 {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	srv.ClearReputation(w, httptest.NewRequest("DELETE", "/admin/reputation?identityKey="+mockIdentityKey, nil))
+	if w.Code != 401 {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+}
+
+func TestSenderReputation(t *testing.T) {
+	srv := setupTestServer(t)
+	srv.spamPolicy = SpamPolicy{Window: time.Hour, RaiseFeesAt: 1, FeeMultiplier: 10, RequirePaymentAt: 2, MinRecipientFee: 100, BanAt: 3}
+	now := time.Now()
+
+	boxWide := &db.FeeResolution{Fee: 5}
+	senderRule := &db.FeeResolution{Fee: 5, Permission: &db.PermissionRecord{}}
+
+	rep, err := srv.reputation("spammer", now)
+	if err != nil || rep.Level != ReputationGood || rep.recipientFee(5, boxWide) != 5 {
+		t.Fatalf("expected a good reputation without reports, got %+v, %v", rep, err)
+	}
+
+	tests := []struct {
+		level         string
+		boxFee        int // fee 5 from a box-wide rule
+		freeFee       int // fee 0 from a free box
+		senderRuleFee int
+	}{
+		{ReputationRaisedFees, 50, 0, 5},
+		{ReputationPaymentRequired, 100, 100, 5},
+		{ReputationBanned, 100, 100, 5},
+	}
+	for i, tt := range tests {
+		recipient := fmt.Sprintf("recipient%d", i)
+		mbID, err := srv.DB.EnsureMessageBox(recipient, "inbox")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := srv.DB.InsertMessage(fmt.Sprintf("spam-%d", i), mbID, "spammer", recipient, `{}`); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := srv.DB.ReportSpam(recipient, fmt.Sprintf("spam-%d", i), now); err != nil {
+			t.Fatal(err)
+		}
+
+		rep, err := srv.reputation("spammer", now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rep.Level != tt.level || rep.Reporters != i+1 {
+			t.Fatalf("after %d reports: expected %s, got %+v", i+1, tt.level, rep)
+		}
+		if fee := rep.recipientFee(5, boxWide); fee != tt.boxFee {
+			t.Errorf("%s: box-wide fee %d, want %d", tt.level, fee, tt.boxFee)
+		}
+		if fee := rep.recipientFee(0, &db.FeeResolution{}); fee != tt.freeFee {
+			t.Errorf("%s: free box fee %d, want %d", tt.level, fee, tt.freeFee)
+		}
+		if fee := rep.recipientFee(5, senderRule); fee != tt.senderRuleFee {
+			t.Errorf("%s: sender rule fee %d, want %d", tt.level, fee, tt.senderRuleFee)
+		}
+		if fee := rep.recipientFee(-1, boxWide); fee != -1 {
+			t.Errorf("%s: expected a block to stay a block, got %d", tt.level, fee)
+		}
+	}
+
+	resp := toReputationResponse(senderReputation{Level: ReputationGood}, SpamPolicy{Window: time.Hour, BanAt: 3})
+	if resp.BanAt == nil || resp.RaiseFeesAt != nil || resp.FeeMultiplier != 0 {
+		t.Fatalf("expected only enabled thresholds, got %+v", resp)
+	}
+}
+
+func TestVerifyProofOfWork(t *testing.T) {
+	const difficulty = 8
+	sender, recipient := mockIdentityKey, "03bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+
+	nonce := ""
+	for i := 0; nonce == ""; i++ {
+		if candidate := strconv.Itoa(i); leadingZeroBits(proofOfWorkDigest(sender, recipient, "inbox", "msg-1", candidate)) >= difficulty {
+			nonce = candidate
+		}
+	}
+
+	if !verifyProofOfWork(sender, recipient, "inbox", "msg-1", nonce, difficulty) {
+		t.Fatal("expected the proof to verify")
+	}
+	if !verifyProofOfWork(sender, recipient, "inbox", "msg-1", "", 0) {
+		t.Fatal("expected no proof to be needed without a difficulty")
+	}
+	if verifyProofOfWork(sender, recipient, "inbox", "msg-1", "", difficulty) {
+		t.Fatal("expected a missing proof to fail")
+	}
+	if verifyProofOfWork(sender, recipient, "inbox", "msg-1", strings.Repeat("0", maxPowNonceLength+1), difficulty) {
+		t.Fatal("expected an oversized nonce to fail")
+	}
+
+	// the proof is bound to the message it was made for
+	if verifyProofOfWork(sender, recipient, "inbox", "msg-2", nonce, difficulty) {
+		t.Fatal("expected the proof not to carry over to another messageId")
+	}
+	if verifyProofOfWork(recipient, sender, "inbox", "msg-1", nonce, difficulty) {
+		t.Fatal("expected the proof not to carry over to another sender")
+	}
+
+	if n := leadingZeroBits([32]byte{0, 0x10}); n != 11 {
+		t.Fatalf("expected 11 leading zero bits, got %d", n)
+	}
+}
+
+func TestParseSetPermissionPowDifficulty(t *testing.T) {
+	fee, blocked, difficulty, tooHard := 0, -1, 20, maxPowDifficulty+1
+
+	rule, code, _ := parseSetPermission(SetPermissionRequest{MessageBox: "inbox", RecipientFee: &fee, PowDifficulty: &difficulty})
+	if code != "" || rule.PowDifficulty != 20 {
+		t.Fatalf("expected a valid rule requiring 20 bits, got %+v, %s", rule, code)
+	}
+	if _, code, _ := parseSetPermission(SetPermissionRequest{MessageBox: "inbox", RecipientFee: &fee, PowDifficulty: &tooHard}); code != "ERR_INVALID_POW_DIFFICULTY" {
+		t.Fatalf("expected ERR_INVALID_POW_DIFFICULTY, got %q", code)
+	}
+	if _, code, _ := parseSetPermission(SetPermissionRequest{MessageBox: "inbox", RecipientFee: &blocked, PowDifficulty: &difficulty}); code != "ERR_INVALID_REQUEST" {
+		t.Fatalf("expected a blocking rule with a difficulty to be rejected, got %q", code)
+	}
+}
+
+func TestCertificateHandlers_NoAuth(t *testing.T) {
+	srv := setupTestServer(t)
+
+	for name, h := range map[string]http.HandlerFunc{
+		"PresentCertificates":         srv.PresentCertificates,
+		"ListCertificates":            srv.ListCertificates,
+		"DeleteCertificates":          srv.DeleteCertificates,
+		"SetCertificateRequirement":   srv.SetCertificateRequirement,
+		"ListCertificateRequirements": srv.ListCertificateRequirements,
+	} {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest("POST", "/", bytes.NewBufferString("{}")))
+		if w.Code != 401 {
+			t.Errorf("%s: expected 401, got %d", name, w.Code)
+		}
+	}
+}
+
+func TestValidateCertificateRequirement(t *testing.T) {
+	fee, negative := 0, -1
+	certType := base64.StdEncoding.EncodeToString(make([]byte, 32))
+
+	tests := []struct {
+		req  SetCertificateRequirementRequest
+		code string
+	}{
+		{SetCertificateRequirementRequest{MessageBox: "business", Certifiers: []string{mockIdentityKey}, CertificateType: certType, Fields: []string{"email"}, VerifiedFee: &fee}, ""},
+		{SetCertificateRequirementRequest{MessageBox: "business"}, ""}, // removes the requirement
+		{SetCertificateRequirementRequest{Certifiers: []string{mockIdentityKey}}, "ERR_INVALID_REQUEST"},
+		{SetCertificateRequirementRequest{MessageBox: "app.*", Certifiers: []string{mockIdentityKey}}, "ERR_INVALID_MESSAGEBOX"},
+		{SetCertificateRequirementRequest{MessageBox: "business", Certifiers: []string{"nope"}}, "ERR_INVALID_PUBLIC_KEY"},
+		{SetCertificateRequirementRequest{MessageBox: "business", Certifiers: []string{mockIdentityKey}, CertificateType: "c2hvcnQ="}, "ERR_INVALID_CERTIFICATE_TYPE"},
+		{SetCertificateRequirementRequest{MessageBox: "business", Certifiers: []string{mockIdentityKey}, Fields: []string{" "}}, "ERR_INVALID_REQUEST"},
+		{SetCertificateRequirementRequest{MessageBox: "business", Certifiers: []string{mockIdentityKey}, VerifiedFee: &negative}, "ERR_INVALID_FEE"},
+	}
+	for i, tt := range tests {
+		if code, _ := validateCertificateRequirement(tt.req); code != tt.code {
+			t.Errorf("case %d: expected %q, got %q", i, tt.code, code)
+		}
+	}
+
+	// keys are stored the way certificates carry them, once each
+	if got := normalizeCertifiers([]string{strings.ToUpper(mockIdentityKey), mockIdentityKey}); !slices.Equal(got, []string{mockIdentityKey}) {
+		t.Fatalf("expected the certifier in compressed lowercase hex, got %v", got)
+	}
+}
+
+func TestCertificatesReceived(t *testing.T) {
+	ctx := context.Background()
+	newWallet := func() (*sdk.CompletedProtoWallet, *ec.PublicKey) {
+		key, err := ec.NewPrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := sdk.NewCompletedProtoWallet(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return w, key.PubKey()
+	}
+	serverWallet, serverKey := newWallet()
+	certifierWallet, certifierKey := newWallet()
+	senderWallet, senderKey := newWallet()
+
+	srv := NewServer(setupTestServer(t).DB, serverWallet)
+	certType := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+
+	master, err := certificates.IssueCertificateForSubject(ctx, certifierWallet.ProtoWallet,
+		sdk.Counterparty{Type: sdk.CounterpartyTypeOther, Counterparty: senderKey},
+		map[string]string{"email": "alice@example.com", "country": "CH"}, certType, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := certificates.CreateKeyringForVerifier(ctx, senderWallet.ProtoWallet,
+		sdk.Counterparty{Type: sdk.CounterpartyTypeOther, Counterparty: certifierKey},
+		sdk.Counterparty{Type: sdk.CounterpartyTypeOther, Counterparty: serverKey},
+		master.Fields, []sdk.CertificateFieldNameUnder50Bytes{"email"}, master.MasterKeyring, master.SerialNumber, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := certificates.NewVerifiableCertificate(&master.Certificate, keyring)
+
+	// a certificate about someone else is ignored
+	_, otherKey := newWallet()
+	if err := srv.CertificatesReceived(ctx, otherKey, []*certificates.VerifiableCertificate{cert}); err != nil {
+		t.Fatal(err)
+	}
+	if certs, _ := srv.DB.ListSenderCertificates(otherKey.ToDERHex(), time.Now()); len(certs) != 0 {
+		t.Fatalf("expected no certificates for another sender, got %+v", certs)
+	}
+
+	if err := srv.CertificatesReceived(ctx, senderKey, []*certificates.VerifiableCertificate{cert}); err != nil {
+		t.Fatal(err)
+	}
+	certs, err := srv.DB.ListSenderCertificates(senderKey.ToDERHex(), time.Now())
+	if err != nil || len(certs) != 1 {
+		t.Fatalf("expected one certificate, got %+v, %v", certs, err)
+	}
+	if c := certs[0]; c.Certifier != certifierKey.ToDERHex() || c.CertificateType != certType || !slices.Equal(c.Fields, []string{"email"}) {
+		t.Fatalf("expected only the revealed field, got %+v", c)
+	}
+	if c := certs[0]; !c.ExpiresAt.After(time.Now().Add(DefaultCertificateTTL - time.Minute)) {
+		t.Fatalf("expected the certificate to be kept for %v, expires %v", DefaultCertificateTTL, c.ExpiresAt)
+	}
+
+	// a required certificate lets verified senders deliver and lowers their fee
+	verifiedFee := 1
+	if err := srv.DB.SetCertificateRequirement(db.CertificateRequirement{
+		Recipient: mockIdentityKey, MessageBox: "business", Certifiers: []string{certifierKey.ToDERHex()}, Fields: []string{"email"}, VerifiedFee: &verifiedFee,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	res := &db.FeeResolution{Fee: 10}
+	check, err := srv.checkCertificates(mockIdentityKey, "business", res, certs)
+	if err != nil || !check.allowed() || check.recipientFee(10) != 1 || check.recipientFee(-1) != -1 {
+		t.Fatalf("expected a verified sender, got %+v, %v", check, err)
+	}
+	if q := check.quote(); q == nil || !q.Verified || q.VerifiedFee == nil {
+		t.Fatalf("unexpected quote %+v", q)
+	}
+
+	check, err = srv.checkCertificates(mockIdentityKey, "business", res, nil)
+	if err != nil || !check.allowed() || check.recipientFee(10) != 10 {
+		t.Fatalf("expected an unverified sender to pay the usual fee, got %+v, %v", check, err)
+	}
+
+	if err := srv.DB.SetCertificateRequirement(db.CertificateRequirement{
+		Recipient: mockIdentityKey, MessageBox: "business", Certifiers: []string{certifierKey.ToDERHex()}, Fields: []string{"country"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if check, _ := srv.checkCertificates(mockIdentityKey, "business", res, certs); check.allowed() {
+		t.Fatal("expected a certificate not revealing country to be turned away")
+	}
+	if check, _ := srv.checkCertificates(mockIdentityKey, "business", &db.FeeResolution{Permission: &db.PermissionRecord{}}, nil); !check.allowed() || check.quote() != nil {
+		t.Fatal("expected a sender-specific rule to bypass the requirement")
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/bsv-blockchain/go-bsv-middleware/pkg/middleware"
 	"github.com/bsv-blockchain/go-message-box-server/internal/logger"
@@ -15,9 +17,12 @@ import (
 
 // feeRow holds fee information for a recipient (used by buildPerRecipientOutputs).
 type feeRow struct {
-	recipient    string
-	recipientFee int
-	allowed      bool
+	recipient     string
+	recipientFee  int
+	allowed       bool
+	permissionID  int                         // sender-specific rule that set the fee, 0 for the box-wide default
+	subscription  *db.SubscriptionOfferRecord // offer bought by the send, replacing the per-message fee
+	powDifficulty int                         // leading zero bits the sender's proof of work needs, 0 for none
 }
 
 // OutputMappingError represents an error during output-to-recipient mapping.
@@ -33,16 +38,89 @@ func (e *OutputMappingError) Error() string {
 
 // Server holds shared dependencies for all handlers.
 type Server struct {
-	DB     *db.DB
-	wallet sdk.Interface
+	DB        *db.DB
+	wallet    sdk.Interface
+	adminKeys map[string]bool
+
+	deviceTransferPolicy string
+	quoteTTL             time.Duration
+	certificateTTL       time.Duration
+
+	txStatus            TxStatusSource
+	replayConfirmations int
+
+	spamPolicy SpamPolicy
+}
+
+// ServerOption configures optional Server settings.
+type ServerOption func(*Server)
+
+// WithAdminIdentityKeys allows the given identity keys to use operator endpoints.
+func WithAdminIdentityKeys(keys []string) ServerOption {
+	return func(s *Server) {
+		for _, k := range keys {
+			s.adminKeys[k] = true
+		}
+	}
+}
+
+// WithDeviceTransferPolicy sets how a token registered by another identity is handled,
+// DeviceTransferPolicyReject or DeviceTransferPolicyChallenge (default, which rejects when FCM is disabled).
+func WithDeviceTransferPolicy(policy string) ServerOption {
+	return func(s *Server) {
+		s.deviceTransferPolicy = policy
+	}
+}
+
+// WithQuoteTTL sets how long signed quotes stay valid (DefaultQuoteTTL if unset).
+func WithQuoteTTL(ttl time.Duration) ServerOption {
+	return func(s *Server) {
+		if ttl > 0 {
+			s.quoteTTL = ttl
+		}
+	}
+}
+
+// WithCertificateTTL sets how long presented identity certificates are kept (DefaultCertificateTTL if unset).
+func WithCertificateTTL(ttl time.Duration) ServerOption {
+	return func(s *Server) {
+		if ttl > 0 {
+			s.certificateTTL = ttl
+		}
+	}
+}
+
+// WithPaymentReplayWindow rejects payment transactions already confirmations deep, as reported by src.
+// Their spent outputs can then be pruned, see jobs.RunSpentOutputPruner. Without it, no payment is too old.
+func WithPaymentReplayWindow(src TxStatusSource, confirmations int) ServerOption {
+	return func(s *Server) {
+		if src != nil && confirmations > 0 {
+			s.txStatus = src
+			s.replayConfirmations = confirmations
+		}
+	}
 }
 
 // NewServer creates instance of Server used by all handlers.
-func NewServer(db *db.DB, wallet sdk.Interface) *Server {
-	return &Server{
-		DB:     db,
-		wallet: wallet,
+func NewServer(db *db.DB, wallet sdk.Interface, opts ...ServerOption) *Server {
+	s := &Server{
+		DB:        db,
+		wallet:    wallet,
+		adminKeys: make(map[string]bool),
+
+		deviceTransferPolicy: DeviceTransferPolicyChallenge,
+		quoteTTL:             DefaultQuoteTTL,
+		certificateTTL:       DefaultCertificateTTL,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// isAdmin reports whether the identity key is a configured operator key.
+func (s *Server) isAdmin(identityKey string) bool {
+	return identityKey != "" && s.adminKeys[identityKey]
 }
 
 // writeJSON writes a JSON response.
@@ -73,6 +151,20 @@ func getIdentityKey(r *http.Request) string {
 	return identity.ToDERHex()
 }
 
+// parseLimit reads the "limit" query parameter, returning def when it is absent.
+// Returns an error describing the valid range if it is not a number between 1 and max.
+func parseLimit(r *http.Request, def, max int) (int, error) {
+	limitStr := r.URL.Query().Get("limit")
+	if limitStr == "" {
+		return def, nil
+	}
+	v, err := strconv.Atoi(limitStr)
+	if err != nil || v < 1 || v > max {
+		return 0, fmt.Errorf("Limit must be a number between 1 and %d", max)
+	}
+	return v, nil
+}
+
 // isValidPubKey validates a public key hex string.
 func isValidPubKey(key string) bool {
 	_, err := ec.PublicKeyFromString(key)
@@ -121,15 +213,16 @@ func toSDKInternalizeOutput(po PaymentOutput) (sdk.InternalizeOutput, error) {
 }
 
 // buildPerRecipientOutputs maps payment outputs to recipients based on customInstructions or positional fallback.
-func buildPerRecipientOutputs(outputs []PaymentOutput, deliveryFee int, feeRows []feeRow) (map[string][]PaymentOutput, error) {
+// serverOutput is the position in outputs of the delivery fee output, which is skipped, or -1 if there is none.
+func buildPerRecipientOutputs(outputs []PaymentOutput, serverOutput int, feeRows []feeRow) (map[string][]PaymentOutput, error) {
 	perRecipientOutputs := make(map[string][]PaymentOutput)
-	// skip first output if it was for server delivery fee
-	startIdx := 0
-	if deliveryFee > 0 && len(outputs) > 0 {
-		startIdx = 1
-	}
 
-	recipientSideOutputs := outputs[startIdx:]
+	recipientSideOutputs := make([]PaymentOutput, 0, len(outputs))
+	for i, out := range outputs {
+		if i != serverOutput {
+			recipientSideOutputs = append(recipientSideOutputs, out)
+		}
+	}
 
 	// Get recipients that require payment
 	var feeRecipients []string
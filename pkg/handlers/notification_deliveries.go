@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bsv-blockchain/go-message-box-server/internal/logger"
+)
+
+// ListNotificationDeliveries godoc
+// @Summary      List recent push notification attempts
+// @Description  Returns the most recent push attempts to the authenticated identity's devices, including provider, status, error code and latency. Useful to debug missing notifications.
+// @Tags         Notifications
+// @Produce      json
+// @Param        limit query int false "Maximum number of results (1-500, default 50)"
+// @Success      200  {object}  ListNotificationDeliveriesResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /notifications/deliveries [get]
+func (s *Server) ListNotificationDeliveries(w http.ResponseWriter, r *http.Request) {
+	identityKey := getIdentityKey(r)
+	if identityKey == "" {
+		writeError(w, 401, "ERR_AUTHENTICATION_REQUIRED", "Authentication required.")
+		return
+	}
+
+	limit, err := parseLimit(r, 50, 500)
+	if err != nil {
+		writeError(w, 400, "ERR_INVALID_LIMIT", err.Error())
+		return
+	}
+
+	records, err := s.DB.ListNotificationDeliveries(identityKey, limit)
+	if err != nil {
+		logger.Error("failed to list notification deliveries", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to retrieve notification deliveries.")
+		return
+	}
+
+	out := []NotificationDeliveryOut{}
+	for _, rec := range records {
+		d := NotificationDeliveryOut{
+			ID:           rec.ID,
+			MessageID:    rec.MessageID.String,
+			MessageBox:   rec.MessageBox.String,
+			Provider:     rec.Provider,
+			Status:       rec.Status,
+			ErrorCode:    rec.ErrorCode.String,
+			ErrorMessage: rec.ErrorMessage.String,
+			LatencyMs:    rec.LatencyMs,
+			CreatedAt:    rec.CreatedAt.Format("2006-01-02T15:04:05.000Z"),
+		}
+		if rec.DeviceRegistrationID.Valid {
+			id := rec.DeviceRegistrationID.Int64
+			d.DeviceRegistrationID = &id
+		}
+		out = append(out, d)
+	}
+
+	writeJSON(w, 200, ListNotificationDeliveriesResponse{
+		Status:     "success",
+		Deliveries: out,
+	})
+}
+
+// GetNotificationFailures godoc
+// @Summary      Aggregate push notification failures (operators only)
+// @Description  Returns failed push attempts grouped by provider and error code over a recent window. Restricted to identity keys listed in ADMIN_IDENTITY_KEYS.
+// @Tags         Admin
+// @Produce      json
+// @Param        sinceHours query int false "Window size in hours (1-720, default 24)"
+// @Success      200  {object}  NotificationFailuresResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /admin/notifications/failures [get]
+func (s *Server) GetNotificationFailures(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.requireAdmin(w, r); !ok {
+		return
+	}
+
+	hours := 24
+	if hoursStr := r.URL.Query().Get("sinceHours"); hoursStr != "" {
+		if v, err := strconv.Atoi(hoursStr); err == nil && v >= 1 && v <= 720 {
+			hours = v
+		} else {
+			writeError(w, 400, "ERR_INVALID_WINDOW", "sinceHours must be a number between 1 and 720")
+			return
+		}
+	}
+
+	since := time.Now().Add(-time.Duration(hours) * time.Hour)
+	counts, err := s.DB.CountDeliveryFailures(since)
+	if err != nil {
+		logger.Error("failed to count delivery failures", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to aggregate delivery failures.")
+		return
+	}
+
+	total := 0
+	out := []DeliveryFailureOut{}
+	for _, c := range counts {
+		total += c.Count
+		out = append(out, DeliveryFailureOut{
+			Provider:  c.Provider,
+			ErrorCode: c.ErrorCode,
+			Count:     c.Count,
+		})
+	}
+
+	writeJSON(w, 200, NotificationFailuresResponse{
+		Status:   "success",
+		Since:    since.UTC().Format("2006-01-02T15:04:05.000Z"),
+		Total:    total,
+		Failures: out,
+	})
+}
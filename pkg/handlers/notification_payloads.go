@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bsv-blockchain/go-message-box-server/internal/firebase"
+	"github.com/bsv-blockchain/go-message-box-server/internal/logger"
+	"github.com/bsv-blockchain/go-message-box-server/pkg/db"
+)
+
+const (
+	// maxNotificationTTLSeconds is the longest TTL FCM accepts (4 weeks).
+	maxNotificationTTLSeconds = 28 * 24 * 60 * 60
+	// maxCollapseKeyLength is the APNs limit for apns-collapse-id.
+	maxCollapseKeyLength = 64
+	// maxSenderNameLength bounds sender supplied display names.
+	maxSenderNameLength = 64
+	// maxTemplateLength bounds title and body templates.
+	maxTemplateLength = 256
+)
+
+// SetNotificationPayload godoc
+// @Summary      Configure push notification payloads for a message box
+// @Description  Sets title/body templates, unread badge, silent (data-only) mode, collapse key and TTL for pushes triggered by a message box. Templates use Go text/template syntax with fields .Sender, .SenderName, .MessageBox, .MessageID and .UnreadCount.
+// @Tags         Notifications
+// @Accept       json
+// @Produce      json
+// @Param        request body SetNotificationPayloadRequest true "Payload settings"
+// @Success      200  {object}  SuccessResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /notificationPayloads/set [post]
+func (s *Server) SetNotificationPayload(w http.ResponseWriter, r *http.Request) {
+	identityKey := getIdentityKey(r)
+	if identityKey == "" {
+		writeError(w, 401, "ERR_AUTHENTICATION_REQUIRED", "Authentication required.")
+		return
+	}
+
+	var req SetNotificationPayloadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, 400, "ERR_INVALID_JSON", "Invalid JSON body")
+		return
+	}
+
+	messageBox := strings.TrimSpace(req.MessageBox)
+	if messageBox == "" {
+		writeError(w, 400, "ERR_INVALID_MESSAGEBOX", "messageBox is required.")
+		return
+	}
+
+	for _, t := range []*string{req.TitleTemplate, req.BodyTemplate} {
+		if t == nil {
+			continue
+		}
+		if len(*t) > maxTemplateLength {
+			writeError(w, 400, "ERR_INVALID_TEMPLATE", fmt.Sprintf("Templates must not exceed %d characters.", maxTemplateLength))
+			return
+		}
+		if err := firebase.ValidateTemplate(*t); err != nil {
+			writeError(w, 400, "ERR_INVALID_TEMPLATE", fmt.Sprintf("Invalid template: %v", err))
+			return
+		}
+	}
+
+	if req.CollapseKey != nil && len(*req.CollapseKey) > maxCollapseKeyLength {
+		writeError(w, 400, "ERR_INVALID_COLLAPSE_KEY", fmt.Sprintf("collapseKey must not exceed %d bytes.", maxCollapseKeyLength))
+		return
+	}
+
+	if req.TTLSeconds != nil && (*req.TTLSeconds < 0 || *req.TTLSeconds > maxNotificationTTLSeconds) {
+		writeError(w, 400, "ERR_INVALID_TTL", fmt.Sprintf("ttlSeconds must be between 0 and %d.", maxNotificationTTLSeconds))
+		return
+	}
+
+	rec := db.NotificationPayloadRecord{
+		IdentityKey:   identityKey,
+		MessageBox:    messageBox,
+		TitleTemplate: nullString(req.TitleTemplate),
+		BodyTemplate:  nullString(req.BodyTemplate),
+		Silent:        req.Silent,
+		Badge:         req.Badge,
+		CollapseKey:   nullString(req.CollapseKey),
+	}
+	if req.TTLSeconds != nil {
+		rec.TTLSeconds = sql.NullInt64{Int64: int64(*req.TTLSeconds), Valid: true}
+	}
+
+	if err := s.DB.SetNotificationPayload(rec); err != nil {
+		logger.Error("failed to set notification payload", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to update notification payload settings.")
+		return
+	}
+
+	writeJSON(w, 200, SuccessResponse{Status: "success"})
+}
+
+// GetNotificationPayload godoc
+// @Summary      Get push notification payload settings for a message box
+// @Description  Returns the payload settings used for pushes triggered by a message box. If nothing was stored the server defaults are returned with isDefault=true.
+// @Tags         Notifications
+// @Produce      json
+// @Param        messageBox query string true "Name of the message box"
+// @Success      200  {object}  GetNotificationPayloadResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /notificationPayloads/get [get]
+func (s *Server) GetNotificationPayload(w http.ResponseWriter, r *http.Request) {
+	identityKey := getIdentityKey(r)
+	if identityKey == "" {
+		writeError(w, 401, "ERR_AUTHENTICATION_REQUIRED", "Authentication required.")
+		return
+	}
+
+	messageBox := r.URL.Query().Get("messageBox")
+	if messageBox == "" {
+		writeError(w, 400, "ERR_MISSING_PARAMETERS", "messageBox parameter is required.")
+		return
+	}
+
+	rec, err := s.DB.GetNotificationPayload(identityKey, messageBox)
+	if err != nil {
+		logger.Error("failed to get notification payload", "error", err)
+		writeError(w, 500, "ERR_INTERNAL", "An internal error has occurred.")
+		return
+	}
+
+	out := NotificationPayloadDetail{
+		MessageBox:    messageBox,
+		TitleTemplate: firebase.DefaultTitleTemplate,
+		BodyTemplate:  firebase.DefaultBodyTemplate,
+		IsDefault:     rec == nil,
+	}
+	if rec != nil {
+		if rec.TitleTemplate.Valid {
+			out.TitleTemplate = rec.TitleTemplate.String
+		}
+		if rec.BodyTemplate.Valid {
+			out.BodyTemplate = rec.BodyTemplate.String
+		}
+		out.Silent = rec.Silent
+		out.Badge = rec.Badge
+		out.CollapseKey = rec.CollapseKey.String
+		if rec.TTLSeconds.Valid {
+			ttl := int(rec.TTLSeconds.Int64)
+			out.TTLSeconds = &ttl
+		}
+	}
+
+	writeJSON(w, 200, GetNotificationPayloadResponse{
+		Status:  "success",
+		Payload: out,
+	})
+}
+
+// buildFCMPayload assembles the push payload for one recipient from the recipient's box settings
+// and the sender's per-send options.
+func (s *Server) buildFCMPayload(recipient, sender, messageBox string, messageBoxID int64, messageID string, opts *NotificationOptions) firebase.FCMPayload {
+	settings, err := s.DB.GetNotificationPayload(recipient, messageBox)
+	if err != nil {
+		logger.Error("failed to get notification payload settings", "error", err, "recipient", recipient)
+	}
+	if settings == nil {
+		settings = &db.NotificationPayloadRecord{}
+	}
+
+	data := firebase.TemplateData{
+		Sender:     sender,
+		SenderName: firebase.ShortKey(sender),
+		MessageBox: messageBox,
+		MessageID:  messageID,
+	}
+	if opts != nil && opts.SenderName != "" {
+		data.SenderName = opts.SenderName
+	}
+
+	payload := firebase.FCMPayload{
+		MessageID:   messageID,
+		Originator:  sender,
+		MessageBox:  messageBox,
+		Silent:      settings.Silent,
+		CollapseKey: settings.CollapseKey.String,
+	}
+	if settings.TTLSeconds.Valid {
+		payload.TTL = time.Duration(settings.TTLSeconds.Int64) * time.Second
+	}
+
+	if settings.Badge {
+		count, err := s.DB.CountMessages(recipient, messageBoxID)
+		if err != nil {
+			logger.Error("failed to count unread messages", "error", err, "recipient", recipient)
+		} else {
+			data.UnreadCount = count
+			payload.Badge = &count
+		}
+	}
+
+	if opts != nil {
+		// senders may ask for a quieter push, but never override a silent box
+		payload.Silent = payload.Silent || opts.Silent
+		if opts.CollapseKey != "" {
+			payload.CollapseKey = opts.CollapseKey
+		}
+		if opts.TTLSeconds != nil {
+			payload.TTL = time.Duration(*opts.TTLSeconds) * time.Second
+		}
+	}
+
+	payload.Title = firebase.RenderTemplate(settings.TitleTemplate.String, firebase.DefaultTitleTemplate, data)
+	payload.Body = firebase.RenderTemplate(settings.BodyTemplate.String, firebase.DefaultBodyTemplate, data)
+
+	return payload
+}
+
+// validateNotificationOptions checks the per-send notification options of a sendMessage request.
+func validateNotificationOptions(opts *NotificationOptions) (code, description string) {
+	if opts == nil {
+		return "", ""
+	}
+	if len([]rune(opts.SenderName)) > maxSenderNameLength {
+		return "ERR_INVALID_NOTIFICATION", fmt.Sprintf("notification.senderName must not exceed %d characters.", maxSenderNameLength)
+	}
+	if len(opts.CollapseKey) > maxCollapseKeyLength {
+		return "ERR_INVALID_NOTIFICATION", fmt.Sprintf("notification.collapseKey must not exceed %d bytes.", maxCollapseKeyLength)
+	}
+	if opts.TTLSeconds != nil && (*opts.TTLSeconds < 0 || *opts.TTLSeconds > maxNotificationTTLSeconds) {
+		return "ERR_INVALID_NOTIFICATION", fmt.Sprintf("notification.ttlSeconds must be between 0 and %d.", maxNotificationTTLSeconds)
+	}
+	return "", ""
+}
+
+// nullString converts an optional string into a sql.NullString.
+func nullString(s *string) sql.NullString {
+	if s == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: *s, Valid: true}
+}
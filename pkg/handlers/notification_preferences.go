@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bsv-blockchain/go-message-box-server/internal/logger"
+	"github.com/bsv-blockchain/go-message-box-server/pkg/db"
+)
+
+// SetNotificationPreference godoc
+// @Summary      Set push notification preferences for a message box
+// @Description  Chooses whether messages arriving in a message box trigger push notifications: "none", "always", or "allowlist" (only from allowedSenders). Optional quiet hours suppress pushes during a daily window in the given timezone.
+// @Tags         Notifications
+// @Accept       json
+// @Produce      json
+// @Param        request body SetNotificationPreferenceRequest true "Notification preference"
+// @Success      200  {object}  SuccessResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /notificationPreferences/set [post]
+func (s *Server) SetNotificationPreference(w http.ResponseWriter, r *http.Request) {
+	identityKey := getIdentityKey(r)
+	if identityKey == "" {
+		writeError(w, 401, "ERR_AUTHENTICATION_REQUIRED", "Authentication required.")
+		return
+	}
+
+	var req SetNotificationPreferenceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, 400, "ERR_INVALID_JSON", "Invalid JSON body")
+		return
+	}
+
+	messageBox := strings.TrimSpace(req.MessageBox)
+	if messageBox == "" {
+		writeError(w, 400, "ERR_INVALID_MESSAGEBOX", "messageBox is required.")
+		return
+	}
+
+	if !db.IsValidNotificationMode(req.Mode) {
+		writeError(w, 400, "ERR_INVALID_MODE", "mode must be one of: none, always, allowlist")
+		return
+	}
+
+	if req.Mode == db.NotificationModeAllowlist && len(req.AllowedSenders) == 0 {
+		writeError(w, 400, "ERR_ALLOWED_SENDERS_REQUIRED", "allowedSenders must contain at least one sender for allowlist mode.")
+		return
+	}
+
+	for _, sender := range req.AllowedSenders {
+		if !isValidPubKey(sender) {
+			writeError(w, 400, "ERR_INVALID_PUBLIC_KEY", fmt.Sprintf("Invalid sender public key: %s", sender))
+			return
+		}
+	}
+
+	var start, end, timezone *string
+	if req.QuietHours != nil {
+		if err := db.ParseQuietHours(req.QuietHours.Start, req.QuietHours.End, req.QuietHours.Timezone); err != nil {
+			writeError(w, 400, "ERR_INVALID_QUIET_HOURS", err.Error())
+			return
+		}
+		start, end, timezone = &req.QuietHours.Start, &req.QuietHours.End, &req.QuietHours.Timezone
+	}
+
+	// allowed senders only matter in allowlist mode, don't keep stale lists around
+	allowedSenders := req.AllowedSenders
+	if req.Mode != db.NotificationModeAllowlist {
+		allowedSenders = nil
+	}
+
+	if err := s.DB.SetNotificationPreference(identityKey, messageBox, req.Mode, allowedSenders, start, end, timezone); err != nil {
+		logger.Error("failed to set notification preference", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to update notification preference.")
+		return
+	}
+
+	writeJSON(w, 200, SuccessResponse{Status: "success"})
+}
+
+// GetNotificationPreference godoc
+// @Summary      Get push notification preferences for a message box
+// @Description  Returns the effective notification preference for a message box. If nothing was stored the server default is returned with isDefault=true.
+// @Tags         Notifications
+// @Produce      json
+// @Param        messageBox query string true "Name of the message box"
+// @Success      200  {object}  GetNotificationPreferenceResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /notificationPreferences/get [get]
+func (s *Server) GetNotificationPreference(w http.ResponseWriter, r *http.Request) {
+	identityKey := getIdentityKey(r)
+	if identityKey == "" {
+		writeError(w, 401, "ERR_AUTHENTICATION_REQUIRED", "Authentication required.")
+		return
+	}
+
+	messageBox := r.URL.Query().Get("messageBox")
+	if messageBox == "" {
+		writeError(w, 400, "ERR_MISSING_PARAMETERS", "messageBox parameter is required.")
+		return
+	}
+
+	pref, err := s.DB.GetNotificationPreference(identityKey, messageBox)
+	if err != nil {
+		logger.Error("failed to get notification preference", "error", err)
+		writeError(w, 500, "ERR_INTERNAL", "An internal error has occurred.")
+		return
+	}
+
+	isDefault := pref == nil
+	if isDefault {
+		pref = db.DefaultNotificationPreference(identityKey, messageBox)
+	}
+
+	writeJSON(w, 200, GetNotificationPreferenceResponse{
+		Status:     "success",
+		Preference: toNotificationPreferenceDetail(pref, isDefault),
+	})
+}
+
+// ListNotificationPreferences godoc
+// @Summary      List push notification preferences
+// @Description  Returns all notification preferences stored for the authenticated identity. Boxes without a stored preference use the server default.
+// @Tags         Notifications
+// @Produce      json
+// @Success      200  {object}  ListNotificationPreferencesResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /notificationPreferences/list [get]
+func (s *Server) ListNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	identityKey := getIdentityKey(r)
+	if identityKey == "" {
+		writeError(w, 401, "ERR_AUTHENTICATION_REQUIRED", "Authentication required.")
+		return
+	}
+
+	prefs, err := s.DB.ListNotificationPreferences(identityKey)
+	if err != nil {
+		logger.Error("failed to list notification preferences", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to retrieve notification preferences.")
+		return
+	}
+
+	out := []NotificationPreferenceDetail{}
+	for i := range prefs {
+		out = append(out, toNotificationPreferenceDetail(&prefs[i], false))
+	}
+
+	writeJSON(w, 200, ListNotificationPreferencesResponse{
+		Status:      "success",
+		Preferences: out,
+	})
+}
+
+// toNotificationPreferenceDetail converts a db record into its response representation.
+func toNotificationPreferenceDetail(p *db.NotificationPreferenceRecord, isDefault bool) NotificationPreferenceDetail {
+	out := NotificationPreferenceDetail{
+		MessageBox:     p.MessageBox,
+		Mode:           p.Mode,
+		AllowedSenders: p.AllowedSenders,
+		IsDefault:      isDefault,
+	}
+	if out.AllowedSenders == nil {
+		out.AllowedSenders = []string{}
+	}
+	if p.QuietHoursStart.Valid && p.QuietHoursEnd.Valid {
+		out.QuietHours = &QuietHours{
+			Start:    p.QuietHoursStart.String,
+			End:      p.QuietHoursEnd.String,
+			Timezone: p.Timezone.String,
+		}
+	}
+	if !isDefault {
+		out.CreatedAt = p.CreatedAt.Format("2006-01-02T15:04:05.000Z")
+		out.UpdatedAt = p.UpdatedAt.Format("2006-01-02T15:04:05.000Z")
+	}
+	return out
+}
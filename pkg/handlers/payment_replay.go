@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bsv-blockchain/go-message-box-server/internal/logger"
+	"github.com/bsv-blockchain/go-message-box-server/pkg/db"
+	"github.com/bsv-blockchain/go-wallet-toolbox/pkg/wdk"
+)
+
+// TxStatusSource reports how many blocks deep transactions are; implemented by the wallet toolbox services.
+type TxStatusSource interface {
+	GetStatusForTxIDs(ctx context.Context, txIDs []string) (*wdk.GetStatusForTxIDsResult, error)
+}
+
+// paymentSpentOutputs lists the outputs a send pays with: the delivery fee output at position serverOutput
+// (-1 for none) and the outputs mapped to each recipient.
+func paymentSpentOutputs(txid, sender, messageBox string, outputs []PaymentOutput, serverOutput int, perRecipient map[string][]PaymentOutput) []db.SpentOutput {
+	var spent []db.SpentOutput
+	if serverOutput >= 0 {
+		spent = append(spent, db.SpentOutput{TxID: txid, OutputIndex: outputs[serverOutput].OutputIndex, Sender: sender, MessageBox: messageBox})
+	}
+	for recipient, outs := range perRecipient {
+		for _, out := range outs {
+			spent = append(spent, db.SpentOutput{
+				TxID:        txid,
+				OutputIndex: out.OutputIndex,
+				Sender:      sender,
+				Recipient:   sql.NullString{String: recipient, Valid: true},
+				MessageBox:  messageBox,
+			})
+		}
+	}
+	return spent
+}
+
+// checkPaymentDepth rejects a payment tx that is already buried replayConfirmations deep, returning the HTTP
+// status to fail with. Spent outputs of such transactions are pruned, so accepting them could let an old
+// payment be used twice. A tx the services do not know is fresh; when its status cannot be fetched at all the
+// payment is refused, since its outputs may already have been pruned.
+func (s *Server) checkPaymentDepth(ctx context.Context, txid string) (int, *OutputMappingError) {
+	if s.txStatus == nil {
+		return 0, nil
+	}
+	res, err := s.txStatus.GetStatusForTxIDs(ctx, []string{txid})
+	if errors.Is(err, wdk.ErrNotFoundError) {
+		return 0, nil
+	}
+	if err != nil {
+		logger.Error("failed to get payment tx status", "error", err, "txid", txid)
+		return 503, &OutputMappingError{
+			Code:        "ERR_PAYMENT_STATUS_UNAVAILABLE",
+			Description: "The status of the payment transaction could not be checked. Retry later.",
+		}
+	}
+	for _, r := range res.Results {
+		if r.TxID == txid && r.Depth != nil && *r.Depth >= s.replayConfirmations {
+			return 409, &OutputMappingError{
+				Code:        "ERR_PAYMENT_REPLAYED",
+				Description: fmt.Sprintf("Payment transaction %s is %d blocks deep; pay with a new transaction.", txid, *r.Depth),
+			}
+		}
+	}
+	return 0, nil
+}
+
+// releaseOutputs releases the claimed outputs of a send that was not stored, so the sender can retry with the
+// same transaction. With keepDeliveryFee the delivery fee output stays spent, since the server already took it.
+func (s *Server) releaseOutputs(claimed []db.SpentOutput, keepDeliveryFee bool) {
+	var release []db.SpentOutput
+	for _, o := range claimed {
+		if o.Recipient.Valid || !keepDeliveryFee {
+			release = append(release, o)
+		}
+	}
+	if len(release) == 0 {
+		return
+	}
+	if err := s.DB.ReleasePaymentOutputs(release); err != nil {
+		logger.Error("failed to release payment outputs", "error", err, "txid", release[0].TxID)
+	}
+}
+
+func replayedOutpoints(outputs []db.SpentOutput) string {
+	points := make([]string, len(outputs))
+	for i, o := range outputs {
+		points[i] = fmt.Sprintf("%s.%d", o.TxID, o.OutputIndex)
+	}
+	return strings.Join(points, ", ")
+}
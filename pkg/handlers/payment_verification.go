@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bsv-blockchain/go-sdk/transaction"
+	sdk "github.com/bsv-blockchain/go-sdk/wallet"
+)
+
+// underpaidRecipient is a recipient whose mapped outputs pay less than their fee.
+type underpaidRecipient struct {
+	recipient string
+	required  int
+	paid      uint64
+}
+
+// parsePaymentTx decodes the Atomic BEEF of a payment and returns the transaction it is about.
+func parsePaymentTx(beef []byte) (*transaction.Transaction, error) {
+	tx, err := transaction.NewTransactionFromBEEF(beef)
+	if err != nil {
+		return nil, err
+	}
+	if tx == nil {
+		return nil, errors.New("subject transaction not found in BEEF")
+	}
+	return tx, nil
+}
+
+// deliveryFeeOutput returns the position in p.Outputs of the output paying the server delivery fee:
+// the entry for DeliveryFeeOutputIndex when declared, the first entry otherwise.
+func deliveryFeeOutput(p *Payment) (int, error) {
+	if p.DeliveryFeeOutputIndex == nil {
+		return 0, nil
+	}
+	for i, out := range p.Outputs {
+		if out.OutputIndex == *p.DeliveryFeeOutputIndex {
+			return i, nil
+		}
+	}
+	return -1, &OutputMappingError{
+		Code:        "ERR_INVALID_PAYMENT_OUTPUT",
+		Description: fmt.Sprintf("deliveryFeeOutputIndex %d is not one of the payment outputs.", *p.DeliveryFeeOutputIndex),
+	}
+}
+
+// verifyDeliveryFee checks that the delivery fee output exists in the payment tx and pays at least fee.
+// The wallet accepting an output only means it can spend it, not that the amount is right.
+func verifyDeliveryFee(tx *transaction.Transaction, out PaymentOutput, fee int) error {
+	if int(out.OutputIndex) >= len(tx.Outputs) {
+		return &OutputMappingError{
+			Code:        "ERR_INVALID_PAYMENT_OUTPUT",
+			Description: fmt.Sprintf("Output %d does not exist in the payment transaction.", out.OutputIndex),
+		}
+	}
+	if paid := tx.Outputs[out.OutputIndex].Satoshis; paid < uint64(fee) {
+		return &OutputMappingError{
+			Code:        "ERR_INSUFFICIENT_DELIVERY_FEE",
+			Description: fmt.Sprintf("Delivery fee output %d pays %d satoshis but %d are required.", out.OutputIndex, paid, fee),
+		}
+	}
+	return nil
+}
+
+// verifyRecipientPayments checks the outputs mapped to each paying recipient against the payment tx:
+// every output must exist and be claimed only once (not also as the server's fee output), must be a
+// wallet payment to a P2PKH output whose remittance names the sender, and the outputs of a recipient
+// must add up to at least their fee. Other protocols are rejected: the server cannot check what they pay.
+//
+// The key a wallet payment is locked to is derived from the sender/recipient shared secret, which the
+// server cannot compute; naming the sender is what lets the recipient's wallet derive and claim it.
+func verifyRecipientPayments(tx *transaction.Transaction, senderKey string, perRecipient map[string][]PaymentOutput, feeRows []feeRow, serverOutput *uint32) ([]underpaidRecipient, error) {
+	claimed := make(map[uint32]bool)
+	if serverOutput != nil {
+		claimed[*serverOutput] = true
+	}
+
+	var underpaid []underpaidRecipient
+	for _, fr := range feeRows {
+		if fr.recipientFee <= 0 {
+			continue
+		}
+
+		var paid uint64
+		for _, out := range perRecipient[fr.recipient] {
+			if int(out.OutputIndex) >= len(tx.Outputs) {
+				return nil, &OutputMappingError{
+					Code:        "ERR_INVALID_PAYMENT_OUTPUT",
+					Description: fmt.Sprintf("Output %d does not exist in the payment transaction.", out.OutputIndex),
+				}
+			}
+			if claimed[out.OutputIndex] {
+				return nil, &OutputMappingError{
+					Code:        "ERR_INVALID_PAYMENT_OUTPUT",
+					Description: fmt.Sprintf("Output %d is claimed by more than one payee.", out.OutputIndex),
+				}
+			}
+			claimed[out.OutputIndex] = true
+
+			txOut := tx.Outputs[out.OutputIndex]
+			if sdk.InternalizeProtocol(out.Protocol) != sdk.InternalizeProtocolWalletPayment {
+				return nil, &OutputMappingError{
+					Code:        "ERR_INVALID_PAYMENT_OUTPUT",
+					Description: fmt.Sprintf("Output %d must be a wallet payment.", out.OutputIndex),
+				}
+			}
+			if out.PaymentRemittance == nil || out.PaymentRemittance.SenderIdentityKey != senderKey {
+				return nil, &OutputMappingError{
+					Code:        "ERR_INVALID_PAYMENT_OUTPUT",
+					Description: fmt.Sprintf("Output %d must carry a payment remittance from the sender.", out.OutputIndex),
+				}
+			}
+			if txOut.LockingScript == nil || !txOut.LockingScript.IsP2PKH() {
+				return nil, &OutputMappingError{
+					Code:        "ERR_INVALID_PAYMENT_OUTPUT",
+					Description: fmt.Sprintf("Output %d is not a P2PKH payment.", out.OutputIndex),
+				}
+			}
+			paid += txOut.Satoshis
+		}
+
+		if paid < uint64(fr.recipientFee) {
+			underpaid = append(underpaid, underpaidRecipient{recipient: fr.recipient, required: fr.recipientFee, paid: paid})
+		}
+	}
+
+	return underpaid, nil
+}
+
+// outputsAmount sums the satoshis of outputs that exist in the payment tx.
+func outputsAmount(tx *transaction.Transaction, outputs []PaymentOutput) int64 {
+	var total uint64
+	for _, out := range outputs {
+		if int(out.OutputIndex) < len(tx.Outputs) {
+			total += tx.Outputs[out.OutputIndex].Satoshis
+		}
+	}
+	return int64(total)
+}
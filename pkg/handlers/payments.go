@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bsv-blockchain/go-message-box-server/internal/logger"
+	"github.com/bsv-blockchain/go-message-box-server/pkg/db"
+)
+
+// ListEarnings godoc
+// @Summary      List recipient fees earned
+// @Description  Returns the recipient fees senders paid to the authenticated identity, newest first, with the total over the whole period (not only the returned page).
+// @Description  These payments are relayed inside the stored messages; amount is what the outputs paid, which may exceed the fee.
+// @Tags         Payments
+// @Produce      json
+// @Param        messageBox query string false "Only payments for this message box"
+// @Param        since query string false "Only payments at or after this RFC 3339 time"
+// @Param        until query string false "Only payments before this RFC 3339 time"
+// @Param        limit query int false "Maximum number of results (1-500, default 50)"
+// @Success      200  {object}  ListEarningsResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /payments/earnings [get]
+func (s *Server) ListEarnings(w http.ResponseWriter, r *http.Request) {
+	identityKey := getIdentityKey(r)
+	if identityKey == "" {
+		writeError(w, 401, "ERR_AUTHENTICATION_REQUIRED", "Authentication required.")
+		return
+	}
+
+	filter, code, desc := parsePaymentFilter(r)
+	if code != "" {
+		writeError(w, 400, code, desc)
+		return
+	}
+
+	limit, err := parseLimit(r, 50, 500)
+	if err != nil {
+		writeError(w, 400, "ERR_INVALID_LIMIT", err.Error())
+		return
+	}
+
+	records, totals, err := s.DB.ListRecipientEarnings(identityKey, filter, limit)
+	if err != nil {
+		logger.Error("failed to list earnings", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to retrieve earnings.")
+		return
+	}
+
+	out := []PaymentOut{}
+	for _, rec := range records {
+		out = append(out, toPaymentOut(rec))
+	}
+
+	writeJSON(w, 200, ListEarningsResponse{
+		Status:      "success",
+		TotalAmount: totals.Amount,
+		TotalCount:  totals.Count,
+		Payments:    out,
+	})
+}
+
+// GetDeliveryFeeRevenue godoc
+// @Summary      Delivery fee revenue (operators only)
+// @Description  Sums the delivery fees internalized by the server wallet per message box. With kind=route_fee, sums the route prices charged by the payment middleware instead. Restricted to identity keys listed in ADMIN_IDENTITY_KEYS.
+// @Tags         Admin
+// @Produce      json
+// @Param        kind query string false "delivery_fee (default) or route_fee"
+// @Param        messageBox query string false "Only payments for this message box"
+// @Param        since query string false "Only payments at or after this RFC 3339 time"
+// @Param        until query string false "Only payments before this RFC 3339 time"
+// @Success      200  {object}  DeliveryFeeRevenueResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /admin/payments/revenue [get]
+func (s *Server) GetDeliveryFeeRevenue(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.requireAdmin(w, r); !ok {
+		return
+	}
+
+	filter, code, desc := parsePaymentFilter(r)
+	if code != "" {
+		writeError(w, 400, code, desc)
+		return
+	}
+
+	kind := r.URL.Query().Get("kind")
+	switch kind {
+	case "":
+		kind = db.PaymentKindDeliveryFee
+	case db.PaymentKindDeliveryFee, db.PaymentKindRouteFee:
+	default:
+		writeError(w, 400, "ERR_INVALID_KIND", "kind must be delivery_fee or route_fee.")
+		return
+	}
+
+	totals, err := s.DB.Revenue(kind, filter)
+	if err != nil {
+		logger.Error("failed to sum delivery fee revenue", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to aggregate delivery fee revenue.")
+		return
+	}
+
+	resp := DeliveryFeeRevenueResponse{Status: "success", ByMessageBox: []RevenueByBoxOut{}}
+	for _, t := range totals {
+		resp.TotalAmount += t.Amount
+		resp.TotalCount += t.Count
+		resp.ByMessageBox = append(resp.ByMessageBox, RevenueByBoxOut{
+			MessageBox: t.MessageBox,
+			Count:      t.Count,
+			Amount:     t.Amount,
+		})
+	}
+
+	writeJSON(w, 200, resp)
+}
+
+// parsePaymentFilter reads the messageBox, since and until query parameters.
+func parsePaymentFilter(r *http.Request) (db.PaymentFilter, string, string) {
+	q := r.URL.Query()
+	f := db.PaymentFilter{MessageBox: strings.TrimSpace(q.Get("messageBox"))}
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"since", &f.Since}, {"until", &f.Until}} {
+		v := q.Get(p.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return f, "ERR_INVALID_TIME", p.name + " must be an RFC 3339 timestamp."
+		}
+		*p.dst = t
+	}
+	if !f.Since.IsZero() && !f.Until.IsZero() && !f.Until.After(f.Since) {
+		return f, "ERR_INVALID_TIME", "until must be after since."
+	}
+	return f, "", ""
+}
+
+// recordPayment adds a payment to the ledger and returns its id, 0 if it could not be recorded.
+// The money has already moved, so a failure is only logged.
+func (s *Server) recordPayment(p db.PaymentRecord) int64 {
+	id, err := s.DB.InsertPayment(p)
+	if err != nil {
+		logger.Error("failed to record payment", "error", err, "txid", p.TxID, "kind", p.Kind)
+	}
+	return id
+}
+
+func toPaymentOut(p db.PaymentRecord) PaymentOut {
+	return PaymentOut{
+		ID:         p.ID,
+		TxID:       p.TxID,
+		Sender:     p.Sender,
+		MessageBox: p.MessageBox,
+		MessageID:  p.MessageID.String,
+		Fee:        p.Fee,
+		Amount:     p.Amount,
+		Status:     p.Status,
+		CreatedAt:  p.CreatedAt.Format("2006-01-02T15:04:05.000Z"),
+	}
+}
@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bsv-blockchain/go-message-box-server/internal/logger"
+	"github.com/bsv-blockchain/go-message-box-server/pkg/db"
+)
+
+// maxBulkPermissions bounds the number of entries in one bulk upsert.
+const maxBulkPermissions = 1000
+
+// permissionCSVHeader is the column order of CSV exports.
+var permissionCSVHeader = []string{
+	"sender", "messageBox", "recipientFee", "expiresAt", "maxMessages",
+	"feePerKb", "largePayloadThreshold", "largePayloadFee", "powDifficulty",
+}
+
+// DeletePermission godoc
+// @Summary      Delete a message permission
+// @Description  Deletes the permission for a specific sender, or the box-wide setting when sender is omitted. The next message falls back to the remaining rules.
+// @Tags         Permissions
+// @Produce      json
+// @Param        messageBox query string true "Name of the message box (or pattern)"
+// @Param        sender query string false "Sender's public key (omit for box-wide setting)"
+// @Success      200  {object}  DeletePermissionResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /permissions [delete]
+func (s *Server) DeletePermission(w http.ResponseWriter, r *http.Request) {
+	identityKey := getIdentityKey(r)
+	if identityKey == "" {
+		writeError(w, 401, "ERR_AUTHENTICATION_REQUIRED", "Authentication required.")
+		return
+	}
+
+	messageBox := r.URL.Query().Get("messageBox")
+	if messageBox == "" {
+		writeError(w, 400, "ERR_MISSING_PARAMETERS", "messageBox parameter is required.")
+		return
+	}
+
+	senderParam := r.URL.Query().Get("sender")
+	var sender *string
+	if senderParam != "" {
+		if !isValidPubKey(senderParam) {
+			writeError(w, 400, "ERR_INVALID_PUBLIC_KEY", "Invalid sender public key format.")
+			return
+		}
+		sender = &senderParam
+	}
+
+	found, err := s.DB.DeletePermission(identityKey, sender, messageBox)
+	if err != nil {
+		logger.Error("failed to delete permission", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to delete message permission.")
+		return
+	}
+	if !found {
+		writeError(w, 404, "ERR_PERMISSION_NOT_FOUND", "Permission not found.")
+		return
+	}
+
+	desc := fmt.Sprintf("Box-wide permission for %s deleted.", messageBox)
+	if sender != nil {
+		desc = fmt.Sprintf("Permission for sender %s to %s deleted.", *sender, messageBox)
+	}
+	writeJSON(w, 200, DeletePermissionResponse{
+		Status:      "success",
+		Description: desc,
+	})
+}
+
+// BulkSetPermissions godoc
+// @Summary      Set many message permissions
+// @Description  Upserts up to 1000 permissions in one transaction. Every entry is validated first; if any entry is invalid, nothing is applied and the errors are returned per entry index.
+// @Tags         Permissions
+// @Accept       json
+// @Produce      json
+// @Param        request body BulkSetPermissionsRequest true "Permissions to set"
+// @Success      200  {object}  BulkSetPermissionsResponse
+// @Failure      400  {object}  BulkSetPermissionsError
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /permissions/bulkSet [post]
+func (s *Server) BulkSetPermissions(w http.ResponseWriter, r *http.Request) {
+	identityKey := getIdentityKey(r)
+	if identityKey == "" {
+		writeError(w, 401, "ERR_AUTHENTICATION_REQUIRED", "Authentication required.")
+		return
+	}
+
+	var req BulkSetPermissionsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, 400, "ERR_INVALID_JSON", "Invalid JSON body")
+		return
+	}
+
+	if len(req.Permissions) == 0 || len(req.Permissions) > maxBulkPermissions {
+		writeError(w, 400, "ERR_INVALID_REQUEST", fmt.Sprintf("permissions must contain between 1 and %d entries.", maxBulkPermissions))
+		return
+	}
+
+	rules, entryErrs := parseBulkPermissions(req.Permissions)
+	if len(entryErrs) > 0 {
+		writeJSON(w, 400, BulkSetPermissionsError{
+			Status:      "error",
+			Code:        "ERR_INVALID_ENTRIES",
+			Description: fmt.Sprintf("%d of %d entries are invalid; no permissions were changed.", len(entryErrs), len(req.Permissions)),
+			Errors:      entryErrs,
+		})
+		return
+	}
+
+	if err := s.DB.SetMessagePermissionRules(identityKey, rules); err != nil {
+		logger.Error("failed to bulk set permissions", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to update message permissions.")
+		return
+	}
+
+	writeJSON(w, 200, BulkSetPermissionsResponse{
+		Status:  "success",
+		Applied: len(rules),
+	})
+}
+
+// ExportPermissions godoc
+// @Summary      Export message permissions
+// @Description  Returns all permissions of the authenticated identity as JSON (default) or CSV, in the /permissions/set format so they can be re-applied with /permissions/bulkSet.
+// @Description  Expired or used-up rules are left out; capped rules are exported with their remaining message count.
+// @Tags         Permissions
+// @Produce      json
+// @Produce      text/csv
+// @Param        format query string false "Export format: 'json' or 'csv' (default 'json')"
+// @Success      200  {object}  ExportPermissionsResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /permissions/export [get]
+func (s *Server) ExportPermissions(w http.ResponseWriter, r *http.Request) {
+	identityKey := getIdentityKey(r)
+	if identityKey == "" {
+		writeError(w, 401, "ERR_AUTHENTICATION_REQUIRED", "Authentication required.")
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		writeError(w, 400, "ERR_INVALID_FORMAT", "format must be 'json' or 'csv'.")
+		return
+	}
+
+	perms, err := s.DB.ExportPermissions(identityKey)
+	if err != nil {
+		logger.Error("failed to export permissions", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to export permissions.")
+		return
+	}
+
+	now := time.Now()
+	entries := []SetPermissionRequest{}
+	for i := range perms {
+		if perms[i].ActiveAt(now) {
+			entries = append(entries, toExportEntry(&perms[i]))
+		}
+	}
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="permissions.csv"`)
+		w.WriteHeader(200)
+		if err := writePermissionsCSV(w, entries); err != nil {
+			logger.Error("failed to write CSV export", "error", err)
+		}
+		return
+	}
+
+	writeJSON(w, 200, ExportPermissionsResponse{
+		Status:      "success",
+		ExportedAt:  now.UTC().Format("2006-01-02T15:04:05.000Z"),
+		Permissions: entries,
+	})
+}
+
+// parseBulkPermissions validates every entry, collecting all errors rather than stopping at the first.
+func parseBulkPermissions(entries []SetPermissionRequest) ([]db.PermissionRule, []BulkPermissionEntryError) {
+	rules := make([]db.PermissionRule, 0, len(entries))
+	var errs []BulkPermissionEntryError
+	for i, entry := range entries {
+		rule, code, desc := parseSetPermission(entry)
+		if code != "" {
+			errs = append(errs, BulkPermissionEntryError{Index: i, Code: code, Description: desc})
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules, errs
+}
+
+// toExportEntry converts a stored rule to the /permissions/set format.
+func toExportEntry(p *db.PermissionRecord) SetPermissionRequest {
+	fee := p.RecipientFee
+	entry := SetPermissionRequest{MessageBox: p.MessageBox, RecipientFee: &fee}
+	if p.Sender.Valid {
+		sender := p.Sender.String
+		entry.Sender = &sender
+	}
+	entry.ExpiresAt, _ = permissionLimitFields(p)
+	entry.MaxMessages = p.RemainingMessages()
+	if p.FeePerKB > 0 {
+		v := p.FeePerKB
+		entry.FeePerKB = &v
+	}
+	if p.LargePayloadThreshold > 0 {
+		threshold, surcharge := p.LargePayloadThreshold, p.LargePayloadFee
+		entry.LargePayloadThreshold = &threshold
+		entry.LargePayloadFee = &surcharge
+	}
+	if p.PowDifficulty > 0 {
+		v := p.PowDifficulty
+		entry.PowDifficulty = &v
+	}
+	return entry
+}
+
+// writePermissionsCSV writes entries with permissionCSVHeader columns; unset values are empty cells.
+func writePermissionsCSV(w http.ResponseWriter, entries []SetPermissionRequest) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(permissionCSVHeader); err != nil {
+		return err
+	}
+
+	str := func(v *string) string {
+		if v == nil {
+			return ""
+		}
+		return *v
+	}
+	num := func(v *int) string {
+		if v == nil {
+			return ""
+		}
+		return strconv.Itoa(*v)
+	}
+
+	for _, e := range entries {
+		if err := cw.Write([]string{
+			str(e.Sender), e.MessageBox, num(e.RecipientFee), str(e.ExpiresAt), num(e.MaxMessages),
+			num(e.FeePerKB), num(e.LargePayloadThreshold), num(e.LargePayloadFee), num(e.PowDifficulty),
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -6,13 +6,20 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bsv-blockchain/go-message-box-server/internal/logger"
+	"github.com/bsv-blockchain/go-message-box-server/pkg/db"
 )
 
 // SetPermission godoc
 // @Summary      Set a message permission
 // @Description  Sets fee requirements for receiving messages. Use recipientFee=0 for free, recipientFee=-1 to block, or a positive value for required payment in satoshis. Omit sender for box-wide defaults.
+// @Description  Sender-specific rules may set expiresAt and/or maxMessages; once either is reached the box-wide default applies again.
+// @Description  feePerKb, largePayloadThreshold and largePayloadFee add size-based charges on top of recipientFee.
+// @Description  powDifficulty makes every message also carry a proof of work with that many leading zero bits (see sendMessage's proofOfWork), a cost for senders into free boxes that needs no payment.
+// @Description  messageBox may be a glob pattern (e.g. "app.*") matching many boxes. Rules are resolved from most to least specific:
+// @Description  sender + exact box, sender + pattern, box-wide exact, box-wide pattern; among patterns the longer literal prefix wins.
 // @Tags         Permissions
 // @Accept       json
 // @Produce      json
@@ -36,19 +43,14 @@ func (s *Server) SetPermission(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.MessageBox == "" || req.RecipientFee == nil {
-		writeError(w, 400, "ERR_INVALID_REQUEST", "messageBox (string) and recipientFee (number) are required. sender (string) is optional for box-wide settings.")
-		return
-	}
-
-	if req.Sender != nil && !isValidPubKey(*req.Sender) {
-		writeError(w, 400, "ERR_INVALID_PUBLIC_KEY", "Invalid sender public key format.")
+	rule, code, desc := parseSetPermission(req)
+	if code != "" {
+		writeError(w, 400, code, desc)
 		return
 	}
+	fee, limits, pricing := rule.RecipientFee, rule.Limits, rule.Pricing
 
-	fee := *req.RecipientFee
-
-	if err := s.DB.SetMessagePermission(identityKey, req.Sender, req.MessageBox, fee); err != nil {
+	if err := s.DB.SetPermissionRule(identityKey, rule); err != nil {
 		logger.Error("failed to set permission", "error", err)
 		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to update message permission.")
 		return
@@ -71,7 +73,7 @@ func (s *Server) SetPermission(w http.ResponseWriter, r *http.Request) {
 		} else {
 			description = fmt.Sprintf("%s %s to %s are now blocked.", actionText, senderText, req.MessageBox)
 		}
-	case fee == 0:
+	case fee == 0 && pricing.IsFlat():
 		if isBoxWide {
 			description = fmt.Sprintf("%s %s to %s is now always allowed.", actionText, senderText, req.MessageBox)
 		} else {
@@ -85,6 +87,22 @@ func (s *Server) SetPermission(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if pricing.FeePerKB > 0 {
+		description += fmt.Sprintf(" Plus %d satoshis per KB of message body.", pricing.FeePerKB)
+	}
+	if pricing.LargePayloadThreshold > 0 && pricing.LargePayloadFee > 0 {
+		description += fmt.Sprintf(" Plus %d satoshis for bodies over %d bytes.", pricing.LargePayloadFee, pricing.LargePayloadThreshold)
+	}
+	if rule.PowDifficulty > 0 {
+		description += fmt.Sprintf(" Each message also needs a proof of work of %d bits.", rule.PowDifficulty)
+	}
+	if limits.ExpiresAt != nil {
+		description += fmt.Sprintf(" Expires at %s.", limits.ExpiresAt.UTC().Format("2006-01-02T15:04:05.000Z"))
+	}
+	if limits.MaxMessages != nil {
+		description += fmt.Sprintf(" Applies to the next %d message(s).", *limits.MaxMessages)
+	}
+
 	writeJSON(w, 200, SetPermissionResponse{
 		Status:      "success",
 		Description: description,
@@ -141,6 +159,10 @@ func (s *Server) GetPermission(w http.ResponseWriter, r *http.Request) {
 		} else if perm.RecipientFee > 0 {
 			status = "payment_required"
 		}
+		if !perm.ActiveAt(time.Now()) {
+			status = "expired"
+		}
+		expiresAt, maxMessages := permissionLimitFields(perm)
 
 		var desc string
 		if sender != nil {
@@ -162,8 +184,18 @@ func (s *Server) GetPermission(w http.ResponseWriter, r *http.Request) {
 				MessageBox:   messageBox,
 				RecipientFee: perm.RecipientFee,
 				Status:       status,
-				CreatedAt:    perm.CreatedAt.Format("2006-01-02T15:04:05.000Z"),
-				UpdatedAt:    perm.UpdatedAt.Format("2006-01-02T15:04:05.000Z"),
+				ExpiresAt:    expiresAt,
+				MaxMessages:  maxMessages,
+				MessagesUsed: perm.MessagesUsed,
+				IsPattern:    perm.IsPattern,
+				SizePricingDetail: SizePricingDetail{
+					FeePerKB:              perm.FeePerKB,
+					LargePayloadThreshold: perm.LargePayloadThreshold,
+					LargePayloadFee:       perm.LargePayloadFee,
+				},
+				PowDifficulty: perm.PowDifficulty,
+				CreatedAt:     perm.CreatedAt.Format("2006-01-02T15:04:05.000Z"),
+				UpdatedAt:     perm.UpdatedAt.Format("2006-01-02T15:04:05.000Z"),
 			},
 		})
 	} else {
@@ -183,6 +215,7 @@ func (s *Server) GetPermission(w http.ResponseWriter, r *http.Request) {
 // ListPermissions godoc
 // @Summary      List message permissions
 // @Description  Returns all permission settings for the authenticated identity, optionally filtered by message box.
+// @Description  When filtered by message box, pattern rules matching the box are included in precedence order and the rule that applies for each sender is marked effective.
 // @Tags         Permissions
 // @Produce      json
 // @Param        messageBox query string false "Filter by message box name"
@@ -208,18 +241,13 @@ func (s *Server) ListPermissions(w http.ResponseWriter, r *http.Request) {
 		messageBox = &messageBoxParam
 	}
 
-	limitStr := r.URL.Query().Get("limit")
 	offsetStr := r.URL.Query().Get("offset")
 	sortOrder := r.URL.Query().Get("createdAtOrder")
 
-	limit := 100
-	if limitStr != "" {
-		if v, err := strconv.Atoi(limitStr); err == nil && v >= 1 && v <= 1000 {
-			limit = v
-		} else {
-			writeError(w, 400, "ERR_INVALID_LIMIT", "Limit must be a number between 1 and 1000")
-			return
-		}
+	limit, err := parseLimit(r, 100, 1000)
+	if err != nil {
+		writeError(w, 400, "ERR_INVALID_LIMIT", err.Error())
+		return
 	}
 
 	offset := 0
@@ -249,12 +277,22 @@ func (s *Server) ListPermissions(w http.ResponseWriter, r *http.Request) {
 		if p.Sender.Valid {
 			senderVal = &p.Sender.String
 		}
+		expiresAt, maxMessages := permissionLimitFields(&p)
 		out = append(out, PermissionDetailList{
-			Sender:       senderVal,
-			MessageBox:   p.MessageBox,
-			RecipientFee: p.RecipientFee,
-			CreatedAt:    p.CreatedAt.Format("2006-01-02T15:04:05.000Z"),
-			UpdatedAt:    p.UpdatedAt.Format("2006-01-02T15:04:05.000Z"),
+			Sender:                senderVal,
+			MessageBox:            p.MessageBox,
+			RecipientFee:          p.RecipientFee,
+			ExpiresAt:             expiresAt,
+			MaxMessages:           maxMessages,
+			MessagesUsed:          p.MessagesUsed,
+			FeePerKB:              p.FeePerKB,
+			LargePayloadThreshold: p.LargePayloadThreshold,
+			LargePayloadFee:       p.LargePayloadFee,
+			PowDifficulty:         p.PowDifficulty,
+			IsPattern:             p.IsPattern,
+			Effective:             p.Effective,
+			CreatedAt:             p.CreatedAt.Format("2006-01-02T15:04:05.000Z"),
+			UpdatedAt:             p.UpdatedAt.Format("2006-01-02T15:04:05.000Z"),
 		})
 	}
 
@@ -272,14 +310,24 @@ func (s *Server) ListPermissions(w http.ResponseWriter, r *http.Request) {
 // GetQuote godoc
 // @Summary      Get a delivery quote
 // @Description  Returns fee information for sending a message to one or more recipients. Single recipient returns QuoteSingleResponse, multiple recipients returns QuoteMultiResponse.
+// @Description  When a time-bounded or usage-capped sender rule applies, permissionExpiresAt and remainingMessages are included.
+// @Description  When a rate limit applies, rateLimit reports the remaining messages in the current window.
+// @Description  When the sender's reputation raised a recipient fee, reputation names the level (see /reputation). Banned senders fail with 403 ERR_SENDER_BANNED.
+// @Description  When the recipient offers a subscription to the box, subscription reports its price and duration; sendMessage with subscribe buys it instead of paying recipientFee.
+// @Description  When the recipient's rule requires a proof of work, powDifficulty reports its leading zero bits (see sendMessage's proofOfWork).
+// @Description  When the recipient requires an identity certificate on the box, certificate reports the accepted certifiers, type and fields, the fee for verified senders, and whether the sender is verified; senders who cannot deliver without one have status certificate_required.
+// @Description  Recipient fees are computed for bodySize; when a recipient uses size-based pricing, baseRecipientFee and pricing are included.
+// @Description  The response carries a quoteId signed by the server that sendMessage accepts until quoteExpiresAt to pay exactly the quoted fees. It covers bodies up to bodySize bytes.
 // @Tags         Permissions
 // @Produce      json
 // @Param        recipient query string true "Recipient public key (can be repeated for multiple recipients)"
 // @Param        messageBox query string true "Name of the message box"
+// @Param        bodySize query int false "Size in bytes of the message body to be sent, used for size-based pricing (default 0)"
 // @Success      200  {object}  QuoteSingleResponse "Single recipient quote"
 // @Success      200  {object}  QuoteMultiResponse "Multiple recipient quote"
 // @Failure      400  {object}  ErrorResponse
 // @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
 // @Failure      500  {object}  ErrorResponse
 // @Security     BSVAuth
 // @Router       /permissions/quote [get]
@@ -320,6 +368,34 @@ func (s *Server) GetQuote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	bodySize := 0
+	if v := r.URL.Query().Get("bodySize"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, 400, "ERR_INVALID_BODY_SIZE", "bodySize must be a non-negative number of bytes.")
+			return
+		}
+		bodySize = n
+	}
+
+	reputation, err := s.reputation(senderKey, time.Now())
+	if err != nil {
+		logger.Error("failed to get sender reputation", "error", err)
+		writeError(w, 500, "ERR_INTERNAL", "An internal error has occurred.")
+		return
+	}
+	if reputation.Level == ReputationBanned {
+		writeError(w, 403, "ERR_SENDER_BANNED", "This sender was reported as spam too often and cannot send messages.")
+		return
+	}
+
+	senderCerts, err := s.DB.ListSenderCertificates(senderKey, time.Now())
+	if err != nil {
+		logger.Error("failed to list sender certificates", "error", err)
+		writeError(w, 500, "ERR_INTERNAL", "An internal error has occurred.")
+		return
+	}
+
 	deliveryFee, err := s.DB.GetServerDeliveryFee(messageBox)
 	if err != nil {
 		logger.Error("failed to get delivery fee", "error", err)
@@ -329,52 +405,94 @@ func (s *Server) GetQuote(w http.ResponseWriter, r *http.Request) {
 
 	// Single recipient: legacy response
 	if len(recipients) == 1 {
-		recipientFee, err := s.DB.GetRecipientFee(recipients[0], senderKey, messageBox)
+		now := time.Now()
+		res, err := s.DB.ResolveRecipientFee(recipients[0], senderKey, messageBox, now)
 		if err != nil {
 			logger.Error("failed to get recipient fee", "error", err)
 			writeError(w, 500, "ERR_INTERNAL", "An internal error has occurred.")
 			return
 		}
+		limits, err := s.quoteLimits(res, recipients[0], senderKey, messageBox, now)
+		if err != nil {
+			logger.Error("failed to get quote limits", "error", err)
+			writeError(w, 500, "ERR_INTERNAL", "An internal error has occurred.")
+			return
+		}
+		certs, err := s.checkCertificates(recipients[0], messageBox, res, senderCerts)
+		if err != nil {
+			logger.Error("failed to check sender certificates", "error", err)
+			writeError(w, 500, "ERR_INTERNAL", "An internal error has occurred.")
+			return
+		}
+		rf := quotedRecipientFee(&limits, reputation, certs, res, bodySize)
+		signed, ok := s.writeSignedQuote(w, r, senderKey, messageBox, bodySize, deliveryFee, []quotedRecipient{newQuotedRecipient(recipients[0], rf, res)}, now)
+		if !ok {
+			return
+		}
 		writeJSON(w, 200, QuoteSingleResponse{
 			Status:      "success",
 			Description: "Message delivery quote generated.",
 			Quote: QuoteSingle{
 				DeliveryFee:  deliveryFee,
-				RecipientFee: recipientFee,
+				RecipientFee: rf,
+				QuoteLimits:  withPricing(limits, res),
 			},
+			SignedQuote: signed,
 		})
 		return
 	}
 
 	var quotes []QuoteEntry
+	var quoted []quotedRecipient
 	var blockedRecipients []string
 	totalRecipientFees := 0
 	totalDeliveryFees := 0
 
 	for _, rec := range recipients {
-		rf, err := s.DB.GetRecipientFee(rec, senderKey, messageBox)
+		now := time.Now()
+		res, err := s.DB.ResolveRecipientFee(rec, senderKey, messageBox, now)
 		if err != nil {
 			logger.Error("failed to get recipient fee", "error", err)
 			writeError(w, 500, "ERR_INTERNAL", "An internal error has occurred.")
 			return
 		}
+		limits, err := s.quoteLimits(res, rec, senderKey, messageBox, now)
+		if err != nil {
+			logger.Error("failed to get quote limits", "error", err)
+			writeError(w, 500, "ERR_INTERNAL", "An internal error has occurred.")
+			return
+		}
+		limits = withPricing(limits, res)
+		certs, err := s.checkCertificates(rec, messageBox, res, senderCerts)
+		if err != nil {
+			logger.Error("failed to check sender certificates", "error", err)
+			writeError(w, 500, "ERR_INTERNAL", "An internal error has occurred.")
+			return
+		}
+		rf := quotedRecipientFee(&limits, reputation, certs, res, bodySize)
 
 		status := "always_allow"
 		if rf == -1 {
 			status = "blocked"
 			blockedRecipients = append(blockedRecipients, rec)
+		} else if !certs.allowed() {
+			status = "certificate_required"
+		} else if limits.RateLimit != nil && limits.RateLimit.Remaining == 0 {
+			status = "rate_limited"
 		} else if rf > 0 {
 			status = "payment_required"
 			totalRecipientFees += rf
 		}
 		totalDeliveryFees += deliveryFee
 
+		quoted = append(quoted, newQuotedRecipient(rec, rf, res))
 		quotes = append(quotes, QuoteEntry{
 			Recipient:    rec,
 			MessageBox:   messageBox,
 			DeliveryFee:  deliveryFee,
 			RecipientFee: rf,
 			Status:       status,
+			QuoteLimits:  limits,
 		})
 	}
 
@@ -385,6 +503,11 @@ func (s *Server) GetQuote(w http.ResponseWriter, r *http.Request) {
 		blockedRecipients = []string{}
 	}
 
+	signed, ok := s.writeSignedQuote(w, r, senderKey, messageBox, bodySize, deliveryFee, quoted, time.Now())
+	if !ok {
+		return
+	}
+
 	writeJSON(w, 200, QuoteMultiResponse{
 		Status:            "success",
 		Description:       fmt.Sprintf("Message delivery quotes generated for %d recipients.", len(recipients)),
@@ -395,5 +518,206 @@ func (s *Server) GetQuote(w http.ResponseWriter, r *http.Request) {
 			TotalForPayableRecipients: totalDeliveryFees + totalRecipientFees,
 		},
 		BlockedRecipients: blockedRecipients,
+		SignedQuote:       signed,
 	})
 }
+
+// writeSignedQuote issues a signed quote, writing a 500 error and returning false if signing fails.
+func (s *Server) writeSignedQuote(w http.ResponseWriter, r *http.Request, sender, messageBox string, bodySize, deliveryFee int, recipients []quotedRecipient, now time.Time) (SignedQuote, bool) {
+	id, expiresAt, err := s.issueQuote(r.Context(), sender, messageBox, bodySize, deliveryFee, recipients, now)
+	if err != nil {
+		logger.Error("failed to sign quote", "error", err)
+		writeError(w, 500, "ERR_INTERNAL", "An internal error has occurred.")
+		return SignedQuote{}, false
+	}
+	if id == "" {
+		return SignedQuote{}, true
+	}
+	return SignedQuote{QuoteID: id, QuoteExpiresAt: expiresAt.UTC().Format("2006-01-02T15:04:05.000Z")}, true
+}
+
+// parseSetPermission validates a SetPermissionRequest and converts it to a rule.
+// Returns an error code and description when invalid.
+func parseSetPermission(req SetPermissionRequest) (db.PermissionRule, string, string) {
+	rule := db.PermissionRule{Sender: req.Sender, MessageBox: req.MessageBox}
+
+	if req.MessageBox == "" || req.RecipientFee == nil {
+		return rule, "ERR_INVALID_REQUEST", "messageBox (string) and recipientFee (number) are required. sender (string) is optional for box-wide settings."
+	}
+	rule.RecipientFee = *req.RecipientFee
+	if rule.RecipientFee < -1 || rule.RecipientFee > db.MaxFee {
+		return rule, "ERR_INVALID_FEE", fmt.Sprintf("recipientFee must be -1 to block, or between 0 and %d satoshis.", db.MaxFee)
+	}
+
+	if req.Sender != nil && !isValidPubKey(*req.Sender) {
+		return rule, "ERR_INVALID_PUBLIC_KEY", "Invalid sender public key format."
+	}
+
+	if db.IsBoxPattern(req.MessageBox) {
+		if err := db.ValidateBoxPattern(req.MessageBox); err != nil {
+			return rule, "ERR_INVALID_MESSAGEBOX_PATTERN", "messageBox is not a valid glob pattern."
+		}
+	}
+
+	var code, desc string
+	if rule.Limits, code, desc = parsePermissionLimits(req); code != "" {
+		return rule, code, desc
+	}
+	if rule.Pricing, code, desc = parseSizePricing(req); code != "" {
+		return rule, code, desc
+	}
+	if req.PowDifficulty != nil {
+		if *req.PowDifficulty < 0 || *req.PowDifficulty > maxPowDifficulty {
+			return rule, "ERR_INVALID_POW_DIFFICULTY", fmt.Sprintf("powDifficulty must be between 0 and %d.", maxPowDifficulty)
+		}
+		if rule.RecipientFee == -1 && *req.PowDifficulty > 0 {
+			return rule, "ERR_INVALID_REQUEST", "powDifficulty cannot be set on a blocking permission."
+		}
+		rule.PowDifficulty = *req.PowDifficulty
+	}
+	return rule, "", ""
+}
+
+// parsePermissionLimits validates the optional expiresAt/maxMessages of a SetPermissionRequest.
+// Returns an error code and description when invalid.
+func parsePermissionLimits(req SetPermissionRequest) (db.PermissionLimits, string, string) {
+	var limits db.PermissionLimits
+	if req.ExpiresAt == nil && req.MaxMessages == nil {
+		return limits, "", ""
+	}
+
+	if req.Sender == nil {
+		return limits, "ERR_INVALID_REQUEST", "expiresAt and maxMessages are only supported for sender-specific permissions."
+	}
+
+	if req.ExpiresAt != nil {
+		t, err := time.Parse(time.RFC3339, *req.ExpiresAt)
+		if err != nil {
+			return limits, "ERR_INVALID_EXPIRES_AT", "expiresAt must be an RFC 3339 timestamp."
+		}
+		if !t.After(time.Now()) {
+			return limits, "ERR_INVALID_EXPIRES_AT", "expiresAt must be in the future."
+		}
+		limits.ExpiresAt = &t
+	}
+
+	if req.MaxMessages != nil {
+		if *req.MaxMessages < 1 {
+			return limits, "ERR_INVALID_MAX_MESSAGES", "maxMessages must be a positive number."
+		}
+		limits.MaxMessages = req.MaxMessages
+	}
+
+	return limits, "", ""
+}
+
+// permissionLimitFields formats the limits of a permission record for responses.
+func permissionLimitFields(p *db.PermissionRecord) (*string, *int) {
+	var expiresAt *string
+	if p.ExpiresAt.Valid {
+		v := p.ExpiresAt.Time.UTC().Format("2006-01-02T15:04:05.000Z")
+		expiresAt = &v
+	}
+	var maxMessages *int
+	if p.MaxMessages.Valid {
+		v := int(p.MaxMessages.Int64)
+		maxMessages = &v
+	}
+	return expiresAt, maxMessages
+}
+
+// quoteLimits reports the limits of the sender-specific rule behind a fee, the sender's rate limit state
+// and the recipient's subscription offer.
+func (s *Server) quoteLimits(res *db.FeeResolution, recipient, sender, messageBox string, now time.Time) (QuoteLimits, error) {
+	var limits QuoteLimits
+	if res.Rule != nil && res.Rule.IsPattern {
+		limits.MatchedRule = &res.Rule.MessageBox
+	}
+	if res.Default != nil {
+		limits.DefaultPolicy = &res.Default.MessageBox
+	}
+	if res.Permission != nil {
+		limits.PermissionExpiresAt, _ = permissionLimitFields(res.Permission)
+		limits.RemainingMessages = res.Permission.RemainingMessages()
+	}
+	if res.Subscription != nil {
+		v := res.Subscription.ExpiresAt.UTC().Format("2006-01-02T15:04:05.000Z")
+		limits.PermissionExpiresAt = &v
+	}
+
+	st, err := s.DB.CheckRateLimit(recipient, sender, messageBox, now)
+	if err != nil {
+		return limits, err
+	}
+	limits.RateLimit = toRateLimitQuote(st, now)
+
+	offer, err := s.DB.GetSubscriptionOffer(recipient, messageBox)
+	if err != nil {
+		return limits, err
+	}
+	limits.Subscription = subscriptionQuote(offer, res)
+	limits.PowDifficulty = res.PowDifficulty()
+	return limits, nil
+}
+
+// parseSizePricing validates the optional size-based pricing of a SetPermissionRequest.
+// Returns an error code and description when invalid.
+func parseSizePricing(req SetPermissionRequest) (db.SizePricing, string, string) {
+	var pricing db.SizePricing
+	if req.FeePerKB == nil && req.LargePayloadThreshold == nil && req.LargePayloadFee == nil {
+		return pricing, "", ""
+	}
+
+	if *req.RecipientFee < 0 {
+		return pricing, "ERR_INVALID_PRICING", "Size-based pricing cannot be combined with a blocked permission."
+	}
+
+	if req.FeePerKB != nil {
+		pricing.FeePerKB = *req.FeePerKB
+	}
+	if req.LargePayloadThreshold != nil {
+		pricing.LargePayloadThreshold = *req.LargePayloadThreshold
+	}
+	if req.LargePayloadFee != nil {
+		pricing.LargePayloadFee = *req.LargePayloadFee
+	}
+
+	if pricing.FeePerKB < 0 || pricing.LargePayloadThreshold < 0 || pricing.LargePayloadFee < 0 {
+		return pricing, "ERR_INVALID_PRICING", "feePerKb, largePayloadThreshold and largePayloadFee must not be negative."
+	}
+	if pricing.FeePerKB > db.MaxFee || pricing.LargePayloadFee > db.MaxFee {
+		return pricing, "ERR_INVALID_PRICING", fmt.Sprintf("feePerKb and largePayloadFee must be at most %d satoshis.", db.MaxFee)
+	}
+	if (pricing.LargePayloadThreshold > 0) != (pricing.LargePayloadFee > 0) {
+		return pricing, "ERR_INVALID_PRICING", "largePayloadThreshold and largePayloadFee must be set together."
+	}
+
+	return pricing, "", ""
+}
+
+// quotedRecipientFee prices a body of bodySize bytes for the sender's certificates and reputation,
+// reporting the certificate requirement in limits and naming the reputation when it raised the fee.
+func quotedRecipientFee(limits *QuoteLimits, rep senderReputation, certs certificateCheck, res *db.FeeResolution, bodySize int) int {
+	limits.Certificate = certs.quote()
+	fee := certs.recipientFee(res.FeeFor(bodySize))
+	adjusted := rep.recipientFee(fee, res)
+	if adjusted != fee {
+		limits.Reputation = &rep.Level
+	}
+	return adjusted
+}
+
+// withPricing adds the base fee and size-based pricing to quote limits when the price depends on the body size.
+func withPricing(limits QuoteLimits, res *db.FeeResolution) QuoteLimits {
+	if res.Fee < 0 || res.Pricing.IsFlat() {
+		return limits
+	}
+	base := res.Fee
+	limits.BaseRecipientFee = &base
+	limits.Pricing = &SizePricingDetail{
+		FeePerKB:              res.Pricing.FeePerKB,
+		LargePayloadThreshold: res.Pricing.LargePayloadThreshold,
+		LargePayloadFee:       res.Pricing.LargePayloadFee,
+	}
+	return limits
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"math/bits"
+)
+
+// maxPowDifficulty caps the difficulty a recipient can require; each bit doubles the sender's work.
+const maxPowDifficulty = 32
+
+// maxPowNonceLength bounds the nonces accepted in proofOfWork.
+const maxPowNonceLength = 64
+
+// proofOfWorkDigest is the hash a sender's nonce must bring below the difficulty target. It binds the proof
+// to one message, so a proof cannot be reused for another recipient, box or messageId; the digest is
+// recorded with the message, so it cannot be reused after the messageId is acknowledged either.
+func proofOfWorkDigest(sender, recipient, messageBox, messageID, nonce string) [32]byte {
+	return sha256.Sum256([]byte(sender + ":" + recipient + ":" + messageBox + ":" + messageID + ":" + nonce))
+}
+
+// leadingZeroBits counts the zero bits at the start of digest.
+func leadingZeroBits(digest [32]byte) int {
+	n := 0
+	for _, b := range digest {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+// verifyProofOfWork reports whether nonce proves difficulty bits of work for the message.
+func verifyProofOfWork(sender, recipient, messageBox, messageID, nonce string, difficulty int) bool {
+	if difficulty <= 0 {
+		return true
+	}
+	if nonce == "" || len(nonce) > maxPowNonceLength {
+		return false
+	}
+	return leadingZeroBits(proofOfWorkDigest(sender, recipient, messageBox, messageID, nonce)) >= difficulty
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bsv-blockchain/go-message-box-server/pkg/db"
+	ec "github.com/bsv-blockchain/go-sdk/primitives/ec"
+	sdk "github.com/bsv-blockchain/go-sdk/wallet"
+)
+
+// DefaultQuoteTTL is how long a signed quote can be presented to sendMessage.
+const DefaultQuoteTTL = 5 * time.Minute
+
+// Quotes are signed by the server identity for "anyone", so clients can verify them with
+// ProtoWallet("anyone").VerifySignature using the server identity key as counterparty.
+var quoteProtocol = sdk.Protocol{SecurityLevel: sdk.SecurityLevelEveryApp, Protocol: "messagebox quote"}
+
+const quoteKeyID = "1"
+
+// signedQuote is the payload of a quote id.
+type signedQuote struct {
+	ID          string            `json:"id"`
+	Sender      string            `json:"sender"`
+	MessageBox  string            `json:"messageBox"`
+	BodySize    int               `json:"bodySize"`
+	DeliveryFee int               `json:"deliveryFee"`
+	Recipients  []quotedRecipient `json:"recipients"`
+	ExpiresAt   int64             `json:"expiresAt"` // unix seconds
+}
+
+// quotedRecipient is the recipient fee locked by a quote, -1 if the recipient was blocked, with the sender
+// rule or subscription that priced it (0 for none).
+type quotedRecipient struct {
+	Recipient      string `json:"recipient"`
+	RecipientFee   int    `json:"recipientFee"`
+	PermissionID   int    `json:"permissionId,omitempty"`
+	SubscriptionID int64  `json:"subscriptionId,omitempty"`
+}
+
+// newQuotedRecipient quotes fee for recipient, bound to the rule or subscription of res.
+func newQuotedRecipient(recipient string, fee int, res *db.FeeResolution) quotedRecipient {
+	q := quotedRecipient{Recipient: recipient, RecipientFee: fee}
+	if res.Permission != nil {
+		q.PermissionID = res.Permission.ID
+	}
+	if res.Subscription != nil {
+		q.SubscriptionID = res.Subscription.ID
+	}
+	return q
+}
+
+// pricedBy reports whether res is still the rule or subscription the quote was priced by. A capped rule
+// used up since, or an expired rule or subscription, no longer resolves, so its quoted fee must not apply.
+func (q quotedRecipient) pricedBy(res *db.FeeResolution) bool {
+	return q == newQuotedRecipient(q.Recipient, q.RecipientFee, res)
+}
+
+// quoteError is returned when a presented quote cannot be used.
+type quoteError struct {
+	status      int
+	code        string
+	description string
+}
+
+func (e *quoteError) Error() string { return e.description }
+
+// issueQuote signs a quote for the fees just computed. Returns an empty id when the server has no wallet.
+func (s *Server) issueQuote(ctx context.Context, sender, messageBox string, bodySize, deliveryFee int, recipients []quotedRecipient, now time.Time) (string, time.Time, error) {
+	if s.wallet == nil {
+		return "", time.Time{}, nil
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiresAt := now.Add(s.quoteTTL).Truncate(time.Second)
+	id, err := s.signQuote(ctx, &signedQuote{
+		ID:          hex.EncodeToString(nonce),
+		Sender:      sender,
+		MessageBox:  messageBox,
+		BodySize:    bodySize,
+		DeliveryFee: deliveryFee,
+		Recipients:  recipients,
+		ExpiresAt:   expiresAt.Unix(),
+	})
+	return id, expiresAt, err
+}
+
+// signQuote returns the quote id: the base64url JSON payload and signature joined by a dot.
+func (s *Server) signQuote(ctx context.Context, q *signedQuote) (string, error) {
+	payload, err := json.Marshal(q)
+	if err != nil {
+		return "", err
+	}
+	res, err := s.wallet.CreateSignature(ctx, sdk.CreateSignatureArgs{
+		EncryptionArgs: quoteEncryptionArgs(),
+		Data:           payload,
+	}, "messagebox-server")
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(res.Signature.Serialize()), nil
+}
+
+// verifyQuote checks the signature and expiry of a quote id and returns its payload.
+func (s *Server) verifyQuote(ctx context.Context, quoteID string, now time.Time) (*signedQuote, error) {
+	invalid := &quoteError{status: 400, code: "ERR_INVALID_QUOTE", description: "Quote is malformed or was not issued by this server."}
+
+	payloadPart, sigPart, ok := strings.Cut(quoteID, ".")
+	if !ok {
+		return nil, invalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(payloadPart)
+	if err != nil {
+		return nil, invalid
+	}
+	sigBytes, err := base64.RawURLEncoding.DecodeString(sigPart)
+	if err != nil {
+		return nil, invalid
+	}
+	sig, err := ec.ParseDERSignature(sigBytes)
+	if err != nil {
+		return nil, invalid
+	}
+
+	forSelf := true
+	res, err := s.wallet.VerifySignature(ctx, sdk.VerifySignatureArgs{
+		EncryptionArgs: quoteEncryptionArgs(),
+		Data:           payload,
+		Signature:      sig,
+		ForSelf:        &forSelf,
+	}, "messagebox-server")
+	if err != nil || !res.Valid {
+		return nil, invalid
+	}
+
+	var q signedQuote
+	if err := json.Unmarshal(payload, &q); err != nil {
+		return nil, invalid
+	}
+	if now.Unix() > q.ExpiresAt {
+		return nil, &quoteError{status: 400, code: "ERR_QUOTE_EXPIRED", description: "Quote has expired, request a new one."}
+	}
+	return &q, nil
+}
+
+// matches checks that a quote covers this send: same sender, box and recipients, and a body no larger than quoted.
+func (q *signedQuote) matches(sender, messageBox string, recipients []string, bodySize int) error {
+	quoted := make([]string, 0, len(q.Recipients))
+	for _, r := range q.Recipients {
+		quoted = append(quoted, r.Recipient)
+	}
+	sent := slices.Clone(recipients)
+	slices.Sort(quoted)
+	slices.Sort(sent)
+
+	var reason string
+	switch {
+	case q.Sender != sender:
+		reason = "it was issued to another sender"
+	case q.MessageBox != messageBox:
+		reason = fmt.Sprintf("it was issued for message box %s", q.MessageBox)
+	case !slices.Equal(quoted, sent):
+		reason = "its recipients differ"
+	case bodySize > q.BodySize:
+		reason = fmt.Sprintf("the body is larger than the quoted %d bytes", q.BodySize)
+	default:
+		return nil
+	}
+	return &quoteError{status: 400, code: "ERR_QUOTE_MISMATCH", description: "Quote does not cover this message: " + reason + "."}
+}
+
+// recipient returns what the quote locked for recipient.
+func (q *signedQuote) recipient(recipient string) (quotedRecipient, bool) {
+	for _, r := range q.Recipients {
+		if r.Recipient == recipient {
+			return r, true
+		}
+	}
+	return quotedRecipient{}, false
+}
+
+func quoteEncryptionArgs() sdk.EncryptionArgs {
+	return sdk.EncryptionArgs{
+		ProtocolID:   quoteProtocol,
+		KeyID:        quoteKeyID,
+		Counterparty: sdk.Counterparty{Type: sdk.CounterpartyTypeAnyone},
+	}
+}
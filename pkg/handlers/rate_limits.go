@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bsv-blockchain/go-message-box-server/internal/logger"
+	"github.com/bsv-blockchain/go-message-box-server/pkg/db"
+)
+
+const (
+	defaultRateLimitWindowSeconds = 3600
+	minRateLimitWindowSeconds     = 60
+	maxRateLimitWindowSeconds     = 7 * 24 * 3600
+)
+
+// SetRateLimit godoc
+// @Summary      Set a message rate limit
+// @Description  Limits how many messages a sender may deliver into a message box per time window. Omit sender to limit all senders together. Use maxMessages=0 to remove the limit.
+// @Tags         Permissions
+// @Accept       json
+// @Produce      json
+// @Param        request body SetRateLimitRequest true "Rate limit settings"
+// @Success      200  {object}  SetRateLimitResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /permissions/rateLimits/set [post]
+func (s *Server) SetRateLimit(w http.ResponseWriter, r *http.Request) {
+	identityKey := getIdentityKey(r)
+	if identityKey == "" {
+		writeError(w, 401, "ERR_AUTHENTICATION_REQUIRED", "Authentication required.")
+		return
+	}
+
+	var req SetRateLimitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, 400, "ERR_INVALID_JSON", "Invalid JSON body")
+		return
+	}
+
+	if req.MessageBox == "" || req.MaxMessages == nil {
+		writeError(w, 400, "ERR_INVALID_REQUEST", "messageBox (string) and maxMessages (number) are required. sender (string) is optional for box-wide limits.")
+		return
+	}
+
+	if req.Sender != nil && !isValidPubKey(*req.Sender) {
+		writeError(w, 400, "ERR_INVALID_PUBLIC_KEY", "Invalid sender public key format.")
+		return
+	}
+
+	if *req.MaxMessages < 0 {
+		writeError(w, 400, "ERR_INVALID_MAX_MESSAGES", "maxMessages must be zero or a positive number.")
+		return
+	}
+
+	window := defaultRateLimitWindowSeconds
+	if req.WindowSeconds != nil {
+		window = *req.WindowSeconds
+	}
+	if window < minRateLimitWindowSeconds || window > maxRateLimitWindowSeconds {
+		writeError(w, 400, "ERR_INVALID_WINDOW",
+			fmt.Sprintf("windowSeconds must be between %d and %d.", minRateLimitWindowSeconds, maxRateLimitWindowSeconds))
+		return
+	}
+
+	senderText := "all senders"
+	if req.Sender != nil {
+		senderText = *req.Sender
+	}
+
+	if *req.MaxMessages == 0 {
+		if _, err := s.DB.DeleteRateLimit(identityKey, req.Sender, req.MessageBox); err != nil {
+			logger.Error("failed to delete rate limit", "error", err)
+			writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to update rate limit.")
+			return
+		}
+		writeJSON(w, 200, SetRateLimitResponse{
+			Status:      "success",
+			Description: fmt.Sprintf("Messages from %s to %s are no longer rate limited.", senderText, req.MessageBox),
+		})
+		return
+	}
+
+	if err := s.DB.SetRateLimit(identityKey, req.Sender, req.MessageBox, *req.MaxMessages, window); err != nil {
+		logger.Error("failed to set rate limit", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to update rate limit.")
+		return
+	}
+
+	writeJSON(w, 200, SetRateLimitResponse{
+		Status:      "success",
+		Description: fmt.Sprintf("Messages from %s to %s are now limited to %d per %d seconds.", senderText, req.MessageBox, *req.MaxMessages, window),
+	})
+}
+
+// ListRateLimits godoc
+// @Summary      List message rate limits
+// @Description  Returns all rate limits of the authenticated identity, optionally filtered by message box.
+// @Tags         Permissions
+// @Produce      json
+// @Param        messageBox query string false "Filter by message box name"
+// @Success      200  {object}  ListRateLimitsResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /permissions/rateLimits/list [get]
+func (s *Server) ListRateLimits(w http.ResponseWriter, r *http.Request) {
+	identityKey := getIdentityKey(r)
+	if identityKey == "" {
+		writeError(w, 401, "ERR_AUTHENTICATION_REQUIRED", "Authentication required.")
+		return
+	}
+
+	var messageBox *string
+	if v := r.URL.Query().Get("messageBox"); v != "" {
+		messageBox = &v
+	}
+
+	limits, err := s.DB.ListRateLimits(identityKey, messageBox)
+	if err != nil {
+		logger.Error("failed to list rate limits", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to list rate limits.")
+		return
+	}
+
+	var out []RateLimitDetail
+	for _, l := range limits {
+		var senderVal *string
+		if l.Sender.Valid {
+			senderVal = &l.Sender.String
+		}
+		out = append(out, RateLimitDetail{
+			Sender:        senderVal,
+			MessageBox:    l.MessageBox,
+			MaxMessages:   l.MaxMessages,
+			WindowSeconds: l.WindowSeconds,
+			CreatedAt:     l.CreatedAt.Format("2006-01-02T15:04:05.000Z"),
+			UpdatedAt:     l.UpdatedAt.Format("2006-01-02T15:04:05.000Z"),
+		})
+	}
+
+	if out == nil {
+		out = []RateLimitDetail{}
+	}
+
+	writeJSON(w, 200, ListRateLimitsResponse{
+		Status:     "success",
+		RateLimits: out,
+	})
+}
+
+// toRateLimitQuote formats a rate limit state for quotes, nil when no limit applies.
+func toRateLimitQuote(st *db.RateLimitStatus, now time.Time) *RateLimitQuote {
+	if st == nil {
+		return nil
+	}
+	q := &RateLimitQuote{
+		Limit:         st.Limit,
+		WindowSeconds: st.WindowSeconds,
+		Remaining:     st.Remaining,
+		ResetAt:       st.ResetAt.UTC().Format("2006-01-02T15:04:05.000Z"),
+	}
+	if st.Limited() {
+		q.RetryAfterSeconds = st.RetryAfter(now)
+	}
+	return q
+}
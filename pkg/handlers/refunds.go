@@ -0,0 +1,371 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bsv-blockchain/go-message-box-server/internal/logger"
+	"github.com/bsv-blockchain/go-message-box-server/pkg/db"
+	ec "github.com/bsv-blockchain/go-sdk/primitives/ec"
+	"github.com/bsv-blockchain/go-sdk/script"
+	"github.com/bsv-blockchain/go-sdk/transaction/template/p2pkh"
+	sdk "github.com/bsv-blockchain/go-sdk/wallet"
+)
+
+// walletPaymentProtocol is the BRC-29 protocol refunds are locked with, so the payee's wallet
+// can internalize them as a "wallet payment" from the server.
+var walletPaymentProtocol = sdk.Protocol{SecurityLevel: sdk.SecurityLevelEveryAppAndCounterparty, Protocol: "3241645161d8"}
+
+// RejectPayment godoc
+// @Summary      Reject a paid message and refund its sender
+// @Description  Returns the recipient fee a sender paid with a message. The server never holds recipient fees, so the caller provides the refund: a payment from their wallet whose "wallet payment" outputs pay the original sender at least the amount received and name the caller as senderIdentityKey.
+// @Description  Each refund output refunds one payment only; outputs already used fail with 409 ERR_PAYMENT_REPLAYED. The refund is recorded, listed to the sender by /payments/refunds, and the message is deleted if it was not acknowledged yet. The server delivery fee is not refunded, since the message was delivered. A subscription bought with the message is revoked.
+// @Tags         Payments
+// @Accept       json
+// @Produce      json
+// @Param        request body RejectPaymentRequest true "Message to reject and the refund"
+// @Success      200  {object}  RejectPaymentResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      409  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /payments/reject [post]
+func (s *Server) RejectPayment(w http.ResponseWriter, r *http.Request) {
+	identityKey := getIdentityKey(r)
+	if identityKey == "" {
+		writeError(w, 401, "ERR_AUTHENTICATION_REQUIRED", "Authentication required.")
+		return
+	}
+
+	var req RejectPaymentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, 400, "ERR_INVALID_JSON", "Invalid JSON body")
+		return
+	}
+	messageID := strings.TrimSpace(req.MessageID)
+	if messageID == "" {
+		writeError(w, 400, "ERR_MESSAGEID_REQUIRED", "Missing messageId.")
+		return
+	}
+	if req.Refund == nil || len(req.Refund.Tx) == 0 || len(req.Refund.Outputs) == 0 {
+		writeError(w, 400, "ERR_MISSING_PAYMENT_TX", "A refund transaction paying the sender is required.")
+		return
+	}
+
+	paid, err := s.DB.GetRelayedPayment(identityKey, messageID)
+	if err != nil {
+		logger.Error("failed to get relayed payment", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to retrieve the payment.")
+		return
+	}
+	if paid == nil {
+		writeError(w, 404, "ERR_PAYMENT_NOT_FOUND", "No unrefunded payment was relayed to you with this message.")
+		return
+	}
+
+	refundTx, err := parsePaymentTx(req.Refund.Tx)
+	if err != nil {
+		writeError(w, 400, "ERR_INVALID_PAYMENT_TX", fmt.Sprintf("Invalid refund transaction: %v", err))
+		return
+	}
+	// the refund is checked like a recipient payment, with the roles of sender and recipient swapped
+	underpaid, err := verifyRecipientPayments(refundTx, identityKey,
+		map[string][]PaymentOutput{paid.Sender: req.Refund.Outputs},
+		[]feeRow{{recipient: paid.Sender, recipientFee: int(paid.Amount), allowed: true}}, nil)
+	if err != nil {
+		var omErr *OutputMappingError
+		if errors.As(err, &omErr) {
+			writeError(w, 400, omErr.Code, omErr.Description)
+		} else {
+			logger.Error("refund verification failed", "error", err)
+			writeError(w, 500, "ERR_INTERNAL", "An internal error has occurred.")
+		}
+		return
+	}
+	if len(underpaid) > 0 {
+		writeError(w, 400, "ERR_INSUFFICIENT_PAYMENT",
+			fmt.Sprintf("Refund pays %d satoshis but %d were received.", underpaid[0].paid, paid.Amount))
+		return
+	}
+
+	// an output refunds one payment only
+	claimed, replayed, err := s.claimRefundOutputs(refundTx.TxID().String(), identityKey, paid, req.Refund.Outputs)
+	if err != nil {
+		logger.Error("failed to claim refund outputs", "error", err)
+		writeError(w, 500, "ERR_INTERNAL", "An internal error has occurred.")
+		return
+	}
+	if len(replayed) > 0 {
+		writeError(w, 409, "ERR_PAYMENT_REPLAYED", fmt.Sprintf("Refund outputs were already used: %s", replayedOutpoints(replayed)))
+		return
+	}
+
+	refundJSON, _ := json.Marshal(req.Refund)
+	rec := db.RefundRecord{
+		PaymentID:  paid.ID,
+		Payer:      identityKey,
+		Payee:      paid.Sender,
+		MessageBox: paid.MessageBox,
+		MessageID:  paid.MessageID,
+		Reason:     db.RefundReasonRejected,
+		Amount:     outputsAmount(refundTx, req.Refund.Outputs),
+		TxID:       sql.NullString{String: refundTx.TxID().String(), Valid: true},
+		Payment:    sql.NullString{String: string(refundJSON), Valid: true},
+		Status:     db.RefundStatusSent,
+	}
+	if rec.ID, err = s.DB.InsertRefund(rec); err != nil {
+		s.releaseOutputs(claimed, false)
+		if errors.Is(err, db.ErrAlreadyRefunded) {
+			writeError(w, 409, "ERR_ALREADY_REFUNDED", "This payment has already been refunded.")
+			return
+		}
+		logger.Error("failed to record refund", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to record the refund.")
+		return
+	}
+
+	if _, err := s.DB.AcknowledgeMessages(identityKey, []string{messageID}); err != nil {
+		logger.Error("failed to delete rejected message", "error", err, "messageId", messageID)
+	}
+	if _, err := s.DB.RevokeSubscription(identityKey, messageID, time.Now()); err != nil {
+		logger.Error("failed to revoke subscription", "error", err, "messageId", messageID)
+	}
+
+	writeJSON(w, 200, RejectPaymentResponse{
+		Status: "success",
+		Refund: toRefundOut(rec, req.Refund),
+	})
+}
+
+// claimRefundOutputs records the outputs of a refund of paid as spent by payer, so one refund transaction
+// cannot be presented for several payments. Returns the outputs claimed, or the outpoints already used.
+func (s *Server) claimRefundOutputs(txid, payer string, paid *db.PaymentRecord, outputs []PaymentOutput) ([]db.SpentOutput, []db.SpentOutput, error) {
+	spent := paymentSpentOutputs(txid, payer, paid.MessageBox, outputs, -1, map[string][]PaymentOutput{paid.Sender: outputs})
+	replayed, err := s.DB.ClaimPaymentOutputs(spent)
+	if err != nil || len(replayed) > 0 {
+		return nil, replayed, err
+	}
+	return spent, nil, nil
+}
+
+// ListRefunds godoc
+// @Summary      List refunds paid to the caller
+// @Description  Returns refunds of fees the caller paid as a sender, newest first: delivery fees the server returned because a message could not be stored, and recipient fees returned by recipients who rejected a message.
+// @Description  Each sent refund includes the payment to pass to the wallet's internalizeAction.
+// @Tags         Payments
+// @Produce      json
+// @Param        limit query int false "Maximum number of results (1-500, default 50)"
+// @Success      200  {object}  ListRefundsResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /payments/refunds [get]
+func (s *Server) ListRefunds(w http.ResponseWriter, r *http.Request) {
+	identityKey := getIdentityKey(r)
+	if identityKey == "" {
+		writeError(w, 401, "ERR_AUTHENTICATION_REQUIRED", "Authentication required.")
+		return
+	}
+
+	limit, err := parseLimit(r, 50, 500)
+	if err != nil {
+		writeError(w, 400, "ERR_INVALID_LIMIT", err.Error())
+		return
+	}
+
+	records, err := s.DB.ListRefunds(identityKey, limit)
+	if err != nil {
+		logger.Error("failed to list refunds", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to retrieve refunds.")
+		return
+	}
+
+	out := []RefundOut{}
+	for _, rec := range records {
+		var payment *Payment
+		if rec.Payment.Valid {
+			payment = &Payment{}
+			if err := json.Unmarshal([]byte(rec.Payment.String), payment); err != nil {
+				logger.Error("failed to decode stored refund", "error", err, "refundId", rec.ID)
+				payment = nil
+			}
+		}
+		out = append(out, toRefundOut(rec, payment))
+	}
+
+	writeJSON(w, 200, ListRefundsResponse{Status: "success", Refunds: out})
+}
+
+// writeDeliveryFailure writes an error for a send that could not be stored and releases the outputs it claimed.
+// When the server already internalized the delivery fee (paid is not nil), the fee is refunded first and the
+// refund is returned.
+func (s *Server) writeDeliveryFailure(w http.ResponseWriter, r *http.Request, status int, code, description string, paid *db.PaymentRecord, claimed []db.SpentOutput) {
+	s.releaseOutputs(claimed, paid != nil)
+	if paid == nil {
+		writeError(w, status, code, description)
+		return
+	}
+	refund := s.refundDeliveryFee(r.Context(), paid)
+	writeJSON(w, status, DeliveryFailedError{
+		Status:      "error",
+		Code:        code,
+		Description: description,
+		Refund:      &refund,
+	})
+}
+
+// refundDeliveryFee pays an internalized delivery fee back to its sender and records the refund,
+// including a failed attempt so operators can settle it by hand.
+func (s *Server) refundDeliveryFee(ctx context.Context, paid *db.PaymentRecord) RefundOut {
+	rec := db.RefundRecord{
+		PaymentID:  paid.ID,
+		Payer:      s.serverIdentityKey(ctx),
+		Payee:      paid.Sender,
+		MessageBox: paid.MessageBox,
+		Reason:     db.RefundReasonDeliveryFailed,
+		Amount:     paid.Amount,
+		Status:     db.RefundStatusSent,
+	}
+
+	payment, txid, err := s.createRefund(ctx, paid.Sender, paid.Amount, "MessageBox delivery fee refund")
+	if err != nil {
+		logger.Error("failed to create delivery fee refund", "error", err, "paymentId", paid.ID)
+		rec.Status = db.RefundStatusFailed
+		rec.Error = sql.NullString{String: err.Error(), Valid: true}
+	} else {
+		refundJSON, _ := json.Marshal(payment)
+		rec.TxID = sql.NullString{String: txid, Valid: true}
+		rec.Payment = sql.NullString{String: string(refundJSON), Valid: true}
+	}
+
+	if rec.ID, err = s.DB.InsertRefund(rec); err != nil {
+		logger.Error("failed to record delivery fee refund", "error", err, "paymentId", paid.ID, "txid", txid)
+	}
+	return toRefundOut(rec, payment)
+}
+
+// createRefund pays amount from the server wallet to payee, locked to a BRC-29 key derived for the payee.
+// It returns the payment the payee internalizes and the refund txid.
+func (s *Server) createRefund(ctx context.Context, payee string, amount int64, description string) (*Payment, string, error) {
+	return s.createServerPayment(ctx, payee, amount, description, "Refund", "messagebox refund")
+}
+
+// createServerPayment pays amount from the server wallet to payee as a BRC-29 wallet payment.
+func (s *Server) createServerPayment(ctx context.Context, payee string, amount int64, description, outputDescription, label string) (*Payment, string, error) {
+	if s.wallet == nil {
+		return nil, "", errors.New("server wallet is not configured")
+	}
+	payeeKey, err := ec.PublicKeyFromString(payee)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid payee identity key: %w", err)
+	}
+	serverKey := s.serverIdentityKey(ctx)
+	if serverKey == "" {
+		return nil, "", errors.New("server identity key is unavailable")
+	}
+
+	prefix, err := newDerivationNonce()
+	if err != nil {
+		return nil, "", err
+	}
+	suffix, err := newDerivationNonce()
+	if err != nil {
+		return nil, "", err
+	}
+	lockingScript, err := s.refundLockingScript(ctx, payeeKey, prefix, suffix)
+	if err != nil {
+		return nil, "", err
+	}
+
+	randomizeOutputs := false
+	res, err := s.wallet.CreateAction(ctx, sdk.CreateActionArgs{
+		Description: description,
+		Outputs: []sdk.CreateActionOutput{{
+			LockingScript:     lockingScript.Bytes(),
+			Satoshis:          uint64(amount),
+			OutputDescription: outputDescription,
+		}},
+		Labels:  []string{label},
+		Options: &sdk.CreateActionOptions{RandomizeOutputs: &randomizeOutputs},
+	}, "messagebox-server")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create payment transaction: %w", err)
+	}
+
+	return &Payment{
+		Tx: res.Tx,
+		Outputs: []PaymentOutput{{
+			OutputIndex: 0,
+			Protocol:    string(sdk.InternalizeProtocolWalletPayment),
+			PaymentRemittance: &PaymentRemittance{
+				DerivationPrefix:  prefix,
+				DerivationSuffix:  suffix,
+				SenderIdentityKey: serverKey,
+			},
+		}},
+		Description: description,
+	}, res.Txid.String(), nil
+}
+
+// refundLockingScript returns the P2PKH script of the BRC-29 key the server derives for payee,
+// which the payee's wallet derives back when internalizing the refund.
+func (s *Server) refundLockingScript(ctx context.Context, payee *ec.PublicKey, prefix, suffix string) (*script.Script, error) {
+	derived, err := s.wallet.GetPublicKey(ctx, sdk.GetPublicKeyArgs{
+		EncryptionArgs: sdk.EncryptionArgs{
+			ProtocolID:   walletPaymentProtocol,
+			KeyID:        prefix + " " + suffix,
+			Counterparty: sdk.Counterparty{Type: sdk.CounterpartyTypeOther, Counterparty: payee},
+		},
+	}, "messagebox-server")
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive refund key: %w", err)
+	}
+
+	mainnet := true
+	if net, err := s.wallet.GetNetwork(ctx, nil, "messagebox-server"); err == nil && net.Network == sdk.NetworkTestnet {
+		mainnet = false
+	}
+	addr, err := script.NewAddressFromPublicKey(derived.PublicKey, mainnet)
+	if err != nil {
+		return nil, err
+	}
+	return p2pkh.Lock(addr)
+}
+
+// newDerivationNonce returns 16 random bytes as base64, the encoding of BRC-29 derivation prefixes and suffixes.
+func newDerivationNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+func toRefundOut(r db.RefundRecord, payment *Payment) RefundOut {
+	out := RefundOut{
+		ID:         r.ID,
+		Reason:     r.Reason,
+		Payer:      r.Payer,
+		MessageBox: r.MessageBox,
+		MessageID:  r.MessageID.String,
+		Amount:     r.Amount,
+		TxID:       r.TxID.String,
+		Status:     r.Status,
+		Payment:    payment,
+	}
+	if !r.CreatedAt.IsZero() {
+		out.CreatedAt = r.CreatedAt.Format("2006-01-02T15:04:05.000Z")
+	}
+	return out
+}
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bsv-blockchain/go-message-box-server/internal/logger"
+	"github.com/bsv-blockchain/go-message-box-server/pkg/db"
+)
+
+// Sender reputation levels, from best to worst.
+const (
+	ReputationGood            = "good"
+	ReputationRaisedFees      = "raised_fees"      // box-wide and default recipient fees are multiplied
+	ReputationPaymentRequired = "payment_required" // recipient fees have a minimum, even into free boxes
+	ReputationBanned          = "banned"           // the sender cannot send or quote
+)
+
+// SpamPolicy sets how spam reports against a sender affect them. Thresholds count the different
+// recipients who reported the sender within Window; a threshold of 0 disables that level.
+type SpamPolicy struct {
+	Window           time.Duration
+	RaiseFeesAt      int
+	FeeMultiplier    int
+	RequirePaymentAt int
+	MinRecipientFee  int
+	BanAt            int
+}
+
+func (p SpamPolicy) enabled() bool {
+	return p.Window > 0 && (p.RaiseFeesAt > 0 || p.RequirePaymentAt > 0 || p.BanAt > 0)
+}
+
+// level returns the reputation of a sender reported by reporters different recipients.
+func (p SpamPolicy) level(reporters int) string {
+	switch {
+	case p.BanAt > 0 && reporters >= p.BanAt:
+		return ReputationBanned
+	case p.RequirePaymentAt > 0 && reporters >= p.RequirePaymentAt:
+		return ReputationPaymentRequired
+	case p.RaiseFeesAt > 0 && reporters >= p.RaiseFeesAt:
+		return ReputationRaisedFees
+	}
+	return ReputationGood
+}
+
+// WithSpamPolicy makes spam reports raise fees for, require payment from or ban reported senders.
+// Without it, reports are recorded but have no effect.
+func WithSpamPolicy(p SpamPolicy) ServerOption {
+	return func(s *Server) {
+		s.spamPolicy = p
+	}
+}
+
+// senderReputation is the reputation of a sender under the server's spam policy.
+type senderReputation struct {
+	Level     string
+	Reporters int
+	policy    SpamPolicy
+}
+
+// reputation returns the current reputation of sender.
+func (s *Server) reputation(sender string, now time.Time) (senderReputation, error) {
+	rep := senderReputation{Level: ReputationGood, policy: s.spamPolicy}
+	if !s.spamPolicy.enabled() {
+		return rep, nil
+	}
+	n, err := s.DB.CountSpamReporters(sender, now.Add(-s.spamPolicy.Window))
+	if err != nil {
+		return rep, err
+	}
+	rep.Reporters = n
+	rep.Level = s.spamPolicy.level(n)
+	return rep, nil
+}
+
+// recipientFee adjusts the recipient fee res priced at fee for the sender's reputation.
+// Blocks and sender-specific rules are the recipient's own decision and are left alone.
+func (r senderReputation) recipientFee(fee int, res *db.FeeResolution) int {
+	if fee < 0 || res.SenderSpecific() {
+		return fee
+	}
+	if r.policy.RaiseFeesAt > 0 && r.Reporters >= r.policy.RaiseFeesAt {
+		m := max(r.policy.FeeMultiplier, 1)
+		fee = min(fee, db.MaxFee/m) * m
+	}
+	if r.policy.RequirePaymentAt > 0 && r.Reporters >= r.policy.RequirePaymentAt {
+		fee = max(fee, r.policy.MinRecipientFee)
+	}
+	return fee
+}
+
+// ReportSpam godoc
+// @Summary      Report a message as spam
+// @Description  Reports a message in one of the caller's boxes as spam and deletes it. Reports against a sender from different recipients count toward the sender's reputation (see /reputation):
+// @Description  depending on the server's thresholds, their box-wide and default recipient fees are raised, payment is required even into free boxes, or they are banned from sending. Each message can be reported once; reporting it again returns 409 even though the message is gone.
+// @Tags         Messages
+// @Accept       json
+// @Produce      json
+// @Param        request body ReportSpamRequest true "Message to report"
+// @Success      200  {object}  ReportSpamResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      409  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /report [post]
+func (s *Server) ReportSpam(w http.ResponseWriter, r *http.Request) {
+	identityKey := getIdentityKey(r)
+	if identityKey == "" {
+		writeError(w, 401, "ERR_AUTHENTICATION_REQUIRED", "Authentication required.")
+		return
+	}
+
+	var req ReportSpamRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, 400, "ERR_INVALID_JSON", "Invalid JSON body")
+		return
+	}
+	messageID := strings.TrimSpace(req.MessageID)
+	if messageID == "" {
+		writeError(w, 400, "ERR_MESSAGEID_REQUIRED", "Missing messageId.")
+		return
+	}
+
+	report, err := s.DB.ReportSpam(identityKey, messageID, time.Now())
+	if errors.Is(err, db.ErrAlreadyReported) {
+		writeError(w, 409, "ERR_ALREADY_REPORTED", "This message has already been reported.")
+		return
+	}
+	if err != nil {
+		logger.Error("failed to report spam", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to record the report.")
+		return
+	}
+	if report == nil {
+		writeError(w, 404, "ERR_MESSAGE_NOT_FOUND", "No message with this messageId is in your message boxes.")
+		return
+	}
+
+	writeJSON(w, 200, ReportSpamResponse{
+		Status:      "success",
+		Description: fmt.Sprintf("Message %s from %s was reported as spam and deleted.", messageID, report.Sender),
+		Sender:      report.Sender,
+	})
+}
+
+// GetReputation godoc
+// @Summary      Get your sender reputation
+// @Description  Returns the caller's reputation as a sender: how many different recipients reported their messages as spam within the server's window, and what that costs them.
+// @Description  raised_fees multiplies box-wide and default recipient fees by feeMultiplier, payment_required also charges at least minRecipientFee per recipient, and banned rejects sends and quotes with ERR_SENDER_BANNED. Thresholds are omitted when disabled.
+// @Tags         Messages
+// @Produce      json
+// @Success      200  {object}  ReputationResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /reputation [get]
+func (s *Server) GetReputation(w http.ResponseWriter, r *http.Request) {
+	identityKey := getIdentityKey(r)
+	if identityKey == "" {
+		writeError(w, 401, "ERR_AUTHENTICATION_REQUIRED", "Authentication required.")
+		return
+	}
+
+	rep, err := s.reputation(identityKey, time.Now())
+	if err != nil {
+		logger.Error("failed to get reputation", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to retrieve reputation.")
+		return
+	}
+
+	writeJSON(w, 200, toReputationResponse(rep, s.spamPolicy))
+}
+
+// ClearReputation godoc
+// @Summary      Clear a sender's spam reports (operators only)
+// @Description  Deletes every spam report against a sender, restoring a good reputation, e.g. after reports turned out to be abusive.
+// @Tags         Admin
+// @Produce      json
+// @Param        identityKey query string true "Sender's public key"
+// @Success      200  {object}  ClearReputationResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /admin/reputation [delete]
+func (s *Server) ClearReputation(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.requireAdmin(w, r); !ok {
+		return
+	}
+
+	sender := strings.TrimSpace(r.URL.Query().Get("identityKey"))
+	if !isValidPubKey(sender) {
+		writeError(w, 400, "ERR_INVALID_PUBLIC_KEY", "identityKey must be a valid public key.")
+		return
+	}
+
+	n, err := s.DB.ClearSpamReports(sender)
+	if err != nil {
+		logger.Error("failed to clear spam reports", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to clear spam reports.")
+		return
+	}
+
+	writeJSON(w, 200, ClearReputationResponse{Status: "success", ClearedReports: n})
+}
+
+func toReputationResponse(rep senderReputation, p SpamPolicy) ReputationResponse {
+	threshold := func(v int) *int {
+		if v <= 0 || !p.enabled() {
+			return nil
+		}
+		return &v
+	}
+	out := ReputationResponse{
+		Status:           "success",
+		Reputation:       rep.Level,
+		Reporters:        rep.Reporters,
+		WindowSeconds:    int(p.Window.Seconds()),
+		RaiseFeesAt:      threshold(p.RaiseFeesAt),
+		RequirePaymentAt: threshold(p.RequirePaymentAt),
+		BanAt:            threshold(p.BanAt),
+	}
+	if out.RaiseFeesAt != nil {
+		out.FeeMultiplier = max(p.FeeMultiplier, 1)
+	}
+	if out.RequirePaymentAt != nil {
+		out.MinRecipientFee = p.MinRecipientFee
+	}
+	return out
+}
@@ -5,8 +5,24 @@ import "encoding/json"
 // SendMessageRequest is the expected JSON body for /sendMessage.
 // @Description Request to send a message to one or more recipients
 type SendMessageRequest struct {
-	Message *SendMessageBody `json:"message"`
-	Payment *Payment         `json:"payment,omitempty"`
+	Message      *SendMessageBody     `json:"message"`
+	Payment      *Payment             `json:"payment,omitempty"`
+	Notification *NotificationOptions `json:"notification,omitempty"`
+	QuoteID      string               `json:"quoteId,omitempty"`   // signed quote from /permissions/quote locking the fees
+	UseCredit    bool                 `json:"useCredit,omitempty"` // debit the fees from the sender's credit balance instead of payment
+	Subscribe    bool                 `json:"subscribe,omitempty"` // pay each recipient's subscription price instead of the per-message fee
+	// Nonce per recipient key for recipients whose permission requires a proof of work
+	ProofOfWork map[string]string `json:"proofOfWork,omitempty"`
+}
+
+// NotificationOptions are per-send hints for the push notification sent to recipients.
+// Recipient box settings (templates, badge) still apply; these only refine the push.
+// @Description Per-send push notification options
+type NotificationOptions struct {
+	SenderName  string `json:"senderName,omitempty" example:"Alice"`    // Display name available to templates
+	Silent      bool   `json:"silent,omitempty" example:"false"`        // Request a data-only push (cannot un-silence a silent box)
+	CollapseKey string `json:"collapseKey,omitempty" example:"chat-42"` // Overrides the box collapse key
+	TTLSeconds  *int   `json:"ttlSeconds,omitempty" example:"3600"`     // Overrides the box TTL
 }
 
 // SendMessageBody holds the message fields.
@@ -37,6 +53,15 @@ type RegisterDeviceRequest struct {
 	FCMToken string  `json:"fcmToken"`
 	DeviceID *string `json:"deviceId,omitempty"`
 	Platform *string `json:"platform,omitempty"`
+	// Nonce pushed to the device when the token is owned by another identity
+	ChallengeNonce *string `json:"challengeNonce,omitempty"`
+}
+
+// UpdateDeviceRequest is the expected JSON body for PATCH /devices/{id}.
+// @Description Request to update device labels
+type UpdateDeviceRequest struct {
+	DeviceID *string `json:"deviceId,omitempty" example:"work-phone"`
+	Platform *string `json:"platform,omitempty" example:"ios"`
 }
 
 // SetPermissionRequest represents the request for setPermission.
@@ -45,6 +70,73 @@ type SetPermissionRequest struct {
 	Sender       *string `json:"sender,omitempty" example:"03abc..."`
 	MessageBox   string  `json:"messageBox" example:"inbox"`
 	RecipientFee *int    `json:"recipientFee" example:"100"`
+	// Optional limits for sender-specific rules; once either is reached the box-wide default applies
+	ExpiresAt   *string `json:"expiresAt,omitempty" example:"2024-01-08T00:00:00.000Z"`
+	MaxMessages *int    `json:"maxMessages,omitempty" example:"5"`
+	// Optional size-based pricing added on top of recipientFee
+	FeePerKB              *int `json:"feePerKb,omitempty" example:"2"`                  // satoshis per started KB of body
+	LargePayloadThreshold *int `json:"largePayloadThreshold,omitempty" example:"65536"` // bytes
+	LargePayloadFee       *int `json:"largePayloadFee,omitempty" example:"500"`         // satoshis for bodies above the threshold
+	// Optional leading zero bits every message must prove work for, see sendMessage's proofOfWork
+	PowDifficulty *int `json:"powDifficulty,omitempty" example:"20"`
+}
+
+// BulkSetPermissionsRequest is the expected JSON body for /permissions/bulkSet.
+// @Description Request to upsert many permissions at once
+type BulkSetPermissionsRequest struct {
+	Permissions []SetPermissionRequest `json:"permissions"`
+}
+
+// RejectPaymentRequest is the expected JSON body for /payments/reject.
+// @Description Request to reject a paid message and return its fee to the sender
+type RejectPaymentRequest struct {
+	MessageID string   `json:"messageId" example:"msg-123"`
+	Refund    *Payment `json:"refund"` // payment from the caller's wallet to the original sender
+}
+
+// SetRateLimitRequest is the expected JSON body for /permissions/rateLimits/set.
+// @Description Request to limit how many messages a sender (or all senders) may deliver per time window
+type SetRateLimitRequest struct {
+	Sender        *string `json:"sender,omitempty" example:"03abc..."` // omit to limit all senders together
+	MessageBox    string  `json:"messageBox" example:"inbox"`
+	MaxMessages   *int    `json:"maxMessages" example:"10"`               // 0 removes the limit
+	WindowSeconds *int    `json:"windowSeconds,omitempty" example:"3600"` // default 3600
+}
+
+// SetNotificationPreferenceRequest is the expected JSON body for /notificationPreferences/set.
+// @Description Request to set push notification preferences for a message box
+type SetNotificationPreferenceRequest struct {
+	MessageBox     string      `json:"messageBox" example:"inbox"`
+	Mode           string      `json:"mode" example:"allowlist"` // "none", "always" or "allowlist"
+	AllowedSenders []string    `json:"allowedSenders,omitempty"` // Required for "allowlist" mode
+	QuietHours     *QuietHours `json:"quietHours,omitempty"`
+}
+
+// SetNotificationPayloadRequest is the expected JSON body for /notificationPayloads/set.
+// @Description Request to configure push notification payloads for a message box
+type SetNotificationPayloadRequest struct {
+	MessageBox    string  `json:"messageBox" example:"inbox"`
+	TitleTemplate *string `json:"titleTemplate,omitempty" example:"{{.SenderName}}"`
+	BodyTemplate  *string `json:"bodyTemplate,omitempty" example:"New message in {{.MessageBox}}"`
+	Silent        bool    `json:"silent,omitempty" example:"false"`
+	Badge         bool    `json:"badge,omitempty" example:"true"`
+	CollapseKey   *string `json:"collapseKey,omitempty" example:"inbox"`
+	TTLSeconds    *int    `json:"ttlSeconds,omitempty" example:"86400"`
+}
+
+// SetServerFeeRequest is the expected JSON body for /admin/serverFees/set.
+// @Description Request to set the server delivery fee of a message box ("*" sets the default fee)
+type SetServerFeeRequest struct {
+	MessageBox  string `json:"messageBox" example:"notifications"`
+	DeliveryFee *int   `json:"deliveryFee" example:"10"`
+}
+
+// QuietHours describes a daily window during which no pushes are sent.
+// @Description Daily quiet hours window in the identity's timezone
+type QuietHours struct {
+	Start    string `json:"start" example:"22:00"`            // HH:MM
+	End      string `json:"end" example:"07:00"`              // HH:MM, may be earlier than start to wrap midnight
+	Timezone string `json:"timezone" example:"Europe/London"` // IANA timezone name
 }
 
 // Payment represents the payment transaction data for paid message delivery.
@@ -56,6 +148,8 @@ type Payment struct {
 	Description    string          `json:"description,omitempty"`
 	Labels         []string        `json:"labels,omitempty"`
 	SeekPermission *bool           `json:"seekPermission,omitempty"`
+	// Transaction output index paying the server delivery fee; defaults to the first entry of outputs
+	DeliveryFeeOutputIndex *uint32 `json:"deliveryFeeOutputIndex,omitempty" example:"0"`
 }
 
 // PaymentOutput represents a single output in the payment transaction.
@@ -86,3 +180,55 @@ type InsertionRemittance struct {
 	CustomInstructions json.RawMessage `json:"customInstructions,omitempty"`
 	Tags               []string        `json:"tags,omitempty"`
 }
+
+// ReportSpamRequest is the expected JSON body for /report.
+// @Description Request to report a received message as spam
+type ReportSpamRequest struct {
+	MessageID string `json:"messageId" example:"msg-123"`
+}
+
+// SetSubscriptionOfferRequest is the expected JSON body for /permissions/subscriptions/set.
+// @Description Request to sell senders free access to a message box for a number of days
+type SetSubscriptionOfferRequest struct {
+	MessageBox   string `json:"messageBox" example:"inbox"`
+	Price        *int   `json:"price" example:"5000"`      // satoshis per purchase, 0 removes the offer
+	DurationDays *int   `json:"durationDays" example:"30"` // free access per purchase, default 30 days
+}
+
+// SetRoutePriceRequest is the expected JSON body for /admin/routePrices/set.
+// @Description Request to price a route for a message box ("*" sets the route's default price)
+type SetRoutePriceRequest struct {
+	Route         string `json:"route" example:"/listMessages"`
+	MessageBox    string `json:"messageBox" example:"inbox"`
+	Price         *int   `json:"price" example:"5"`
+	FreeRequests  int    `json:"freeRequests" example:"100"`    // free requests per caller in each window
+	WindowSeconds int    `json:"windowSeconds" example:"86400"` // free tier window, default one day
+}
+
+// DepositCreditRequest is the expected JSON body for /credits/deposit.
+// @Description Payment to add to the caller's credit balance
+type DepositCreditRequest struct {
+	Payment *Payment `json:"payment"` // every output is a wallet payment to the server
+}
+
+// WithdrawCreditRequest is the expected JSON body for /credits/withdraw.
+// @Description Amount of unused credit to pay back
+type WithdrawCreditRequest struct {
+	Amount *int64 `json:"amount,omitempty" example:"500"` // defaults to the whole balance
+}
+
+// PresentCertificatesRequest is the expected JSON body for /certificates.
+// @Description BRC-52 identity certificates of the caller, each with a keyring revealing fields to the server
+type PresentCertificatesRequest struct {
+	Certificates []json.RawMessage `json:"certificates" swaggertype:"array,object"`
+}
+
+// SetCertificateRequirementRequest is the expected JSON body for /permissions/certificates/set.
+// @Description Request to require an identity certificate of senders into a message box
+type SetCertificateRequirementRequest struct {
+	MessageBox      string   `json:"messageBox" example:"business"`
+	Certifiers      []string `json:"certifiers"`                                                                       // accepted certifier keys, empty removes the requirement
+	CertificateType string   `json:"certificateType,omitempty" example:"z40BOInXkI8m7f/wBrv4MJ09bZfzZbTj2fJqCtONqCY="` // base64 type ID, omit for any type
+	Fields          []string `json:"fields,omitempty"`                                                                 // fields the certificate must reveal to the server
+	VerifiedFee     *int     `json:"verifiedFee,omitempty" example:"0"`                                                // fee for verified senders, omit to turn unverified senders away
+}
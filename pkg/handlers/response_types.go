@@ -36,6 +36,8 @@ type ListMessagesResponse struct {
 type SendMessageResult struct {
 	Recipient string `json:"recipient" example:"03abc..."`
 	MessageID string `json:"messageId" example:"msg-123"`
+	// End of the sender's free access to the box; only set with subscribe
+	SubscribedUntil *string `json:"subscribedUntil,omitempty" example:"2024-01-31T12:00:00.000Z"`
 }
 
 // SendMessageResponse represents the response for sendMessage.
@@ -44,6 +46,8 @@ type SendMessageResponse struct {
 	Status  string              `json:"status" example:"success"`
 	Message string              `json:"message" example:"Your message has been sent to 1 recipient(s)."`
 	Results []SendMessageResult `json:"results"`
+	// Credit balance left after the fees were debited; only set with useCredit
+	CreditBalance *int64 `json:"creditBalance,omitempty" example:"990"`
 }
 
 // DeviceOut represents a device in responses.
@@ -74,6 +78,22 @@ type RegisterDeviceResponse struct {
 	DeviceID int64  `json:"deviceId" example:"1"`
 }
 
+// DeviceChallengeResponse is returned when a token owned by another identity must be claimed with a challenge.
+// @Description Response when a device ownership challenge has been pushed to the device
+type DeviceChallengeResponse struct {
+	Status      string `json:"status" example:"error"`
+	Code        string `json:"code" example:"ERR_DEVICE_CHALLENGE_REQUIRED"`
+	Description string `json:"description" example:"This token is registered to another identity. A challenge was pushed to the device; resubmit with challengeNonce."`
+	ExpiresAt   string `json:"expiresAt" example:"2024-01-01T00:10:00.000Z"`
+}
+
+// UpdateDeviceResponse represents the response for updateDevice.
+// @Description Response after updating device labels
+type UpdateDeviceResponse struct {
+	Status string    `json:"status" example:"success"`
+	Device DeviceOut `json:"device"`
+}
+
 // SetPermissionResponse represents the response for setPermission.
 // @Description Response after setting a permission
 type SetPermissionResponse struct {
@@ -81,6 +101,46 @@ type SetPermissionResponse struct {
 	Description string `json:"description" example:"Messages from sender to inbox now require 100 satoshis."`
 }
 
+// DeletePermissionResponse represents the response for deletePermission.
+// @Description Response after deleting a permission
+type DeletePermissionResponse struct {
+	Status      string `json:"status" example:"success"`
+	Description string `json:"description" example:"Permission for sender 03abc... to inbox deleted."`
+}
+
+// BulkSetPermissionsResponse represents the response for bulkSetPermissions.
+// @Description Response after upserting many permissions
+type BulkSetPermissionsResponse struct {
+	Status  string `json:"status" example:"success"`
+	Applied int    `json:"applied" example:"120"`
+}
+
+// BulkPermissionEntryError describes why one entry of a bulk upsert was rejected.
+// @Description Validation error for one bulk permission entry
+type BulkPermissionEntryError struct {
+	Index       int    `json:"index" example:"3"`
+	Code        string `json:"code" example:"ERR_INVALID_PUBLIC_KEY"`
+	Description string `json:"description" example:"Invalid sender public key format."`
+}
+
+// BulkSetPermissionsError is returned when entries of a bulk upsert are invalid; nothing is applied.
+// @Description Error response listing invalid bulk permission entries
+type BulkSetPermissionsError struct {
+	Status      string                     `json:"status" example:"error"`
+	Code        string                     `json:"code" example:"ERR_INVALID_ENTRIES"`
+	Description string                     `json:"description" example:"2 of 120 entries are invalid; no permissions were changed."`
+	Errors      []BulkPermissionEntryError `json:"errors"`
+}
+
+// ExportPermissionsResponse represents the JSON response for exportPermissions.
+// Entries use the /permissions/set format so they can be re-applied with /permissions/bulkSet.
+// @Description Exported permissions, re-applicable with bulkSet
+type ExportPermissionsResponse struct {
+	Status      string                 `json:"status" example:"success"`
+	ExportedAt  string                 `json:"exportedAt" example:"2024-01-01T12:00:00.000Z"`
+	Permissions []SetPermissionRequest `json:"permissions"`
+}
+
 // PermissionDetail is used by GET /permissions/get — client returns it raw, expects camelCase.
 // @Description Permission details (camelCase for getPermission endpoint)
 type PermissionDetail struct {
@@ -88,8 +148,14 @@ type PermissionDetail struct {
 	MessageBox   string  `json:"messageBox" example:"inbox"`
 	RecipientFee int     `json:"recipientFee" example:"100"`
 	Status       string  `json:"status,omitempty" example:"payment_required"`
-	CreatedAt    string  `json:"createdAt" example:"2024-01-01T12:00:00.000Z"`
-	UpdatedAt    string  `json:"updatedAt" example:"2024-01-01T12:00:00.000Z"`
+	ExpiresAt    *string `json:"expiresAt,omitempty" example:"2024-01-08T00:00:00.000Z"`
+	MaxMessages  *int    `json:"maxMessages,omitempty" example:"5"`
+	MessagesUsed int     `json:"messagesUsed" example:"2"`
+	IsPattern    bool    `json:"isPattern" example:"false"`
+	SizePricingDetail
+	PowDifficulty int    `json:"powDifficulty,omitempty" example:"20"`
+	CreatedAt     string `json:"createdAt" example:"2024-01-01T12:00:00.000Z"`
+	UpdatedAt     string `json:"updatedAt" example:"2024-01-01T12:00:00.000Z"`
 }
 
 // PermissionDetailList is used by GET /permissions/list — client maps explicitly from snake_case.
@@ -98,8 +164,18 @@ type PermissionDetailList struct {
 	Sender       *string `json:"sender" example:"03abc..."`
 	MessageBox   string  `json:"message_box" example:"inbox"`
 	RecipientFee int     `json:"recipient_fee" example:"100"`
-	CreatedAt    string  `json:"created_at" example:"2024-01-01T12:00:00.000Z"`
-	UpdatedAt    string  `json:"updated_at" example:"2024-01-01T12:00:00.000Z"`
+	ExpiresAt    *string `json:"expires_at,omitempty" example:"2024-01-08T00:00:00.000Z"`
+	MaxMessages  *int    `json:"max_messages,omitempty" example:"5"`
+	MessagesUsed int     `json:"messages_used" example:"2"`
+	// size-based pricing, omitted for flat fees
+	FeePerKB              int    `json:"fee_per_kb,omitempty" example:"2"`
+	LargePayloadThreshold int    `json:"large_payload_threshold,omitempty" example:"65536"`
+	LargePayloadFee       int    `json:"large_payload_fee,omitempty" example:"500"`
+	PowDifficulty         int    `json:"pow_difficulty,omitempty" example:"20"`
+	IsPattern             bool   `json:"is_pattern" example:"false"`
+	Effective             bool   `json:"effective,omitempty" example:"true"` // only set when filtering by messageBox
+	CreatedAt             string `json:"created_at" example:"2024-01-01T12:00:00.000Z"`
+	UpdatedAt             string `json:"updated_at" example:"2024-01-01T12:00:00.000Z"`
 }
 
 // GetPermissionResponse represents the response for getPermission.
@@ -118,11 +194,274 @@ type ListPermissionsResponse struct {
 	TotalCount  int                    `json:"totalCount" example:"10"`
 }
 
+// NotificationPreferenceDetail represents a notification preference in responses.
+// @Description Push notification preference for a message box
+type NotificationPreferenceDetail struct {
+	MessageBox     string      `json:"messageBox" example:"inbox"`
+	Mode           string      `json:"mode" example:"allowlist"`
+	AllowedSenders []string    `json:"allowedSenders"`
+	QuietHours     *QuietHours `json:"quietHours,omitempty"`
+	IsDefault      bool        `json:"isDefault" example:"false"`
+	CreatedAt      string      `json:"createdAt,omitempty" example:"2024-01-01T12:00:00.000Z"`
+	UpdatedAt      string      `json:"updatedAt,omitempty" example:"2024-01-01T12:00:00.000Z"`
+}
+
+// GetNotificationPreferenceResponse represents the response for getNotificationPreference.
+// @Description Response containing the effective notification preference for a message box
+type GetNotificationPreferenceResponse struct {
+	Status     string                       `json:"status" example:"success"`
+	Preference NotificationPreferenceDetail `json:"preference"`
+}
+
+// ListNotificationPreferencesResponse represents the response for listNotificationPreferences.
+// @Description Response containing all stored notification preferences
+type ListNotificationPreferencesResponse struct {
+	Status      string                         `json:"status" example:"success"`
+	Preferences []NotificationPreferenceDetail `json:"preferences"`
+}
+
+// NotificationPayloadDetail represents push payload settings in responses.
+// @Description Push notification payload settings for a message box
+type NotificationPayloadDetail struct {
+	MessageBox    string `json:"messageBox" example:"inbox"`
+	TitleTemplate string `json:"titleTemplate" example:"{{.SenderName}}"`
+	BodyTemplate  string `json:"bodyTemplate" example:"New message in {{.MessageBox}}"`
+	Silent        bool   `json:"silent" example:"false"`
+	Badge         bool   `json:"badge" example:"true"`
+	CollapseKey   string `json:"collapseKey,omitempty" example:"inbox"`
+	TTLSeconds    *int   `json:"ttlSeconds,omitempty" example:"86400"`
+	IsDefault     bool   `json:"isDefault" example:"false"`
+}
+
+// GetNotificationPayloadResponse represents the response for getNotificationPayload.
+// @Description Response containing push payload settings for a message box
+type GetNotificationPayloadResponse struct {
+	Status  string                    `json:"status" example:"success"`
+	Payload NotificationPayloadDetail `json:"payload"`
+}
+
+// NotificationDeliveryOut represents one push attempt in responses.
+// @Description Push notification delivery attempt for one device
+type NotificationDeliveryOut struct {
+	ID                   int    `json:"id" example:"1"`
+	DeviceRegistrationID *int64 `json:"deviceRegistrationId,omitempty" example:"1"`
+	MessageID            string `json:"messageId,omitempty" example:"msg-123"`
+	MessageBox           string `json:"messageBox,omitempty" example:"notifications"`
+	Provider             string `json:"provider" example:"fcm"`
+	Status               string `json:"status" example:"failed"`
+	ErrorCode            string `json:"errorCode,omitempty" example:"UNREGISTERED"`
+	ErrorMessage         string `json:"errorMessage,omitempty" example:"Requested entity was not found."`
+	LatencyMs            int64  `json:"latencyMs" example:"120"`
+	CreatedAt            string `json:"createdAt" example:"2024-01-01T12:00:00.000Z"`
+}
+
+// ListNotificationDeliveriesResponse represents the response for listNotificationDeliveries.
+// @Description Response containing recent push attempts for the caller's devices
+type ListNotificationDeliveriesResponse struct {
+	Status     string                    `json:"status" example:"success"`
+	Deliveries []NotificationDeliveryOut `json:"deliveries"`
+}
+
+// DeliveryFailureOut represents the failure count for one provider and error code.
+// @Description Aggregated push failures for one provider and error code
+type DeliveryFailureOut struct {
+	Provider  string `json:"provider" example:"fcm"`
+	ErrorCode string `json:"errorCode" example:"UNREGISTERED"`
+	Count     int    `json:"count" example:"12"`
+}
+
+// NotificationFailuresResponse represents the response for the operator failures endpoint.
+// @Description Aggregated push failures by error type
+type NotificationFailuresResponse struct {
+	Status   string               `json:"status" example:"success"`
+	Since    string               `json:"since" example:"2024-01-01T12:00:00.000Z"`
+	Total    int                  `json:"total" example:"12"`
+	Failures []DeliveryFailureOut `json:"failures"`
+}
+
+// ServerFeeOut represents the delivery fee of one message box.
+// @Description Server delivery fee for a message box
+type ServerFeeOut struct {
+	MessageBox  string `json:"messageBox" example:"notifications"`
+	DeliveryFee int    `json:"deliveryFee" example:"10"`
+	UpdatedAt   string `json:"updatedAt" example:"2024-01-01T12:00:00.000Z"`
+}
+
+// ListServerFeesResponse represents the response for the operator server fees list.
+// @Description Server delivery fees per box and the default for other boxes
+type ListServerFeesResponse struct {
+	Status     string         `json:"status" example:"success"`
+	DefaultFee *int           `json:"defaultFee" example:"0"` // null when boxes without a fee are free
+	Fees       []ServerFeeOut `json:"fees"`
+}
+
+// SetServerFeeResponse represents the response after setting or removing a server fee.
+// @Description Result of a server delivery fee change
+type SetServerFeeResponse struct {
+	Status      string `json:"status" example:"success"`
+	MessageBox  string `json:"messageBox" example:"notifications"`
+	PreviousFee *int   `json:"previousFee" example:"5"`
+	DeliveryFee *int   `json:"deliveryFee" example:"10"` // null after removal
+}
+
+// ServerFeeChangeOut represents one entry of the server fee audit history.
+// @Description Audit entry for a server delivery fee change
+type ServerFeeChangeOut struct {
+	ID          int    `json:"id" example:"1"`
+	MessageBox  string `json:"messageBox" example:"notifications"`
+	PreviousFee *int   `json:"previousFee" example:"5"` // null when the fee was created
+	NewFee      *int   `json:"newFee" example:"10"`     // null when the fee was removed
+	ChangedBy   string `json:"changedBy" example:"03abc..."`
+	CreatedAt   string `json:"createdAt" example:"2024-01-01T12:00:00.000Z"`
+}
+
+// ServerFeeHistoryResponse represents the response for the server fee audit history.
+// @Description Recent server delivery fee changes
+type ServerFeeHistoryResponse struct {
+	Status  string               `json:"status" example:"success"`
+	Changes []ServerFeeChangeOut `json:"changes"`
+}
+
 // QuoteSingle represents a single-recipient quote.
 // @Description Quote for single recipient
 type QuoteSingle struct {
 	DeliveryFee  int `json:"deliveryFee" example:"10"`
-	RecipientFee int `json:"recipientFee" example:"100"`
+	RecipientFee int `json:"recipientFee" example:"100"` // for the quoted bodySize
+	QuoteLimits
+}
+
+// SizePricingDetail describes size-based pricing on top of a flat recipient fee.
+// @Description Size-based pricing, omitted for flat fees
+type SizePricingDetail struct {
+	FeePerKB              int `json:"feePerKb,omitempty" example:"2"`
+	LargePayloadThreshold int `json:"largePayloadThreshold,omitempty" example:"65536"`
+	LargePayloadFee       int `json:"largePayloadFee,omitempty" example:"500"`
+}
+
+// QuoteLimits reports the limits of the sender-specific rule a quote was based on.
+// @Description Expiry and remaining messages of the permission used for a quote, omitted when unlimited
+type QuoteLimits struct {
+	PermissionExpiresAt *string         `json:"permissionExpiresAt,omitempty" example:"2024-01-08T00:00:00.000Z"`
+	RemainingMessages   *int            `json:"remainingMessages,omitempty" example:"3"`
+	RateLimit           *RateLimitQuote `json:"rateLimit,omitempty"`
+	MatchedRule         *string         `json:"matchedRule,omitempty" example:"app.*"`           // pattern of the rule that set the fee
+	DefaultPolicy       *string         `json:"defaultPolicy,omitempty" example:"notifications"` // operator default that set the fee when no rule matched
+	// Base fee and size-based pricing behind recipientFee, set when the price depends on the body size
+	BaseRecipientFee *int               `json:"baseRecipientFee,omitempty" example:"10"`
+	Pricing          *SizePricingDetail `json:"pricing,omitempty"`
+	// Free access the sender can buy instead of paying per message, see sendMessage's subscribe
+	Subscription *SubscriptionQuote `json:"subscription,omitempty"`
+	// Sender reputation that raised the fee, omitted when good (see /reputation)
+	Reputation *string `json:"reputation,omitempty" example:"raised_fees"`
+	// Leading zero bits the proof of work for this recipient needs, see sendMessage's proofOfWork
+	PowDifficulty int `json:"powDifficulty,omitempty" example:"20"`
+	// Identity certificate the recipient asks of senders, see /certificates
+	Certificate *CertificateQuote `json:"certificate,omitempty"`
+}
+
+// SubscriptionQuote reports a recipient's subscription offer for the quoted box.
+// @Description Price of free access to the box for a number of days
+type SubscriptionQuote struct {
+	Price        int `json:"price" example:"5000"`
+	DurationDays int `json:"durationDays" example:"30"`
+}
+
+// RateLimitQuote reports the most restrictive rate limit applying to the sender.
+// @Description Rate limit state for the sender in the current window
+type RateLimitQuote struct {
+	Limit             int    `json:"limit" example:"10"`
+	WindowSeconds     int    `json:"windowSeconds" example:"3600"`
+	Remaining         int    `json:"remaining" example:"4"`
+	ResetAt           string `json:"resetAt" example:"2024-01-01T13:00:00.000Z"`
+	RetryAfterSeconds int    `json:"retryAfterSeconds,omitempty" example:"120"` // set when remaining is 0
+}
+
+// SetRateLimitResponse represents the response for setRateLimit.
+// @Description Response after setting or removing a rate limit
+type SetRateLimitResponse struct {
+	Status      string `json:"status" example:"success"`
+	Description string `json:"description" example:"Messages from sender to inbox are now limited to 10 per 3600 seconds."`
+}
+
+// ReportSpamResponse represents the response for /report.
+// @Description Result of a spam report
+type ReportSpamResponse struct {
+	Status      string `json:"status" example:"success"`
+	Description string `json:"description" example:"Message msg-123 from 03abc... was reported as spam and deleted."`
+	Sender      string `json:"sender" example:"03abc..."`
+}
+
+// ReputationResponse represents the response for /reputation.
+// @Description Sender reputation of the caller and the thresholds that change it
+type ReputationResponse struct {
+	Status        string `json:"status" example:"success"`
+	Reputation    string `json:"reputation" example:"good" enums:"good,raised_fees,payment_required,banned"`
+	Reporters     int    `json:"reporters" example:"1"` // different recipients who reported the caller within the window
+	WindowSeconds int    `json:"windowSeconds" example:"2592000"`
+	// Reporters at which each level starts, omitted when disabled
+	RaiseFeesAt      *int `json:"raiseFeesAt,omitempty" example:"3"`
+	RequirePaymentAt *int `json:"requirePaymentAt,omitempty" example:"5"`
+	BanAt            *int `json:"banAt,omitempty" example:"10"`
+	FeeMultiplier    int  `json:"feeMultiplier,omitempty" example:"10"`
+	MinRecipientFee  int  `json:"minRecipientFee,omitempty" example:"100"`
+}
+
+// ClearReputationResponse represents the response after clearing a sender's spam reports.
+// @Description Number of spam reports deleted
+type ClearReputationResponse struct {
+	Status         string `json:"status" example:"success"`
+	ClearedReports int64  `json:"clearedReports" example:"4"`
+}
+
+// SetSubscriptionOfferResponse represents the response for setSubscriptionOffer.
+// @Description Response after setting or removing a subscription offer
+type SetSubscriptionOfferResponse struct {
+	Status      string `json:"status" example:"success"`
+	Description string `json:"description" example:"Senders can now buy 30 days of free messages to inbox for 5000 satoshis."`
+}
+
+// SubscriptionOfferDetail represents a subscription offer in responses.
+// @Description Subscription offer details
+type SubscriptionOfferDetail struct {
+	MessageBox   string `json:"messageBox" example:"inbox"`
+	Price        int    `json:"price" example:"5000"`
+	DurationDays int    `json:"durationDays" example:"30"`
+	UpdatedAt    string `json:"updatedAt" example:"2024-01-01T12:00:00.000Z"`
+}
+
+// ListSubscriptionOffersResponse represents the response for listSubscriptionOffers.
+// @Description List of the caller's subscription offers
+type ListSubscriptionOffersResponse struct {
+	Status string                    `json:"status" example:"success"`
+	Offers []SubscriptionOfferDetail `json:"offers"`
+}
+
+// RateLimitDetail represents a rate limit in responses.
+// @Description Rate limit details
+type RateLimitDetail struct {
+	Sender        *string `json:"sender" example:"03abc..."`
+	MessageBox    string  `json:"messageBox" example:"inbox"`
+	MaxMessages   int     `json:"maxMessages" example:"10"`
+	WindowSeconds int     `json:"windowSeconds" example:"3600"`
+	CreatedAt     string  `json:"createdAt" example:"2024-01-01T12:00:00.000Z"`
+	UpdatedAt     string  `json:"updatedAt" example:"2024-01-01T12:00:00.000Z"`
+}
+
+// ListRateLimitsResponse represents the response for listRateLimits.
+// @Description Response containing rate limits
+type ListRateLimitsResponse struct {
+	Status     string            `json:"status" example:"success"`
+	RateLimits []RateLimitDetail `json:"rateLimits"`
+}
+
+// RateLimitedError represents an error when a sender exceeded a recipient's rate limit.
+// @Description Error response when delivery is rate limited for some recipients
+type RateLimitedError struct {
+	Status                string   `json:"status" example:"error"`
+	Code                  string   `json:"code" example:"ERR_RATE_LIMITED"`
+	Description           string   `json:"description" example:"Rate limit exceeded for recipients: 03abc..."`
+	RetryAfterSeconds     int      `json:"retryAfterSeconds" example:"120"`
+	RateLimitedRecipients []string `json:"rateLimitedRecipients"`
 }
 
 // QuoteSingleResponse represents the response for single-recipient quote.
@@ -131,6 +470,14 @@ type QuoteSingleResponse struct {
 	Status      string      `json:"status" example:"success"`
 	Description string      `json:"description" example:"Message delivery quote generated."`
 	Quote       QuoteSingle `json:"quote"`
+	SignedQuote
+}
+
+// SignedQuote identifies a signed quote that sendMessage honours until it expires.
+// @Description Signed quote id locking the quoted fees for one sendMessage
+type SignedQuote struct {
+	QuoteID        string `json:"quoteId,omitempty" example:"eyJpZCI6Ij...MEQCIF..."`
+	QuoteExpiresAt string `json:"quoteExpiresAt,omitempty" example:"2024-01-01T12:05:00.000Z"`
 }
 
 // QuoteEntry represents a quote for one recipient in multi-recipient quotes.
@@ -141,6 +488,7 @@ type QuoteEntry struct {
 	DeliveryFee  int    `json:"deliveryFee" example:"10"`
 	RecipientFee int    `json:"recipientFee" example:"100"`
 	Status       string `json:"status" example:"payment_required"`
+	QuoteLimits
 }
 
 // QuoteTotals represents totals for multi-recipient quotes.
@@ -159,6 +507,24 @@ type QuoteMultiResponse struct {
 	QuotesByRecipient []QuoteEntry `json:"quotesByRecipient"`
 	Totals            QuoteTotals  `json:"totals"`
 	BlockedRecipients []string     `json:"blockedRecipients"`
+	SignedQuote
+}
+
+// UnderpaidRecipient describes a recipient whose payment outputs do not cover their fee.
+// @Description Required and paid satoshis for an underpaid recipient
+type UnderpaidRecipient struct {
+	Recipient        string `json:"recipient" example:"03abc..."`
+	RequiredSatoshis int    `json:"requiredSatoshis" example:"1000"`
+	PaidSatoshis     uint64 `json:"paidSatoshis" example:"1"`
+}
+
+// InsufficientPaymentError represents an error when payment outputs do not cover recipient fees.
+// @Description Error response when recipients are underpaid
+type InsufficientPaymentError struct {
+	Status              string               `json:"status" example:"error"`
+	Code                string               `json:"code" example:"ERR_INSUFFICIENT_PAYMENT"`
+	Description         string               `json:"description" example:"Payment does not cover the fee of recipients: 03abc..."`
+	UnderpaidRecipients []UnderpaidRecipient `json:"underpaidRecipients"`
 }
 
 // DeliveryBlockedError represents an error when recipients are blocked.
@@ -169,3 +535,233 @@ type DeliveryBlockedError struct {
 	Description       string   `json:"description" example:"Blocked recipients: 03abc..."`
 	BlockedRecipients []string `json:"blockedRecipients"`
 }
+
+// DeliveryFailedError is returned when a paid message could not be stored after the server took its delivery fee.
+// @Description Error response carrying the refund of the delivery fee
+type DeliveryFailedError struct {
+	Status      string     `json:"status" example:"error"`
+	Code        string     `json:"code" example:"ERR_DUPLICATE_MESSAGE"`
+	Description string     `json:"description" example:"Duplicate message."`
+	Refund      *RefundOut `json:"refund,omitempty"`
+}
+
+// RefundOut represents a fee paid back to its sender.
+// @Description A refund of a delivery or recipient fee; payment is passed to internalizeAction by the sender
+type RefundOut struct {
+	ID         int64    `json:"id" example:"1"`
+	Reason     string   `json:"reason" example:"delivery_failed"` // "delivery_failed" or "rejected_by_recipient"
+	Payer      string   `json:"payer" example:"02a1b2..."`        // server or recipient identity key
+	MessageBox string   `json:"messageBox" example:"inbox"`
+	MessageID  string   `json:"messageId,omitempty" example:"msg-123"`
+	Amount     int64    `json:"amount" example:"10"`
+	TxID       string   `json:"txid,omitempty" example:"4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"`
+	Status     string   `json:"status" example:"sent"` // "sent" or "failed"
+	Payment    *Payment `json:"payment,omitempty"`
+	CreatedAt  string   `json:"createdAt,omitempty" example:"2024-01-01T12:00:00.000Z"`
+}
+
+// RejectPaymentResponse represents the response for /payments/reject.
+// @Description The recorded refund of a rejected message
+type RejectPaymentResponse struct {
+	Status string    `json:"status" example:"success"`
+	Refund RefundOut `json:"refund"`
+}
+
+// ListRefundsResponse represents the response for /payments/refunds.
+// @Description Refunds paid to the caller
+type ListRefundsResponse struct {
+	Status  string      `json:"status" example:"success"`
+	Refunds []RefundOut `json:"refunds"`
+}
+
+// PaymentOut represents a recipient fee relayed to the caller.
+// @Description A fee paid by a sender for one message
+type PaymentOut struct {
+	ID         int64  `json:"id" example:"1"`
+	TxID       string `json:"txid" example:"4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"`
+	Sender     string `json:"sender" example:"028d37b941208cd6b8a4c28288eda5f2f16c2b3ab0fcb6d13c18b47fe37b971fc1"`
+	MessageBox string `json:"messageBox" example:"inbox"`
+	MessageID  string `json:"messageId,omitempty" example:"msg-123"`
+	Fee        int    `json:"fee" example:"100"`    // satoshis required
+	Amount     int64  `json:"amount" example:"100"` // satoshis paid
+	Status     string `json:"status" example:"relayed"`
+	CreatedAt  string `json:"createdAt" example:"2024-01-01T12:00:00.000Z"`
+}
+
+// ListEarningsResponse represents the response for /payments/earnings.
+// @Description Recipient fees earned by the caller
+type ListEarningsResponse struct {
+	Status      string       `json:"status" example:"success"`
+	TotalAmount int64        `json:"totalAmount" example:"1200"`
+	TotalCount  int          `json:"totalCount" example:"12"`
+	Payments    []PaymentOut `json:"payments"`
+}
+
+// RevenueByBoxOut is the delivery fee revenue of one message box.
+// @Description Delivery fee revenue of one message box
+type RevenueByBoxOut struct {
+	MessageBox string `json:"messageBox" example:"notifications"`
+	Count      int    `json:"count" example:"40"`
+	Amount     int64  `json:"amount" example:"400"`
+}
+
+// DeliveryFeeRevenueResponse represents the response for the operator revenue endpoint.
+// @Description Delivery fees internalized by the server wallet
+type DeliveryFeeRevenueResponse struct {
+	Status       string            `json:"status" example:"success"`
+	TotalAmount  int64             `json:"totalAmount" example:"400"`
+	TotalCount   int               `json:"totalCount" example:"40"`
+	ByMessageBox []RevenueByBoxOut `json:"byMessageBox"`
+}
+
+// RoutePriceOut represents the price of a route for one message box.
+// @Description Satoshis charged per request to a route, after the free tier
+type RoutePriceOut struct {
+	Route         string `json:"route" example:"/listMessages"`
+	MessageBox    string `json:"messageBox" example:"inbox"` // "*" for boxes without their own price
+	Price         int    `json:"price" example:"5"`
+	FreeRequests  int    `json:"freeRequests" example:"100"`
+	WindowSeconds int    `json:"windowSeconds" example:"86400"`
+	UpdatedAt     string `json:"updatedAt" example:"2024-01-01T12:00:00.000Z"`
+}
+
+// ListRoutePricesResponse represents the response for the route price list.
+// @Description Prices charged by the payment middleware per route and box
+type ListRoutePricesResponse struct {
+	Status string          `json:"status" example:"success"`
+	Routes []string        `json:"routes"` // routes that can be priced
+	Prices []RoutePriceOut `json:"prices"`
+}
+
+// SetRoutePriceResponse represents the response after setting or removing a route price.
+// @Description Result of a route price change
+type SetRoutePriceResponse struct {
+	Status     string         `json:"status" example:"success"`
+	RoutePrice *RoutePriceOut `json:"routePrice,omitempty"` // omitted after removal
+}
+
+// CreditBalanceResponse represents the response for /credits/balance.
+// @Description Credit balance of the caller and recipient fees owed to it
+type CreditBalanceResponse struct {
+	Status          string `json:"status" example:"success"`
+	Balance         int64  `json:"balance" example:"1000"`
+	PendingEarnings int64  `json:"pendingEarnings" example:"30"` // recipient fees paid from credit, settled in the next batch
+}
+
+// CreditEntryOut represents one entry of a credit ledger.
+// @Description A credit or debit of a credit balance
+type CreditEntryOut struct {
+	ID               int64  `json:"id" example:"1"`
+	Kind             string `json:"kind" example:"recipient_fee"` // deposit, delivery_fee, recipient_fee, withdrawal or reversal
+	Amount           int64  `json:"amount" example:"-10"`         // negative for debits
+	BalanceAfter     int64  `json:"balanceAfter" example:"990"`
+	TxID             string `json:"txid,omitempty" example:"4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"`
+	Recipient        string `json:"recipient,omitempty" example:"02a1b2..."`
+	MessageBox       string `json:"messageBox,omitempty" example:"inbox"`
+	MessageID        string `json:"messageId,omitempty" example:"msg-123"`
+	SettlementStatus string `json:"settlementStatus,omitempty" example:"pending"` // held, pending, settled or reversed
+	CreatedAt        string `json:"createdAt" example:"2024-01-01T12:00:00.000Z"`
+}
+
+// CreditLedgerResponse represents the response for /credits/ledger.
+// @Description Credit ledger of the caller, newest first
+type CreditLedgerResponse struct {
+	Status  string           `json:"status" example:"success"`
+	Entries []CreditEntryOut `json:"entries"`
+}
+
+// CreditPayoutOut represents a payment made from credit to its payee.
+// @Description A settlement of recipient fees or a withdrawal; payment is passed to internalizeAction by the payee
+type CreditPayoutOut struct {
+	ID        int64    `json:"id" example:"1"`
+	Kind      string   `json:"kind" example:"settlement"` // "settlement" or "withdrawal"
+	Amount    int64    `json:"amount" example:"120"`
+	TxID      string   `json:"txid" example:"4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"`
+	Payment   *Payment `json:"payment,omitempty"`
+	CreatedAt string   `json:"createdAt" example:"2024-01-01T12:00:00.000Z"`
+}
+
+// ListCreditPayoutsResponse represents the response for /credits/payouts.
+// @Description Payouts made to the caller, newest first
+type ListCreditPayoutsResponse struct {
+	Status  string            `json:"status" example:"success"`
+	Payouts []CreditPayoutOut `json:"payouts"`
+}
+
+// DepositCreditResponse represents the response for /credits/deposit.
+// @Description Result of a credit deposit
+type DepositCreditResponse struct {
+	Status  string `json:"status" example:"success"`
+	TxID    string `json:"txid" example:"4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"`
+	Amount  int64  `json:"amount" example:"1000"`
+	Balance int64  `json:"balance" example:"1000"`
+}
+
+// WithdrawCreditResponse represents the response for /credits/withdraw.
+// @Description Unused credit paid back to the caller
+type WithdrawCreditResponse struct {
+	Status  string          `json:"status" example:"success"`
+	Balance int64           `json:"balance" example:"0"`
+	Payout  CreditPayoutOut `json:"payout"`
+}
+
+// CertificateDetail represents a certificate the caller presented.
+// @Description Identity certificate details; field values are not returned
+type CertificateDetail struct {
+	Certifier       string   `json:"certifier" example:"02abc..."`
+	CertificateType string   `json:"certificateType" example:"z40BOInXkI8m7f/wBrv4MJ09bZfzZbTj2fJqCtONqCY="`
+	SerialNumber    string   `json:"serialNumber" example:"KyZP1tZn1kTAQkWvuuZMF2B9HnCPwNjaDBczYCpEbdU="`
+	Fields          []string `json:"fields"` // fields revealed to the server
+	ExpiresAt       string   `json:"expiresAt" example:"2024-01-31T12:00:00.000Z"`
+	UpdatedAt       string   `json:"updatedAt" example:"2024-01-01T12:00:00.000Z"`
+}
+
+// ListCertificatesResponse represents the response for /certificates.
+// @Description Identity certificates the caller presented
+type ListCertificatesResponse struct {
+	Status       string              `json:"status" example:"success"`
+	Description  string              `json:"description,omitempty" example:"1 certificate(s) verified."`
+	Certificates []CertificateDetail `json:"certificates"`
+}
+
+// DeleteCertificatesResponse represents the response for DELETE /certificates.
+// @Description Number of identity certificates deleted
+type DeleteCertificatesResponse struct {
+	Status              string `json:"status" example:"success"`
+	DeletedCertificates int64  `json:"deletedCertificates" example:"1"`
+}
+
+// SetCertificateRequirementResponse represents the response after setting a certificate requirement.
+// @Description Result of setting a certificate requirement
+type SetCertificateRequirementResponse struct {
+	Status      string `json:"status" example:"success"`
+	Description string `json:"description" example:"Only senders with a certificate from one of 1 certifier(s) can now deliver to business."`
+}
+
+// CertificateRequirementDetail represents a certificate requirement in responses.
+// @Description Certificate requirement details
+type CertificateRequirementDetail struct {
+	MessageBox      string   `json:"messageBox" example:"business"`
+	Certifiers      []string `json:"certifiers"`
+	CertificateType string   `json:"certificateType,omitempty" example:"z40BOInXkI8m7f/wBrv4MJ09bZfzZbTj2fJqCtONqCY="`
+	Fields          []string `json:"fields,omitempty"`
+	VerifiedFee     *int     `json:"verifiedFee,omitempty" example:"0"`
+	UpdatedAt       string   `json:"updatedAt" example:"2024-01-01T12:00:00.000Z"`
+}
+
+// ListCertificateRequirementsResponse represents the response for /permissions/certificates/list.
+// @Description List of the caller's certificate requirements
+type ListCertificateRequirementsResponse struct {
+	Status       string                         `json:"status" example:"success"`
+	Requirements []CertificateRequirementDetail `json:"requirements"`
+}
+
+// CertificateQuote reports a recipient's certificate requirement for the quoted box.
+// @Description Certificate the recipient asks of senders and whether the sender has presented one
+type CertificateQuote struct {
+	Certifiers      []string `json:"certifiers"`
+	CertificateType string   `json:"certificateType,omitempty" example:"z40BOInXkI8m7f/wBrv4MJ09bZfzZbTj2fJqCtONqCY="`
+	Fields          []string `json:"fields,omitempty"`
+	VerifiedFee     *int     `json:"verifiedFee,omitempty" example:"0"` // omitted when unverified senders cannot deliver
+	Verified        bool     `json:"verified" example:"false"`
+}
@@ -0,0 +1,287 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bsv-blockchain/go-bsv-middleware/pkg/middleware"
+	"github.com/bsv-blockchain/go-message-box-server/internal/logger"
+	"github.com/bsv-blockchain/go-message-box-server/pkg/db"
+)
+
+// PricedRoutes are the routes operators can charge for through the payment middleware,
+// relative to the routing prefix.
+var PricedRoutes = []string{
+	"/sendMessage",
+	"/listMessages",
+	"/acknowledgeMessage",
+	"/permissions/get",
+	"/permissions/list",
+	"/permissions/quote",
+}
+
+// maxRouteWindowSeconds caps the free tier window at 30 days.
+const maxRouteWindowSeconds = 30 * 24 * 60 * 60
+
+// RoutePriceCalculator returns the request price function for the payment middleware.
+// Requests are free unless their route is priced, and free while the caller has free requests left.
+// prefix is the routing prefix the routes are mounted under.
+func (s *Server) RoutePriceCalculator(prefix string) func(r *http.Request) (int, error) {
+	return func(r *http.Request) (int, error) {
+		price, err := s.routePrice(r, strings.TrimPrefix(r.URL.Path, prefix))
+		if err != nil || price == nil || price.Price <= 0 {
+			return 0, err
+		}
+		free, err := s.DB.UseFreeRouteRequest(price, getIdentityKey(r), time.Now())
+		if err != nil || free {
+			return 0, err
+		}
+		return price.Price, nil
+	}
+}
+
+// RecordRouteCharges records requests paid through the payment middleware in the payments ledger.
+// It must wrap the routes inside the payment middleware.
+func (s *Server) RecordRouteCharges(prefix string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info, err := middleware.ShouldGetPaymentInfo(r.Context())
+		if err == nil && info.SatoshisPaid > 0 {
+			s.recordRouteCharge(r, strings.TrimPrefix(r.URL.Path, prefix), info)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) recordRouteCharge(r *http.Request, route string, info *middleware.PaymentInfo) {
+	rec := db.PaymentRecord{
+		Kind:       db.PaymentKindRouteFee,
+		Sender:     getIdentityKey(r),
+		MessageBox: requestMessageBox(r),
+		Fee:        info.SatoshisPaid,
+		Amount:     int64(info.SatoshisPaid),
+		Status:     db.PaymentStatusInternalized,
+	}
+	if rec.MessageBox == "" {
+		rec.MessageBox = db.DefaultRoutePriceBox
+	}
+	if !info.Accepted {
+		rec.Status = db.PaymentStatusRejected
+	}
+	if tx, err := parsePaymentTx(info.Tx); err == nil {
+		rec.TxID = tx.TxID().String()
+	} else {
+		logger.Error("failed to parse route payment", "error", err, "route", route)
+	}
+	s.recordPayment(rec)
+}
+
+// routePrice returns the price of route for the request's message box, nil if the route is free.
+func (s *Server) routePrice(r *http.Request, route string) (*db.RoutePriceRecord, error) {
+	if !slices.Contains(PricedRoutes, route) {
+		return nil, nil
+	}
+	// Most routes are never priced; avoid reading the body for them
+	if ok, err := s.DB.HasRoutePrices(route); err != nil || !ok {
+		return nil, err
+	}
+	return s.DB.GetRoutePrice(route, requestMessageBox(r))
+}
+
+// requestMessageBox finds the message box a request is about: the messageBox query parameter,
+// or messageBox (message.messageBox for sendMessage) in a JSON body. The body is left unread for the handler.
+func requestMessageBox(r *http.Request) string {
+	if box := r.URL.Query().Get("messageBox"); box != "" {
+		return strings.TrimSpace(box)
+	}
+	if r.Body == nil || r.Method == http.MethodGet {
+		return ""
+	}
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+
+	var req struct {
+		MessageBox string `json:"messageBox"`
+		Message    *struct {
+			MessageBox string `json:"messageBox"`
+		} `json:"message"`
+	}
+	if json.Unmarshal(body, &req) != nil {
+		return ""
+	}
+	if req.MessageBox == "" && req.Message != nil {
+		return strings.TrimSpace(req.Message.MessageBox)
+	}
+	return strings.TrimSpace(req.MessageBox)
+}
+
+// ListRoutePrices godoc
+// @Summary      List route prices
+// @Description  Returns the satoshis the payment middleware charges per request to each priced route and message box, and each route's free tier. Prices for "*" apply to boxes without their own price. Unpriced routes are free.
+// @Tags         Payments
+// @Produce      json
+// @Success      200  {object}  ListRoutePricesResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /routePrices [get]
+func (s *Server) ListRoutePrices(w http.ResponseWriter, r *http.Request) {
+	if getIdentityKey(r) == "" {
+		writeError(w, 401, "ERR_AUTHENTICATION_REQUIRED", "Authentication required.")
+		return
+	}
+
+	prices, err := s.DB.ListRoutePrices()
+	if err != nil {
+		logger.Error("failed to list route prices", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to retrieve route prices.")
+		return
+	}
+
+	out := []RoutePriceOut{}
+	for _, p := range prices {
+		out = append(out, toRoutePriceOut(p))
+	}
+
+	writeJSON(w, 200, ListRoutePricesResponse{
+		Status: "success",
+		Routes: PricedRoutes,
+		Prices: out,
+	})
+}
+
+// SetRoutePrice godoc
+// @Summary      Price a route (operators only)
+// @Description  Sets the satoshis charged per request to a route through the payment middleware, for a message box or "*" for boxes without their own price. Each caller gets freeRequests free requests per windowSeconds (default one day) before paying. A price of 0 makes the box free on that route.
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param        request body SetRoutePriceRequest true "Price to set"
+// @Success      200  {object}  SetRoutePriceResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /admin/routePrices/set [post]
+func (s *Server) SetRoutePrice(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.requireAdmin(w, r); !ok {
+		return
+	}
+
+	var req SetRoutePriceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, 400, "ERR_INVALID_JSON", "Invalid JSON body")
+		return
+	}
+
+	if code, desc := validateRoutePrice(req.Route, req.MessageBox); code != "" {
+		writeError(w, 400, code, desc)
+		return
+	}
+	if req.Price == nil || *req.Price < 0 {
+		writeError(w, 400, "ERR_INVALID_FEE", "price must be a non-negative number of satoshis.")
+		return
+	}
+	if req.FreeRequests < 0 {
+		writeError(w, 400, "ERR_INVALID_FREE_REQUESTS", "freeRequests must not be negative.")
+		return
+	}
+	if req.WindowSeconds == 0 {
+		req.WindowSeconds = 24 * 60 * 60
+	}
+	if req.WindowSeconds < 1 || req.WindowSeconds > maxRouteWindowSeconds {
+		writeError(w, 400, "ERR_INVALID_WINDOW", "windowSeconds must be between 1 and 2592000 (30 days).")
+		return
+	}
+
+	if err := s.DB.SetRoutePrice(req.Route, req.MessageBox, *req.Price, req.FreeRequests, req.WindowSeconds); err != nil {
+		logger.Error("failed to set route price", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to update route price.")
+		return
+	}
+
+	out := RoutePriceOut{
+		Route:         req.Route,
+		MessageBox:    req.MessageBox,
+		Price:         *req.Price,
+		FreeRequests:  req.FreeRequests,
+		WindowSeconds: req.WindowSeconds,
+		UpdatedAt:     time.Now().UTC().Format("2006-01-02T15:04:05.000Z"),
+	}
+	writeJSON(w, 200, SetRoutePriceResponse{Status: "success", RoutePrice: &out})
+}
+
+// DeleteRoutePrice godoc
+// @Summary      Remove a route price (operators only)
+// @Description  Removes the price of a route for a message box, which then falls back to the route's "*" price. Removing "*" makes boxes without their own price free.
+// @Tags         Admin
+// @Produce      json
+// @Param        route query string true "Route, e.g. /listMessages"
+// @Param        messageBox query string true "Name of the message box, or * for the route's default price"
+// @Success      200  {object}  SetRoutePriceResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /admin/routePrices [delete]
+func (s *Server) DeleteRoutePrice(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.requireAdmin(w, r); !ok {
+		return
+	}
+
+	route := r.URL.Query().Get("route")
+	messageBox := r.URL.Query().Get("messageBox")
+	if code, desc := validateRoutePrice(route, messageBox); code != "" {
+		writeError(w, 400, code, desc)
+		return
+	}
+
+	deleted, err := s.DB.DeleteRoutePrice(route, messageBox)
+	if err != nil {
+		logger.Error("failed to delete route price", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to remove route price.")
+		return
+	}
+	if !deleted {
+		writeError(w, 404, "ERR_ROUTE_PRICE_NOT_FOUND", "No price is set for this route and message box.")
+		return
+	}
+
+	writeJSON(w, 200, SetRoutePriceResponse{Status: "success"})
+}
+
+// validateRoutePrice accepts a priced route with a plain box name or the default price entry.
+func validateRoutePrice(route, messageBox string) (string, string) {
+	if route == "" || messageBox == "" {
+		return "ERR_MISSING_PARAMETERS", "route and messageBox are required."
+	}
+	if !slices.Contains(PricedRoutes, route) {
+		return "ERR_INVALID_ROUTE", "route must be one of: " + strings.Join(PricedRoutes, ", ")
+	}
+	if messageBox != db.DefaultRoutePriceBox && db.IsBoxPattern(messageBox) {
+		return "ERR_INVALID_MESSAGEBOX", "messageBox must be a box name or * for the route's default price."
+	}
+	return "", ""
+}
+
+func toRoutePriceOut(p db.RoutePriceRecord) RoutePriceOut {
+	return RoutePriceOut{
+		Route:         p.Route,
+		MessageBox:    p.MessageBox,
+		Price:         p.Price,
+		FreeRequests:  p.FreeRequests,
+		WindowSeconds: p.WindowSeconds,
+		UpdatedAt:     p.UpdatedAt.Format("2006-01-02T15:04:05.000Z"),
+	}
+}
@@ -1,21 +1,39 @@
 package handlers
 
 import (
+	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bsv-blockchain/go-message-box-server/internal/firebase"
 	"github.com/bsv-blockchain/go-message-box-server/internal/logger"
 	"github.com/bsv-blockchain/go-message-box-server/pkg/db"
+	"github.com/bsv-blockchain/go-sdk/transaction"
 	sdk "github.com/bsv-blockchain/go-sdk/wallet"
 )
 
 // SendMessage godoc
 // @Summary      Send a message to recipient(s)
 // @Description  Inserts a message into the target recipient's message box. Supports single or multiple recipients. Payment may be required depending on recipient's fee settings.
+// @Description  A quoteId from /permissions/quote locks the quoted fees for the same sender, box and recipients and a body no larger than the quoted bodySize. Each quote can be used once; invalid, expired, mismatched or reused quotes fail with ERR_INVALID_QUOTE, ERR_QUOTE_EXPIRED, ERR_QUOTE_MISMATCH or 409 ERR_QUOTE_USED. Blocks and rate limits still apply.
+// @Description  A quoted fee holds only while the sender permission or subscription that priced it still applies; once it expired or its message cap was used up, the send fails with 409 ERR_QUOTE_STALE.
+// @Description  A messageId repeated in the send or already stored fails with ERR_DUPLICATE_MESSAGE before any fee is taken.
+// @Description  Messages to all recipients are stored together or not at all. A capped sender permission or rate limit used up by a concurrent send fails the send with 409 ERR_PERMISSION_USED_UP or 429 ERR_RATE_LIMITED. If storing fails after the server took its delivery fee, the fee is refunded to the sender and the error (DeliveryFailedError) carries the refund.
+// @Description  With useCredit the delivery and recipient fees are debited from the sender's credit balance (see /credits/deposit) instead of paid by a transaction; a balance that does not cover them fails with 402 ERR_INSUFFICIENT_CREDIT. Debits of a send that is not stored are returned to the balance.
+// @Description  With subscribe the sender buys each recipient's subscription offer (see /permissions/subscriptions/set): the offer price replaces the per-message recipient fee, and the sender may then message the box for free until subscribedUntil. Recipients without an offer fail with ERR_NO_SUBSCRIPTION_OFFER; subscribe cannot be combined with quoteId.
+// @Description  Recipients whose permission sets powDifficulty (see /permissions/quote) require proofOfWork[recipient] to be a nonce of at most 64 characters such that
+// @Description  sha256(sender + ":" + recipient + ":" + messageBox + ":" + messageId + ":" + nonce) starts with powDifficulty zero bits; missing or insufficient proofs fail with ERR_PROOF_OF_WORK_REQUIRED. Each proof is accepted once: resending an acknowledged messageId with the same nonce fails with 409 ERR_PROOF_OF_WORK_USED. Subscribing replaces the proof.
+// @Description  Recipients who require an identity certificate on the box (see /permissions/certificates/set) turn away senders without one with 403 ERR_CERTIFICATE_REQUIRED, or charge verified senders a lower fee.
+// @Description  Recipient fees of senders reported as spam are raised according to their reputation (see /reputation); banned senders fail with 403 ERR_SENDER_BANNED.
+// @Description  Payment outputs are checked against the transaction before anything is stored; recipients whose outputs pay less than their fee are listed in a 400 ERR_INSUFFICIENT_PAYMENT error (InsufficientPaymentError).
+// @Description  Each payment output pays for one send only. Outputs already used by another message, or a payment transaction already PAYMENT_REPLAY_CONFIRMATIONS blocks deep, fail with 409 ERR_PAYMENT_REPLAYED. If the depth cannot be checked, the send fails with 503 ERR_PAYMENT_STATUS_UNAVAILABLE.
 // @Tags         Messages
 // @Accept       json
 // @Produce      json
@@ -23,8 +41,12 @@ import (
 // @Success      200  {object}  SendMessageResponse
 // @Failure      400  {object}  ErrorResponse
 // @Failure      401  {object}  ErrorResponse
+// @Failure      402  {object}  ErrorResponse
 // @Failure      403  {object}  DeliveryBlockedError
+// @Failure      409  {object}  ErrorResponse
+// @Failure      429  {object}  RateLimitedError
 // @Failure      500  {object}  ErrorResponse
+// @Failure      503  {object}  ErrorResponse
 // @Security     BSVAuth
 // @Router       /sendMessage [post]
 func (s *Server) SendMessage(w http.ResponseWriter, r *http.Request) {
@@ -59,6 +81,11 @@ func (s *Server) SendMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if code, desc := validateNotificationOptions(req.Notification); code != "" {
+		writeError(w, 400, code, desc)
+		return
+	}
+
 	// Normalize recipients
 	recipientsRaw := msg.Recipients
 	if len(recipientsRaw) == 0 || string(recipientsRaw) == "null" {
@@ -121,6 +148,57 @@ func (s *Server) SendMessage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	boxType := strings.TrimSpace(msg.MessageBox)
+	for i := range recipients {
+		recipients[i] = strings.TrimSpace(recipients[i])
+	}
+
+	if req.Subscribe && req.QuoteID != "" {
+		writeError(w, 400, "ERR_INVALID_REQUEST", "quoteId cannot be combined with subscribe.")
+		return
+	}
+
+	var quote *signedQuote
+	if req.QuoteID != "" {
+		if s.wallet == nil {
+			writeError(w, 400, "ERR_INVALID_QUOTE", "Quotes are not supported by this server.")
+			return
+		}
+		q, err := s.verifyQuote(r.Context(), req.QuoteID, time.Now())
+		if err == nil {
+			err = q.matches(senderKey, boxType, recipients, len(msg.Body))
+		}
+		if err != nil {
+			var qErr *quoteError
+			if errors.As(err, &qErr) {
+				writeError(w, qErr.status, qErr.code, qErr.description)
+			} else {
+				logger.Error("quote verification failed", "error", err)
+				writeError(w, 500, "ERR_INTERNAL", "An internal error has occurred.")
+			}
+			return
+		}
+		quote = q
+	}
+
+	// reported senders pay more or cannot send at all
+	reputation, err := s.reputation(senderKey, time.Now())
+	if err != nil {
+		logger.Error("failed to get sender reputation", "error", err)
+		writeError(w, 500, "ERR_INTERNAL", "An internal error has occurred.")
+		return
+	}
+	if reputation.Level == ReputationBanned {
+		writeError(w, 403, "ERR_SENDER_BANNED", "This sender was reported as spam too often and cannot send messages.")
+		return
+	}
+
+	// identity certificates the sender presented, for recipients who require one
+	senderCerts, err := s.DB.ListSenderCertificates(senderKey, time.Now())
+	if err != nil {
+		logger.Error("failed to list sender certificates", "error", err)
+		writeError(w, 500, "ERR_INTERNAL", "An internal error has occurred.")
+		return
+	}
 
 	// Ensure messageBox exists for each recipient
 	for _, recip := range recipients {
@@ -138,21 +216,66 @@ func (s *Server) SendMessage(w http.ResponseWriter, r *http.Request) {
 		writeError(w, 500, "ERR_INTERNAL", "An internal error has occurred.")
 		return
 	}
+	if quote != nil {
+		deliveryFee = quote.DeliveryFee
+	}
 
 	var feeRows []feeRow
+	var notOffered, uncertified, staleQuote []string
 	for _, recip := range recipients {
 		recip = strings.TrimSpace(recip)
-		rf, err := s.DB.GetRecipientFee(recip, senderKey, boxType)
+		res, err := s.DB.ResolveRecipientFee(recip, senderKey, boxType, time.Now())
 		if err != nil {
 			logger.Error("failed to get recipient fee", "error", err)
 			writeError(w, 500, "ERR_INTERNAL", "An internal error has occurred.")
 			return
 		}
-		feeRows = append(feeRows, feeRow{
-			recipient:    recip,
-			recipientFee: rf,
-			allowed:      rf != -1,
-		})
+		certs, err := s.checkCertificates(recip, boxType, res, senderCerts)
+		if err != nil {
+			logger.Error("failed to check sender certificates", "error", err)
+			writeError(w, 500, "ERR_INTERNAL", "An internal error has occurred.")
+			return
+		}
+		if !certs.allowed() {
+			uncertified = append(uncertified, recip)
+		}
+		// the fee is priced on the actual body, never on a size declared by the client
+		fee := reputation.recipientFee(certs.recipientFee(res.FeeFor(len(msg.Body))), res)
+		if quote != nil && fee != -1 {
+			// a quote locks the price, but a recipient who blocked the sender since stays blocked
+			if quoted, ok := quote.recipient(recip); ok && quoted.RecipientFee >= 0 {
+				if quoted.pricedBy(res) {
+					fee = quoted.RecipientFee
+				} else {
+					staleQuote = append(staleQuote, recip)
+				}
+			}
+		}
+		row := feeRow{
+			recipient:     recip,
+			recipientFee:  fee,
+			allowed:       fee != -1,
+			powDifficulty: res.PowDifficulty(),
+		}
+		if res.Permission != nil {
+			row.permissionID = res.Permission.ID
+		}
+		if req.Subscribe && row.allowed {
+			offer, err := s.DB.GetSubscriptionOffer(recip, boxType)
+			if err != nil {
+				logger.Error("failed to get subscription offer", "error", err)
+				writeError(w, 500, "ERR_INTERNAL", "An internal error has occurred.")
+				return
+			}
+			if offer == nil {
+				notOffered = append(notOffered, recip)
+			} else {
+				row.recipientFee = offer.Price
+				row.subscription = offer
+				row.powDifficulty = 0 // buying a subscription pays for access instead
+			}
+		}
+		feeRows = append(feeRows, row)
 	}
 
 	// Check blocked
@@ -171,6 +294,82 @@ func (s *Server) SendMessage(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	if len(uncertified) > 0 {
+		writeError(w, 403, "ERR_CERTIFICATE_REQUIRED",
+			fmt.Sprintf("Recipients only accept senders with an identity certificate (see /certificates): %s", strings.Join(uncertified, ", ")))
+		return
+	}
+	if len(staleQuote) > 0 {
+		writeError(w, 409, "ERR_QUOTE_STALE",
+			fmt.Sprintf("The permission or subscription that priced the quote no longer applies to recipients: %s. Request a new quote.", strings.Join(staleQuote, ", ")))
+		return
+	}
+	if len(notOffered) > 0 {
+		writeError(w, 400, "ERR_NO_SUBSCRIPTION_OFFER",
+			fmt.Sprintf("No subscription to %s is offered by recipients: %s", boxType, strings.Join(notOffered, ", ")))
+		return
+	}
+
+	// Reject repeated or stored messageIds before anything is charged. A concurrent send of the same id is
+	// still caught when the messages are stored, and the delivery fee is refunded then.
+	duplicates, err := s.duplicateMessageIDs(messageIDs)
+	if err != nil {
+		logger.Error("failed to check message ids", "error", err)
+		writeError(w, 500, "ERR_INTERNAL", "An internal error has occurred.")
+		return
+	}
+	if len(duplicates) > 0 {
+		writeError(w, 400, "ERR_DUPLICATE_MESSAGE",
+			fmt.Sprintf("Duplicate messageIds: %s. Send with a new messageId.", strings.Join(duplicates, ", ")))
+		return
+	}
+
+	// Check proofs of work before anything is charged; each proof is recorded with its message
+	var unproven, reused []string
+	proofDigests := make([]string, len(feeRows))
+	for i, fr := range feeRows {
+		if fr.powDifficulty <= 0 {
+			continue
+		}
+		nonce := req.ProofOfWork[fr.recipient]
+		if !verifyProofOfWork(senderKey, fr.recipient, boxType, messageIDs[i], nonce, fr.powDifficulty) {
+			unproven = append(unproven, fr.recipient)
+			continue
+		}
+		digest := proofOfWorkDigest(senderKey, fr.recipient, boxType, messageIDs[i], nonce)
+		proofDigests[i] = hex.EncodeToString(digest[:])
+		used, err := s.DB.ProofOfWorkUsed(fr.recipient, proofDigests[i])
+		if err != nil {
+			logger.Error("failed to check proof of work", "error", err)
+			writeError(w, 500, "ERR_INTERNAL", "An internal error has occurred.")
+			return
+		}
+		if used {
+			reused = append(reused, fr.recipient)
+		}
+	}
+	if len(unproven) > 0 {
+		writeError(w, 400, "ERR_PROOF_OF_WORK_REQUIRED",
+			fmt.Sprintf("Missing or insufficient proof of work for recipients: %s", strings.Join(unproven, ", ")))
+		return
+	}
+	if len(reused) > 0 {
+		writeError(w, 409, "ERR_PROOF_OF_WORK_USED",
+			fmt.Sprintf("Proof of work already used for recipients: %s. Send with a new messageId.", strings.Join(reused, ", ")))
+		return
+	}
+
+	// Check rate limits before any payment is taken; the message is counted when it is stored
+	rateLimited, retryAfter, err := s.checkRateLimits(feeRows, senderKey, boxType, time.Now())
+	if err != nil {
+		logger.Error("failed to check rate limit", "error", err)
+		writeError(w, 500, "ERR_INTERNAL", "An internal error has occurred.")
+		return
+	}
+	if len(rateLimited) > 0 {
+		writeRateLimited(w, rateLimited, retryAfter)
+		return
+	}
 
 	// Check if payment is required
 	anyRecipientFee := false
@@ -182,49 +381,59 @@ func (s *Server) SendMessage(w http.ResponseWriter, r *http.Request) {
 	}
 	requiresPayment := deliveryFee > 0 || anyRecipientFee
 	perRecipientOutputs := make(map[string][]PaymentOutput)
+	serverOutput := -1
+	var paymentTx *transaction.Transaction
+	var claimed []db.SpentOutput // outputs recorded as spent by this send
+	var creditEntries []int64    // credit debited by this send, returned if nothing is stored
+	var creditBalance *int64
+
+	// fees debited from prepaid credit replace the payment transaction
+	if requiresPayment && req.UseCredit {
+		entries, balance, err := s.DB.DebitCredit(senderKey, creditDebits(boxType, deliveryFee, feeRows, messageIDs))
+		if errors.Is(err, db.ErrInsufficientCredit) {
+			writeError(w, 402, "ERR_INSUFFICIENT_CREDIT", "The credit balance does not cover the fees of this message.")
+			return
+		}
+		if err != nil {
+			logger.Error("failed to debit credit", "error", err)
+			writeError(w, 500, "ERR_INTERNAL", "An internal error has occurred.")
+			return
+		}
+		creditEntries = entries
+		creditBalance = &balance
+	}
 
 	// payments internalization
-	if requiresPayment {
+	if requiresPayment && !req.UseCredit {
 		if req.Payment == nil || len(req.Payment.Tx) == 0 || len(req.Payment.Outputs) == 0 {
 			writeError(w, 400, "ERR_MISSING_PAYMENT_TX", "Payment transaction data is required for payable delivery.")
 			return
 		}
 
-		if deliveryFee > 0 {
-			serverOutput := req.Payment.Outputs[0] // server delivery fee is the output at index 0
-
-			sdkOutput, err := toSDKInternalizeOutput(serverOutput)
-			if err != nil {
-				writeError(w, 400, "ERR_INVALID_PAYMENT_OUTPUT", fmt.Sprintf("Invalid payment output: %v", err))
-				return
-			}
-
-			description := req.Payment.Description
-			if description == "" {
-				description = "MessageBox delivery payment"
-			}
+		paymentTx, err = parsePaymentTx(req.Payment.Tx)
+		if err != nil {
+			writeError(w, 400, "ERR_INVALID_PAYMENT_TX", fmt.Sprintf("Invalid payment transaction: %v", err))
+			return
+		}
 
-			internalizeArgs := sdk.InternalizeActionArgs{
-				Tx:          req.Payment.Tx,
-				Outputs:     []sdk.InternalizeOutput{sdkOutput},
-				Description: description,
-				Labels:      req.Payment.Labels,
+		// verify the server's own fee first, the wallet accepts any amount it can spend
+		if deliveryFee > 0 {
+			if serverOutput, err = deliveryFeeOutput(req.Payment); err == nil {
+				err = verifyDeliveryFee(paymentTx, req.Payment.Outputs[serverOutput], deliveryFee)
 			}
-
-			result, err := s.wallet.InternalizeAction(r.Context(), internalizeArgs, "messagebox-server")
 			if err != nil {
-				logger.Error("failed to internalize delivery fee", "error", err)
-				writeError(w, 500, "ERR_INTERNALIZE_FAILED", fmt.Sprintf("Failed to internalize payment: %v", err))
-				return
-			}
-			if !result.Accepted {
-				writeError(w, 400, "ERR_INSUFFICIENT_PAYMENT", "Payment was not accepted by the server.")
+				var omErr *OutputMappingError
+				if errors.As(err, &omErr) {
+					writeError(w, 400, omErr.Code, omErr.Description)
+				} else {
+					logger.Error("delivery fee verification failed", "error", err)
+					writeError(w, 500, "ERR_INTERNAL", "An internal error has occurred.")
+				}
 				return
 			}
-			logger.Log("[DEBUG] Internalized server delivery output at index 0")
 		}
 
-		perRecipientOutputs, err = buildPerRecipientOutputs(req.Payment.Outputs, deliveryFee, feeRows)
+		perRecipientOutputs, err = buildPerRecipientOutputs(req.Payment.Outputs, serverOutput, feeRows)
 		if err != nil {
 			if omErr, ok := err.(*OutputMappingError); ok {
 				logger.Error("output mapping failed", "code", omErr.Code, "description", omErr.Description)
@@ -235,19 +444,141 @@ func (s *Server) SendMessage(w http.ResponseWriter, r *http.Request) {
 			}
 			return
 		}
+
+		// verify recipient payments before the server takes its fee or anything is stored
+		var serverOutputIndex *uint32
+		if serverOutput >= 0 {
+			serverOutputIndex = &req.Payment.Outputs[serverOutput].OutputIndex
+		}
+		underpaid, err := verifyRecipientPayments(paymentTx, senderKey, perRecipientOutputs, feeRows, serverOutputIndex)
+		if err != nil {
+			var omErr *OutputMappingError
+			if errors.As(err, &omErr) {
+				writeError(w, 400, omErr.Code, omErr.Description)
+			} else {
+				logger.Error("payment verification failed", "error", err)
+				writeError(w, 500, "ERR_INTERNAL", "An internal error has occurred.")
+			}
+			return
+		}
+		if len(underpaid) > 0 {
+			out := make([]UnderpaidRecipient, 0, len(underpaid))
+			var keys []string
+			for _, u := range underpaid {
+				out = append(out, UnderpaidRecipient{Recipient: u.recipient, RequiredSatoshis: u.required, PaidSatoshis: u.paid})
+				keys = append(keys, u.recipient)
+			}
+			writeJSON(w, 400, InsufficientPaymentError{
+				Status:              "error",
+				Code:                "ERR_INSUFFICIENT_PAYMENT",
+				Description:         fmt.Sprintf("Payment does not cover the fee of recipients: %s", strings.Join(keys, ", ")),
+				UnderpaidRecipients: out,
+			})
+			return
+		}
+
+		// an output pays for one send only
+		txid := paymentTx.TxID().String()
+		if status, omErr := s.checkPaymentDepth(r.Context(), txid); omErr != nil {
+			writeError(w, status, omErr.Code, omErr.Description)
+			return
+		}
+		spent := paymentSpentOutputs(txid, senderKey, boxType, req.Payment.Outputs, serverOutput, perRecipientOutputs)
+		replayed, err := s.DB.ClaimPaymentOutputs(spent)
+		if err != nil {
+			logger.Error("failed to claim payment outputs", "error", err)
+			writeError(w, 500, "ERR_INTERNAL", "An internal error has occurred.")
+			return
+		}
+		if len(replayed) > 0 {
+			writeError(w, 409, "ERR_PAYMENT_REPLAYED", fmt.Sprintf("Payment outputs were already used by another message: %s", replayedOutpoints(replayed)))
+			return
+		}
+		claimed = spent
 	}
 
-	var results []SendMessageResult
+	// a quote is spent only once the payment is known to be good
+	if quote != nil {
+		redeemed, err := s.DB.RedeemQuote(quote.ID, senderKey, time.Unix(quote.ExpiresAt, 0))
+		if err != nil {
+			logger.Error("failed to redeem quote", "error", err)
+			s.releaseOutputs(claimed, false)
+			s.reverseCreditDebits(senderKey, creditEntries)
+			writeError(w, 500, "ERR_INTERNAL", "An internal error has occurred.")
+			return
+		}
+		if !redeemed {
+			s.releaseOutputs(claimed, false)
+			s.reverseCreditDebits(senderKey, creditEntries)
+			writeError(w, 409, "ERR_QUOTE_USED", "This quote has already been used.")
+			return
+		}
+	}
+
+	// set once the server holds the delivery fee, which must then be refunded if nothing is stored
+	var paidDeliveryFee *db.PaymentRecord
+	if requiresPayment && !req.UseCredit && deliveryFee > 0 {
+		sdkOutput, err := toSDKInternalizeOutput(req.Payment.Outputs[serverOutput])
+		if err != nil {
+			s.releaseOutputs(claimed, false)
+			writeError(w, 400, "ERR_INVALID_PAYMENT_OUTPUT", fmt.Sprintf("Invalid payment output: %v", err))
+			return
+		}
+
+		description := req.Payment.Description
+		if description == "" {
+			description = "MessageBox delivery payment"
+		}
+
+		internalizeArgs := sdk.InternalizeActionArgs{
+			Tx:          req.Payment.Tx,
+			Outputs:     []sdk.InternalizeOutput{sdkOutput},
+			Description: description,
+			Labels:      req.Payment.Labels,
+		}
+
+		deliveryPayment := db.PaymentRecord{
+			TxID:       paymentTx.TxID().String(),
+			Kind:       db.PaymentKindDeliveryFee,
+			Sender:     senderKey,
+			MessageBox: boxType,
+			Fee:        deliveryFee,
+			Amount:     outputsAmount(paymentTx, req.Payment.Outputs[serverOutput:serverOutput+1]),
+		}
+		result, err := s.wallet.InternalizeAction(r.Context(), internalizeArgs, "messagebox-server")
+		if err != nil {
+			logger.Error("failed to internalize delivery fee", "error", err)
+			deliveryPayment.Status = db.PaymentStatusFailed
+			deliveryPayment.Error = sql.NullString{String: err.Error(), Valid: true}
+			s.recordPayment(deliveryPayment)
+			s.releaseOutputs(claimed, false)
+			writeError(w, 500, "ERR_INTERNALIZE_FAILED", fmt.Sprintf("Failed to internalize payment: %v", err))
+			return
+		}
+		if !result.Accepted {
+			deliveryPayment.Status = db.PaymentStatusRejected
+			s.recordPayment(deliveryPayment)
+			s.releaseOutputs(claimed, false)
+			writeError(w, 400, "ERR_INSUFFICIENT_PAYMENT", "Payment was not accepted by the server.")
+			return
+		}
+		deliveryPayment.Status = db.PaymentStatusInternalized
+		deliveryPayment.ID = s.recordPayment(deliveryPayment)
+		paidDeliveryFee = &deliveryPayment
+		logger.Log("[DEBUG] Internalized server delivery output", "outputIndex", sdkOutput.OutputIndex)
+	}
+
+	// store every message or none, so a failed send can be refunded as a whole
+	newMessages := make([]db.NewMessage, 0, len(feeRows))
 	for i, fr := range feeRows {
 		mbID, err := s.DB.GetMessageBoxID(fr.recipient, boxType)
 		if err != nil {
 			logger.Error("failed to get messageBoxId", "error", err)
-			writeError(w, 500, "ERR_INTERNAL", "An internal error has occurred.")
+			s.reverseCreditDebits(senderKey, creditEntries)
+			s.writeDeliveryFailure(w, r, 500, "ERR_INTERNAL", "An internal error has occurred.", paidDeliveryFee, claimed)
 			return
 		}
 
-		msgID := messageIDs[i]
-
 		// Build stored body
 		storedBody := map[string]any{
 			"message": json.RawMessage(msg.Body),
@@ -263,37 +594,175 @@ func (s *Server) SendMessage(w http.ResponseWriter, r *http.Request) {
 				SeekPermission: req.Payment.SeekPermission,
 			}
 			storedBody["payment"] = perRecipientPayment
+		} else if creditEntries != nil && fr.recipientFee > 0 {
+			// paid from the sender's credit, settled to the recipient in a later batch
+			storedBody["creditedFee"] = fr.recipientFee
 		}
 
 		bodyBytes, _ := json.Marshal(storedBody)
+		newMessages = append(newMessages, db.NewMessage{
+			MessageID:    messageIDs[i],
+			MessageBoxID: mbID,
+			MessageBox:   boxType,
+			Sender:       senderKey,
+			Recipient:    fr.recipient,
+			Body:         string(bodyBytes),
+			PermissionID: fr.permissionID,
+			Subscription: fr.subscription,
+			ProofDigest:  proofDigests[i],
+		})
+	}
 
-		if err := s.DB.InsertMessage(msgID, mbID, senderKey, fr.recipient, string(bodyBytes)); err != nil {
-			if errors.Is(err, db.ErrDuplicateMessage) {
-				logger.Error("duplicate message rejected", "messageId", msgID)
-				writeError(w, 400, "ERR_DUPLICATE_MESSAGE", "Duplicate message.")
-				return
-			}
-			logger.Error("failed to insert message", "error", err)
-			writeError(w, 500, "ERR_INTERNAL", "An internal error has occurred.")
+	subscriptions, err := s.DB.InsertMessages(newMessages, creditEntries)
+	if err != nil {
+		s.reverseCreditDebits(senderKey, creditEntries)
+		if errors.Is(err, db.ErrDuplicateMessage) {
+			logger.Error("duplicate message rejected", "error", err)
+			s.writeDeliveryFailure(w, r, 400, "ERR_DUPLICATE_MESSAGE", "Duplicate message.", paidDeliveryFee, claimed)
+			return
+		}
+		if errors.Is(err, db.ErrRateLimited) {
+			// a concurrent send took the last message a rate limit allowed
+			_, retryAfter, _ := s.checkRateLimits(feeRows, senderKey, boxType, time.Now())
+			w.Header().Set("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+			s.writeDeliveryFailure(w, r, 429, "ERR_RATE_LIMITED", "A rate limit was used up by a concurrent send. Retry later.", paidDeliveryFee, claimed)
 			return
 		}
+		if errors.Is(err, db.ErrProofOfWorkUsed) {
+			// a concurrent send stored a message with the same proof
+			s.writeDeliveryFailure(w, r, 409, "ERR_PROOF_OF_WORK_USED", "Proof of work already used. Send with a new messageId.", paidDeliveryFee, claimed)
+			return
+		}
+		if errors.Is(err, db.ErrPermissionUsedUp) {
+			// a concurrent send took the last message a capped sender rule allowed
+			s.writeDeliveryFailure(w, r, 409, "ERR_PERMISSION_USED_UP", "A permission used by this message has reached its message cap. Request a new quote and retry.", paidDeliveryFee, claimed)
+			return
+		}
+		logger.Error("failed to insert message", "error", err)
+		s.writeDeliveryFailure(w, r, 500, "ERR_INTERNAL", "An internal error has occurred.", paidDeliveryFee, claimed)
+		return
+	}
+
+	if creditEntries != nil {
+		if deliveryFee > 0 {
+			s.recordPayment(db.PaymentRecord{
+				Kind:       db.PaymentKindDeliveryFee,
+				Sender:     senderKey,
+				MessageBox: boxType,
+				Fee:        deliveryFee,
+				Amount:     int64(deliveryFee),
+				Status:     db.PaymentStatusCredited,
+			})
+		}
+	}
 
-		if db.ShouldUseFCMDelivery(boxType) {
-			go firebase.SendFCMNotification(s.DB, fr.recipient, firebase.FCMPayload{
-				Title:     "New Message",
-				MessageID: msgID,
+	var results []SendMessageResult
+	for i, fr := range feeRows {
+		mbID := newMessages[i].MessageBoxID
+		msgID := newMessages[i].MessageID
+
+		if recipientOutputs, ok := perRecipientOutputs[fr.recipient]; ok && fr.recipientFee > 0 && paymentTx != nil {
+			s.recordPayment(db.PaymentRecord{
+				TxID:       paymentTx.TxID().String(),
+				Kind:       db.PaymentKindRecipientFee,
+				Sender:     senderKey,
+				Recipient:  sql.NullString{String: fr.recipient, Valid: true},
+				MessageBox: boxType,
+				MessageID:  sql.NullString{String: msgID, Valid: true},
+				Fee:        fr.recipientFee,
+				Amount:     outputsAmount(paymentTx, recipientOutputs),
+				Status:     db.PaymentStatusRelayed,
+			})
+		} else if creditEntries != nil && fr.recipientFee > 0 {
+			s.recordPayment(db.PaymentRecord{
+				Kind:       db.PaymentKindRecipientFee,
+				Sender:     senderKey,
+				Recipient:  sql.NullString{String: fr.recipient, Valid: true},
+				MessageBox: boxType,
+				MessageID:  sql.NullString{String: msgID, Valid: true},
+				Fee:        fr.recipientFee,
+				Amount:     int64(fr.recipientFee),
+				Status:     db.PaymentStatusCredited,
 			})
 		}
 
-		results = append(results, SendMessageResult{Recipient: fr.recipient, MessageID: msgID})
+		var subscribedUntil *string
+		if sub := subscriptions[i]; sub != nil {
+			v := sub.ExpiresAt.UTC().Format("2006-01-02T15:04:05.000Z")
+			subscribedUntil = &v
+		}
+
+		usePush, err := s.DB.ShouldUseFCMDelivery(fr.recipient, senderKey, boxType, time.Now())
+		if err != nil {
+			// the message is already stored, a missing push must not fail the send
+			logger.Error("failed to evaluate notification preference", "error", err, "recipient", fr.recipient)
+		}
+		if usePush {
+			payload := s.buildFCMPayload(fr.recipient, senderKey, boxType, mbID, msgID, req.Notification)
+			go firebase.SendFCMNotification(s.DB, fr.recipient, payload)
+		}
+
+		results = append(results, SendMessageResult{Recipient: fr.recipient, MessageID: msgID, SubscribedUntil: subscribedUntil})
 	}
 
 	if results == nil {
 		results = []SendMessageResult{}
 	}
 	writeJSON(w, 200, SendMessageResponse{
-		Status:  "success",
-		Message: fmt.Sprintf("Your message has been sent to %d recipient(s).", len(results)),
-		Results: results,
+		Status:        "success",
+		Message:       fmt.Sprintf("Your message has been sent to %d recipient(s).", len(results)),
+		Results:       results,
+		CreditBalance: creditBalance,
+	})
+}
+
+// duplicateMessageIDs returns the messageIds that are repeated in the send or already stored.
+func (s *Server) duplicateMessageIDs(messageIDs []string) ([]string, error) {
+	var duplicates []string
+	seen := make(map[string]bool, len(messageIDs))
+	for _, id := range messageIDs {
+		if seen[id] && !slices.Contains(duplicates, id) {
+			duplicates = append(duplicates, id)
+		}
+		seen[id] = true
+	}
+	existing, err := s.DB.ExistingMessageIDs(messageIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range existing {
+		if !slices.Contains(duplicates, id) {
+			duplicates = append(duplicates, id)
+		}
+	}
+	return duplicates, nil
+}
+
+// checkRateLimits returns the recipients whose rate limits leave the sender no message in the current window,
+// and the seconds until the last of those windows resets.
+func (s *Server) checkRateLimits(feeRows []feeRow, senderKey, boxType string, now time.Time) ([]string, int, error) {
+	var limited []string
+	retryAfter := 0
+	for _, fr := range feeRows {
+		st, err := s.DB.CheckRateLimit(fr.recipient, senderKey, boxType, now)
+		if err != nil {
+			return nil, 0, err
+		}
+		if st != nil && st.Limited() {
+			limited = append(limited, fr.recipient)
+			retryAfter = max(retryAfter, st.RetryAfter(now))
+		}
+	}
+	return limited, retryAfter, nil
+}
+
+func writeRateLimited(w http.ResponseWriter, recipients []string, retryAfter int) {
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	writeJSON(w, 429, RateLimitedError{
+		Status:                "error",
+		Code:                  "ERR_RATE_LIMITED",
+		Description:           fmt.Sprintf("Rate limit exceeded for recipients: %s", strings.Join(recipients, ", ")),
+		RetryAfterSeconds:     retryAfter,
+		RateLimitedRecipients: recipients,
 	})
 }
@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/bsv-blockchain/go-message-box-server/internal/logger"
+	"github.com/bsv-blockchain/go-message-box-server/pkg/db"
+)
+
+// ListServerFees godoc
+// @Summary      List server delivery fees (operators only)
+// @Description  Returns the delivery fee of every configured message box and the default fee charged for other boxes. Restricted to identity keys listed in ADMIN_IDENTITY_KEYS.
+// @Tags         Admin
+// @Produce      json
+// @Success      200  {object}  ListServerFeesResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /admin/serverFees [get]
+func (s *Server) ListServerFees(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.requireAdmin(w, r); !ok {
+		return
+	}
+
+	fees, err := s.DB.ListServerFees()
+	if err != nil {
+		logger.Error("failed to list server fees", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to retrieve server fees.")
+		return
+	}
+
+	resp := ListServerFeesResponse{Status: "success", Fees: []ServerFeeOut{}}
+	for _, f := range fees {
+		if f.MessageBox == db.DefaultServerFeeBox {
+			fee := f.DeliveryFee
+			resp.DefaultFee = &fee
+			continue
+		}
+		resp.Fees = append(resp.Fees, ServerFeeOut{
+			MessageBox:  f.MessageBox,
+			DeliveryFee: f.DeliveryFee,
+			UpdatedAt:   f.UpdatedAt.Format("2006-01-02T15:04:05.000Z"),
+		})
+	}
+
+	writeJSON(w, 200, resp)
+}
+
+// SetServerFee godoc
+// @Summary      Set a server delivery fee (operators only)
+// @Description  Sets the delivery fee in satoshis charged by the server for a message box. Use messageBox "*" to set the default fee for boxes without their own fee. Every change is recorded in the fee history.
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param        request body SetServerFeeRequest true "Fee to set"
+// @Success      200  {object}  SetServerFeeResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /admin/serverFees/set [post]
+func (s *Server) SetServerFee(w http.ResponseWriter, r *http.Request) {
+	identityKey, ok := s.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	var req SetServerFeeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, 400, "ERR_INVALID_JSON", "Invalid JSON body")
+		return
+	}
+
+	if code, desc := validateServerFeeBox(req.MessageBox); code != "" {
+		writeError(w, 400, code, desc)
+		return
+	}
+	if req.DeliveryFee == nil || *req.DeliveryFee < 0 {
+		writeError(w, 400, "ERR_INVALID_FEE", "deliveryFee must be a non-negative number of satoshis.")
+		return
+	}
+
+	previous, err := s.DB.SetServerDeliveryFee(req.MessageBox, *req.DeliveryFee, identityKey)
+	if err != nil {
+		logger.Error("failed to set server fee", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to update server fee.")
+		return
+	}
+
+	writeJSON(w, 200, SetServerFeeResponse{
+		Status:      "success",
+		MessageBox:  req.MessageBox,
+		PreviousFee: previous,
+		DeliveryFee: req.DeliveryFee,
+	})
+}
+
+// DeleteServerFee godoc
+// @Summary      Remove a server delivery fee (operators only)
+// @Description  Removes the delivery fee of a message box, which then falls back to the default fee. Removing "*" makes boxes without their own fee free.
+// @Tags         Admin
+// @Produce      json
+// @Param        messageBox query string true "Name of the message box, or * for the default fee"
+// @Success      200  {object}  SetServerFeeResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /admin/serverFees [delete]
+func (s *Server) DeleteServerFee(w http.ResponseWriter, r *http.Request) {
+	identityKey, ok := s.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	messageBox := r.URL.Query().Get("messageBox")
+	if code, desc := validateServerFeeBox(messageBox); code != "" {
+		writeError(w, 400, code, desc)
+		return
+	}
+
+	previous, err := s.DB.DeleteServerDeliveryFee(messageBox, identityKey)
+	if err != nil {
+		logger.Error("failed to delete server fee", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to remove server fee.")
+		return
+	}
+	if previous == nil {
+		writeError(w, 404, "ERR_SERVER_FEE_NOT_FOUND", "No server fee is set for this message box.")
+		return
+	}
+
+	writeJSON(w, 200, SetServerFeeResponse{
+		Status:      "success",
+		MessageBox:  messageBox,
+		PreviousFee: previous,
+	})
+}
+
+// GetServerFeeHistory godoc
+// @Summary      Server delivery fee history (operators only)
+// @Description  Returns the most recent server delivery fee changes, newest first, with who made them.
+// @Tags         Admin
+// @Produce      json
+// @Param        messageBox query string false "Only changes to this message box"
+// @Param        limit query int false "Maximum number of results (1-500, default 50)"
+// @Success      200  {object}  ServerFeeHistoryResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /admin/serverFees/history [get]
+func (s *Server) GetServerFeeHistory(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.requireAdmin(w, r); !ok {
+		return
+	}
+
+	limit, err := parseLimit(r, 50, 500)
+	if err != nil {
+		writeError(w, 400, "ERR_INVALID_LIMIT", err.Error())
+		return
+	}
+
+	var messageBox *string
+	if v := r.URL.Query().Get("messageBox"); v != "" {
+		messageBox = &v
+	}
+
+	changes, err := s.DB.ListServerFeeChanges(messageBox, limit)
+	if err != nil {
+		logger.Error("failed to list server fee changes", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to retrieve server fee history.")
+		return
+	}
+
+	out := []ServerFeeChangeOut{}
+	for _, c := range changes {
+		out = append(out, ServerFeeChangeOut{
+			ID:          c.ID,
+			MessageBox:  c.MessageBox,
+			PreviousFee: nullIntPtr(c.PreviousFee),
+			NewFee:      nullIntPtr(c.NewFee),
+			ChangedBy:   c.ChangedBy,
+			CreatedAt:   c.CreatedAt.Format("2006-01-02T15:04:05.000Z"),
+		})
+	}
+
+	writeJSON(w, 200, ServerFeeHistoryResponse{
+		Status:  "success",
+		Changes: out,
+	})
+}
+
+// requireAdmin writes a 401 or 403 unless the request comes from an operator key.
+func (s *Server) requireAdmin(w http.ResponseWriter, r *http.Request) (string, bool) {
+	identityKey := getIdentityKey(r)
+	if identityKey == "" {
+		writeError(w, 401, "ERR_AUTHENTICATION_REQUIRED", "Authentication required.")
+		return "", false
+	}
+	if !s.isAdmin(identityKey) {
+		writeError(w, 403, "ERR_FORBIDDEN", "This endpoint is restricted to server operators.")
+		return "", false
+	}
+	return identityKey, true
+}
+
+// validateServerFeeBox accepts a plain box name or the default fee entry.
+func validateServerFeeBox(messageBox string) (string, string) {
+	if messageBox == "" {
+		return "ERR_MISSING_PARAMETERS", "messageBox is required."
+	}
+	if messageBox != db.DefaultServerFeeBox && db.IsBoxPattern(messageBox) {
+		return "ERR_INVALID_MESSAGEBOX", "messageBox must be a box name or * for the default fee."
+	}
+	return "", ""
+}
+
+func nullIntPtr(v sql.NullInt64) *int {
+	if !v.Valid {
+		return nil
+	}
+	n := int(v.Int64)
+	return &n
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/bsv-blockchain/go-message-box-server/internal/logger"
+	"github.com/bsv-blockchain/go-message-box-server/pkg/db"
+)
+
+const defaultSubscriptionDays = 30
+
+// SetSubscriptionOffer godoc
+// @Summary      Set a subscription offer
+// @Description  Sells senders free access to a message box: a sender who sends with subscribe pays price once instead of the per-message fee, and may then message the box for free for durationDays. Buying again while a subscription runs extends it. Use price=0 to remove the offer; subscriptions already bought keep running.
+// @Description  A subscription is kept apart from permissions, so the recipient's own rules for the sender still apply once it ends, and a rule blocking the sender also wins while it runs. Rejecting the paying message with /payments/reject revokes the subscription. The server delivery fee still applies to every message.
+// @Tags         Permissions
+// @Accept       json
+// @Produce      json
+// @Param        request body SetSubscriptionOfferRequest true "Subscription offer"
+// @Success      200  {object}  SetSubscriptionOfferResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /permissions/subscriptions/set [post]
+func (s *Server) SetSubscriptionOffer(w http.ResponseWriter, r *http.Request) {
+	identityKey := getIdentityKey(r)
+	if identityKey == "" {
+		writeError(w, 401, "ERR_AUTHENTICATION_REQUIRED", "Authentication required.")
+		return
+	}
+
+	var req SetSubscriptionOfferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, 400, "ERR_INVALID_JSON", "Invalid JSON body")
+		return
+	}
+
+	days, code, desc := validateSubscriptionOffer(req)
+	if code != "" {
+		writeError(w, 400, code, desc)
+		return
+	}
+
+	if *req.Price == 0 {
+		if _, err := s.DB.DeleteSubscriptionOffer(identityKey, req.MessageBox); err != nil {
+			logger.Error("failed to delete subscription offer", "error", err)
+			writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to update subscription offer.")
+			return
+		}
+		writeJSON(w, 200, SetSubscriptionOfferResponse{
+			Status:      "success",
+			Description: fmt.Sprintf("Subscriptions to %s are no longer offered.", req.MessageBox),
+		})
+		return
+	}
+
+	if err := s.DB.SetSubscriptionOffer(identityKey, req.MessageBox, *req.Price, days); err != nil {
+		logger.Error("failed to set subscription offer", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to update subscription offer.")
+		return
+	}
+
+	writeJSON(w, 200, SetSubscriptionOfferResponse{
+		Status:      "success",
+		Description: fmt.Sprintf("Senders can now buy %d days of free messages to %s for %d satoshis.", days, req.MessageBox, *req.Price),
+	})
+}
+
+// ListSubscriptionOffers godoc
+// @Summary      List subscription offers
+// @Description  Returns the subscription offers of the authenticated identity.
+// @Tags         Permissions
+// @Produce      json
+// @Success      200  {object}  ListSubscriptionOffersResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     BSVAuth
+// @Router       /permissions/subscriptions/list [get]
+func (s *Server) ListSubscriptionOffers(w http.ResponseWriter, r *http.Request) {
+	identityKey := getIdentityKey(r)
+	if identityKey == "" {
+		writeError(w, 401, "ERR_AUTHENTICATION_REQUIRED", "Authentication required.")
+		return
+	}
+
+	offers, err := s.DB.ListSubscriptionOffers(identityKey)
+	if err != nil {
+		logger.Error("failed to list subscription offers", "error", err)
+		writeError(w, 500, "ERR_DATABASE_ERROR", "Failed to list subscription offers.")
+		return
+	}
+
+	out := []SubscriptionOfferDetail{}
+	for _, o := range offers {
+		out = append(out, SubscriptionOfferDetail{
+			MessageBox:   o.MessageBox,
+			Price:        o.Price,
+			DurationDays: o.DurationDays,
+			UpdatedAt:    o.UpdatedAt.Format("2006-01-02T15:04:05.000Z"),
+		})
+	}
+
+	writeJSON(w, 200, ListSubscriptionOffersResponse{
+		Status: "success",
+		Offers: out,
+	})
+}
+
+// validateSubscriptionOffer checks a SetSubscriptionOfferRequest and returns its duration in days.
+// Returns an error code and description when invalid.
+func validateSubscriptionOffer(req SetSubscriptionOfferRequest) (int, string, string) {
+	if req.MessageBox == "" || req.Price == nil {
+		return 0, "ERR_INVALID_REQUEST", "messageBox (string) and price (number) are required."
+	}
+	if db.IsBoxPattern(req.MessageBox) {
+		return 0, "ERR_INVALID_MESSAGEBOX", "Subscriptions are offered for a single box, not a pattern."
+	}
+	if *req.Price < 0 {
+		return 0, "ERR_INVALID_FEE", "price must be a non-negative number of satoshis."
+	}
+
+	days := defaultSubscriptionDays
+	if req.DurationDays != nil {
+		days = *req.DurationDays
+	}
+	if days < 1 || days > db.MaxSubscriptionDays {
+		return 0, "ERR_INVALID_DURATION", fmt.Sprintf("durationDays must be between 1 and %d.", db.MaxSubscriptionDays)
+	}
+	return days, "", ""
+}
+
+// subscriptionQuote returns the subscription a sender can buy from the offer, nil when there is none
+// or the sender is blocked.
+func subscriptionQuote(offer *db.SubscriptionOfferRecord, res *db.FeeResolution) *SubscriptionQuote {
+	if offer == nil || res.Fee < 0 {
+		return nil
+	}
+	return &SubscriptionQuote{Price: offer.Price, DurationDays: offer.DurationDays}
+}